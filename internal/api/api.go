@@ -13,14 +13,71 @@ import (
 	"syscall"
 	"time"
 
+	"example.com/url-shortener/internal/bots"
 	"example.com/url-shortener/internal/cache"
 	"example.com/url-shortener/internal/config"
+	"example.com/url-shortener/internal/events"
+	"example.com/url-shortener/internal/hits"
+	"example.com/url-shortener/internal/jwt"
+	"example.com/url-shortener/internal/limiter"
 	"example.com/url-shortener/internal/logging"
 	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/trending"
 	"example.com/url-shortener/internal/util"
+	"example.com/url-shortener/internal/visitors"
+	"example.com/url-shortener/internal/webhooks"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+/*
+	Holds everything the route handlers need in order to serve requests.
+*/
+type server struct {
+	config      config.Configuration
+	f           *os.File
+	store       model.Store
+	cacheClient *redis.Client
+	cnt         *util.Counter
+	// tracks wrong passwords for protected short URLs
+	lockout *limiter.Lockout
+	// verifies bearer tokens, nil when JWT authentication is disabled
+	verifier *jwt.Verifier
+	// background jobs started on this server instance
+	jobs *jobRegistry
+	// records click events in the background, nil when click events are disabled
+	clickEvents *events.Writer
+	// counts hits of short URLs in batches, nil to write every hit directly
+	hits *hits.Batcher
+	// counts unique visitors of short URLs, nil when not counted
+	visitors visitors.Counter
+	// counts recent clicks of short URLs to list trending ones, nil when not counted
+	trending trending.Tracker
+	// passes click and short URL events to event stream subscribers, nil when events are not streamed
+	hub *stream.Hub
+	// classifies requests by user agent as bot traffic, nil when bots are not told apart
+	bots *bots.Classifier
+	// delivers short URL events to webhooks, nil when webhooks are not delivered
+	webhooks *webhooks.Dispatcher
+}
+
+/*
+	Returns a server using the provided configuration, log file, database, cache and counter.
+*/
+func newServer(config config.Configuration, f *os.File, store model.Store, cacheClient *redis.Client, cnt *util.Counter) *server {
+	return &server{
+		config:      config,
+		f:           f,
+		store:       store,
+		cacheClient: cacheClient,
+		cnt:         cnt,
+		lockout:     limiter.NewLockout(config.PasswordMaxFailures, config.PasswordLockoutMinutes*time.Minute),
+		jobs:        newJobRegistry(),
+	}
+}
+
 /*
 	Main function for the application. Contains all of the logic required to load configuration, start logging, and start the API.
 */
@@ -41,25 +98,94 @@ func Start() {
 
 	log.Printf("Starting server...")
 
+	// connect to the configured database
+	var store model.Store
+	var dbClient *mongo.Client
+	switch config.DBDriver {
+	case "memory":
+		store = model.NewMemoryStore(f, config.DebugMode)
+	case "bolt":
+		// the connection string is the path to the embedded database file
+		boltStore, err := model.OpenBoltStore(f, config.DebugMode, config.DBConnString)
+		if err != nil {
+			log.Fatalf("Error opening database file (%v)", err)
+		}
+		store = boltStore
+	default:
+		dbClient = model.GetDBClient(config.DBConnString)
+		// bootstrap the indexes the application relies on, including the unique slug index
+		if err := model.EnsureIndexes(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		if _, err := model.BackfillUrlDomains(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient); err != nil {
+			log.Fatalf("Error backfilling URL domains (%v)", err)
+		}
+		if err := model.EnsureApiKeyIndexes(f, config.DebugMode, config.DBDatabase, config.DBKeysCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		if err := model.EnsureEventIndexes(f, config.DebugMode, config.DBDatabase, config.DBEventsCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		if err := model.EnsureRollupIndexes(f, config.DebugMode, config.DBDatabase, config.DBRollupsCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		if err := model.EnsureWebhookIndexes(f, config.DebugMode, config.DBDatabase, config.DBWebhooksCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		if err := model.EnsureDeliveryIndexes(f, config.DebugMode, config.DBDatabase, config.DBDeliveriesCollection, dbClient); err != nil {
+			log.Fatalf("Error creating database indexes (%v)", err)
+		}
+		store = &model.MongoStore{
+			F:                    f,
+			Debug:                config.DebugMode,
+			DB:                   config.DBDatabase,
+			Collection:           config.DBCollection,
+			KeysCollection:       config.DBKeysCollection,
+			EventsCollection:     config.DBEventsCollection,
+			RollupsCollection:    config.DBRollupsCollection,
+			WebhooksCollection:   config.DBWebhooksCollection,
+			DeliveriesCollection: config.DBDeliveriesCollection,
+			Client:               dbClient,
+		}
+	}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	// pick how new counter ranges are reserved
+	// imported slugs are only reserved up to 10 ranges ahead, further ones are left to the unique slug index
+	cnt := util.Counter{ReserveWindow: 10 * config.CounterBlockSize}
+	switch config.CounterAllocator {
+	case "file":
+		cnt.Allocator = &util.FileRangeAllocator{
+			FileName:  config.CounterAllocFile,
+			Start:     config.CounterStart,
+			BlockSize: config.CounterBlockSize,
+		}
+	case "mongo":
+		cnt.Allocator = &model.MongoRangeAllocator{
+			Client:     dbClient,
+			DB:         config.DBDatabase,
+			Collection: config.DBCountersCollection,
+			Name:       config.DBCollection,
+			Start:      config.CounterStart,
+			BlockSize:  config.CounterBlockSize,
+		}
+	}
+
 	// if counterFile exists, used the saved range
 	// if not, get a new range
-	cnt := util.Counter{}
 	if util.FileExists(config.CounterFile) {
 		cnt.LoadCounterRange(f, config.DebugMode, config.CounterFile)
-	} else {
-		cnt.GetNewRange(f, config.DebugMode)
+	} else if err := cnt.GetNewRange(f, config.DebugMode); err != nil {
+		log.Fatalf("Error reserving initial counter range (%v)", err)
 	}
 	// save counter range to file on non-fatal exit
 	defer cnt.SaveCounterRange(f, config.DebugMode, config.CounterFile)
 
-	dbClient := model.GetDBClient(config.DBConnString)
-	// close the database connection before exit
-	defer func() {
-		if err := dbClient.Disconnect(context.TODO()); err != nil {
-			panic(err)
-		}
-	}()
-
 	cacheClient := cache.GetCacheClient(config.CacheHost, config.CachePort, config.CacheDB, config.CachePass)
 
 	if config.DebugMode {
@@ -67,250 +193,99 @@ func Start() {
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
-	router.SetTrustedProxies(nil)
-
-	// ping health check
-	router.GET("/v1/ping", func(gc *gin.Context) {
-		gc.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"message": "pong",
-		})
-	})
-
-	// create new short URL
-	router.POST("/v1/urls", func(gc *gin.Context) {
-		url := model.Url{}
-		if err := gc.ShouldBindJSON(&url); err != nil {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Error parsing URL for shortening.",
-			})
-			return
-		}
-
-		// check if target URL is provided
-		if url.Target == "" {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Missing URL for shortening.",
-			})
-			return
+	s := newServer(config, f, store, cacheClient, &cnt)
+
+	// count unique visitors and trending short URLs in Redis when the cache is enabled, and in memory otherwise
+	if config.CacheEnabled {
+		visitorsClient := cache.GetCacheClient(config.CacheHost, config.CachePort, config.VisitorsCacheDB, config.CachePass)
+		s.visitors = &visitors.RedisCounter{
+			F:         f,
+			Debug:     config.DebugMode,
+			Client:    visitorsClient,
+			Retention: config.VisitorsRetentionDays * 24 * time.Hour,
 		}
-		// check if target URL is valid
-		if !util.IsValidUrl(url.Target) {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid URL for shortening.",
-			})
-			return
-		}
-
-		url.Slug = util.GenerateUrlSlug(f, config.DebugMode, &cnt)
-		url.Created = uint64(time.Now().Unix())
-		url.Hits = 1
-
-		err := model.InsertUrl(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, url)
-		if err != nil {
-			gc.JSON(http.StatusServiceUnavailable, gin.H{
-				"status":  http.StatusServiceUnavailable,
-				"message": "Error creating new short URL.",
-			})
-			return
-		}
-
-		if config.CacheEnabled {
-			cache.SetCachedUrl(f, config.DebugMode, config.CacheExpireHours, cacheClient, url)
-		}
-
-		gc.JSON(http.StatusCreated, gin.H{
-			"status":  http.StatusCreated,
-			"message": "success",
-			"urls":    url,
-		})
-	})
-
-	// get target URL from slug
-	router.GET("/v1/urls/:slug", func(gc *gin.Context) {
-		slug := gc.Param("slug")
+		s.trending = &trending.RedisTracker{F: f, Debug: config.DebugMode, Client: visitorsClient}
+	} else {
+		s.visitors = visitors.NewMemoryCounter(config.VisitorsRetentionDays * 24 * time.Hour)
+		s.trending = trending.NewMemoryTracker()
+	}
 
-		// verify provided slug
-		if !util.IsValidSlug(config.MaxSlugLen, slug) {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid short URL provided.",
-			})
-			return
-		}
+	// record click events in the background, queued events are written before the database connection closes
+	if config.EventsEnabled {
+		s.clickEvents = events.NewWriter(f, config.DebugMode, store, config.EventsQueueSize, config.EventsBatchSize, config.EventsFlushSeconds*time.Second)
+		defer s.clickEvents.Close()
+	}
 
-		// check cache (if enabled) for the provided slug
-		// if found, return
-		// if not found, continue on to check the database
-		if config.CacheEnabled {
-			url, _ := cache.GetCachedUrl(f, config.DebugMode, cacheClient, slug)
-			if url.Target != "" {
-				// update the hit count for the given short URL
-				err := model.UpdateUrlHits(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, slug)
-				if err != nil {
-					log.Printf("Error updating hits for URL from cache (slug: %v) (%v)", slug, err)
-				}
+	// stream events to subscribers, open streams are ended before the server shuts down so they do not hold it up
+	s.hub = stream.NewHub(config.StreamBufferSize, config.StreamMaxSubscribers)
 
-				gc.JSON(http.StatusOK, gin.H{
-					"status":  http.StatusOK,
-					"message": "success",
-					"urls":    url,
-				})
-				return
-			}
+	// deliver events to webhooks, queued events are stored as deliveries before the database connection closes
+	retry := webhooks.Retry{
+		MaxAttempts: config.WebhooksMaxAttempts,
+		Backoff:     config.WebhooksBackoffSeconds * time.Second,
+		MaxBackoff:  config.WebhooksMaxBackoffMinutes * time.Minute,
+	}
+	if config.WebhooksAllowPrivate {
+		log.Printf("Webhooks are allowed to use http and reach loopback, private and link-local addresses (webhooksAllowPrivate), do not enable this in production")
+	}
+	s.webhooks = webhooks.NewDispatcher(f, config.DebugMode, store, config.WebhooksQueueSize, retry, config.WebhooksTimeoutSeconds*time.Second, config.WebhooksPollSeconds*time.Second, config.WebhooksAllowPrivate)
+	defer s.webhooks.Close()
+
+	// count hits in memory and write them in batches, pending hits are written before the database connection closes
+	s.hits = hits.NewBatcher(f, config.DebugMode, store, config.HitsMaxPending, config.HitsFlushSeconds*time.Second)
+	defer s.hits.Close()
+
+	// load the keys used to verify bearer tokens, file based keys are reloaded periodically to pick up rotations
+	refreshDone := make(chan struct{})
+	defer close(refreshDone)
+	if config.JWTEnabled {
+		var keys jwt.KeySource
+		var err error
+		switch config.JWTKeySource {
+		case "pem":
+			keys, err = jwt.NewPEMKeySource(config.JWTKeyFile)
+		case "jwks":
+			keys, err = jwt.NewJWKSKeySource(config.JWTKeyFile)
+		case "hmac":
+			keys = &jwt.HMACKeySource{Secret: []byte(config.JWTSecret)}
 		}
-
-		// check database for the provided slug
-		url, err := model.GetUrl(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, slug)
 		if err != nil {
-			gc.JSON(http.StatusNotFound, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Short URL not found.",
-			})
-		} else {
-			// update the hit count for the given short URL
-			err := model.UpdateUrlHits(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, slug)
-			if err != nil {
-				log.Printf("Error updating hits for URL (slug: %v) (%v)", slug, err)
-			}
-
-			// URL is not in cache, so add it
-			if config.CacheEnabled {
-				_ = cache.SetCachedUrl(f, config.DebugMode, config.CacheExpireHours, cacheClient, url)
-			}
-
-			gc.JSON(http.StatusOK, gin.H{
-				"status":  http.StatusOK,
-				"message": "success",
-				"urls":    url,
-			})
+			log.Fatalf("Error loading JWT keys (%v)", err)
 		}
-	})
-
-	// get all URLs
-	router.GET("/v1/urls", func(gc *gin.Context) {
-		urls, err := model.GetUrls(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient)
-		if err != nil {
-			gc.JSON(http.StatusNotFound, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Error retrieving all URLs.",
-			})
-		} else {
-			gc.JSON(http.StatusOK, gin.H{
-				"status":  http.StatusOK,
-				"message": "success",
-				"urls":    urls,
-			})
+		s.verifier = &jwt.Verifier{
+			Keys:     keys,
+			Issuer:   config.JWTIssuer,
+			Audience: config.JWTAudience,
+			Leeway:   config.JWTLeewaySeconds * time.Second,
 		}
-	})
-
-	// update target URL from slug
-	router.PUT("/v1/urls/:slug", func(gc *gin.Context) {
-		slug := gc.Param("slug")
-		// verify provided slug
-		if !util.IsValidSlug(config.MaxSlugLen, slug) {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid short URL provided.",
-			})
-			return
+		if fileKeys, ok := keys.(*jwt.FileKeySource); ok {
+			go s.refreshJwtKeys(fileKeys, refreshDone)
 		}
+	}
 
-		url := model.Url{}
-		if err := gc.ShouldBindJSON(&url); err != nil {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Error parsing URL for updating.",
-			})
-			return
-		}
-
-		// check if target URL is provided
-		if url.Target == "" {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Missing URL for updating.",
-			})
-			return
-		}
-		// check if target URL is valid
-		if !util.IsValidUrl(url.Target) {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid URL for updating.",
-			})
-			return
-		}
-
-		url.Slug = slug
-		url.Created = uint64(time.Now().Unix())
-		url.Hits = 1
-
-		// update record in cache if it exists
-		if config.CacheEnabled {
-			cache.SetCachedUrl(f, config.DebugMode, config.CacheExpireHours, cacheClient, url)
-		}
-
-		// update record in database
-		err := model.UpdateUrl(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, url)
-		if err != nil {
-			gc.JSON(http.StatusServiceUnavailable, gin.H{
-				"status":  http.StatusServiceUnavailable,
-				"message": "Error updating URL record.",
-			})
-			return
-		}
-
-		gc.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"message": "success",
-			"urls":    url,
-		})
-	})
-
-	// delete short URL by slug
-	router.DELETE("/v1/urls/:slug", func(gc *gin.Context) {
-		slug := gc.Param("slug")
-		// verify provided slug
-		if !util.IsValidSlug(config.MaxSlugLen, slug) {
-			gc.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid short URL provided.",
-			})
-			return
-		}
+	// tell bots apart from people by user agent, a configured rule file is reloaded periodically to pick up new rules
+	classifier, err := bots.NewClassifier(config.BotRulesFile)
+	if err != nil {
+		log.Fatalf("Error loading bot rules (%v)", err)
+	}
+	s.bots = classifier
+	if config.BotRulesFile != "" {
+		go s.refreshBotRules(refreshDone)
+	}
 
-		// delete URL from cache (if enabled)
-		if config.CacheEnabled {
-			cache.DeleteCachedUrl(f, config.DebugMode, cacheClient, slug)
-		}
+	router := s.router()
 
-		// delete URL from database
-		err := model.DeleteUrl(f, config.DebugMode, config.DBDatabase, config.DBCollection, dbClient, slug)
-		if err != nil {
-			gc.JSON(http.StatusNotFound, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Short URL not found.",
-			})
-		} else {
-			gc.JSON(http.StatusOK, gin.H{
-				"status":  http.StatusOK,
-				"message": "success",
-			})
-		}
-	})
+	// periodically remove expired short URLs
+	purgeDone := make(chan struct{})
+	defer close(purgeDone)
+	go s.purgeExpiredUrls(purgeDone)
 
-	// catch all default route
-	router.NoRoute(func(gc *gin.Context) {
-		gc.JSON(http.StatusNotFound, gin.H{
-			"status":  http.StatusNotFound,
-			"message": "Invalid route.",
-		})
-	})
+	// periodically roll up click events for the statistics API
+	rollupDone := make(chan struct{})
+	defer close(rollupDone)
+	if config.EventsEnabled {
+		go s.rollUpClickEvents(rollupDone)
+	}
 
 	srv := &http.Server{
 		Addr:      fmt.Sprintf(":%v", config.GinPort),
@@ -335,6 +310,7 @@ func Start() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	s.hub.Close()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Error shutting down server (%v)", err)
 	}
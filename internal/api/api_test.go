@@ -0,0 +1,1776 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/bots"
+	"example.com/url-shortener/internal/config"
+	"example.com/url-shortener/internal/events"
+	"example.com/url-shortener/internal/hits"
+	"example.com/url-shortener/internal/importer"
+	"example.com/url-shortener/internal/jwt"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stats"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/trending"
+	"example.com/url-shortener/internal/util"
+	"example.com/url-shortener/internal/visitors"
+	"example.com/url-shortener/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Returns a server backed by the in-memory store with the cache disabled, so no external services are needed.
+*/
+func newTestServer(t *testing.T, testLog string) *server {
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(testLog)
+	})
+
+	gin.SetMode(gin.TestMode)
+	cnt := util.Counter{}
+	cnt.GetNewRange(f, true)
+	config := config.Configuration{
+		DebugMode:              true,
+		MaxSlugLen:             7,
+		BatchMaxSize:           5,
+		ImportMaxRows:          10,
+		VanitySlugPattern:      "^[A-Za-z0-9][A-Za-z0-9-]{0,29}$",
+		VanitySlugRegexp:       regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9-]{0,29}$"),
+		ReservedSlugs:          []string{"v1", "api", "admin", "health"},
+		RedirectCode:           http.StatusFound,
+		PasswordMaxFailures:    3,
+		PasswordLockoutMinutes: 15,
+	}
+	return newServer(config, f, model.NewMemoryStore(f, true), nil, &cnt)
+}
+
+/*
+	Sends a request to the router and returns the recorded response.
+*/
+func doRequest(router http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+/*
+	Sends a request authenticated with the provided API key to the router and returns the recorded response.
+*/
+func doKeyRequest(router http.Handler, method string, path string, body string, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", key)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+/*
+	Creates a short URL through the API and returns it.
+*/
+func createTestUrl(t *testing.T, router http.Handler, body string) model.Url {
+	w := doRequest(router, http.MethodPost, "/v1/urls", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("FAILED creating short URL. Expected: %v, got: %v (%v)", http.StatusCreated, w.Code, w.Body.String())
+	}
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("FAILED decoding created short URL. Expected: nil error, got: %v", err)
+	}
+	return response.Urls
+}
+
+/*
+	Tests creating, getting, updating and deleting a short URL
+*/
+func TestUrlLifecycle(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestUrlLifecycle.log")
+	router := s.router()
+
+	url := createTestUrl(t, router, `{"target":"https://www.google.com"}`)
+	if url.Slug != "4C92" {
+		t.Errorf("FAILED creating short URL. Expected: 4C92, got: %v", url.Slug)
+	}
+
+	w := doRequest(router, http.MethodGet, "/v1/urls/"+url.Slug, "")
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED getting short URL. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+
+	w = doRequest(router, http.MethodPut, "/v1/urls/"+url.Slug, `{"target":"https://www.reddit.com"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED updating short URL. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+	w = doRequest(router, http.MethodPut, "/v1/urls/missing", `{"target":"https://www.reddit.com"}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED updating missing short URL. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+
+	w = doRequest(router, http.MethodDelete, "/v1/urls/"+url.Slug, "")
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED deleting short URL. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/v1/urls/"+url.Slug, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED getting deleted short URL. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	} else {
+		t.Logf("PASSED short URL lifecycle. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+}
+
+/*
+	Tests the public redirect route
+*/
+func TestRedirect(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestRedirect.log")
+	router := s.router()
+	url := createTestUrl(t, router, `{"target":"https://www.google.com"}`)
+
+	w := doRequest(router, http.MethodGet, "/"+url.Slug, "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://www.google.com" {
+		t.Errorf("FAILED redirecting short URL. Expected: 302/https://www.google.com, got: %v/%v", w.Code, w.Header().Get("Location"))
+	} else {
+		t.Logf("PASSED redirecting short URL. Expected: 302/https://www.google.com, got: %v/%v", w.Code, w.Header().Get("Location"))
+	}
+
+	w = doRequest(router, http.MethodGet, "/missing", "")
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("FAILED redirecting missing short URL. Expected: 404/text/html, got: %v/%v", w.Code, w.Header().Get("Content-Type"))
+	} else {
+		t.Logf("PASSED redirecting missing short URL. Expected: 404/text/html, got: %v/%v", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+/*
+	Tests creating vanity short URLs
+*/
+func TestVanitySlug(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestVanitySlug.log")
+	router := s.router()
+
+	url := createTestUrl(t, router, `{"slug":"spring-sale","target":"https://www.google.com"}`)
+	if url.Slug != "spring-sale" {
+		t.Errorf("FAILED creating vanity short URL. Expected: spring-sale, got: %v", url.Slug)
+	}
+	w := doRequest(router, http.MethodGet, "/spring-sale", "")
+	if w.Code != http.StatusFound {
+		t.Errorf("FAILED redirecting vanity short URL. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+
+	// test a vanity slug that is already taken
+	w = doRequest(router, http.MethodPost, "/v1/urls", `{"slug":"spring-sale","target":"https://www.google.com"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("FAILED creating taken vanity short URL. Expected: %v, got: %v", http.StatusConflict, w.Code)
+	}
+
+	// test a reserved vanity slug
+	w = doRequest(router, http.MethodPost, "/v1/urls", `{"slug":"Admin","target":"https://www.google.com"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED creating reserved vanity short URL. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+
+	// static routes under /v1/urls/ are reserved even if the configuration does not list them
+	for _, slug := range staticUrlSegments {
+		w = doRequest(router, http.MethodPost, "/v1/urls", `{"slug":"`+slug+`","target":"https://www.google.com"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED creating vanity short URL of static route %v. Expected: %v, got: %v", slug, http.StatusBadRequest, w.Code)
+		} else {
+			t.Logf("PASSED creating vanity short URL of static route %v. Expected: %v, got: %v", slug, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	// a vanity slug matching the next counter value should be skipped by generated slugs
+	createTestUrl(t, router, `{"slug":"4C92","target":"https://www.google.com"}`)
+	url = createTestUrl(t, router, `{"target":"https://www.google.com"}`)
+	if url.Slug != "4C93" {
+		t.Errorf("FAILED skipping taken slug. Expected: 4C93, got: %v", url.Slug)
+	} else {
+		t.Logf("PASSED skipping taken slug. Expected: 4C93, got: %v", url.Slug)
+	}
+
+	// running out of attempts on taken generated slugs is a server error, not a conflict
+	for _, slug := range []string{"4C94", "4C95", "4C96", "4C97", "4C98", "4C99", "4C9A", "4C9B", "4C9C", "4C9D"} {
+		s.store.InsertUrl(model.Url{Slug: slug, Target: "https://www.google.com"})
+	}
+	w = doRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("FAILED running out of generated slugs. Expected: %v, got: %v", http.StatusServiceUnavailable, w.Code)
+	} else {
+		t.Logf("PASSED running out of generated slugs. Expected: %v, got: %v", http.StatusServiceUnavailable, w.Code)
+	}
+
+	// reserved slugs are never generated
+	s.config.ReservedSlugs = append(s.config.ReservedSlugs, "4C9E")
+	url = createTestUrl(t, router, `{"target":"https://www.google.com"}`)
+	if url.Slug != "4C9F" {
+		t.Errorf("FAILED skipping reserved slug. Expected: 4C9F, got: %v", url.Slug)
+	} else {
+		t.Logf("PASSED skipping reserved slug. Expected: 4C9F, got: %v", url.Slug)
+	}
+}
+
+/*
+	Tests that expired short URLs are no longer resolved
+*/
+func TestExpiredUrl(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestExpiredUrl.log")
+	router := s.router()
+
+	url := createTestUrl(t, router, `{"target":"https://www.google.com","ttlSeconds":3600}`)
+	if url.ExpiresAt <= url.Created {
+		t.Errorf("FAILED setting expiry from ttlSeconds. Expected: after %v, got: %v", url.Created, url.ExpiresAt)
+	}
+
+	// browsers cache the redirect no longer than the short URL resolves
+	s.config.RedirectMaxAge = 86400
+	w := doRequest(router, http.MethodGet, "/"+url.Slug, "")
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "private, max-age=3600" && cacheControl != "private, max-age=3599" {
+		t.Errorf("FAILED capping redirect cache at expiry. Expected: private, max-age=3600, got: %v", cacheControl)
+	} else {
+		t.Logf("PASSED capping redirect cache at expiry. Expected: private, max-age=3600, got: %v", cacheControl)
+	}
+
+	// test providing both expiry fields
+	w = doRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","ttlSeconds":3600,"expiresAt":4102444800}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED creating short URL with both expiry fields. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+
+	// expire the URL directly in the store, as the API rejects expiry times in the past
+	expired := model.Url{Slug: "expired", Target: "https://www.google.com", ExpiresAt: 100}
+	s.store.InsertUrl(expired)
+	w = doRequest(router, http.MethodGet, "/v1/urls/expired", "")
+	if w.Code != http.StatusGone {
+		t.Errorf("FAILED getting expired short URL. Expected: %v, got: %v", http.StatusGone, w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/expired", "")
+	if w.Code != http.StatusGone {
+		t.Errorf("FAILED redirecting expired short URL. Expected: %v, got: %v", http.StatusGone, w.Code)
+	} else {
+		t.Logf("PASSED redirecting expired short URL. Expected: %v, got: %v", http.StatusGone, w.Code)
+	}
+
+	// the expiry and hit limit can be removed by an update, but not set and cleared at once
+	w = doRequest(router, http.MethodPut, "/v1/urls/"+url.Slug, `{"target":"https://www.google.com","clearExpiry":true,"ttlSeconds":60}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED setting and clearing expiry. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+	doRequest(router, http.MethodPut, "/v1/urls/"+url.Slug, `{"target":"https://www.google.com","maxHits":5}`)
+	w = doRequest(router, http.MethodPut, "/v1/urls/"+url.Slug, `{"target":"https://www.google.com","clearExpiry":true,"clearMaxHits":true}`)
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Urls.ExpiresAt != 0 || response.Urls.MaxHits != 0 {
+		t.Errorf("FAILED clearing expiry and hit limit. Expected: 200 0/0, got: %v %v/%v", w.Code, response.Urls.ExpiresAt, response.Urls.MaxHits)
+	} else {
+		t.Logf("PASSED clearing expiry and hit limit. Expected: 200 0/0, got: %v %v/%v", w.Code, response.Urls.ExpiresAt, response.Urls.MaxHits)
+	}
+}
+
+/*
+	Tests that short URLs with a hit limit stop resolving once it is reached
+*/
+func TestMaxHits(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestMaxHits.log")
+	s.config.RedirectCode = http.StatusMovedPermanently
+	router := s.router()
+	url := createTestUrl(t, router, `{"target":"https://www.google.com","maxHits":2}`)
+
+	// browsers must not reuse the redirect, or the hit limit would never be enforced for them
+	for i := 0; i < 2; i++ {
+		w := doRequest(router, http.MethodGet, "/"+url.Slug, "")
+		if w.Code != http.StatusFound || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("FAILED redirecting short URL with hits left. Expected: %v no-store, got: %v %v", http.StatusFound, w.Code, w.Header().Get("Cache-Control"))
+		} else {
+			t.Logf("PASSED redirecting short URL with hits left. Expected: %v no-store, got: %v %v", http.StatusFound, w.Code, w.Header().Get("Cache-Control"))
+		}
+	}
+	w := doRequest(router, http.MethodGet, "/"+url.Slug, "")
+	if w.Code != http.StatusGone {
+		t.Errorf("FAILED redirecting short URL without hits left. Expected: %v, got: %v", http.StatusGone, w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/v1/urls/"+url.Slug, "")
+	if w.Code != http.StatusGone {
+		t.Errorf("FAILED getting short URL without hits left. Expected: %v, got: %v", http.StatusGone, w.Code)
+	} else {
+		t.Logf("PASSED getting short URL without hits left. Expected: %v, got: %v", http.StatusGone, w.Code)
+	}
+}
+
+/*
+	Tests resolving password protected short URLs, including the lockout after repeated failures
+*/
+func TestProtectedUrl(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestProtectedUrl.log")
+	router := s.router()
+	url := createTestUrl(t, router, `{"target":"https://www.google.com","password":"hunter2"}`)
+	if !url.Protected || url.Password != "" || url.PasswordHash != "" {
+		t.Errorf("FAILED creating protected short URL. Expected: protected without password, got: %v/%v/%v", url.Protected, url.Password, url.PasswordHash)
+	}
+
+	w := doRequest(router, http.MethodGet, "/v1/urls/"+url.Slug, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED getting protected short URL without password. Expected: %v, got: %v", http.StatusUnauthorized, w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/v1/urls/"+url.Slug, `{"password":"hunter2"}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "passwordHash") {
+		t.Errorf("FAILED getting protected short URL with password. Expected: %v, got: %v (%v)", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// the redirect route shows a form, which posts the password back
+	w = doRequest(router, http.MethodGet, "/"+url.Slug, "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "<form") {
+		t.Errorf("FAILED showing password form. Expected: %v, got: %v", http.StatusUnauthorized, w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/"+url.Slug, strings.NewReader("password=hunter2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://www.google.com" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("FAILED redirecting after password form. Expected: 303/https://www.google.com/no-store, got: %v/%v/%v", w.Code, w.Header().Get("Location"), w.Header().Get("Cache-Control"))
+	}
+
+	// three wrong passwords lock the client out, even for the right password
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/v1/urls/"+url.Slug, nil)
+		req.Header.Set("X-Url-Password", "wrong")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/v1/urls/"+url.Slug, nil)
+	req.Header.Set("X-Url-Password", "hunter2")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("FAILED locking out client after wrong passwords. Expected: %v, got: %v", http.StatusTooManyRequests, w.Code)
+	} else {
+		t.Logf("PASSED locking out client after wrong passwords. Expected: %v, got: %v", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+/*
+	Tests API key management, authentication and ownership of short URLs
+*/
+func TestApiKeys(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestApiKeys.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	router := s.router()
+
+	w := doRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("FAILED creating short URL without API key. Expected: %v, got: %v", http.StatusUnauthorized, w.Code)
+	}
+
+	// the admin API key creates two regular API keys
+	keys := []string{}
+	for _, name := range []string{"marketing", "support"} {
+		w = doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"`+name+`"}`, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusCreated || response.ApiKey == "" {
+			t.Fatalf("FAILED creating API key. Expected: %v, got: %v (%v)", http.StatusCreated, w.Code, w.Body.String())
+		}
+		keys = append(keys, response.ApiKey)
+	}
+	w = doKeyRequest(router, http.MethodGet, "/v1/keys", "", keys[0])
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED listing API keys without admin API key. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	w = doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`, keys[0])
+	if w.Code != http.StatusCreated {
+		t.Fatalf("FAILED creating short URL with API key. Expected: %v, got: %v", http.StatusCreated, w.Code)
+	}
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	slug := response.Urls.Slug
+
+	// only the owner sees and modifies the short URL
+	w = doKeyRequest(router, http.MethodDelete, "/v1/urls/"+slug, "", keys[1])
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED deleting short URL of another API key. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+	w = doKeyRequest(router, http.MethodGet, "/v1/urls", "", keys[1])
+	if strings.Contains(w.Body.String(), slug) {
+		t.Errorf("FAILED listing short URLs of another API key. Expected: no %v, got: %v", slug, w.Body.String())
+	}
+
+	// redirects stay public
+	w = doRequest(router, http.MethodGet, "/"+slug, "")
+	if w.Code != http.StatusFound {
+		t.Errorf("FAILED redirecting short URL without API key. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+
+	w = doKeyRequest(router, http.MethodDelete, "/v1/urls/"+slug, "", keys[0])
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED deleting own short URL. Expected: %v, got: %v", http.StatusOK, w.Code)
+	} else {
+		t.Logf("PASSED deleting own short URL. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestRoles.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	router := s.router()
+
+	w := doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"viewer","role":"superuser"}`, "admin-secret")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED creating API key with unknown role. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+
+	// new API keys default to the editor role
+	w = doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"viewer"}`, "admin-secret")
+	response := struct {
+		Keys   model.ApiKey `json:"keys"`
+		ApiKey string       `json:"apiKey"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusCreated || response.Keys.Role != "editor" {
+		t.Fatalf("FAILED creating API key. Expected: %v editor, got: %v %v", http.StatusCreated, w.Code, response.Keys.Role)
+	}
+	key := response.ApiKey
+
+	w = doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`, key)
+	if w.Code != http.StatusCreated {
+		t.Errorf("FAILED creating short URL as editor. Expected: %v, got: %v", http.StatusCreated, w.Code)
+	}
+	w = doKeyRequest(router, http.MethodDelete, "/v1/cache", "", key)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED flushing cache as editor. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	// only admins assign roles
+	w = doKeyRequest(router, http.MethodPut, "/v1/keys/"+response.Keys.ID+"/role", `{"role":"admin"}`, key)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED assigning role as editor. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+	w = doKeyRequest(router, http.MethodPut, "/v1/keys/MISSING/role", `{"role":"readonly"}`, "admin-secret")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED assigning role to missing API key. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+	w = doKeyRequest(router, http.MethodPut, "/v1/keys/"+response.Keys.ID+"/role", `{"role":"readonly"}`, "admin-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("FAILED assigning role. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+
+	// read-only API keys cannot create or list short URLs
+	w = doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`, key)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED creating short URL as read-only. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+	w = doKeyRequest(router, http.MethodGet, "/v1/urls", "", key)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED listing short URLs as read-only. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	w = doKeyRequest(router, http.MethodDelete, "/v1/cache", "", "admin-secret")
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED flushing cache as admin. Expected: %v, got: %v", http.StatusOK, w.Code)
+	} else {
+		t.Logf("PASSED flushing cache as admin. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+}
+
+/*
+	Returns an HS256 token with the provided claims, signed with the provided secret.
+*/
+func signTestToken(claims map[string]interface{}, secret string) string {
+	h, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/*
+	Sends a request authenticated with the provided bearer token to the router and returns the recorded response.
+*/
+func doTokenRequest(router http.Handler, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestJwtAuth(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestJwtAuth.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	s.config.JWTDefaultRole = "editor"
+	s.verifier = &jwt.Verifier{Keys: &jwt.HMACKeySource{Secret: []byte("jwt-secret")}, Audience: "url-shortener"}
+	router := s.router()
+
+	alice := signTestToken(map[string]interface{}{"sub": "alice", "tenant": "acme", "aud": "url-shortener"}, "jwt-secret")
+	w := doTokenRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com"}`, alice)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("FAILED creating short URL with token. Expected: %v, got: %v (%v)", http.StatusCreated, w.Code, w.Body.String())
+	}
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Urls.Owner != "jwt:alice" || response.Urls.Tenant != "acme" {
+		t.Errorf("FAILED mapping token claims to owner. Expected: jwt:alice acme, got: %v %v", response.Urls.Owner, response.Urls.Tenant)
+	} else {
+		t.Logf("PASSED mapping token claims to owner. Expected: jwt:alice acme, got: %v %v", response.Urls.Owner, response.Urls.Tenant)
+	}
+	slug := response.Urls.Slug
+
+	// the same subject in another tenant does not own the short URL
+	otherTenant := signTestToken(map[string]interface{}{"sub": "alice", "tenant": "globex", "aud": "url-shortener"}, "jwt-secret")
+	w = doTokenRequest(router, http.MethodDelete, "/v1/urls/"+slug, "", otherTenant)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("FAILED deleting short URL from another tenant. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		code   int
+	}{
+		{"wrong secret", signTestToken(map[string]interface{}{"sub": "alice", "aud": "url-shortener"}, "other-secret"), http.MethodGet, "/v1/urls", http.StatusUnauthorized},
+		{"wrong audience", signTestToken(map[string]interface{}{"sub": "alice", "aud": "billing"}, "jwt-secret"), http.MethodGet, "/v1/urls", http.StatusUnauthorized},
+		{"missing subject", signTestToken(map[string]interface{}{"aud": "url-shortener"}, "jwt-secret"), http.MethodGet, "/v1/urls", http.StatusUnauthorized},
+		{"read-only role", signTestToken(map[string]interface{}{"sub": "bob", "aud": "url-shortener", "roles": []string{"readonly"}}, "jwt-secret"), http.MethodGet, "/v1/urls", http.StatusForbidden},
+		{"unknown role", signTestToken(map[string]interface{}{"sub": "bob", "aud": "url-shortener", "roles": "billing-admin"}, "jwt-secret"), http.MethodGet, "/v1/urls", http.StatusForbidden},
+		{"admin role", signTestToken(map[string]interface{}{"sub": "carol", "aud": "url-shortener", "roles": []string{"readonly", "admin"}}, "jwt-secret"), http.MethodGet, "/v1/keys", http.StatusOK},
+	}
+	for _, test := range tests {
+		w = doTokenRequest(router, test.method, test.path, "", test.token)
+		if w.Code != test.code {
+			t.Errorf("FAILED requesting with %v token. Expected: %v, got: %v", test.name, test.code, w.Code)
+		} else {
+			t.Logf("PASSED requesting with %v token. Expected: %v, got: %v", test.name, test.code, w.Code)
+		}
+	}
+
+	// API keys keep working next to tokens
+	w = doKeyRequest(router, http.MethodDelete, "/v1/urls/"+slug, "", "admin-secret")
+	if w.Code != http.StatusOK {
+		t.Errorf("FAILED deleting short URL with admin API key. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+
+	// a token subject never matches the owner recorded for the admin API key
+	w = doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"admin-link"}`, "admin-secret")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("FAILED creating short URL with admin API key. Expected: %v, got: %v", http.StatusCreated, w.Code)
+	}
+	impostor := signTestToken(map[string]interface{}{"sub": "admin", "aud": "url-shortener"}, "jwt-secret")
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if w := doTokenRequest(router, method, "/v1/urls/admin-link", `{"target":"https://www.reddit.com"}`, impostor); w.Code != http.StatusForbidden {
+			t.Errorf("FAILED modifying admin short URL with token subject admin (%v). Expected: %v, got: %v", method, http.StatusForbidden, w.Code)
+		} else {
+			t.Logf("PASSED modifying admin short URL with token subject admin (%v). Expected: %v, got: %v", method, http.StatusForbidden, w.Code)
+		}
+	}
+	if w := doRequest(router, http.MethodGet, "/admin-link", ""); w.Code != http.StatusFound || w.Header().Get("Location") != "https://www.google.com" {
+		t.Errorf("FAILED keeping admin short URL. Expected: %v https://www.google.com, got: %v %v", http.StatusFound, w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestListUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestListUrls.log")
+	router := s.router()
+
+	for i := 0; i < 5; i++ {
+		createTestUrl(t, router, `{"target":"https://www.google.com","tags":["search"," search "]}`)
+		createTestUrl(t, router, `{"target":"https://news.example.com/story","tags":["news"]}`)
+	}
+	w := doRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","tags":["a,b"]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED creating short URL with invalid tag. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+
+	type listResponse struct {
+		Urls       []model.Url `json:"urls"`
+		NextCursor string      `json:"nextCursor"`
+		Total      int64       `json:"total"`
+	}
+
+	// page through the search URLs, 2 at a time
+	seen := map[string]bool{}
+	path := "/v1/urls?tags=search&limit=2&sort=created&order=desc"
+	for pages := 0; pages < 5; pages++ {
+		w = doRequest(router, http.MethodGet, path, "")
+		response := listResponse{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusOK || response.Total != 5 {
+			t.Fatalf("FAILED listing short URLs. Expected: %v with total 5, got: %v (%v)", http.StatusOK, w.Code, w.Body.String())
+		}
+		for _, url := range response.Urls {
+			if len(url.Tags) != 1 || url.Tags[0] != "search" {
+				t.Errorf("FAILED listing short URLs by tag. Expected: [search], got: %v", url.Tags)
+			}
+			seen[url.Slug] = true
+		}
+		if response.NextCursor == "" {
+			break
+		}
+		path = "/v1/urls?tags=search&limit=2&sort=created&order=desc&cursor=" + response.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Errorf("FAILED paging through short URLs. Expected: 5, got: %v", len(seen))
+	} else {
+		t.Logf("PASSED paging through short URLs. Expected: 5, got: %v", len(seen))
+	}
+
+	w = doRequest(router, http.MethodGet, "/v1/urls?domain=NEWS.example.com", "")
+	response := listResponse{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Total != 5 || response.NextCursor != "" {
+		t.Errorf("FAILED listing short URLs by domain. Expected: 5, got: %v (%v)", response.Total, w.Body.String())
+	}
+
+	for _, query := range []string{"limit=0", "limit=5000", "sort=target", "order=up", "createdFrom=yesterday", "cursor=garbage"} {
+		w = doRequest(router, http.MethodGet, "/v1/urls?"+query, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED listing short URLs with %v. Expected: %v, got: %v", query, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	// the listing takes the same filter names as bulk changes
+	s.store.InsertUrl(model.Url{Slug: "old1", Target: "https://www.google.com", Created: 1000})
+	s.store.InsertUrl(model.Url{Slug: "old2", Target: "https://www.google.com", Created: 2000})
+	w = doRequest(router, http.MethodGet, "/v1/urls?createdFrom=500&createdTo=1500", "")
+	response = listResponse{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	bulk := struct {
+		Slugs []string `json:"slugs"`
+	}{}
+	json.Unmarshal(doRequest(router, http.MethodPost, "/v1/urls:batchDelete", `{"filter":{"createdFrom":500,"createdTo":1500},"dryRun":true}`).Body.Bytes(), &bulk)
+	if len(response.Urls) != 1 || response.Urls[0].Slug != "old1" || len(bulk.Slugs) != 1 || bulk.Slugs[0] != "old1" {
+		t.Errorf("FAILED filtering by creation time. Expected: old1 listed and selected, got: %+v and %v", response.Urls, bulk.Slugs)
+	} else {
+		t.Logf("PASSED filtering by creation time. Expected: old1 listed and selected, got: %v and %v", response.Urls[0].Slug, bulk.Slugs)
+	}
+}
+
+/*
+	Tests that only the known custom methods on the URL collection are routed, and anything else glued to /v1/urls is not found
+*/
+func TestUrlsMethodRoute(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestUrlsMethodRoute.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	router := s.router()
+
+	var tests = []struct {
+		path string
+		code int
+	}{
+		{"/v1/urlsfoo", http.StatusNotFound},
+		{"/v1/urls:unknown", http.StatusNotFound},
+		{"/v1/urls:batchfoo", http.StatusNotFound},
+		// known methods still authenticate first
+		{"/v1/urls:batch", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := doRequest(router, http.MethodPost, test.path, `{"urls":[]}`)
+		if w.Code != test.code {
+			t.Errorf("FAILED routing %v. Expected: %v, got: %v", test.path, test.code, w.Code)
+		} else {
+			t.Logf("PASSED routing %v. Expected: %v, got: %v", test.path, test.code, w.Code)
+		}
+	}
+	w := doKeyRequest(router, http.MethodPost, "/v1/urlsfoo", `{"urls":[]}`, "admin-secret")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED routing /v1/urlsfoo with API key. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestBatchCreateUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestBatchCreateUrls.log")
+	router := s.router()
+
+	// take the second counter generated slug as a vanity slug, so the batch has to skip it
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"4C93"}`)
+
+	body := `{"urls":[
+		{"target":"https://www.google.com/1"},
+		{"target":"not a url"},
+		{"target":"https://www.google.com/2","slug":"campaign"},
+		{"target":"https://www.google.com/3","slug":"4C93"},
+		{"target":"https://www.google.com/4","tags":["spring"]}
+	]}`
+	w := doRequest(router, http.MethodPost, "/v1/urls:batch", body)
+	response := struct {
+		Created int `json:"created"`
+		Failed  int `json:"failed"`
+		Results []struct {
+			Index  int        `json:"index"`
+			Status int        `json:"status"`
+			Url    *model.Url `json:"urls"`
+		} `json:"results"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Created != 3 || response.Failed != 2 || len(response.Results) != 5 {
+		t.Fatalf("FAILED batch creating short URLs. Expected: 3 created 2 failed, got: %v (%v)", w.Code, w.Body.String())
+	}
+
+	expected := []int{http.StatusCreated, http.StatusBadRequest, http.StatusCreated, http.StatusConflict, http.StatusCreated}
+	slugs := map[string]bool{}
+	for i, result := range response.Results {
+		if result.Index != i || result.Status != expected[i] {
+			t.Errorf("FAILED batch creating short URL %v. Expected: %v, got: %v", i, expected[i], result.Status)
+		}
+		if result.Url != nil {
+			slugs[result.Url.Slug] = true
+		}
+	}
+	if !slugs["4C92"] || !slugs["campaign"] || !slugs["4C94"] {
+		t.Errorf("FAILED batch creating short URLs. Expected: 4C92, campaign and 4C94, got: %v", slugs)
+	} else {
+		t.Logf("PASSED batch creating short URLs. Expected: 4C92, campaign and 4C94, got: %v", slugs)
+	}
+
+	w = doRequest(router, http.MethodGet, "/4C94", "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://www.google.com/4" {
+		t.Errorf("FAILED redirecting batch created short URL. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+
+	// batches above the configured size are rejected as a whole
+	w = doRequest(router, http.MethodPost, "/v1/urls:batch", `{"urls":[{"target":"https://a.com"},{"target":"https://a.com"},{"target":"https://a.com"},{"target":"https://a.com"},{"target":"https://a.com"},{"target":"https://a.com"}]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED batch creating too many short URLs. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+	w = doRequest(router, http.MethodPost, "/v1/urls:unknown", `{}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED calling unknown URL method. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestBulkUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestBulkUrls.log")
+	router := s.router()
+
+	createTestUrl(t, router, `{"target":"https://www.google.com/a","slug":"bulk1","tags":["spring"]}`)
+	createTestUrl(t, router, `{"target":"https://www.google.com/b","slug":"bulk2","tags":["spring"]}`)
+	createTestUrl(t, router, `{"target":"https://www.example.com/c","slug":"bulk3"}`)
+
+	response := struct {
+		DryRun   bool     `json:"dryRun"`
+		Affected int      `json:"affected"`
+		Slugs    []string `json:"slugs"`
+	}{}
+
+	// a dry run reports the matching URLs without changing them
+	w := doRequest(router, http.MethodPost, "/v1/urls:batchDelete", `{"filter":{"tags":["spring"]},"dryRun":true}`)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || !response.DryRun || response.Affected != 2 {
+		t.Errorf("FAILED dry run of bulk delete. Expected: 2 affected, got: %v (%v)", w.Code, w.Body.String())
+	}
+	w = doRequest(router, http.MethodGet, "/bulk1", "")
+	if w.Code != http.StatusFound {
+		t.Errorf("FAILED dry run of bulk delete. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+
+	// selections matching more URLs than allowed are rejected without changing anything
+	s.config.BulkMaxUrls = 1
+	w = doRequest(router, http.MethodPost, "/v1/urls:batchDelete", `{"filter":{"tags":["spring"]}}`)
+	if w.Code != http.StatusBadRequest || doRequest(router, http.MethodGet, "/bulk1", "").Code != http.StatusFound {
+		t.Errorf("FAILED limiting bulk delete. Expected: %v, got: %v (%v)", http.StatusBadRequest, w.Code, w.Body.String())
+	} else {
+		t.Logf("PASSED limiting bulk delete. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+	s.config.BulkMaxUrls = 0
+
+	w = doRequest(router, http.MethodPost, "/v1/urls:batchUpdate", `{"slugs":["bulk1","bulk3","missing"],"update":{"target":"https://www.google.com/new","maxHits":5}}`)
+	response.Slugs = nil
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.DryRun || response.Affected != 2 {
+		t.Errorf("FAILED bulk update. Expected: 2 affected, got: %v (%v)", w.Code, w.Body.String())
+	} else {
+		t.Logf("PASSED bulk update. Expected: 2 affected, got: %v", response.Slugs)
+	}
+	w = doRequest(router, http.MethodGet, "/bulk3", "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://www.google.com/new" {
+		t.Errorf("FAILED redirecting bulk updated short URL. Expected: https://www.google.com/new, got: %v", w.Header().Get("Location"))
+	}
+
+	w = doRequest(router, http.MethodPost, "/v1/urls:batchDelete", `{"filter":{"domain":"www.google.com","tags":["spring"]}}`)
+	response.Slugs = nil
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Affected != 2 {
+		t.Errorf("FAILED bulk delete. Expected: 2 affected, got: %v (%v)", w.Code, w.Body.String())
+	} else {
+		t.Logf("PASSED bulk delete. Expected: 2 affected, got: %v", response.Slugs)
+	}
+	w = doRequest(router, http.MethodGet, "/bulk2", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED bulk delete. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+
+	// an empty selection or update is rejected instead of touching every URL
+	invalid := []struct {
+		method string
+		body   string
+	}{
+		{":batchDelete", `{}`},
+		{":batchUpdate", `{"slugs":["bulk3"]}`},
+		{":batchUpdate", `{"slugs":["bulk3"],"update":{"target":"not a url"}}`},
+		{":batchDelete", `{"slugs":["a","b","c","d","e","f"]}`},
+	}
+	for _, test := range invalid {
+		w = doRequest(router, http.MethodPost, "/v1/urls"+test.method, test.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED invalid bulk change %v. Expected: %v, got: %v", test.body, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+/*
+	Waits for a background job to finish and returns its final progress.
+*/
+func waitForJob(t *testing.T, router http.Handler, location string) jobStatus {
+	response := struct {
+		Job jobStatus `json:"job"`
+	}{}
+	for i := 0; i < 100; i++ {
+		w := doRequest(router, http.MethodGet, location, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("FAILED getting job. Expected: %v, got: %v (%v)", http.StatusOK, w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response.Job.State != jobRunning {
+			return response.Job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("FAILED waiting for job. Expected: finished job, got: %v", response.Job.State)
+	return response.Job
+}
+
+func TestImportUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestImportUrls.log")
+	router := s.router()
+
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"taken"}`)
+
+	// 4C95 is a slug the counter would generate a few URLs from now
+	body := "slug,long_url,created_at,clicks\n" +
+		"4C95,https://www.google.com/1,1646128800,42\n" +
+		",https://www.google.com/2,,\n" +
+		"bad slug!,https://www.google.com/3,,\n" +
+		"taken,https://www.google.com/4,,\n" +
+		"other,not a url,,\n"
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/urls:import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted || w.Header().Get("Location") == "" {
+		t.Fatalf("FAILED starting import. Expected: %v, got: %v (%v)", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	status := waitForJob(t, router, w.Header().Get("Location"))
+	if status.State != jobDone || status.Total != 5 || status.Processed != 5 || status.Succeeded != 3 || status.Failed != 2 {
+		t.Errorf("FAILED importing short URLs. Expected: 3 imported 2 failed, got: %+v", status)
+	} else {
+		t.Logf("PASSED importing short URLs. Expected: 3 imported 2 failed, got: %+v", status)
+	}
+	if len(status.Errors) != 2 || status.Errors[0].Row != 5 || status.Errors[1].Row != 6 {
+		t.Errorf("FAILED reporting import errors. Expected: rows 5 and 6, got: %+v", status.Errors)
+	}
+	if len(status.Renamed) != 1 || status.Renamed[0].Slug != "bad slug!" || status.Renamed[0].NewSlug != "4C97" {
+		t.Errorf("FAILED reporting renamed slugs. Expected: bad slug! renamed to 4C97, got: %+v", status.Renamed)
+	}
+
+	w = doRequest(router, http.MethodGet, "/v1/urls/4C95", "")
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Urls.Target != "https://www.google.com/1" || response.Urls.Created != 1646128800 || response.Urls.Hits != 42 {
+		t.Errorf("FAILED keeping imported short URL. Expected: https://www.google.com/1 1646128800 42, got: %+v", response.Urls)
+	}
+
+	// the counter moved past the imported slug, so it is never generated again
+	url := createTestUrl(t, router, `{"target":"https://www.google.com/5"}`)
+	if url.Slug != "4C98" {
+		t.Errorf("FAILED generating slug after import. Expected: 4C98, got: %v", url.Slug)
+	} else {
+		t.Logf("PASSED generating slug after import. Expected: 4C98, got: %v", url.Slug)
+	}
+
+	// files can be uploaded as a multipart form too, taking the format from the file name
+	var form strings.Builder
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "links.json")
+	part.Write([]byte(`{"links":[{"id":"bit.ly/abc","long_url":"https://www.google.com/6","tags":["spring"]}]}`))
+	writer.Close()
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/v1/urls:import", strings.NewReader(form.String()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("FAILED starting multipart import. Expected: %v, got: %v (%v)", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	status = waitForJob(t, router, w.Header().Get("Location"))
+	if status.Succeeded != 1 {
+		t.Errorf("FAILED importing uploaded file. Expected: 1 imported, got: %+v", status)
+	}
+	w = doRequest(router, http.MethodGet, "/abc", "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://www.google.com/6" {
+		t.Errorf("FAILED redirecting imported short URL. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+
+	// files that cannot be imported at all are rejected before a job starts
+	invalid := []struct {
+		path string
+		body string
+	}{
+		{"/v1/urls:import?format=xml", "<urls/>"},
+		{"/v1/urls:import?format=csv", "slug,title\nabc,Home\n"},
+		{"/v1/urls:import?format=json", "[" + strings.Repeat(`{"url":"https://a.com"},`, 10) + `{"url":"https://a.com"}]`},
+		{"/v1/urls:import?format=json", `[{"url":"https://a.com"},`},
+	}
+	for _, test := range invalid {
+		w = doRequest(router, http.MethodPost, test.path, test.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED rejecting invalid import %v. Expected: %v, got: %v", test.path, http.StatusBadRequest, w.Code)
+		}
+	}
+	w = doRequest(router, http.MethodGet, "/v1/jobs/missing", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("FAILED getting missing job. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+}
+
+/*
+	Tests that importing a long vanity slug does not move the counter to its value, so generated slugs stay short
+*/
+func TestImportLongVanitySlug(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestImportLongVanitySlug.log")
+	testFile := "/tmp/TestImportLongVanitySlug.dat"
+	os.Remove(testFile)
+	defer os.Remove(testFile)
+	s.cnt = &util.Counter{Allocator: &util.FileRangeAllocator{FileName: testFile, Start: 2000001, BlockSize: 1000}, ReserveWindow: 10000}
+	s.cnt.GetNewRange(s.f, true)
+	router := s.router()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/urls:import", strings.NewReader("slug,long_url\nzzzzzzzzzz,https://www.google.com/1\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("FAILED starting import. Expected: %v, got: %v (%v)", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	if status := waitForJob(t, router, w.Header().Get("Location")); status.Succeeded != 1 {
+		t.Fatalf("FAILED importing long vanity slug. Expected: 1 imported, got: %+v", status)
+	}
+
+	// use up the current range, so the second URL gets its slug from a new range
+	s.cnt.Counter = s.cnt.CounterEnd
+	createTestUrl(t, router, `{"target":"https://www.google.com/2"}`)
+	url := createTestUrl(t, router, `{"target":"https://www.google.com/3"}`)
+	if len(url.Slug) != 4 {
+		t.Errorf("FAILED generating slug after importing long vanity slug. Expected: 4 characters, got: %v", url.Slug)
+	} else {
+		t.Logf("PASSED generating slug after importing long vanity slug. Expected: 4 characters, got: %v", url.Slug)
+	}
+}
+
+func TestExportUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestExportUrls.log")
+	router := s.router()
+
+	createTestUrl(t, router, `{"target":"https://www.google.com/1","slug":"export1","tags":["spring","sale"]}`)
+	createTestUrl(t, router, `{"target":"https://www.example.com/2","slug":"export2","maxHits":10}`)
+	createTestUrl(t, router, `{"target":"https://www.google.com/3","slug":"export3","tags":["spring"]}`)
+
+	w := doRequest(router, http.MethodGet, "/v1/urls/export?format=csv&tags=spring", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("FAILED exporting URLs as CSV. Expected: %v, got: %v (%v)", http.StatusOK, w.Code, w.Body.String())
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) != 3 || records[0][0] != "slug" || records[1][0] != "export1" || records[1][9] != "spring,sale" || records[2][0] != "export3" {
+		t.Errorf("FAILED exporting URLs as CSV. Expected: header, export1 and export3, got: %v (%v)", records, err)
+	} else {
+		t.Logf("PASSED exporting URLs as CSV. Expected: header, export1 and export3, got: %v", records)
+	}
+
+	// the CSV export can be imported again
+	rows, err := importer.Parse("csv", strings.NewReader(doRequest(router, http.MethodGet, "/v1/urls/export", "").Body.String()), 10)
+	if err != nil || len(rows) != 3 || rows[0].Slug != "export1" || len(rows[0].Tags) != 2 {
+		t.Errorf("FAILED importing exported CSV. Expected: 3 rows, got: %+v (%v)", rows, err)
+	}
+
+	w = doRequest(router, http.MethodGet, "/v1/urls/export?format=jsonl", "")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	record := exportRecord{}
+	if w.Code != http.StatusOK || len(lines) != 3 {
+		t.Fatalf("FAILED exporting URLs as JSON Lines. Expected: 3 lines, got: %v (%v)", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil || record.Slug != "export2" || record.MaxHits != 10 || record.Domain != "www.example.com" || record.Created == 0 {
+		t.Errorf("FAILED exporting URLs as JSON Lines. Expected: export2, got: %v (%v)", lines[1], err)
+	} else {
+		t.Logf("PASSED exporting URLs as JSON Lines. Expected: export2, got: %v", lines[1])
+	}
+
+	// nothing matching still returns the CSV header
+	w = doRequest(router, http.MethodGet, "/v1/urls/export?domain=missing.com", "")
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 1 {
+		t.Errorf("FAILED exporting no URLs. Expected: header only, got: %v", w.Body.String())
+	}
+	w = doRequest(router, http.MethodGet, "/v1/urls/export?format=xml", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED exporting unsupported format. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/v1/urls/export?createdFrom=yesterday", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED exporting with invalid filter. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+}
+
+/*
+	Keeps the click events written to it.
+*/
+type clickSink struct {
+	mu     sync.Mutex
+	events []model.ClickEvent
+}
+
+func (s *clickSink) InsertClickEvents(events []model.ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestClickEvents(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestClickEvents.log")
+	sink := &clickSink{}
+	s.clickEvents = events.NewWriter(s.f, true, sink, 10, 10, time.Hour)
+	s.config.EventsCountryHeader = "CF-IPCountry"
+	router := s.router()
+
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"clicks"}`)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/clicks", nil)
+	req.RemoteAddr = "203.0.113.42:52000"
+	req.Header.Set("Referer", "https://news.example.com/story")
+	req.Header.Set("User-Agent", "Mozilla/5.0 "+strings.Repeat("x", 1000))
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("CF-IPCountry", "de")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("FAILED redirecting short URL. Expected: %v, got: %v", http.StatusFound, w.Code)
+	}
+	// checking a short URL is not a click, and neither is an unknown short URL
+	doRequest(router, http.MethodHead, "/clicks", "")
+	doRequest(router, http.MethodGet, "/missing", "")
+	// looking up a short URL through the API counts a hit, so it is a click too
+	doRequest(router, http.MethodGet, "/v1/urls/clicks", "")
+
+	// closing the writer flushes the queued events
+	s.clickEvents.Close()
+	if len(sink.events) != 2 {
+		t.Fatalf("FAILED recording click events. Expected: 2, got: %v", len(sink.events))
+	}
+	if url, _ := s.store.GetUrl("clicks"); url.Hits != uint64(len(sink.events)) {
+		t.Errorf("FAILED matching hits and click events. Expected: %v hits, got: %v", len(sink.events), url.Hits)
+	} else {
+		t.Logf("PASSED matching hits and click events. Expected: %v hits, got: %v", len(sink.events), url.Hits)
+	}
+	event := sink.events[0]
+	if event.Slug != "clicks" || event.Timestamp == 0 || event.Referrer != "https://news.example.com/story" || event.IP != "203.0.113.0" || event.Language != "en-US,en;q=0.9" || event.Country != "DE" || len(event.UserAgent) != maxClickHeaderLen {
+		t.Errorf("FAILED recording click event. Expected: clicks from 203.0.113.0, got: %+v", event)
+	} else {
+		t.Logf("PASSED recording click event. Expected: clicks from 203.0.113.0, got: %+v", event)
+	}
+}
+
+/*
+	Tests reading the statistics of a short URL from its rollups, the window options and that callers only see their own short URLs
+*/
+func TestUrlStats(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestUrlStats.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	router := s.router()
+
+	keys := []string{}
+	for _, name := range []string{"alice", "bob"} {
+		w := doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"`+name+`"}`, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		keys = append(keys, response.ApiKey)
+	}
+	w := doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"stats"}`, keys[0])
+	if w.Code != http.StatusCreated {
+		t.Fatalf("FAILED creating short URL. Expected: %v, got: %v", http.StatusCreated, w.Code)
+	}
+
+	now := uint64(time.Now().Unix())
+	s.store.InsertClickEvents([]model.ClickEvent{
+		{Slug: "stats", Timestamp: now - 7200, Referrer: "https://news.example.com/a", UserAgent: "curl/7.79.1", IP: "203.0.113.0"},
+		{Slug: "stats", Timestamp: now - 60, UserAgent: "curl/7.79.1", IP: "203.0.113.0", Country: "DE"},
+		{Slug: "stats", Timestamp: now - 30, UserAgent: "curl/7.79.1", IP: "198.51.100.0", Country: "DE"},
+		{Slug: "other", Timestamp: now - 30},
+	})
+	if _, err := stats.RollUp(s.f, true, s.store); err != nil {
+		t.Fatalf("FAILED rolling up click events. Expected: nil error, got: %v", err)
+	}
+
+	w = doKeyRequest(router, http.MethodGet, "/v1/urls/stats/stats?window=24h", "", keys[0])
+	response := struct {
+		Hits     uint64        `json:"hits"`
+		From     uint64        `json:"from"`
+		To       uint64        `json:"to"`
+		Interval string        `json:"interval"`
+		Stats    stats.Summary `json:"stats"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Stats.Clicks != 3 || response.Stats.Unique != 2 {
+		t.Errorf("FAILED getting statistics. Expected: %v with 3 clicks, 2 unique, got: %v %+v", http.StatusOK, w.Code, response.Stats)
+	} else {
+		t.Logf("PASSED getting statistics. Expected: %v with 3 clicks, 2 unique, got: %v %+v", http.StatusOK, w.Code, response.Stats)
+	}
+	if response.Interval != "hour" || response.From%3600 != 0 || response.To%3600 != 0 || uint64(len(response.Stats.Buckets)) != (response.To-response.From)/3600 {
+		t.Errorf("FAILED getting hourly buckets. Expected: hour buckets covering the window, got: %v %v-%v (%v buckets)", response.Interval, response.From, response.To, len(response.Stats.Buckets))
+	}
+	if len(response.Stats.Countries) == 0 || response.Stats.Countries[0].Name != "DE" || len(response.Stats.Referrers) != 2 || response.Stats.Browsers[0].Name != "curl" {
+		t.Errorf("FAILED getting top values. Expected: DE, 2 referrers and curl, got: %+v", response.Stats)
+	}
+
+	w = doKeyRequest(router, http.MethodGet, "/v1/urls/stats/stats?window=30d", "", "admin-secret")
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Interval != "day" || response.Stats.Clicks != 3 {
+		t.Errorf("FAILED getting daily statistics as admin. Expected: %v day with 3 clicks, got: %v %v %v", http.StatusOK, w.Code, response.Interval, response.Stats.Clicks)
+	}
+
+	var tests = []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"other owner", "/v1/urls/stats/stats", keys[1], http.StatusNotFound},
+		{"missing short URL", "/v1/urls/missing/stats", keys[0], http.StatusNotFound},
+		{"unsupported interval", "/v1/urls/stats/stats?interval=week", keys[0], http.StatusBadRequest},
+		{"invalid window", "/v1/urls/stats/stats?window=soon", keys[0], http.StatusBadRequest},
+		{"too many buckets", "/v1/urls/stats/stats?window=365d&interval=hour", keys[0], http.StatusBadRequest},
+		{"invalid top", "/v1/urls/stats/stats?top=0", keys[0], http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := doKeyRequest(router, http.MethodGet, tt.path, "", tt.key); w.Code != tt.status {
+			t.Errorf("FAILED getting statistics with %v. Expected: %v, got: %v", tt.name, tt.status, w.Code)
+		} else {
+			t.Logf("PASSED getting statistics with %v. Expected: %v, got: %v", tt.name, tt.status, w.Code)
+		}
+	}
+}
+
+/*
+	Tests that hits are counted in batches and written on close, while short URLs with a hit limit are still counted directly
+*/
+func TestBatchedHits(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestBatchedHits.log")
+	s.hits = hits.NewBatcher(s.f, true, s.store, 100, time.Hour)
+	router := s.router()
+
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"batched"}`)
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"limited","maxHits":1}`)
+	for i := 0; i < 3; i++ {
+		doRequest(router, http.MethodGet, "/batched", "")
+	}
+	doRequest(router, http.MethodGet, "/v1/urls/batched", "")
+	if url, _ := s.store.GetUrl("batched"); url.Hits != 0 {
+		t.Errorf("FAILED batching hits. Expected: 0 written, got: %v", url.Hits)
+	}
+
+	// the hit limit is still enforced on every request
+	doRequest(router, http.MethodGet, "/limited", "")
+	if w := doRequest(router, http.MethodGet, "/limited", ""); w.Code != http.StatusGone {
+		t.Errorf("FAILED enforcing hit limit. Expected: %v, got: %v", http.StatusGone, w.Code)
+	}
+
+	w := doRequest(router, http.MethodGet, "/v1/metrics", "")
+	response := struct {
+		Hits hits.Metrics `json:"hits"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Hits.Pending != 4 || response.Hits.PendingUrls != 1 {
+		t.Errorf("FAILED getting metrics. Expected: %v with 4 pending hits, got: %v %+v", http.StatusOK, w.Code, response.Hits)
+	} else {
+		t.Logf("PASSED getting metrics. Expected: %v with 4 pending hits, got: %v %+v", http.StatusOK, w.Code, response.Hits)
+	}
+
+	s.hits.Close()
+	if url, _ := s.store.GetUrl("batched"); url.Hits != 4 {
+		t.Errorf("FAILED writing batched hits on close. Expected: 4, got: %v", url.Hits)
+	} else {
+		t.Logf("PASSED writing batched hits on close. Expected: 4, got: %v", url.Hits)
+	}
+}
+
+/*
+	Tests counting unique visitors on redirects and lookups, and returning them with the short URL and its statistics
+*/
+func TestUniqueVisitors(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestUniqueVisitors.log")
+	s.visitors = visitors.NewMemoryCounter(90 * 24 * time.Hour)
+	router := s.router()
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"visitors"}`)
+
+	for _, visit := range []struct {
+		method string
+		ip     string
+	}{
+		{http.MethodGet, "203.0.113.1"},
+		{http.MethodGet, "203.0.113.1"},
+		{http.MethodGet, "203.0.113.1"},
+		{http.MethodGet, "203.0.113.2"},
+		// checking a short URL is not a visit
+		{http.MethodHead, "203.0.113.3"},
+	} {
+		req := httptest.NewRequest(visit.method, "/visitors", nil)
+		req.RemoteAddr = visit.ip + ":52000"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// looking up the short URL is a visit as well
+	w := doRequest(router, http.MethodGet, "/v1/urls/visitors", "")
+	response := struct {
+		Urls model.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	// the returned hits are read before the lookup itself is counted
+	if response.Urls.UniqueVisitors != 3 || response.Urls.Hits != 4 {
+		t.Errorf("FAILED counting unique visitors. Expected: 3 visitors of 4 hits, got: %v of %v", response.Urls.UniqueVisitors, response.Urls.Hits)
+	} else {
+		t.Logf("PASSED counting unique visitors. Expected: 3 visitors of 4 hits, got: %v of %v", response.Urls.UniqueVisitors, response.Urls.Hits)
+	}
+
+	w = doRequest(router, http.MethodGet, "/v1/urls/visitors/stats?window=3d", "")
+	stats := struct {
+		Visitors visitorStats `json:"visitors"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	daily := stats.Visitors.Daily
+	if w.Code != http.StatusOK || stats.Visitors.Total != 3 || stats.Visitors.Window != 3 || len(daily) != 4 || daily[len(daily)-1].Unique != 3 {
+		t.Errorf("FAILED getting unique visitors with statistics. Expected: 3 all-time, in the window and today, got: %v %+v", w.Code, stats.Visitors)
+	}
+}
+
+/*
+	Tests that link unfurlers are counted as bot hits, leaving hits, hit limits and unique visitors to people,
+	and that their clicks are broken down separately in the statistics
+*/
+func TestBotHits(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestBotHits.log")
+	s.bots, _ = bots.NewClassifier("")
+	s.visitors = visitors.NewMemoryCounter(90 * 24 * time.Hour)
+	s.clickEvents = events.NewWriter(s.f, true, s.store, 10, 10, time.Hour)
+	router := s.router()
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"unfurl","maxHits":1}`)
+
+	visit := func(userAgent string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/unfurl", nil)
+		req.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	// bots do not use up the hit limit, but are turned away once people have
+	codes := []int{
+		visit("Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"),
+		visit("Twitterbot/1.0"),
+		visit("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/118.0"),
+		visit("Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"),
+	}
+	expected := []int{http.StatusFound, http.StatusFound, http.StatusFound, http.StatusGone}
+	for i := range codes {
+		if codes[i] != expected[i] {
+			t.Errorf("FAILED redirecting visit %v. Expected: %v, got: %v", i, expected[i], codes[i])
+		}
+	}
+
+	url, _ := s.store.GetUrl("unfurl")
+	if url.Hits != 1 || url.BotHits != 2 {
+		t.Errorf("FAILED counting bot hits. Expected: 1 hit and 2 bot hits, got: %v and %v", url.Hits, url.BotHits)
+	} else {
+		t.Logf("PASSED counting bot hits. Expected: 1 hit and 2 bot hits, got: %v and %v", url.Hits, url.BotHits)
+	}
+	if total, _ := s.visitors.Total("unfurl"); total != 1 {
+		t.Errorf("FAILED leaving bots out of unique visitors. Expected: 1, got: %v", total)
+	}
+
+	s.clickEvents.Close()
+	if _, err := stats.RollUp(s.f, true, s.store); err != nil {
+		t.Fatalf("FAILED rolling up click events. Expected: nil error, got: %v", err)
+	}
+	w := doRequest(router, http.MethodGet, "/v1/urls/unfurl/stats?window=24h", "")
+	response := struct {
+		Stats stats.Summary `json:"stats"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	summary := response.Stats
+	if summary.Clicks != 3 || summary.HumanClicks != 1 || summary.BotClicks != 2 || len(summary.Bots) != 2 || summary.Browsers[0].Name != "Firefox" {
+		t.Errorf("FAILED getting bot statistics. Expected: 3 clicks, 1 human, 2 bots, got: %+v", summary)
+	} else {
+		t.Logf("PASSED getting bot statistics. Expected: 3 clicks, 1 human, 2 bots, got: %+v", summary)
+	}
+
+	// bots are counted like people when configured
+	s.config.CountBotHits = true
+	createTestUrl(t, router, `{"target":"https://www.google.com","slug":"unfurl2"}`)
+	req := httptest.NewRequest(http.MethodGet, "/unfurl2", nil)
+	req.Header.Set("User-Agent", "Twitterbot/1.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if url, _ := s.store.GetUrl("unfurl2"); url.Hits != 1 || url.BotHits != 0 {
+		t.Errorf("FAILED counting bot hits as hits. Expected: 1 hit and 0 bot hits, got: %v and %v", url.Hits, url.BotHits)
+	}
+}
+
+/*
+	Tests listing the short URLs clicked most recently, limited to the caller's own short URLs unless they can read every short URL
+*/
+func TestTrendingUrls(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestTrendingUrls.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	s.bots, _ = bots.NewClassifier("")
+	s.trending = trending.NewMemoryTracker()
+	router := s.router()
+
+	keys := []string{}
+	for _, name := range []string{"alice", "bob"} {
+		w := doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"`+name+`"}`, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		keys = append(keys, response.ApiKey)
+	}
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"hot"}`, keys[0])
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"warm"}`, keys[0])
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"cold"}`, keys[1])
+
+	for _, slug := range []string{"hot", "hot", "hot", "warm", "cold", "cold"} {
+		doRequest(router, http.MethodGet, "/"+slug, "")
+	}
+	// checks and bots are not clicks
+	doRequest(router, http.MethodHead, "/warm", "")
+	req := httptest.NewRequest(http.MethodGet, "/warm", nil)
+	req.Header.Set("User-Agent", "Twitterbot/1.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var tests = []struct {
+		name     string
+		path     string
+		key      string
+		code     int
+		expected string
+	}{
+		{"all short URLs as admin", "/v1/urls/trending", "admin-secret", http.StatusOK, "[{hot 3} {cold 2} {warm 1}]"},
+		{"own short URLs", "/v1/urls/trending?window=15m", keys[0], http.StatusOK, "[{hot 3} {warm 1}]"},
+		{"top short URL", "/v1/urls/trending?window=24h&top=1", keys[1], http.StatusOK, "[{cold 2}]"},
+		{"too long window", "/v1/urls/trending?window=2d", "admin-secret", http.StatusBadRequest, "[]"},
+		{"invalid top", "/v1/urls/trending?top=0", "admin-secret", http.StatusBadRequest, "[]"},
+	}
+	for _, tt := range tests {
+		w := doKeyRequest(router, http.MethodGet, tt.path, "", tt.key)
+		response := struct {
+			Urls []trending.Url `json:"urls"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != tt.code || fmt.Sprint(response.Urls) != tt.expected {
+			t.Errorf("FAILED getting trending URLs, %v. Expected: %v %v, got: %v %v", tt.name, tt.code, tt.expected, w.Code, response.Urls)
+		} else {
+			t.Logf("PASSED getting trending URLs, %v. Expected: %v %v, got: %v %v", tt.name, tt.code, tt.expected, w.Code, response.Urls)
+		}
+	}
+}
+
+/*
+	Tests that read-only keys can read the statistics, trending short URLs and events of every short URL, but still cannot list short URLs
+*/
+func TestReadOnlyStats(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestReadOnlyStats.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	s.config.StreamHeartbeatSeconds = 60
+	s.trending = trending.NewMemoryTracker()
+	s.hub = stream.NewHub(10, 10)
+	s.clickEvents = events.NewWriter(s.f, true, s.store, 10, 10, time.Hour)
+	router := s.router()
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	keys := []string{}
+	for _, body := range []string{`{"name":"alice"}`, `{"name":"analyst","role":"readonly"}`} {
+		w := doKeyRequest(router, http.MethodPost, "/v1/keys", body, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		keys = append(keys, response.ApiKey)
+	}
+	messages := openTestStream(t, srv.URL, "/v1/events/stream", keys[1])
+
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"watched"}`, keys[0])
+	doRequest(router, http.MethodGet, "/watched", "")
+	s.clickEvents.Close()
+	if _, err := stats.RollUp(s.f, true, s.store); err != nil {
+		t.Fatalf("FAILED rolling up click events. Expected: nil error, got: %v", err)
+	}
+
+	w := doKeyRequest(router, http.MethodGet, "/v1/urls/watched/stats", "", keys[1])
+	statsResponse := struct {
+		Stats stats.Summary `json:"stats"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &statsResponse)
+	if w.Code != http.StatusOK || statsResponse.Stats.Clicks != 1 {
+		t.Errorf("FAILED getting statistics with read-only key. Expected: %v with 1 click, got: %v %+v", http.StatusOK, w.Code, statsResponse.Stats)
+	} else {
+		t.Logf("PASSED getting statistics with read-only key. Expected: %v with 1 click, got: %v %v", http.StatusOK, w.Code, statsResponse.Stats.Clicks)
+	}
+
+	w = doKeyRequest(router, http.MethodGet, "/v1/urls/trending", "", keys[1])
+	trendingResponse := struct {
+		Urls []trending.Url `json:"urls"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &trendingResponse)
+	if w.Code != http.StatusOK || fmt.Sprint(trendingResponse.Urls) != "[{watched 1}]" {
+		t.Errorf("FAILED getting trending URLs with read-only key. Expected: %v [{watched 1}], got: %v %v", http.StatusOK, w.Code, trendingResponse.Urls)
+	} else {
+		t.Logf("PASSED getting trending URLs with read-only key. Expected: %v [{watched 1}], got: %v %v", http.StatusOK, w.Code, trendingResponse.Urls)
+	}
+
+	got := []string{}
+	for i := 0; i < 2; i++ {
+		message := nextTestEvent(t, messages)
+		event := stream.Event{}
+		json.Unmarshal([]byte(message.Data), &event)
+		got = append(got, message.Event+" "+event.Slug)
+	}
+	if fmt.Sprint(got) != "[created watched click watched]" {
+		t.Errorf("FAILED streaming events with read-only key. Expected: [created watched click watched], got: %v", got)
+	} else {
+		t.Logf("PASSED streaming events with read-only key. Expected: [created watched click watched], got: %v", got)
+	}
+
+	if w := doKeyRequest(router, http.MethodGet, "/v1/urls", "", keys[1]); w.Code != http.StatusForbidden {
+		t.Errorf("FAILED listing short URLs with read-only key. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	// closing the hub ends the open stream
+	s.hub.Close()
+	for range messages {
+	}
+}
+
+/*
+	An event read from a Server-Sent Events stream.
+*/
+type sseMessage struct {
+	Event string
+	Data  string
+}
+
+/*
+	Opens an event stream on the test server and returns its events once the stream is ready. The stream is closed when the test ends.
+*/
+func openTestStream(t *testing.T, baseUrl string, path string, key string) <-chan sseMessage {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, baseUrl+path, nil)
+	req.Header.Set("X-Api-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		cancel()
+		t.Fatalf("FAILED opening event stream. Expected: %v text/event-stream, got: %v (%v)", http.StatusOK, resp, err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+	})
+
+	messages := make(chan sseMessage, 10)
+	go func() {
+		defer close(messages)
+		scanner := bufio.NewScanner(resp.Body)
+		message := sseMessage{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				message.Event = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				message.Data = strings.TrimPrefix(line, "data:")
+			case line == "":
+				messages <- message
+				message = sseMessage{}
+			}
+		}
+	}()
+	if message := nextTestEvent(t, messages); message.Event != "ready" {
+		t.Fatalf("FAILED waiting for event stream. Expected: ready, got: %v", message.Event)
+	}
+	return messages
+}
+
+// returns the next event of a stream, failing the test if none arrives in time
+func nextTestEvent(t *testing.T, messages <-chan sseMessage) sseMessage {
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FAILED reading event stream. Expected: event, got: timeout")
+	}
+	return sseMessage{}
+}
+
+/*
+	Tests streaming click and short URL events, limited to the caller's own short URLs unless they can read every short URL
+*/
+func TestEventStream(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestEventStream.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	s.config.StreamHeartbeatSeconds = 60
+	s.hub = stream.NewHub(10, 10)
+	router := s.router()
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	keys := []string{}
+	for _, name := range []string{"alice", "bob"} {
+		w := doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"`+name+`"}`, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		keys = append(keys, response.ApiKey)
+	}
+	if w := doKeyRequest(router, http.MethodGet, "/v1/events/stream?slug=not*valid", "", "admin-secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("FAILED rejecting invalid slug filter. Expected: %v, got: %v", http.StatusBadRequest, w.Code)
+	}
+	own := openTestStream(t, srv.URL, "/v1/events/stream", keys[0])
+	bySlug := openTestStream(t, srv.URL, "/v1/events/stream?slug=live2", "admin-secret")
+
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"live1"}`, keys[0])
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"live2"}`, keys[1])
+	req := httptest.NewRequest(http.MethodGet, "/live1", nil)
+	req.Header.Set("Referer", "https://news.example.com/story")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	doKeyRequest(router, http.MethodDelete, "/v1/urls/live2", "", keys[1])
+	doKeyRequest(router, http.MethodPut, "/v1/urls/live1", `{"target":"https://www.google.com/new"}`, keys[0])
+
+	var tests = []struct {
+		name     string
+		messages <-chan sseMessage
+		expected []string
+	}{
+		{"events of own short URLs", own, []string{"created live1", "click live1", "updated live1"}},
+		{"events of a short URL", bySlug, []string{"created live2", "deleted live2"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for range tt.expected {
+			message := nextTestEvent(t, tt.messages)
+			event := stream.Event{}
+			json.Unmarshal([]byte(message.Data), &event)
+			got = append(got, message.Event+" "+event.Slug)
+			if event.Type == stream.Click && (event.Click == nil || event.Click.Referrer != "https://news.example.com/story") {
+				t.Errorf("FAILED streaming click details. Expected: referrer, got: %+v", event.Click)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("FAILED streaming %v. Expected: %v, got: %v", tt.name, tt.expected, got)
+		} else {
+			t.Logf("PASSED streaming %v. Expected: %v, got: %v", tt.name, tt.expected, got)
+		}
+	}
+
+	// closing the hub ends the open streams
+	s.hub.Close()
+	if _, ok := <-own; ok {
+		t.Errorf("FAILED ending event stream. Expected: closed stream, got: event")
+	}
+}
+
+/*
+	Tests managing webhooks and delivering signed events of a short URL to a local receiver, only for the webhooks of its owner
+*/
+func TestWebhooks(t *testing.T) {
+	s := newTestServer(t, "/tmp/TestWebhooks.log")
+	s.config.AuthEnabled = true
+	s.config.AdminApiKey = "admin-secret"
+	s.webhooks = webhooks.NewDispatcher(s.f, true, s.store, 10, webhooks.Retry{MaxAttempts: 3}, time.Second, 10*time.Millisecond, true)
+	t.Cleanup(s.webhooks.Close)
+	router := s.router()
+
+	// records the event type of every delivery with a valid signature, once the secret is known
+	var mu sync.Mutex
+	secret := ""
+	received := []string{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(req.Body)
+		if !webhooks.Verify(secret, req.Header.Get(webhooks.TimestampHeader), body, req.Header.Get(webhooks.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		payload := webhooks.Payload{}
+		json.Unmarshal(body, &payload)
+		received = append(received, payload.Type+" "+payload.Slug)
+	}))
+	defer receiver.Close()
+
+	keys := []string{}
+	for _, name := range []string{"alice", "bob"} {
+		w := doKeyRequest(router, http.MethodPost, "/v1/keys", `{"name":"`+name+`"}`, "admin-secret")
+		response := struct {
+			ApiKey string `json:"apiKey"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		keys = append(keys, response.ApiKey)
+	}
+
+	var invalid = []struct {
+		name string
+		body string
+	}{
+		{"invalid URL", `{"url":"ftp://example.com","events":["created"]}`},
+		{"missing events", `{"url":"` + receiver.URL + `"}`},
+		{"unknown event", `{"url":"` + receiver.URL + `","events":["created","renamed"]}`},
+		{"short secret", `{"url":"` + receiver.URL + `","events":["created"],"secret":"short"}`},
+	}
+	for _, tt := range invalid {
+		if w := doKeyRequest(router, http.MethodPost, "/v1/webhooks", tt.body, keys[0]); w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED rejecting webhook, %v. Expected: %v, got: %v", tt.name, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	// unless private webhooks are allowed, webhooks must use https and cannot reach local services, also in debug mode
+	for _, hookUrl := range []string{"http://www.google.com", "https://127.0.0.1/hook", "https://169.254.169.254/latest", "https://10.0.0.1/hook", "https://localhost/hook"} {
+		if w := doKeyRequest(router, http.MethodPost, "/v1/webhooks", `{"url":"`+hookUrl+`","events":["created"]}`, keys[0]); w.Code != http.StatusBadRequest {
+			t.Errorf("FAILED rejecting webhook for %v. Expected: %v, got: %v", hookUrl, http.StatusBadRequest, w.Code)
+		} else {
+			t.Logf("PASSED rejecting webhook for %v. Expected: %v, got: %v", hookUrl, http.StatusBadRequest, w.Code)
+		}
+	}
+	s.config.WebhooksAllowPrivate = true
+
+	w := doKeyRequest(router, http.MethodPost, "/v1/webhooks", `{"url":"`+receiver.URL+`","events":["created","deleted"]}`, keys[0])
+	created := struct {
+		Webhook model.Webhook `json:"webhook"`
+		Secret  string        `json:"secret"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.Webhook.ID == "" || !strings.HasPrefix(created.Secret, "whsec_") {
+		t.Fatalf("FAILED creating webhook. Expected: %v with a secret, got: %v %v", http.StatusCreated, w.Code, w.Body.String())
+	}
+	mu.Lock()
+	secret = created.Secret
+	mu.Unlock()
+	hookPath := "/v1/webhooks/" + created.Webhook.ID
+
+	// only the owner of a webhook (or an admin) can see and manage it
+	var listed = []struct {
+		key   string
+		count int
+	}{
+		{keys[0], 1},
+		{keys[1], 0},
+		{"admin-secret", 1},
+	}
+	for _, tt := range listed {
+		w := doKeyRequest(router, http.MethodGet, "/v1/webhooks", "", tt.key)
+		response := struct {
+			Webhooks []model.Webhook `json:"webhooks"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusOK || len(response.Webhooks) != tt.count || strings.Contains(w.Body.String(), secret) {
+			t.Errorf("FAILED listing webhooks. Expected: %v without secrets, got: %v", tt.count, w.Body.String())
+		}
+	}
+	if w := doKeyRequest(router, http.MethodGet, hookPath+"/deliveries", "", keys[1]); w.Code != http.StatusForbidden {
+		t.Errorf("FAILED hiding delivery log of other owner. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"hooked"}`, keys[0])
+	doKeyRequest(router, http.MethodPut, "/v1/urls/hooked", `{"target":"https://www.reddit.com"}`, keys[0])
+	doKeyRequest(router, http.MethodPost, "/v1/urls", `{"target":"https://www.google.com","slug":"unhooked"}`, keys[1])
+	doKeyRequest(router, http.MethodDelete, "/v1/urls/hooked", "", keys[0])
+
+	// updates are not subscribed to, and short URLs of other owners are left out
+	deliveries := []model.WebhookDelivery{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w := doKeyRequest(router, http.MethodGet, hookPath+"/deliveries", "", keys[0])
+		response := struct {
+			Deliveries []model.WebhookDelivery `json:"deliveries"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		deliveries = response.Deliveries
+		if len(deliveries) == 2 && deliveries[0].Status == model.DeliveryDelivered && deliveries[1].Status == model.DeliveryDelivered {
+			break
+		}
+	}
+	mu.Lock()
+	sort.Strings(received)
+	got := fmt.Sprint(received)
+	mu.Unlock()
+	if len(deliveries) != 2 || deliveries[0].Status != model.DeliveryDelivered || deliveries[1].Status != model.DeliveryDelivered || got != "[created hooked deleted hooked]" {
+		t.Errorf("FAILED delivering webhook events. Expected: [created hooked deleted hooked] delivered, got: %v (deliveries: %+v)", got, deliveries)
+	} else {
+		t.Logf("PASSED delivering webhook events. Expected: [created hooked deleted hooked] delivered, got: %v", got)
+	}
+
+	if w := doKeyRequest(router, http.MethodDelete, hookPath, "", keys[1]); w.Code != http.StatusForbidden {
+		t.Errorf("FAILED protecting webhook of other owner. Expected: %v, got: %v", http.StatusForbidden, w.Code)
+	}
+	if w := doKeyRequest(router, http.MethodDelete, hookPath, "", keys[0]); w.Code != http.StatusOK {
+		t.Errorf("FAILED deleting webhook. Expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+	if w := doKeyRequest(router, http.MethodGet, hookPath+"/deliveries", "", keys[0]); w.Code != http.StatusNotFound {
+		t.Errorf("FAILED getting delivery log of deleted webhook. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	} else {
+		t.Logf("PASSED getting delivery log of deleted webhook. Expected: %v, got: %v", http.StatusNotFound, w.Code)
+	}
+}
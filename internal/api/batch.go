@@ -0,0 +1,356 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/url-shortener/internal/cache"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Outcome of one URL of a batch request, in the order the URLs were sent.
+*/
+type batchResult struct {
+	Index   int        `json:"index"`
+	Status  int        `json:"status"`
+	Message string     `json:"message"`
+	Url     *model.Url `json:"urls,omitempty"`
+}
+
+// returns the handler of a custom method on the URL collection, e.g. :batch for POST /v1/urls:batch, or nil if there is no such method
+func (s *server) urlsMethodHandler(method string) gin.HandlerFunc {
+	switch method {
+	case ":batch":
+		return s.batchCreateUrls
+	case ":batchUpdate":
+		return s.batchUpdateUrls
+	case ":batchDelete":
+		return s.batchDeleteUrls
+	case ":import":
+		return s.importUrls
+	}
+	return nil
+}
+
+/*
+	Responds with 404 before authenticating unless the request is for a known custom method on the URL collection.
+	The method is a parameter glued to the end of /v1/urls, so the route matches any path starting with /v1/urls (i.e. /v1/urlsfoo) as well.
+*/
+func (s *server) requireUrlsMethod(gc *gin.Context) {
+	if s.urlsMethodHandler(gc.Param("method")) == nil {
+		s.noRoute(gc)
+		gc.Abort()
+		return
+	}
+	gc.Next()
+}
+
+// dispatches custom methods on the URL collection, which requireUrlsMethod has already checked
+func (s *server) urlsMethod(gc *gin.Context) {
+	s.urlsMethodHandler(gc.Param("method"))(gc)
+}
+
+// create many new short URLs, each URL succeeds or fails on its own
+func (s *server) batchCreateUrls(gc *gin.Context) {
+	body := struct {
+		Urls []model.Url `json:"urls"`
+	}{}
+	if err := gc.ShouldBindJSON(&body); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error parsing URLs for shortening.",
+		})
+		return
+	}
+	if len(body.Urls) == 0 || len(body.Urls) > s.config.BatchMaxSize {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid number of URLs for shortening.",
+			"max":     s.config.BatchMaxSize,
+		})
+		return
+	}
+
+	now := time.Now()
+	results := make([]batchResult, len(body.Urls))
+	// a slug provided by the client is a vanity slug
+	vanity := make([]bool, len(body.Urls))
+	// indexes of the URLs still waiting to be inserted
+	pending := []int{}
+	for i := range body.Urls {
+		results[i] = batchResult{Index: i}
+		vanity[i] = body.Urls[i].Slug != ""
+		if message := s.prepareNewUrl(gc, &body.Urls[i], now); message != "" {
+			results[i].Status, results[i].Message = http.StatusBadRequest, message
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// generated slugs are reserved in one block per attempt, and URLs whose generated slug
+	// turned out to be taken by a vanity slug are retried with a new one
+	inserted := []model.Url{}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		generated := []int{}
+		for _, i := range pending {
+			if !vanity[i] {
+				generated = append(generated, i)
+			}
+		}
+		slugs := s.generateSlugs(len(generated))
+		for n, i := range generated {
+			if n < len(slugs) {
+				body.Urls[i].Slug = slugs[n]
+			} else {
+				// an empty slug means no counter range could be reserved
+				body.Urls[i].Slug = ""
+			}
+		}
+
+		urls := []model.Url{}
+		indexes := []int{}
+		for _, i := range pending {
+			if body.Urls[i].Slug == "" {
+				results[i].Status, results[i].Message = http.StatusServiceUnavailable, "Error creating new short URL."
+				continue
+			}
+			urls = append(urls, body.Urls[i])
+			indexes = append(indexes, i)
+		}
+
+		retry := []int{}
+		for n, err := range s.store.InsertUrls(urls) {
+			i := indexes[n]
+			switch {
+			case err == nil:
+				results[i].Status, results[i].Message = http.StatusCreated, "success"
+				results[i].Url = &body.Urls[i]
+				inserted = append(inserted, body.Urls[i])
+			case errors.Is(err, model.ErrDuplicateSlug) && !vanity[i] && attempt < maxSlugAttempts:
+				retry = append(retry, i)
+			case errors.Is(err, model.ErrDuplicateSlug) && vanity[i]:
+				results[i].Status, results[i].Message = http.StatusConflict, "Short URL already exists."
+			default:
+				results[i].Status, results[i].Message = http.StatusServiceUnavailable, "Error creating new short URL."
+			}
+		}
+		pending = retry
+	}
+
+	if s.config.CacheEnabled && len(inserted) > 0 {
+		cache.SetCachedUrls(s.f, s.config.DebugMode, s.config.CacheExpireHours, s.cacheClient, inserted)
+	}
+	for _, url := range inserted {
+		s.publishUrl(stream.Created, url)
+	}
+
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"created": len(inserted),
+		"failed":  len(body.Urls) - len(inserted),
+		"results": results,
+	})
+}
+
+/*
+	Generates n slugs from one block of counter values, skipping reserved slugs.
+	Fewer slugs are returned if no new counter range could be reserved.
+*/
+func (s *server) generateSlugs(n int) []string {
+	slugs := []string{}
+	for len(slugs) < n {
+		want := n - len(slugs)
+		generated := util.GenerateUrlSlugs(s.f, s.config.DebugMode, s.cnt, want)
+		for _, slug := range generated {
+			if !s.isReservedSlug(slug) {
+				slugs = append(slugs, slug)
+			}
+		}
+		if len(generated) < want {
+			break
+		}
+	}
+	return slugs
+}
+
+/*
+	Selects the URLs changed by a bulk update or delete, by slug, by filter or both.
+*/
+type bulkSelection struct {
+	Slugs  []string `json:"slugs"`
+	Filter struct {
+		Domain      string   `json:"domain"`
+		Owner       string   `json:"owner"`
+		Tenant      string   `json:"tenant"`
+		CreatedFrom uint64   `json:"createdFrom"`
+		CreatedTo   uint64   `json:"createdTo"`
+		Tags        []string `json:"tags"`
+	} `json:"filter"`
+	// only report the URLs that would be changed
+	DryRun bool `json:"dryRun"`
+}
+
+/*
+	Converts the selection of a bulk request into a filter. Callers that cannot modify every URL are limited to their own URLs.
+	Returns the message explaining why the selection is invalid, or an empty string if it is valid.
+*/
+func (s *server) bulkFilter(gc *gin.Context, selection bulkSelection) (model.UrlFilter, string) {
+	filter := model.UrlFilter{
+		Slugs:       selection.Slugs,
+		Domain:      selection.Filter.Domain,
+		Owner:       selection.Filter.Owner,
+		Tenant:      selection.Filter.Tenant,
+		CreatedFrom: selection.Filter.CreatedFrom,
+		CreatedTo:   selection.Filter.CreatedTo,
+		Tags:        selection.Filter.Tags,
+	}
+	// an empty selection would match every URL, which is never what a cleanup wants
+	if filter.IsEmpty() {
+		return filter, "Missing slugs or filter for bulk change."
+	}
+	if len(filter.Slugs) > s.config.BatchMaxSize {
+		return filter, "Too many slugs for bulk change."
+	}
+	if !hasPermission(gc, rbac.WriteAnyUrls) {
+		filter.Owner = gc.GetString(ownerKey)
+		filter.Tenant = gc.GetString(tenantKey)
+	}
+	return filter, ""
+}
+
+// responds with the URLs changed by a bulk request, invalidating their cached records unless it was a dry run
+func (s *server) bulkResponse(gc *gin.Context, slugs []string, dryRun bool) {
+	if !dryRun {
+		s.deleteCachedUrls(slugs)
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"status":   http.StatusOK,
+		"message":  "success",
+		"dryRun":   dryRun,
+		"affected": len(slugs),
+		"slugs":    slugs,
+	})
+}
+
+// removes the cached entries of short URLs changed by a bulk request, also when it failed part way
+func (s *server) deleteCachedUrls(slugs []string) {
+	if s.config.CacheEnabled && len(slugs) > 0 {
+		cache.DeleteCachedUrls(s.f, s.config.DebugMode, s.cacheClient, slugs)
+	}
+}
+
+// responds that the selection of a bulk request matches more URLs than one request may change
+func (s *server) tooManyBulkUrls(gc *gin.Context) {
+	gc.JSON(http.StatusBadRequest, gin.H{
+		"status":  http.StatusBadRequest,
+		"message": "Too many URLs for bulk change, at most " + strconv.Itoa(s.config.BulkMaxUrls) + " can be changed at once.",
+	})
+}
+
+// change the target, tags, expiry or hit limit of many short URLs
+func (s *server) batchUpdateUrls(gc *gin.Context) {
+	body := struct {
+		bulkSelection
+		Update model.Url `json:"update"`
+	}{}
+	if err := gc.ShouldBindJSON(&body); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error parsing bulk update.",
+		})
+		return
+	}
+	filter, message := s.bulkFilter(gc, body.bulkSelection)
+	if message == "" {
+		switch {
+		case body.Update.Target != "" && !util.IsValidUrl(body.Update.Target):
+			message = "Invalid URL for updating."
+		case !setExpiry(&body.Update, time.Now()):
+			message = "Invalid expiry for updating."
+		case !normalizeTags(&body.Update):
+			message = "Invalid tags for updating."
+		}
+	}
+	update := model.UrlUpdate{
+		Target:    body.Update.Target,
+		Tags:      body.Update.Tags,
+		ExpiresAt: body.Update.ExpiresAt,
+		MaxHits:   body.Update.MaxHits,
+	}
+	if message == "" && update.IsEmpty() {
+		message = "Missing changes for bulk update."
+	}
+	if message != "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": message,
+		})
+		return
+	}
+
+	slugs, err := s.store.UpdateUrls(filter, update, s.config.BulkMaxUrls, body.DryRun)
+	if errors.Is(err, model.ErrTooManyUrls) {
+		s.tooManyBulkUrls(gc)
+		return
+	} else if err != nil {
+		if !body.DryRun {
+			s.deleteCachedUrls(slugs)
+		}
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error updating URLs.",
+		})
+		return
+	}
+	if !body.DryRun {
+		s.publishSlugs(stream.Updated, slugs, filter)
+	}
+	s.bulkResponse(gc, slugs, body.DryRun)
+}
+
+// delete many short URLs
+func (s *server) batchDeleteUrls(gc *gin.Context) {
+	body := bulkSelection{}
+	if err := gc.ShouldBindJSON(&body); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error parsing bulk delete.",
+		})
+		return
+	}
+	filter, message := s.bulkFilter(gc, body)
+	if message != "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": message,
+		})
+		return
+	}
+
+	slugs, err := s.store.DeleteUrls(filter, s.config.BulkMaxUrls, body.DryRun)
+	if errors.Is(err, model.ErrTooManyUrls) {
+		s.tooManyBulkUrls(gc)
+		return
+	} else if err != nil {
+		if !body.DryRun {
+			s.deleteCachedUrls(slugs)
+		}
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error deleting URLs.",
+		})
+		return
+	}
+	if !body.DryRun {
+		s.publishSlugs(stream.Deleted, slugs, filter)
+	}
+	s.bulkResponse(gc, slugs, body.DryRun)
+}
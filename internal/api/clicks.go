@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/util"
+	"example.com/url-shortener/internal/visitors"
+	"github.com/gin-gonic/gin"
+)
+
+// longest header value kept in a click event, longer values are cut off
+const maxClickHeaderLen = 512
+
+// cuts a header value down to maxClickHeaderLen bytes
+func clickHeader(gc *gin.Context, name string) string {
+	value := gc.GetHeader(name)
+	if len(value) > maxClickHeaderLen {
+		value = value[:maxClickHeaderLen]
+	}
+	return value
+}
+
+/*
+	Returns the name of the bot sending the request, or an empty string for people and when bots are not classified.
+*/
+func (s *server) classifyBot(gc *gin.Context) string {
+	if s.bots == nil {
+		return ""
+	}
+	name, _ := s.bots.Classify(gc.GetHeader("User-Agent"))
+	return name
+}
+
+// bots are counted separately from people unless configured otherwise
+func (s *server) isBotHit(bot string) bool {
+	return bot != "" && !s.config.CountBotHits
+}
+
+/*
+	Queues a click event for a resolved short URL, when click events are enabled, and publishes it to event stream subscribers.
+	Clicks by bots are marked with the bot name. HEAD requests only check a short URL, so they are not recorded as clicks.
+*/
+func (s *server) recordClick(gc *gin.Context, url model.Url, bot string) {
+	if (s.clickEvents == nil && s.hub == nil) || gc.Request.Method == http.MethodHead {
+		return
+	}
+	event := model.ClickEvent{
+		Slug:      url.Slug,
+		Timestamp: uint64(time.Now().Unix()),
+		Referrer:  clickHeader(gc, "Referer"),
+		UserAgent: clickHeader(gc, "User-Agent"),
+		IP:        util.AnonymizeIP(gc.ClientIP()),
+		Language:  clickHeader(gc, "Accept-Language"),
+		Country:   s.clickCountry(gc),
+		Bot:       bot,
+	}
+	if s.clickEvents != nil {
+		s.clickEvents.Record(event)
+	}
+	s.publish(stream.Event{Type: stream.Click, Slug: url.Slug, Owner: urlOwner(url), Timestamp: event.Timestamp, Click: &event})
+}
+
+// reads the country code from the configured header, ignoring anything that is not a two letter code
+func (s *server) clickCountry(gc *gin.Context) string {
+	if s.config.EventsCountryHeader == "" {
+		return ""
+	}
+	country := strings.ToUpper(strings.TrimSpace(gc.GetHeader(s.config.EventsCountryHeader)))
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return ""
+	}
+	return country
+}
+
+/*
+	Adds the visitor of a resolved short URL to its unique visitor counts. The counter logs its own errors,
+	as counting visitors must never fail resolving a short URL. HEAD requests and bots are not counted, just like hits.
+*/
+func (s *server) countVisitor(gc *gin.Context, url model.Url, bot string) {
+	if s.visitors == nil || gc.Request.Method == http.MethodHead || s.isBotHit(bot) {
+		return
+	}
+	visitor := visitors.Fingerprint(gc.ClientIP(), gc.GetHeader("User-Agent"), gc.GetHeader("Accept-Language"))
+	s.visitors.Add(url.Slug, visitor, time.Now())
+}
+
+/*
+	Counts a click of a resolved short URL towards trending short URLs. Like visitors, errors are only logged by the tracker,
+	and HEAD requests and bots are not counted.
+*/
+func (s *server) countTrending(gc *gin.Context, url model.Url, bot string) {
+	if s.trending == nil || gc.Request.Method == http.MethodHead || s.isBotHit(bot) {
+		return
+	}
+	s.trending.Add(url.Slug, urlOwner(url), time.Now())
+}
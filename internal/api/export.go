@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/url-shortener/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// exported URLs written between flushes to the client
+const exportFlushEvery = 500
+
+// columns of CSV exports, named so the file can be imported again
+var exportColumns = []string{"slug", "target", "domain", "created", "hits", "max_hits", "expires_at", "owner", "tenant", "tags", "protected"}
+
+/*
+	A URL as written to an export. Unlike the API responses every field is always present, so audits do not have to guess at missing ones.
+*/
+type exportRecord struct {
+	Slug      string   `json:"slug"`
+	Target    string   `json:"target"`
+	Domain    string   `json:"domain"`
+	Created   uint64   `json:"created"`
+	Hits      uint64   `json:"hits"`
+	MaxHits   uint64   `json:"maxHits"`
+	ExpiresAt uint64   `json:"expiresAt"`
+	Owner     string   `json:"owner"`
+	Tenant    string   `json:"tenant"`
+	Tags      []string `json:"tags"`
+	Protected bool     `json:"protected"`
+}
+
+func newExportRecord(url model.Url) exportRecord {
+	record := exportRecord{
+		Slug:      url.Slug,
+		Target:    url.Target,
+		Domain:    url.Domain,
+		Created:   url.Created,
+		Hits:      url.Hits,
+		MaxHits:   url.MaxHits,
+		ExpiresAt: url.ExpiresAt,
+		Owner:     url.Owner,
+		Tenant:    url.Tenant,
+		Tags:      url.Tags,
+		Protected: url.Protected,
+	}
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	return record
+}
+
+// the values of a record in the order of exportColumns
+func (record exportRecord) csv() []string {
+	return []string{
+		record.Slug,
+		record.Target,
+		record.Domain,
+		strconv.FormatUint(record.Created, 10),
+		strconv.FormatUint(record.Hits, 10),
+		strconv.FormatUint(record.MaxHits, 10),
+		strconv.FormatUint(record.ExpiresAt, 10),
+		record.Owner,
+		record.Tenant,
+		strings.Join(record.Tags, ","),
+		strconv.FormatBool(record.Protected),
+	}
+}
+
+/*
+	Streams every URL matching the listing filters (see listFilter) as CSV or JSON Lines (format csv, jsonl or ndjson).
+	URLs are written as they are read from the store, so the response is never held in memory.
+	Once the first URL is written the status can no longer change, so later errors end the export early and are only logged.
+*/
+func (s *server) exportUrls(gc *gin.Context) {
+	format := gc.DefaultQuery("format", "csv")
+	contentType := "application/x-ndjson"
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "jsonl", "ndjson":
+	default:
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Unsupported format for exporting, use csv, jsonl or ndjson.",
+		})
+		return
+	}
+	filter, ok := listFilter(gc)
+	if !ok {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid query for exporting URLs.",
+		})
+		return
+	}
+
+	w := bufio.NewWriter(gc.Writer)
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	started, written := false, 0
+	// sends the headers on the first URL, so errors before that can still be reported with a status
+	start := func() {
+		started = true
+		gc.Header("Content-Type", contentType)
+		gc.Header("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+		gc.Status(http.StatusOK)
+		if format == "csv" {
+			csvWriter.Write(exportColumns)
+		}
+	}
+	flush := func() error {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		gc.Writer.Flush()
+		return nil
+	}
+
+	count, err := s.store.ExportUrls(filter, func(url model.Url) error {
+		if !started {
+			start()
+		}
+		record := newExportRecord(url)
+		if format == "csv" {
+			csvWriter.Write(record.csv())
+		} else if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery != 0 {
+			return nil
+		}
+		// stop reading from the store as soon as the client goes away
+		if err := gc.Request.Context().Err(); err != nil {
+			return err
+		}
+		return flush()
+	})
+	if err != nil && !started {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error exporting URLs.",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error exporting URLs, export ended early (count: %v) (%v)", count, err)
+		return
+	}
+	if !started {
+		start()
+	}
+	if err := flush(); err != nil {
+		log.Printf("Error exporting URLs, export ended early (count: %v) (%v)", count, err)
+	}
+}
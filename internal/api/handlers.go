@@ -0,0 +1,689 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/cache"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// page returned to visitors following an unknown short URL
+const notFoundPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Short URL not found</title>
+</head>
+<body>
+<h1>Short URL not found</h1>
+<p>The link you followed does not exist or has been removed.</p>
+</body>
+</html>
+`
+
+// page returned to visitors following an expired or used up short URL
+const gonePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Short URL no longer available</title>
+</head>
+<body>
+<h1>Short URL no longer available</h1>
+<p>The link you followed has expired or reached its limit and is no longer available.</p>
+</body>
+</html>
+`
+
+// number of generated slugs to try before giving up on creating a short URL
+const maxSlugAttempts = 10
+
+// limits for the tags of a short URL
+const (
+	maxTags   = 20
+	maxTagLen = 50
+)
+
+/*
+	Checks if the provided slug is a valid generated slug or a valid vanity slug.
+*/
+func (s *server) isValidSlug(slug string) bool {
+	if util.IsValidSlug(s.config.MaxSlugLen, slug) {
+		return true
+	}
+	return util.IsValidVanitySlug(s.config.VanitySlugRegexp, slug)
+}
+
+// static route segments under /v1/urls/, which a short URL with the same slug could never be looked up behind
+var staticUrlSegments = []string{"export", "trending"}
+
+/*
+	Checks if the provided slug is reserved in the configuration or taken by a static route, whatever the configuration says.
+*/
+func (s *server) isReservedSlug(slug string) bool {
+	return util.IsReservedSlug(s.config.ReservedSlugs, slug) || util.IsReservedSlug(staticUrlSegments, slug)
+}
+
+/*
+	Converts the expiry fields of a create or update request into an absolute expiry time.
+	Returns false if both expiresAt and ttlSeconds are provided, or if the expiry time has already passed.
+*/
+func setExpiry(url *model.Url, now time.Time) bool {
+	if url.TTLSeconds != 0 {
+		if url.ExpiresAt != 0 {
+			return false
+		}
+		url.ExpiresAt = uint64(now.Unix()) + url.TTLSeconds
+		url.TTLSeconds = 0
+	}
+	return url.ExpiresAt == 0 || url.ExpiresAt > uint64(now.Unix())
+}
+
+/*
+	Trims and removes duplicate tags of the URL. Returns false if there are too many tags or a tag is empty or too long.
+	Tags that were not provided are left nil, so updates keep the existing tags.
+*/
+func normalizeTags(url *model.Url) bool {
+	if url.Tags == nil {
+		return true
+	}
+	if len(url.Tags) > maxTags {
+		return false
+	}
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range url.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > maxTagLen || strings.Contains(tag, ",") {
+			return false
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	url.Tags = tags
+	return true
+}
+
+/*
+	Registers all of the API routes and returns the router used to serve them.
+*/
+func (s *server) router() *gin.Engine {
+	router := gin.Default()
+	router.SetTrustedProxies(nil)
+
+	router.GET("/v1/ping", s.ping)
+	router.GET("/v1/urls/:slug", s.getUrl)
+
+	// mutating and listing routes require an API key whose role grants the permission
+	router.POST("/v1/urls", s.authenticate, s.require(rbac.WriteUrls), s.createUrl)
+	router.POST("/v1/urls:method", s.requireUrlsMethod, s.authenticate, s.require(rbac.WriteUrls), s.urlsMethod)
+	router.GET("/v1/urls", s.authenticate, s.require(rbac.ReadUrls), s.getUrls)
+	router.GET("/v1/urls/export", s.authenticate, s.require(rbac.ReadUrls), s.exportUrls)
+	router.GET("/v1/urls/trending", s.authenticate, s.require(rbac.ReadStats), s.getTrendingUrls)
+	router.PUT("/v1/urls/:slug", s.authenticate, s.require(rbac.WriteUrls), s.updateUrl)
+	router.DELETE("/v1/urls/:slug", s.authenticate, s.require(rbac.WriteUrls), s.deleteUrl)
+	router.GET("/v1/jobs/:id", s.authenticate, s.require(rbac.WriteUrls), s.getJob)
+	router.GET("/v1/urls/:slug/stats", s.authenticate, s.require(rbac.ReadStats), s.getUrlStats)
+
+	// API keys and their roles are managed by admins
+	router.POST("/v1/keys", s.authenticate, s.require(rbac.ManageKeys), s.createApiKey)
+	router.GET("/v1/keys", s.authenticate, s.require(rbac.ManageKeys), s.getApiKeys)
+	router.PUT("/v1/keys/:id/role", s.authenticate, s.require(rbac.ManageKeys), s.updateApiKeyRole)
+	router.DELETE("/v1/keys/:id", s.authenticate, s.require(rbac.ManageKeys), s.deleteApiKey)
+	router.GET("/v1/roles", s.authenticate, s.require(rbac.ManageKeys), s.getRoles)
+	router.DELETE("/v1/cache", s.authenticate, s.require(rbac.ManageCache), s.flushCache)
+	router.GET("/v1/metrics", s.authenticate, s.require(rbac.ReadMetrics), s.getMetrics)
+	router.GET("/v1/events/stream", s.authenticate, s.require(rbac.ReadStats), s.getEventStream)
+	router.POST("/v1/webhooks", s.authenticate, s.require(rbac.ManageWebhooks), s.createWebhook)
+	router.GET("/v1/webhooks", s.authenticate, s.require(rbac.ManageWebhooks), s.getWebhooks)
+	router.DELETE("/v1/webhooks/:id", s.authenticate, s.require(rbac.ManageWebhooks), s.deleteWebhook)
+	router.GET("/v1/webhooks/:id/deliveries", s.authenticate, s.require(rbac.ManageWebhooks), s.getWebhookDeliveries)
+
+	router.GET("/:slug", s.redirect)
+	router.HEAD("/:slug", s.redirect)
+	router.POST("/:slug", s.redirect)
+	router.NoRoute(s.noRoute)
+
+	return router
+}
+
+// ping health check
+func (s *server) ping(gc *gin.Context) {
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "pong",
+	})
+}
+
+/*
+	Validates a new short URL from a create request and fills in the fields set by the server.
+	Returns the message explaining why the URL is invalid, or an empty string if it is valid.
+*/
+func (s *server) prepareNewUrl(gc *gin.Context, url *model.Url, now time.Time) string {
+	// check if target URL is provided
+	if url.Target == "" {
+		return "Missing URL for shortening."
+	}
+	// check if target URL is valid
+	if !util.IsValidUrl(url.Target) {
+		return "Invalid URL for shortening."
+	}
+	// check if the vanity slug is valid
+	if url.Slug != "" && (!s.isValidSlug(url.Slug) || s.isReservedSlug(url.Slug)) {
+		return "Invalid vanity short URL provided."
+	}
+	// check if expiry is valid
+	if !setExpiry(url, now) {
+		return "Invalid expiry for shortening."
+	}
+	// check if password is valid
+	if !hashPassword(url) {
+		return "Invalid password for shortening."
+	}
+	// check if tags are valid
+	if !normalizeTags(url) {
+		return "Invalid tags for shortening."
+	}
+
+	url.Created = uint64(now.Unix())
+	url.Hits = 0
+	// there is nothing to clear on a new short URL
+	url.ClearExpiry, url.ClearMaxHits = false, false
+	url.Owner = gc.GetString(ownerKey)
+	url.Tenant = gc.GetString(tenantKey)
+	return ""
+}
+
+// create new short URL
+func (s *server) createUrl(gc *gin.Context) {
+	url := model.Url{}
+	if err := gc.ShouldBindJSON(&url); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error parsing URL for shortening.",
+		})
+		return
+	}
+
+	// a slug provided by the client is a vanity slug
+	vanity := url.Slug != ""
+	if message := s.prepareNewUrl(gc, &url, time.Now()); message != "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": message,
+		})
+		return
+	}
+
+	// generated slugs skip counter values that are reserved or already taken by a vanity slug
+	// this guarantees vanity slugs never collide with counter generated slugs
+	var err error
+	for attempt := 1; attempt <= maxSlugAttempts; attempt++ {
+		if !vanity {
+			// no slug means no counter range could be reserved
+			slugs := s.generateSlugs(1)
+			if len(slugs) == 0 {
+				gc.JSON(http.StatusServiceUnavailable, gin.H{
+					"status":  http.StatusServiceUnavailable,
+					"message": "Error creating new short URL.",
+				})
+				return
+			}
+			url.Slug = slugs[0]
+		}
+
+		err = s.store.InsertUrl(url)
+		if vanity || !errors.Is(err, model.ErrDuplicateSlug) {
+			break
+		}
+		log.Printf("Skipping taken short URL slug (slug: %v)", url.Slug)
+	}
+	// only a vanity slug chosen by the client can already exist, running out of generated slugs is a server error
+	if vanity && errors.Is(err, model.ErrDuplicateSlug) {
+		gc.JSON(http.StatusConflict, gin.H{
+			"status":  http.StatusConflict,
+			"message": "Short URL already exists.",
+		})
+		return
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error creating new short URL.",
+		})
+		return
+	}
+
+	if s.config.CacheEnabled {
+		cache.SetCachedUrl(s.f, s.config.DebugMode, s.config.CacheExpireHours, s.cacheClient, url)
+	}
+	s.publishUrl(stream.Created, url)
+
+	gc.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusCreated,
+		"message": "success",
+		"urls":    url,
+	})
+}
+
+/*
+	Looks up the provided slug, checking the cache (if enabled) before the database.
+	Expired URLs return ErrUrlExpired.
+*/
+func (s *server) lookupUrl(slug string) (model.Url, error) {
+	// check cache (if enabled) for the provided slug
+	// if not found, continue on to check the database
+	url := model.Url{}
+	cached := false
+	if s.config.CacheEnabled {
+		url, _ = cache.GetCachedUrl(s.f, s.config.DebugMode, s.cacheClient, slug)
+		cached = url.Target != ""
+	}
+	if !cached {
+		var err error
+		url, err = s.store.GetUrl(slug)
+		if err != nil {
+			return url, err
+		}
+		// URL is not in cache, so add it
+		if s.config.CacheEnabled {
+			_ = cache.SetCachedUrl(s.f, s.config.DebugMode, s.config.CacheExpireHours, s.cacheClient, url)
+		}
+	}
+
+	// expired URLs stay in the database until they are purged
+	if url.IsExpired(time.Now()) {
+		return url, model.ErrUrlExpired
+	}
+	return url, nil
+}
+
+/*
+	Updates the hit count for the given short URL.
+	Hits are counted in batches when enabled, except for URLs with a hit limit, as for those this is also the atomic check
+	that a hit is left, returning ErrHitLimitReached if not.
+	Bot hits are counted separately and do not use up the hit limit, but bots are turned away once people have used it up.
+*/
+func (s *server) countHit(url model.Url, bot bool) error {
+	if bot {
+		if url.IsHitLimitReached() {
+			return model.ErrHitLimitReached
+		}
+		if s.hits != nil {
+			s.hits.Add(url.Slug, true)
+		} else if err := s.store.IncrementUrlHits(map[string]model.HitCounts{url.Slug: {BotHits: 1}}); err != nil {
+			log.Printf("Error updating bot hits for URL (slug: %v) (%v)", url.Slug, err)
+		}
+		return nil
+	}
+	if s.hits != nil && url.MaxHits == 0 {
+		s.hits.Add(url.Slug, false)
+		return nil
+	}
+	err := s.store.UpdateUrlHits(url.Slug)
+	if errors.Is(err, model.ErrHitLimitReached) {
+		if s.config.CacheEnabled {
+			cache.DeleteCachedUrl(s.f, s.config.DebugMode, s.cacheClient, url.Slug)
+		}
+		return err
+	} else if err != nil {
+		log.Printf("Error updating hits for URL (slug: %v) (%v)", url.Slug, err)
+		// URLs with a hit limit are only served once the hit has been counted
+		if url.MaxHits != 0 {
+			return err
+		}
+	}
+	return nil
+}
+
+// get target URL from slug
+func (s *server) getUrl(gc *gin.Context) {
+	slug := gc.Param("slug")
+
+	// verify provided slug
+	if !s.isValidSlug(slug) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid short URL provided.",
+		})
+		return
+	}
+
+	url, err := s.lookupUrl(slug)
+	// protected URLs need the password before they are resolved
+	if err == nil && url.Protected {
+		err = s.verifyPassword(slug, gc.ClientIP(), requestPassword(gc))
+		if isPasswordError(err) {
+			status, message := passwordError(err)
+			gc.JSON(status, gin.H{
+				"status":  status,
+				"message": message,
+			})
+			return
+		}
+	}
+	bot := s.classifyBot(gc)
+	if err == nil {
+		err = s.countHit(url, s.isBotHit(bot))
+	}
+
+	if errors.Is(err, model.ErrUrlExpired) {
+		gc.JSON(http.StatusGone, gin.H{
+			"status":  http.StatusGone,
+			"message": "Short URL has expired.",
+		})
+	} else if errors.Is(err, model.ErrHitLimitReached) {
+		gc.JSON(http.StatusGone, gin.H{
+			"status":  http.StatusGone,
+			"message": "Short URL has reached its maximum hits.",
+		})
+	} else if err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Short URL not found.",
+		})
+	} else {
+		// looking up a short URL counts as a hit, so it is recorded as a click just like a redirect
+		s.recordClick(gc, url, bot)
+		s.countVisitor(gc, url, bot)
+		s.countTrending(gc, url, bot)
+		if s.visitors != nil {
+			url.UniqueVisitors, _ = s.visitors.Total(slug)
+		}
+		gc.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "success",
+			"urls":    url,
+		})
+	}
+}
+
+// update target URL from slug
+func (s *server) updateUrl(gc *gin.Context) {
+	slug := gc.Param("slug")
+	// verify provided slug
+	if !s.isValidSlug(slug) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid short URL provided.",
+		})
+		return
+	}
+
+	url := model.Url{}
+	if err := gc.ShouldBindJSON(&url); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error parsing URL for updating.",
+		})
+		return
+	}
+
+	// check if target URL is provided
+	if url.Target == "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Missing URL for updating.",
+		})
+		return
+	}
+	// check if target URL is valid
+	if !util.IsValidUrl(url.Target) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid URL for updating.",
+		})
+		return
+	}
+
+	// check if expiry is valid, an expiry or hit limit cannot be set and cleared at once
+	if !setExpiry(&url, time.Now()) || (url.ClearExpiry && url.ExpiresAt != 0) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid expiry for updating.",
+		})
+		return
+	}
+	if url.ClearMaxHits && url.MaxHits != 0 {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid hit limit for updating.",
+		})
+		return
+	}
+	// check if password is valid
+	if !hashPassword(&url) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid password for updating.",
+		})
+		return
+	}
+	// check if tags are valid
+	if !normalizeTags(&url) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid tags for updating.",
+		})
+		return
+	}
+
+	url.Slug = slug
+
+	// only the owner of the short URL (or an admin) can update it
+	existing, err := s.store.GetUrl(slug)
+	if err == nil && !canModify(gc, existing) {
+		gc.JSON(http.StatusForbidden, gin.H{
+			"status":  http.StatusForbidden,
+			"message": "Not allowed to update short URL.",
+		})
+		return
+	}
+
+	// update record in database
+	if err == nil {
+		err = s.store.UpdateUrl(url)
+	}
+	if errors.Is(err, model.ErrUrlNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Short URL not found.",
+		})
+		return
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error updating URL record.",
+		})
+		return
+	}
+
+	// remove the outdated record from the cache, it is added back on the next lookup
+	if s.config.CacheEnabled {
+		cache.DeleteCachedUrl(s.f, s.config.DebugMode, s.cacheClient, slug)
+	}
+
+	// return the full updated record, including fields the update left untouched
+	if updated, err := s.store.GetUrl(slug); err == nil {
+		url = updated
+	}
+	s.publishUrl(stream.Updated, url)
+
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"urls":    url,
+	})
+}
+
+// delete short URL by slug
+func (s *server) deleteUrl(gc *gin.Context) {
+	slug := gc.Param("slug")
+	// verify provided slug
+	if !s.isValidSlug(slug) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid short URL provided.",
+		})
+		return
+	}
+
+	// only the owner of the short URL (or an admin) can delete it
+	existing, err := s.store.GetUrl(slug)
+	if err == nil && !canModify(gc, existing) {
+		gc.JSON(http.StatusForbidden, gin.H{
+			"status":  http.StatusForbidden,
+			"message": "Not allowed to delete short URL.",
+		})
+		return
+	}
+
+	// delete URL from cache (if enabled)
+	if s.config.CacheEnabled {
+		cache.DeleteCachedUrl(s.f, s.config.DebugMode, s.cacheClient, slug)
+	}
+
+	// delete URL from database
+	if err == nil {
+		err = s.store.DeleteUrl(slug)
+	}
+	if err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Short URL not found.",
+		})
+	} else {
+		s.publishUrl(stream.Deleted, existing)
+		gc.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "success",
+		})
+	}
+}
+
+// remove every cached URL, visitors are served from the database until the cache fills again
+func (s *server) flushCache(gc *gin.Context) {
+	if s.config.CacheEnabled {
+		if err := cache.FlushCache(s.f, s.config.DebugMode, s.cacheClient); err != nil {
+			gc.JSON(http.StatusServiceUnavailable, gin.H{
+				"status":  http.StatusServiceUnavailable,
+				"message": "Error flushing cache.",
+			})
+			return
+		}
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+	})
+}
+
+/*
+	Redirects visitors from the short URL to the target URL.
+	HEAD requests are answered the same way but are not counted as hits.
+	Visitors to protected short URLs are shown a password form, which is posted back to the same route.
+*/
+func (s *server) redirect(gc *gin.Context) {
+	slug := gc.Param("slug")
+
+	// unknown or invalid short URLs get a friendly page instead of JSON
+	if !s.isValidSlug(slug) {
+		gc.Data(http.StatusNotFound, "text/html; charset=utf-8", []byte(notFoundPage))
+		return
+	}
+
+	url, err := s.lookupUrl(slug)
+	if err == nil && url.Protected {
+		if gc.Request.Method != http.MethodPost {
+			renderPasswordPage(gc, http.StatusUnauthorized, "")
+			return
+		}
+		err = s.verifyPassword(slug, gc.ClientIP(), gc.PostForm("password"))
+		if isPasswordError(err) {
+			status, message := passwordError(err)
+			renderPasswordPage(gc, status, message)
+			return
+		}
+	}
+	bot := s.classifyBot(gc)
+	if err == nil {
+		if gc.Request.Method == http.MethodHead {
+			if url.IsHitLimitReached() {
+				err = model.ErrHitLimitReached
+			}
+		} else {
+			err = s.countHit(url, s.isBotHit(bot))
+		}
+	}
+
+	if errors.Is(err, model.ErrUrlExpired) || errors.Is(err, model.ErrHitLimitReached) {
+		gc.Data(http.StatusGone, "text/html; charset=utf-8", []byte(gonePage))
+		return
+	} else if err != nil {
+		gc.Data(http.StatusNotFound, "text/html; charset=utf-8", []byte(notFoundPage))
+		return
+	}
+
+	s.recordClick(gc, url, bot)
+	s.countVisitor(gc, url, bot)
+	s.countTrending(gc, url, bot)
+
+	code, cacheControl := s.redirectCaching(url)
+	// answering the password form must not make the browser post the form to the target URL
+	if gc.Request.Method == http.MethodPost {
+		code = http.StatusSeeOther
+	}
+	gc.Header("Cache-Control", cacheControl)
+	gc.Redirect(code, url.Target)
+}
+
+/*
+	Returns the redirect status and Cache-Control header of a short URL.
+	Links with a hit limit are never cached by browsers, so every visit reaches the service and is refused once the limit is reached.
+	They use a temporary redirect, as browsers cache permanent redirects whatever the headers say.
+	Protected links are never cached either, so the target is only reached with the password, and expiring links are cached no longer than they resolve.
+*/
+func (s *server) redirectCaching(url model.Url) (int, string) {
+	code := s.config.RedirectCode
+	if url.MaxHits > 0 {
+		switch code {
+		case http.StatusMovedPermanently:
+			code = http.StatusFound
+		case http.StatusPermanentRedirect:
+			code = http.StatusTemporaryRedirect
+		}
+		return code, "no-store"
+	}
+	if url.Protected {
+		return code, "no-store"
+	}
+	maxAge := int64(s.config.RedirectMaxAge)
+	if url.ExpiresAt != 0 {
+		untilExpiry := int64(url.ExpiresAt) - time.Now().Unix()
+		if untilExpiry <= 0 {
+			return code, "no-store"
+		}
+		if untilExpiry < maxAge {
+			maxAge = untilExpiry
+		}
+	}
+	return code, fmt.Sprintf("private, max-age=%v", maxAge)
+}
+
+// catch all default route
+func (s *server) noRoute(gc *gin.Context) {
+	gc.JSON(http.StatusNotFound, gin.H{
+		"status":  http.StatusNotFound,
+		"message": "Invalid route.",
+	})
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/importer"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// largest import file accepted
+	maxImportBytes = 64 << 20
+	// rows inserted with one store call
+	importChunkSize = 500
+)
+
+/*
+	Returns the format of an uploaded import file, taken from the format parameter, the file name or the content type in that order.
+*/
+func importFormat(gc *gin.Context, fileName string) string {
+	if format := gc.Query("format"); format != "" {
+		return format
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(fileName), "."); ext != "" {
+		return strings.ToLower(ext)
+	}
+	switch gc.ContentType() {
+	case "text/csv":
+		return "csv"
+	case "application/json":
+		return "json"
+	}
+	return ""
+}
+
+/*
+	Starts a background job importing short URLs from a CSV or JSON export of another shortener.
+	The file is sent either as the request body or as the file field of a multipart form.
+*/
+func (s *server) importUrls(gc *gin.Context) {
+	gc.Request.Body = http.MaxBytesReader(gc.Writer, gc.Request.Body, maxImportBytes)
+	var body io.Reader = gc.Request.Body
+	fileName := ""
+	if gc.ContentType() == "multipart/form-data" {
+		header, err := gc.FormFile("file")
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Missing file for importing.",
+			})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Error reading file for importing.",
+			})
+			return
+		}
+		defer file.Close()
+		body, fileName = file, header.Filename
+	}
+
+	rows, err := importer.Parse(importFormat(gc, fileName), body, s.config.ImportMaxRows)
+	if err != nil {
+		response := gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Error reading file for importing.",
+		}
+		switch err {
+		case importer.ErrUnsupportedFormat:
+			response["message"] = "Unsupported format for importing, use csv or json."
+		case importer.ErrMissingTarget:
+			response["message"] = "Missing target URL column for importing."
+		case importer.ErrTooManyRows:
+			response["message"] = "Too many rows for importing."
+			response["max"] = s.config.ImportMaxRows
+		}
+		gc.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	j, err := s.jobs.add("import", gc.GetString(ownerKey), gc.GetString(tenantKey), len(rows))
+	if err != nil {
+		log.Printf("Error starting import job (%v)", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{
+			"status":  http.StatusInternalServerError,
+			"message": "Error starting import.",
+		})
+		return
+	}
+	go s.runImport(j, rows)
+
+	gc.Header("Location", "/v1/jobs/"+j.status.ID)
+	gc.JSON(http.StatusAccepted, gin.H{
+		"status":  http.StatusAccepted,
+		"message": "accepted",
+		"job":     j.snapshot(),
+	})
+}
+
+/*
+	Imports the rows of an import job in chunks, recording the outcome of every row on the job.
+*/
+func (s *server) runImport(j *job, rows []importer.Row) {
+	for start := 0; start < len(rows); start += importChunkSize {
+		end := start + importChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := s.importChunk(j, rows[start:end]); err != nil {
+			log.Printf("Error importing URLs (job: %v) (%v)", j.status.ID, err)
+			j.finish("Error reserving imported short URLs.")
+			return
+		}
+	}
+	if s.config.DebugMode {
+		status := j.snapshot()
+		log.Printf("[DEBUG] Finished importing URLs (job: %v) (imported: %v) (failed: %v)", status.ID, status.Succeeded, status.Failed)
+	}
+	j.finish("")
+}
+
+/*
+	Imports one chunk of rows. Original slugs are kept when they are valid, other rows get a generated slug.
+	Kept slugs are reserved on the counter before inserting, so the counter never generates them again.
+	Only fails when the slugs cannot be reserved, problems with single rows are recorded on the job.
+*/
+func (s *server) importChunk(j *job, rows []importer.Row) error {
+	now := uint64(time.Now().Unix())
+	urls := []model.Url{}
+	// the row each URL was read from
+	from := []importer.Row{}
+	// whether the URL kept its original slug
+	kept := []bool{}
+	// counter values of the kept slugs
+	reserve := []uint64{}
+	for _, row := range rows {
+		if row.Err != nil {
+			j.addError(row.Line, row.Slug, "Error reading row ("+row.Err.Error()+").")
+			continue
+		}
+		url := model.Url{
+			Target:  row.Target,
+			Tags:    row.Tags,
+			Created: row.Created,
+			Hits:    row.Hits,
+			Owner:   j.owner,
+			Tenant:  j.tenant,
+		}
+		if url.Created == 0 {
+			url.Created = now
+		}
+		if url.Target == "" {
+			j.addError(row.Line, row.Slug, "Missing URL for importing.")
+			continue
+		}
+		if !util.IsValidUrl(url.Target) {
+			j.addError(row.Line, row.Slug, "Invalid URL for importing.")
+			continue
+		}
+		if !normalizeTags(&url) {
+			j.addError(row.Line, row.Slug, "Invalid tags for importing.")
+			continue
+		}
+		if row.Slug != "" && s.isValidSlug(row.Slug) && !s.isReservedSlug(row.Slug) {
+			url.Slug = row.Slug
+			if counter, ok := util.DecodeSlug(row.Slug); ok {
+				reserve = append(reserve, counter)
+			}
+		}
+		urls = append(urls, url)
+		from = append(from, row)
+		kept = append(kept, url.Slug != "")
+	}
+
+	if err := s.cnt.Reserve(s.f, s.config.DebugMode, reserve); err != nil {
+		return err
+	}
+
+	// rows whose generated slug turned out to be taken are retried with a new one
+	pending := make([]int, len(urls))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		generated := []int{}
+		for _, i := range pending {
+			if !kept[i] {
+				generated = append(generated, i)
+			}
+		}
+		slugs := s.generateSlugs(len(generated))
+		for n, i := range generated {
+			if n < len(slugs) {
+				urls[i].Slug = slugs[n]
+			} else {
+				// an empty slug means no counter range could be reserved
+				urls[i].Slug = ""
+			}
+		}
+
+		inserting := []model.Url{}
+		indexes := []int{}
+		for _, i := range pending {
+			if urls[i].Slug == "" {
+				j.addError(from[i].Line, from[i].Slug, "Error creating new short URL.")
+				continue
+			}
+			inserting = append(inserting, urls[i])
+			indexes = append(indexes, i)
+		}
+
+		retry := []int{}
+		for n, err := range s.store.InsertUrls(inserting) {
+			i := indexes[n]
+			switch {
+			case err == nil:
+				j.addSuccess(from[i].Line, from[i].Slug, urls[i].Slug)
+				s.publishUrl(stream.Created, urls[i])
+			case errors.Is(err, model.ErrDuplicateSlug) && !kept[i] && attempt < maxSlugAttempts:
+				retry = append(retry, i)
+			case errors.Is(err, model.ErrDuplicateSlug) && kept[i]:
+				j.addError(from[i].Line, from[i].Slug, "Short URL already exists.")
+			default:
+				j.addError(from[i].Line, from[i].Slug, "Error importing short URL.")
+			}
+		}
+		pending = retry
+	}
+	return nil
+}
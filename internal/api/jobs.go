@@ -0,0 +1,203 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// states of a background job
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+const (
+	// per-row errors kept for a job, the failed count keeps counting past it
+	maxJobErrors = 1000
+	// how long finished jobs can still be looked up
+	jobRetention = 24 * time.Hour
+)
+
+/*
+	A row of a job that could not be processed.
+*/
+type jobError struct {
+	Row     int    `json:"row"`
+	Slug    string `json:"slug,omitempty"`
+	Message string `json:"message"`
+}
+
+/*
+	A row of a job whose slug could not be kept, along with the slug it was given instead.
+*/
+type jobRename struct {
+	Row     int    `json:"row"`
+	Slug    string `json:"slug"`
+	NewSlug string `json:"newSlug"`
+}
+
+/*
+	Progress of a background job as reported by GET /v1/jobs/:id.
+*/
+type jobStatus struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	State     string `json:"state"`
+	Message   string `json:"message,omitempty"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	// set when more rows failed than there are errors listed
+	ErrorsOmitted int         `json:"errorsOmitted,omitempty"`
+	Errors        []jobError  `json:"errors"`
+	Renamed       []jobRename `json:"renamed"`
+	Created       uint64      `json:"created"`
+	Finished      uint64      `json:"finished,omitempty"`
+}
+
+/*
+	A background job started by a request. Jobs only live in the memory of the server instance running them.
+*/
+type job struct {
+	mu     sync.Mutex
+	status jobStatus
+	// the caller who started the job, only they (and admins) can look it up
+	owner  string
+	tenant string
+}
+
+/*
+	Records a row that could not be processed.
+*/
+func (j *job) addError(row int, slug string, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Processed++
+	j.status.Failed++
+	if len(j.status.Errors) < maxJobErrors {
+		j.status.Errors = append(j.status.Errors, jobError{Row: row, Slug: slug, Message: message})
+	} else {
+		j.status.ErrorsOmitted++
+	}
+}
+
+/*
+	Records a row that was processed, and the slug it was given if its own could not be kept.
+*/
+func (j *job) addSuccess(row int, slug string, newSlug string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Processed++
+	j.status.Succeeded++
+	if slug != "" && slug != newSlug {
+		j.status.Renamed = append(j.status.Renamed, jobRename{Row: row, Slug: slug, NewSlug: newSlug})
+	}
+}
+
+/*
+	Marks the job as finished. A non-empty message marks it as failed, leaving the remaining rows unprocessed.
+*/
+func (j *job) finish(message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.State = jobDone
+	if message != "" {
+		j.status.State = jobFailed
+		j.status.Message = message
+	}
+	j.status.Finished = uint64(time.Now().Unix())
+	// rows fail at different stages of a chunk, so list them in file order
+	sort.Slice(j.status.Errors, func(a, b int) bool { return j.status.Errors[a].Row < j.status.Errors[b].Row })
+	sort.Slice(j.status.Renamed, func(a, b int) bool { return j.status.Renamed[a].Row < j.status.Renamed[b].Row })
+}
+
+/*
+	Returns a copy of the job progress that is safe to use while the job keeps running.
+*/
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Errors = append([]jobError{}, j.status.Errors...)
+	status.Renamed = append([]jobRename{}, j.status.Renamed...)
+	return status
+}
+
+/*
+	Keeps track of the background jobs started on this server instance.
+*/
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: map[string]*job{}}
+}
+
+/*
+	Registers a new running job for the provided caller. Jobs that finished longer ago than the retention are forgotten.
+*/
+func (r *jobRegistry) add(kind string, owner string, tenant string, total int) (*job, error) {
+	id, err := util.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	j := &job{
+		status: jobStatus{
+			ID:      id,
+			Type:    kind,
+			State:   jobRunning,
+			Total:   total,
+			Created: uint64(now.Unix()),
+		},
+		owner:  owner,
+		tenant: tenant,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, old := range r.jobs {
+		if finished := old.snapshot().Finished; finished != 0 && now.Sub(time.Unix(int64(finished), 0)) > jobRetention {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.status.ID] = j
+	return j, nil
+}
+
+/*
+	Returns the job with the provided ID, or nil if there is none.
+*/
+func (r *jobRegistry) get(id string) *job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jobs[id]
+}
+
+// get the progress of a background job
+func (s *server) getJob(gc *gin.Context) {
+	j := s.jobs.get(gc.Param("id"))
+	// jobs of other callers are reported as missing, so their IDs cannot be probed
+	if j == nil || (!hasPermission(gc, rbac.WriteAnyUrls) && (j.owner != gc.GetString(ownerKey) || j.tenant != gc.GetString(tenantKey))) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Job not found.",
+		})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"job":     j.snapshot(),
+	})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// create new API key, the key itself is only returned in this response
+func (s *server) createApiKey(gc *gin.Context) {
+	body := struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}{}
+	if err := gc.ShouldBindJSON(&body); err != nil || body.Name == "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Missing name for API key.",
+		})
+		return
+	}
+	// new keys are editors unless another role is requested
+	if body.Role == "" {
+		body.Role = rbac.Editor
+	}
+	if !rbac.IsValidRole(body.Role) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid role for API key.",
+		})
+		return
+	}
+
+	secret, err := util.GenerateApiKey()
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error creating new API key.",
+		})
+		return
+	}
+	id, err := util.GenerateID()
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error creating new API key.",
+		})
+		return
+	}
+
+	key := model.ApiKey{
+		ID:      id,
+		Name:    body.Name,
+		Hash:    util.HashApiKey(secret),
+		Role:    body.Role,
+		Created: uint64(time.Now().Unix()),
+	}
+	if err := s.store.InsertApiKey(key); err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error creating new API key.",
+		})
+		return
+	}
+
+	gc.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusCreated,
+		"message": "success",
+		"keys":    key,
+		"apiKey":  secret,
+	})
+}
+
+// get all API keys
+func (s *server) getApiKeys(gc *gin.Context) {
+	keys, err := s.store.GetApiKeys()
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error retrieving all API keys.",
+		})
+	} else {
+		gc.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "success",
+			"keys":    keys,
+		})
+	}
+}
+
+// assign a new role to API key by ID
+func (s *server) updateApiKeyRole(gc *gin.Context) {
+	body := struct {
+		Role string `json:"role"`
+	}{}
+	if err := gc.ShouldBindJSON(&body); err != nil || !rbac.IsValidRole(body.Role) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid role for API key.",
+		})
+		return
+	}
+
+	err := s.store.UpdateApiKeyRole(gc.Param("id"), body.Role)
+	if errors.Is(err, model.ErrApiKeyNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "API key not found.",
+		})
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error updating API key role.",
+		})
+	} else {
+		gc.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "success",
+		})
+	}
+}
+
+// get all roles and the permissions they grant
+func (s *server) getRoles(gc *gin.Context) {
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"roles":   rbac.Roles(),
+	})
+}
+
+// delete API key by ID
+func (s *server) deleteApiKey(gc *gin.Context) {
+	err := s.store.DeleteApiKey(gc.Param("id"))
+	if errors.Is(err, model.ErrApiKeyNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "API key not found.",
+		})
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error deleting API key.",
+		})
+	} else {
+		gc.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "success",
+		})
+	}
+}
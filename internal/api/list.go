@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"github.com/gin-gonic/gin"
+)
+
+// page sizes for listing URLs
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+/*
+	Reads the listing filters from the query string: domain, owner, tenant, createdFrom, createdTo (unix times) and tags (comma separated).
+	Callers that cannot read every URL are always limited to their own URLs, whatever owner they ask for.
+	Returns false if a filter is invalid.
+*/
+func listFilter(gc *gin.Context) (model.UrlFilter, bool) {
+	filter := model.UrlFilter{
+		Domain: gc.Query("domain"),
+		Owner:  gc.Query("owner"),
+		Tenant: gc.Query("tenant"),
+	}
+	if !hasPermission(gc, rbac.ReadAnyUrls) {
+		filter.Owner = gc.GetString(ownerKey)
+		filter.Tenant = gc.GetString(tenantKey)
+	}
+
+	var err error
+	if value := gc.Query("createdFrom"); value != "" {
+		if filter.CreatedFrom, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return filter, false
+		}
+	}
+	if value := gc.Query("createdTo"); value != "" {
+		if filter.CreatedTo, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return filter, false
+		}
+	}
+	if value := gc.Query("tags"); value != "" {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+	}
+	return filter, true
+}
+
+/*
+	Reads the listing options from the query string: the filters (see listFilter), limit, cursor, sort (created or hits) and order (asc or desc).
+	Returns false if an option is invalid.
+*/
+func listOptions(gc *gin.Context) (model.ListOptions, bool) {
+	filter, ok := listFilter(gc)
+	opts := model.ListOptions{
+		Filter: filter,
+		SortBy: gc.DefaultQuery("sort", "created"),
+		Limit:  defaultListLimit,
+		Cursor: gc.Query("cursor"),
+	}
+	if !ok || (opts.SortBy != "created" && opts.SortBy != "hits") {
+		return opts, false
+	}
+
+	switch gc.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, false
+	}
+
+	if value := gc.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, false
+		}
+		opts.Limit = limit
+	}
+	return opts, true
+}
+
+// get one page of URLs, limited to the caller's own URLs unless the caller can read every URL
+func (s *server) getUrls(gc *gin.Context) {
+	opts, ok := listOptions(gc)
+	if !ok {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid query for listing URLs.",
+		})
+		return
+	}
+
+	page, err := s.store.ListUrls(opts)
+	if errors.Is(err, model.ErrInvalidCursor) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid cursor for listing URLs.",
+		})
+	} else if err != nil {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Error retrieving all URLs.",
+		})
+	} else {
+		gc.JSON(http.StatusOK, gin.H{
+			"status":     http.StatusOK,
+			"message":    "success",
+			"urls":       page.Urls,
+			"nextCursor": page.NextCursor,
+			"total":      page.Total,
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Counters of the click event writer, as reported by the metrics API.
+*/
+type clickEventMetrics struct {
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
+
+/*
+	Returns the counters of the background writers of this server instance: pending, written and dropped hits,
+	and dropped and failed click events, the subscribers of the event stream, and delivered and failed webhook deliveries. Writers that are disabled are left out.
+*/
+func (s *server) getMetrics(gc *gin.Context) {
+	response := gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+	}
+	if s.hits != nil {
+		response["hits"] = s.hits.Metrics()
+	}
+	if s.clickEvents != nil {
+		response["clickEvents"] = clickEventMetrics{Dropped: s.clickEvents.Dropped(), Failed: s.clickEvents.Failed()}
+	}
+	if s.hub != nil {
+		response["stream"] = s.hub.Metrics()
+	}
+	if s.webhooks != nil {
+		response["webhooks"] = s.webhooks.Metrics()
+	}
+	gc.JSON(http.StatusOK, response)
+}
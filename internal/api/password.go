@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// longest password bcrypt can hash without truncating it
+const maxPasswordLen = 72
+
+var (
+	errPasswordRequired = errors.New("password required")
+	errInvalidPassword  = errors.New("invalid password")
+	errLockedOut        = errors.New("too many failed password attempts")
+)
+
+// form shown to visitors following a password protected short URL
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<h1>Password required</h1>
+<p>The link you followed is password protected.</p>
+{{if .}}<p><strong>{{.}}</strong></p>
+{{end}}<form method="post">
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+/*
+	Replaces the plaintext password of a create or update request with a salted hash.
+	Returns false if the password is too long to be hashed.
+*/
+func hashPassword(url *model.Url) bool {
+	url.Protected = false
+	url.PasswordHash = ""
+	if url.Password == "" {
+		return true
+	}
+	if len(url.Password) > maxPasswordLen {
+		return false
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(url.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing URL password (%v)", err)
+		return false
+	}
+	url.Protected = true
+	url.PasswordHash = string(hash)
+	url.Password = ""
+	return true
+}
+
+/*
+	Checks the password provided for a protected short URL.
+	Clients are locked out of the short URL for a while after too many wrong passwords.
+*/
+func (s *server) verifyPassword(slug string, clientIP string, password string) error {
+	key := slug + "|" + clientIP
+	now := time.Now()
+	if !s.lockout.Allowed(key, now) {
+		return errLockedOut
+	}
+	if password == "" {
+		return errPasswordRequired
+	}
+
+	// the password hash is never cached, so it always comes from the database
+	url, err := s.store.GetUrl(slug)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)) != nil {
+		s.lockout.Fail(key, now)
+		log.Printf("Invalid password for protected URL (slug: %v) (client: %v)", slug, clientIP)
+		return errInvalidPassword
+	}
+
+	s.lockout.Reset(key)
+	return nil
+}
+
+/*
+	Returns the password sent with an API request, either in the X-Url-Password header or a JSON body.
+*/
+func requestPassword(gc *gin.Context) string {
+	if password := gc.GetHeader("X-Url-Password"); password != "" {
+		return password
+	}
+	body := struct {
+		Password string `json:"password"`
+	}{}
+	if gc.Request.ContentLength > 0 {
+		_ = gc.ShouldBindJSON(&body)
+	}
+	return body.Password
+}
+
+/*
+	Renders the password form, with an optional message explaining why the last attempt failed.
+*/
+func renderPasswordPage(gc *gin.Context, status int, message string) {
+	gc.Status(status)
+	gc.Header("Content-Type", "text/html; charset=utf-8")
+	gc.Header("Cache-Control", "no-store")
+	if err := passwordPage.Execute(gc.Writer, message); err != nil {
+		log.Printf("Error rendering password page (%v)", err)
+	}
+}
+
+/*
+	Checks if the error came from verifying the password, rather than looking up the short URL.
+*/
+func isPasswordError(err error) bool {
+	return errors.Is(err, errPasswordRequired) || errors.Is(err, errInvalidPassword) || errors.Is(err, errLockedOut)
+}
+
+/*
+	Maps password verification errors to a status code and message.
+*/
+func passwordError(err error) (int, string) {
+	switch {
+	case errors.Is(err, errLockedOut):
+		return http.StatusTooManyRequests, "Too many failed password attempts, try again later."
+	case errors.Is(err, errInvalidPassword):
+		return http.StatusUnauthorized, "Incorrect password."
+	default:
+		return http.StatusUnauthorized, "Password required."
+	}
+}
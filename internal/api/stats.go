@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/stats"
+	"example.com/url-shortener/internal/visitors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// window of statistics when none is requested
+	defaultStatsWindow = 7 * 24 * time.Hour
+	// most buckets returned by a statistics request
+	maxStatsBuckets = 1000
+	// values returned per dimension by default, and at most
+	defaultStatsTop = 10
+	maxStatsTop     = 100
+)
+
+// length of the supported bucket intervals in seconds
+var statsIntervals = map[string]uint64{
+	"hour": 3600,
+	"day":  86400,
+}
+
+// parses a window like 24h or 7d, adding days to the units time.ParseDuration understands
+func parseStatsWindow(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.ParseUint(days, 10, 16)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(value)
+}
+
+/*
+	Reads the statistics window from the query string: to (unix time, defaults to now) and either from (unix time) or window (i.e. 24h or 7d).
+	Buckets are hourly or daily (interval hour or day), defaulting to hourly for windows of up to two days.
+	The window is widened to whole buckets. Returns an error message if the query is invalid.
+*/
+func statsWindow(gc *gin.Context, now time.Time) (uint64, uint64, string, string) {
+	to := uint64(now.Unix())
+	var err error
+	if value := gc.Query("to"); value != "" {
+		if to, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return 0, 0, "", "Invalid to time for statistics."
+		}
+	}
+	window := defaultStatsWindow
+	if value := gc.Query("window"); value != "" {
+		if window, err = parseStatsWindow(value); err != nil || window <= 0 {
+			return 0, 0, "", "Invalid window for statistics."
+		}
+	}
+	from := uint64(0)
+	if seconds := uint64(window / time.Second); seconds < to {
+		from = to - seconds
+	}
+	if value := gc.Query("from"); value != "" {
+		if from, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return 0, 0, "", "Invalid from time for statistics."
+		}
+	}
+	if from >= to {
+		return 0, 0, "", "Statistics window must end after it starts."
+	}
+
+	interval := gc.Query("interval")
+	if interval == "" {
+		interval = "day"
+		if to-from <= 2*statsIntervals["day"] {
+			interval = "hour"
+		}
+	}
+	seconds, ok := statsIntervals[interval]
+	if !ok {
+		return 0, 0, "", "Unsupported interval for statistics, use hour or day."
+	}
+	from -= from % seconds
+	if to%seconds != 0 {
+		to += seconds - to%seconds
+	}
+	if (to-from)/seconds > maxStatsBuckets {
+		return 0, 0, "", "Statistics window is too long for the interval."
+	}
+	return from, to, interval, ""
+}
+
+/*
+	Returns the click statistics of a short URL: total hits, and clicks, unique clicks, buckets and top values for the requested window (see statsWindow).
+	Unique visitors are counted separately (see visitors.Counter) and are added all-time, for the window and per day.
+	Statistics are read from the hourly rollups, so clicks show up once the next rollup has run.
+	Callers only see statistics of their own short URLs, unless their role can read statistics of every short URL.
+*/
+func (s *server) getUrlStats(gc *gin.Context) {
+	slug := gc.Param("slug")
+	if !s.isValidSlug(slug) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid short URL provided.",
+		})
+		return
+	}
+	from, to, interval, message := statsWindow(gc, time.Now())
+	if message != "" {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": message,
+		})
+		return
+	}
+	top := defaultStatsTop
+	if value := gc.Query("top"); value != "" {
+		var err error
+		if top, err = strconv.Atoi(value); err != nil || top < 1 || top > maxStatsTop {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid top for statistics, use 1 to 100.",
+			})
+			return
+		}
+	}
+
+	// expired short URLs keep their statistics, so they are read from the store rather than looked up
+	url, err := s.store.GetUrl(slug)
+	if errors.Is(err, model.ErrUrlNotFound) || (err == nil && !hasPermission(gc, rbac.ReadAnyStats) && !isOwner(gc, url)) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Short URL not found.",
+		})
+		return
+	}
+	var rollups []model.ClickRollup
+	if err == nil {
+		rollups, err = s.store.GetClickRollups(slug, from, to)
+	}
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error getting statistics.",
+		})
+		return
+	}
+
+	response := gin.H{
+		"status":   http.StatusOK,
+		"message":  "success",
+		"slug":     slug,
+		"hits":     url.Hits,
+		"from":     from,
+		"to":       to,
+		"interval": interval,
+		"stats":    stats.Summarize(rollups, from, to, statsIntervals[interval], top),
+	}
+	// unique visitors are left out rather than failing the statistics when they cannot be counted
+	if counts, ok := s.visitorStats(slug, from, to); ok {
+		response["visitors"] = counts
+	}
+	gc.JSON(http.StatusOK, response)
+}
+
+/*
+	Approximate unique visitors of a short URL: all-time, across the statistics window, and per day of the window.
+*/
+type visitorStats struct {
+	Total  uint64         `json:"total"`
+	Window uint64         `json:"window"`
+	Daily  []visitors.Day `json:"daily"`
+}
+
+// counts the unique visitors of the days in [from, to), returns false if visitors are not counted or counting failed
+func (s *server) visitorStats(slug string, from uint64, to uint64) (visitorStats, bool) {
+	result := visitorStats{}
+	if s.visitors == nil {
+		return result, false
+	}
+	var err error
+	if result.Total, err = s.visitors.Total(slug); err != nil {
+		return result, false
+	}
+	result.Daily, result.Window, err = s.visitors.Days(slug, time.Unix(int64(from), 0), time.Unix(int64(to)-1, 0))
+	return result, err == nil
+}
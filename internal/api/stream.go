@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/stream"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// passes an event to the event stream subscribers and the webhooks subscribed to it, when enabled
+func (s *server) publish(event stream.Event) {
+	if event.Timestamp == 0 {
+		event.Timestamp = uint64(time.Now().Unix())
+	}
+	if s.hub != nil {
+		s.hub.Publish(event)
+	}
+	if s.webhooks != nil {
+		s.webhooks.Enqueue(event)
+	}
+}
+
+// publishes a change of a short URL, including the short URL as it is now
+func (s *server) publishUrl(eventType string, url model.Url) {
+	s.publish(stream.Event{Type: eventType, Slug: url.Slug, Owner: urlOwner(url), Url: &url})
+}
+
+/*
+	Publishes a change of many short URLs by a bulk request. Only the slugs are known, and the owner only if the bulk request was limited to one.
+*/
+func (s *server) publishSlugs(eventType string, slugs []string, filter model.UrlFilter) {
+	owner := urlOwner(model.Url{Owner: filter.Owner, Tenant: filter.Tenant})
+	for _, slug := range slugs {
+		s.publish(stream.Event{Type: eventType, Slug: slug, Owner: owner})
+	}
+}
+
+/*
+	Streams click and short URL events (created, updated, deleted and expired) as Server-Sent Events, named by event type, until the client disconnects.
+	Events can be filtered by slug, and by owner (and tenant) for callers that can read statistics of every short URL, while other callers only get events of their own short URLs.
+	The stream starts with a ready event and sends a heartbeat event when idle. Subscribers that fall behind are sent an evicted event and disconnected.
+*/
+func (s *server) getEventStream(gc *gin.Context) {
+	filter := stream.Filter{Slug: gc.Query("slug")}
+	if filter.Slug != "" && !s.isValidSlug(filter.Slug) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid short URL provided.",
+		})
+		return
+	}
+	if hasPermission(gc, rbac.ReadAnyStats) {
+		filter.Owner = urlOwner(model.Url{Owner: gc.Query("owner"), Tenant: gc.Query("tenant")})
+	} else {
+		filter.Owner = urlOwner(model.Url{Owner: gc.GetString(ownerKey), Tenant: gc.GetString(tenantKey)})
+	}
+
+	var subscriber *stream.Subscriber
+	ok := false
+	// callers without an owner cannot own short URLs, so they are never subscribed to every event
+	if s.hub != nil && (filter.Owner != "" || hasPermission(gc, rbac.ReadAnyStats)) {
+		subscriber, ok = s.hub.Subscribe(filter)
+	}
+	if !ok {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Event stream is not available.",
+		})
+		return
+	}
+	defer s.hub.Unsubscribe(subscriber)
+
+	heartbeat := time.NewTicker(s.config.StreamHeartbeatSeconds * time.Second)
+	defer heartbeat.Stop()
+	// keep proxies from buffering the stream
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("X-Accel-Buffering", "no")
+	gc.Render(-1, sse.Event{Event: "ready", Data: gin.H{"slug": filter.Slug}})
+	gc.Writer.Flush()
+
+	gc.Stream(func(w io.Writer) bool {
+		select {
+		case <-gc.Request.Context().Done():
+			return false
+		case now := <-heartbeat.C:
+			gc.Render(-1, sse.Event{Event: "heartbeat", Data: gin.H{"timestamp": now.Unix()}})
+			return true
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				if subscriber.Evicted() {
+					gc.Render(-1, sse.Event{Event: "evicted", Data: gin.H{"message": "Subscriber fell behind the event stream."}})
+				}
+				return false
+			}
+			gc.Render(-1, sse.Event{Id: strconv.FormatUint(event.ID, 10), Event: event.Type, Data: event})
+			return true
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"example.com/url-shortener/internal/jwt"
+	"example.com/url-shortener/internal/stats"
+	"example.com/url-shortener/internal/stream"
+)
+
+/*
+	Deletes expired short URLs from the database on every purge interval until done is closed, publishing an expired event for each.
+	Cached entries do not need to be purged, as they never outlive the URL they belong to.
+	Raw click events and finished webhook deliveries are purged along with them once they are older than their retention period.
+*/
+func (s *server) purgeExpiredUrls(done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.PurgeIntervalMinutes * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			expired, err := s.store.DeleteExpiredUrls(uint64(now.Unix()))
+			if err != nil {
+				log.Printf("Error purging expired URLs (%v)", err)
+			} else if len(expired) > 0 {
+				log.Printf("Purged expired URLs (count: %v)", len(expired))
+			}
+			for _, url := range expired {
+				s.publishUrl(stream.Expired, url)
+			}
+			before := now.Add(-s.config.EventsRetentionDays * 24 * time.Hour)
+			if count, err := stats.PurgeClickEvents(s.f, s.config.DebugMode, s.store, uint64(before.Unix())); err != nil {
+				log.Printf("Error purging click events (%v)", err)
+			} else if count > 0 {
+				log.Printf("Purged click events (count: %v)", count)
+			}
+			before = now.Add(-s.config.WebhooksRetentionDays * 24 * time.Hour)
+			if count, err := s.store.DeleteWebhookDeliveries(uint64(before.Unix())); err != nil {
+				log.Printf("Error purging webhook deliveries (%v)", err)
+			} else if count > 0 {
+				log.Printf("Purged webhook deliveries (count: %v)", count)
+			}
+		}
+	}
+}
+
+/*
+	Rolls up new click events into hourly statistics on every rollup interval until done is closed.
+*/
+func (s *server) rollUpClickEvents(done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.StatsRollupMinutes * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := stats.RollUp(s.f, s.config.DebugMode, s.store); err != nil {
+				log.Printf("Error rolling up click events (%v)", err)
+			}
+		}
+	}
+}
+
+/*
+	Reloads the bot rule file on every refresh interval until done is closed, so new rules are picked up without a restart.
+*/
+func (s *server) refreshBotRules(done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.BotRulesRefreshMinutes * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.bots.Reload(); err != nil {
+				log.Printf("Error reloading bot rules, keeping previous rules (file: %v) (%v)", s.bots.FileName, err)
+			} else if s.config.DebugMode {
+				log.Printf("[DEBUG] Reloaded bot rules (file: %v)", s.bots.FileName)
+			}
+		}
+	}
+}
+
+/*
+	Reloads the JWT key file on every refresh interval until done is closed, so rotated keys are picked up without a restart.
+*/
+func (s *server) refreshJwtKeys(keys *jwt.FileKeySource, done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.JWTRefreshMinutes * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := keys.Reload(); err != nil {
+				log.Printf("Error reloading JWT keys, keeping previous keys (file: %v) (%v)", keys.FileName, err)
+			} else if s.config.DebugMode {
+				log.Printf("[DEBUG] Reloaded JWT keys (file: %v)", keys.FileName)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/trending"
+	"github.com/gin-gonic/gin"
+)
+
+// window of trending short URLs when none is requested
+const defaultTrendingWindow = time.Hour
+
+/*
+	Returns the short URLs clicked most within a recent window (window, i.e. 15m or 24h, defaults to an hour), most clicked first.
+	At most top short URLs are returned (defaults to 10, at most 100). Windows of up to an hour are counted by the minute, longer ones by the hour.
+	Callers only see their own short URLs, unless their role can read statistics of every short URL.
+*/
+func (s *server) getTrendingUrls(gc *gin.Context) {
+	window := defaultTrendingWindow
+	if value := gc.Query("window"); value != "" {
+		var err error
+		if window, err = parseStatsWindow(value); err != nil || window < time.Minute || window > trending.MaxWindow {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid window for trending URLs, use 1m to 24h.",
+			})
+			return
+		}
+	}
+	top := defaultStatsTop
+	if value := gc.Query("top"); value != "" {
+		var err error
+		if top, err = strconv.Atoi(value); err != nil || top < 1 || top > maxStatsTop {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid top for trending URLs, use 1 to 100.",
+			})
+			return
+		}
+	}
+
+	urls := []trending.Url{}
+	owner := ""
+	if !hasPermission(gc, rbac.ReadAnyStats) {
+		owner = urlOwner(model.Url{Owner: gc.GetString(ownerKey), Tenant: gc.GetString(tenantKey)})
+	}
+	// callers without an owner cannot own short URLs, so they never see any
+	if s.trending != nil && (owner != "" || hasPermission(gc, rbac.ReadAnyStats)) {
+		var err error
+		if urls, err = s.trending.Top(owner, window, top, time.Now()); err != nil {
+			gc.JSON(http.StatusServiceUnavailable, gin.H{
+				"status":  http.StatusServiceUnavailable,
+				"message": "Error getting trending URLs.",
+			})
+			return
+		}
+	}
+
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+		"window":  uint64(window / time.Second),
+		"urls":    urls,
+	})
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/rbac"
+	"example.com/url-shortener/internal/util"
+	"example.com/url-shortener/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// deliveries returned by the delivery log when no limit is requested, and at most
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// shortest secret accepted for a webhook, shorter secrets are too easy to guess
+const minWebhookSecretLen = 16
+
+/*
+	Checks if the authenticated caller is allowed to manage the provided webhook.
+	Callers that can read every short URL manage every webhook, others only the ones they created.
+*/
+func canManageWebhook(gc *gin.Context, hook model.Webhook) bool {
+	if hasPermission(gc, rbac.ReadAnyUrls) {
+		return true
+	}
+	return hook.Owner != "" && hook.Owner == gc.GetString(ownerKey) && hook.Tenant == gc.GetString(tenantKey)
+}
+
+// reloads the webhooks of the dispatcher, so a created or deleted webhook takes effect right away
+func (s *server) refreshWebhooks() {
+	if s.webhooks != nil {
+		s.webhooks.Refresh()
+	}
+}
+
+/*
+	Creates a webhook posting the requested event types to a URL, signed with the provided secret or a generated one.
+	The URL must use https and resolve to public addresses only, unless private webhooks are allowed (see webhooks.CheckUrl).
+	The secret is only returned in this response. Webhooks created by callers that can read every short URL receive events of every short URL,
+	others only events of their own short URLs.
+*/
+func (s *server) createWebhook(gc *gin.Context) {
+	body := struct {
+		Url    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}{}
+	if err := gc.ShouldBindJSON(&body); err != nil || !util.IsValidUrl(body.Url) || !(strings.HasPrefix(body.Url, "https://") || strings.HasPrefix(body.Url, "http://")) {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid URL for webhook.",
+		})
+		return
+	}
+	if err := webhooks.CheckUrl(gc.Request.Context(), body.Url, s.config.WebhooksAllowPrivate); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid URL for webhook, use an https URL of a public host.",
+		})
+		return
+	}
+	valid := len(body.Events) > 0
+	for _, event := range body.Events {
+		valid = valid && webhooks.IsValidEvent(event)
+	}
+	if !valid {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Invalid events for webhook, use " + strings.Join(webhooks.Events, ", ") + ".",
+		})
+		return
+	}
+	if body.Secret != "" && len(body.Secret) < minWebhookSecretLen {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"status":  http.StatusBadRequest,
+			"message": "Secret for webhook must be at least " + strconv.Itoa(minWebhookSecretLen) + " characters.",
+		})
+		return
+	}
+
+	hook := model.Webhook{
+		Url:     body.Url,
+		Secret:  body.Secret,
+		Events:  body.Events,
+		Owner:   gc.GetString(ownerKey),
+		Tenant:  gc.GetString(tenantKey),
+		Created: uint64(time.Now().Unix()),
+	}
+	if !hasPermission(gc, rbac.ReadAnyUrls) {
+		hook.Scope = urlOwner(model.Url{Owner: hook.Owner, Tenant: hook.Tenant})
+		// callers without an owner cannot own short URLs, so their webhooks would never receive anything
+		if hook.Scope == "" {
+			gc.JSON(http.StatusForbidden, gin.H{
+				"status":  http.StatusForbidden,
+				"message": "Not allowed to create webhook.",
+			})
+			return
+		}
+	}
+
+	var err error
+	if hook.ID, err = util.GenerateID(); err == nil && hook.Secret == "" {
+		hook.Secret, err = util.GenerateWebhookSecret()
+	}
+	if err == nil {
+		err = s.store.InsertWebhook(hook)
+	}
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error creating new webhook.",
+		})
+		return
+	}
+	s.refreshWebhooks()
+
+	gc.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusCreated,
+		"message": "success",
+		"webhook": hook,
+		"secret":  hook.Secret,
+	})
+}
+
+// get all webhooks the caller can manage
+func (s *server) getWebhooks(gc *gin.Context) {
+	hooks, err := s.store.GetWebhooks()
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error retrieving all webhooks.",
+		})
+		return
+	}
+	allowed := []model.Webhook{}
+	for _, hook := range hooks {
+		if canManageWebhook(gc, hook) {
+			allowed = append(allowed, hook)
+		}
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"status":   http.StatusOK,
+		"message":  "success",
+		"webhooks": allowed,
+	})
+}
+
+// looks up the webhook requested by ID, responding with an error and returning false if it is missing or not the caller's
+func (s *server) managedWebhook(gc *gin.Context) (model.Webhook, bool) {
+	hook, err := s.store.GetWebhook(gc.Param("id"))
+	if errors.Is(err, model.ErrWebhookNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found.",
+		})
+		return hook, false
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error retrieving webhook.",
+		})
+		return hook, false
+	}
+	if !canManageWebhook(gc, hook) {
+		gc.JSON(http.StatusForbidden, gin.H{
+			"status":  http.StatusForbidden,
+			"message": "Not allowed to manage webhook.",
+		})
+		return hook, false
+	}
+	return hook, true
+}
+
+/*
+	Deletes a webhook by ID. Pending deliveries are given up on, and its delivery log is kept until finished deliveries are purged.
+*/
+func (s *server) deleteWebhook(gc *gin.Context) {
+	hook, ok := s.managedWebhook(gc)
+	if !ok {
+		return
+	}
+	err := s.store.DeleteWebhook(hook.ID)
+	if errors.Is(err, model.ErrWebhookNotFound) {
+		gc.JSON(http.StatusNotFound, gin.H{
+			"status":  http.StatusNotFound,
+			"message": "Webhook not found.",
+		})
+		return
+	} else if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error deleting webhook.",
+		})
+		return
+	}
+	s.refreshWebhooks()
+
+	gc.JSON(http.StatusOK, gin.H{
+		"status":  http.StatusOK,
+		"message": "success",
+	})
+}
+
+/*
+	Returns the delivery log of a webhook, newest first: every delivery with its status (pending, delivered or failed), attempts and the outcome of the last attempt.
+	At most limit deliveries are returned (defaults to 50, at most 500).
+*/
+func (s *server) getWebhookDeliveries(gc *gin.Context) {
+	limit := defaultDeliveryLimit
+	if value := gc.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxDeliveryLimit {
+			gc.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid limit for webhook deliveries, use 1 to 500.",
+			})
+			return
+		}
+	}
+	hook, ok := s.managedWebhook(gc)
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.store.GetWebhookDeliveries(hook.ID, limit)
+	if err != nil {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  http.StatusServiceUnavailable,
+			"message": "Error retrieving webhook deliveries.",
+		})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"status":     http.StatusOK,
+		"message":    "success",
+		"deliveries": deliveries,
+	})
+}
@@ -0,0 +1,126 @@
+package bots
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+/*
+	Names the bot whose user agents match the pattern, a regular expression matched case-insensitively anywhere in the user agent.
+*/
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+/*
+	Rules used when no rule file is configured. Link unfurlers come first, as they are the most common bot traffic on short links.
+*/
+var DefaultRules = []Rule{
+	{Name: "Slack", Pattern: `Slackbot|Slack-ImgProxy`},
+	{Name: "Twitter", Pattern: `Twitterbot`},
+	{Name: "Facebook", Pattern: `facebookexternalhit|Facebot|meta-externalagent`},
+	{Name: "LinkedIn", Pattern: `LinkedInBot`},
+	{Name: "Discord", Pattern: `Discordbot`},
+	{Name: "Telegram", Pattern: `TelegramBot`},
+	{Name: "WhatsApp", Pattern: `WhatsApp`},
+	{Name: "Microsoft Teams", Pattern: `SkypeUriPreview|MicrosoftPreview`},
+	{Name: "Apple", Pattern: `Applebot`},
+	{Name: "Google", Pattern: `Googlebot|Google-InspectionTool|AdsBot-Google|Mediapartners-Google`},
+	{Name: "Bing", Pattern: `bingbot|BingPreview`},
+	{Name: "curl", Pattern: `^curl/`},
+	{Name: "Wget", Pattern: `^Wget/`},
+	{Name: "HTTP library", Pattern: `python-requests|python-urllib|Go-http-client|okhttp|axios|node-fetch|libwww-perl|Java/`},
+	{Name: "Headless browser", Pattern: `HeadlessChrome|PhantomJS`},
+	{Name: "Other bot", Pattern: `bot\b|crawler|spider|preview`},
+}
+
+// a rule with its compiled pattern
+type compiledRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+/*
+	Classifies user agents as bots using an ordered list of rules, the first matching rule names the bot.
+	Rules are read from a JSON file ({"rules": [{"name": ..., "pattern": ...}]}), call Reload to pick up changes to it.
+	Safe for concurrent use.
+*/
+type Classifier struct {
+	FileName string
+	mu       sync.RWMutex
+	rules    []compiledRule
+}
+
+/*
+	Returns a classifier using the rules in the provided file, or the default rules if fileName is empty.
+*/
+func NewClassifier(fileName string) (*Classifier, error) {
+	c := &Classifier{FileName: fileName}
+	if fileName == "" {
+		rules, err := compileRules(DefaultRules)
+		c.rules = rules
+		return c, err
+	}
+	return c, c.Reload()
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("bot rule without a name (pattern: %v)", rule.Pattern)
+		}
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for bot rule %v (%v)", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{name: rule.Name, pattern: pattern})
+	}
+	return compiled, nil
+}
+
+/*
+	Reads the rule file again. The previously loaded rules are kept if the file cannot be read or a rule is invalid.
+*/
+func (c *Classifier) Reload() error {
+	data, err := os.ReadFile(c.FileName)
+	if err != nil {
+		return err
+	}
+	file := struct {
+		Rules []Rule `json:"rules"`
+	}{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	rules, err := compileRules(file.Rules)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.rules = rules
+	c.mu.Unlock()
+	return nil
+}
+
+/*
+	Returns the name of the bot sending the user agent, or false if it is not classified as a bot.
+*/
+func (c *Classifier) Classify(userAgent string) (string, bool) {
+	if userAgent == "" {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, rule := range c.rules {
+		if rule.pattern.MatchString(userAgent) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package bots
+
+import (
+	"os"
+	"testing"
+)
+
+/*
+	Tests classifying common link unfurlers, crawlers and browsers with the default rules
+*/
+func TestClassify(t *testing.T) {
+	c, err := NewClassifier("")
+	if err != nil {
+		t.Fatalf("FAILED compiling default rules. Expected: nil error, got: %v", err)
+	}
+	var tests = []struct {
+		userAgent string
+		name      string
+		bot       bool
+	}{
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "Slack", true},
+		{"Twitterbot/1.0", "Twitter", true},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", "Facebook", true},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", "Discord", true},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Google", true},
+		{"curl/7.79.1", "curl", true},
+		{"Mozilla/5.0 (compatible; SomeNewBot/0.1)", "Other bot", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/118.0", "", false},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		name, bot := c.Classify(tt.userAgent)
+		if name != tt.name || bot != tt.bot {
+			t.Errorf("FAILED classifying user agent %q. Expected: %q %v, got: %q %v", tt.userAgent, tt.name, tt.bot, name, bot)
+		} else {
+			t.Logf("PASSED classifying user agent %q. Expected: %q %v, got: %q %v", tt.userAgent, tt.name, tt.bot, name, bot)
+		}
+	}
+}
+
+/*
+	Tests loading rules from a file, and that invalid rules keep the previously loaded ones
+*/
+func TestReload(t *testing.T) {
+	testFile := "/tmp/TestReload.json"
+	defer os.Remove(testFile)
+	os.WriteFile(testFile, []byte(`{"rules":[{"name":"Monitor","pattern":"uptime-check"}]}`), 0600)
+	c, err := NewClassifier(testFile)
+	if err != nil {
+		t.Fatalf("FAILED loading rule file. Expected: nil error, got: %v", err)
+	}
+	if name, bot := c.Classify("Uptime-Check/2.0"); !bot || name != "Monitor" {
+		t.Errorf("FAILED classifying with rule file. Expected: Monitor, got: %q %v", name, bot)
+	}
+	// the default rules are replaced by the file
+	if _, bot := c.Classify("Twitterbot/1.0"); bot {
+		t.Errorf("FAILED replacing default rules. Expected: not a bot, got: bot")
+	}
+
+	for _, data := range []string{`{"rules":[{"name":"Broken","pattern":"("}]}`, `{"rules":[{"pattern":"x"}]}`, `not json`} {
+		os.WriteFile(testFile, []byte(data), 0600)
+		if err := c.Reload(); err == nil {
+			t.Errorf("FAILED rejecting invalid rule file %q. Expected: error, got: nil", data)
+		}
+	}
+	if name, _ := c.Classify("uptime-check"); name != "Monitor" {
+		t.Errorf("FAILED keeping previous rules. Expected: Monitor, got: %q", name)
+	} else {
+		t.Logf("PASSED keeping previous rules. Expected: Monitor, got: %q", name)
+	}
+
+	// the example rule file matches the default rules
+	example, err := NewClassifier("../../example/bot_rules.json")
+	if err != nil || len(example.rules) != len(DefaultRules) {
+		t.Errorf("FAILED loading example rule file. Expected: %v rules, got: %v (%v)", len(DefaultRules), len(example.rules), err)
+	}
+}
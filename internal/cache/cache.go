@@ -26,16 +26,41 @@ func GetCacheClient(host string, port string, db int, pass string) *redis.Client
 	return cacheClient
 }
 
+/*
+	Returns how long the URL can stay cached, which is never longer than until the URL expires.
+	Returns false if the URL has already expired and must not be cached.
+*/
+func cacheExpiry(expireHours time.Duration, url model.Url) (time.Duration, bool) {
+	expire := expireHours * time.Hour
+	if url.ExpiresAt != 0 {
+		untilExpiry := time.Until(time.Unix(int64(url.ExpiresAt), 0))
+		if untilExpiry <= 0 {
+			return 0, false
+		}
+		if untilExpiry < expire {
+			expire = untilExpiry
+		}
+	}
+	return expire, true
+}
+
 /*
 	Adds or updates the target URL in the cache based on the provided short URL slug.
+	If the URL expires before the cache expiry, the cached entry expires with it.
 */
 func SetCachedUrl(f *os.File, debug bool, expireHours time.Duration, client *redis.Client, url model.Url) error {
 	log.SetOutput(f)
+	expire, ok := cacheExpiry(expireHours, url)
+	// an already expired URL must not be served from the cache
+	if !ok {
+		return DeleteCachedUrl(f, debug, client, url.Slug)
+	}
+
 	json, err := json.Marshal(url)
 	if err != nil {
 		log.Printf("Error marshalling cached URL (slug: %v) (%v)", url.Slug, err)
 	}
-	err = client.Set(ctx, url.Slug, json, expireHours*time.Hour).Err()
+	err = client.Set(ctx, url.Slug, json, expire).Err()
 	if err != nil {
 		log.Printf("Error setting cached URL (slug: %v) (%v)", url.Slug, err)
 	}
@@ -46,6 +71,36 @@ func SetCachedUrl(f *os.File, debug bool, expireHours time.Duration, client *red
 	return err
 }
 
+/*
+	Adds many URLs to the cache in one round trip using a pipeline. Expired URLs are skipped.
+*/
+func SetCachedUrls(f *os.File, debug bool, expireHours time.Duration, client *redis.Client, urls []model.Url) error {
+	log.SetOutput(f)
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, url := range urls {
+			expire, ok := cacheExpiry(expireHours, url)
+			if !ok {
+				continue
+			}
+			json, err := json.Marshal(url)
+			if err != nil {
+				log.Printf("Error marshalling cached URL (slug: %v) (%v)", url.Slug, err)
+				continue
+			}
+			pipe.Set(ctx, url.Slug, json, expire)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error setting cached URLs (count: %v) (%v)", len(urls), err)
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Inserted URLs in cache (count: %v)", len(urls))
+	}
+	return err
+}
+
 /*
 	Checks the cache for the provided short URL slug and returns the target URL if available.
 */
@@ -89,3 +144,48 @@ func DeleteCachedUrl(f *os.File, debug bool, client *redis.Client, slug string)
 	}
 	return err
 }
+
+/*
+	Removes the cached URL records for many short URL slugs in one round trip using a pipeline.
+*/
+func DeleteCachedUrls(f *os.File, debug bool, client *redis.Client, slugs []string) error {
+	log.SetOutput(f)
+	if len(slugs) == 0 {
+		return nil
+	}
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		// delete in chunks to keep each command small
+		for start := 0; start < len(slugs); start += 1000 {
+			end := start + 1000
+			if end > len(slugs) {
+				end = len(slugs)
+			}
+			pipe.Del(ctx, slugs[start:end]...)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Printf("Error deleting cached URLs (count: %v) (%v)", len(slugs), err)
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted URLs from cache (count: %v)", len(slugs))
+	}
+	return err
+}
+
+/*
+	Removes every cached URL record by flushing the configured cache database.
+*/
+func FlushCache(f *os.File, debug bool, client *redis.Client) error {
+	log.SetOutput(f)
+	err := client.FlushDB(ctx).Err()
+	if err != nil {
+		log.Printf("Error flushing cache (%v)", err)
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Flushed cache")
+	}
+	return err
+}
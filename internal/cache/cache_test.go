@@ -6,6 +6,7 @@ import (
 
 	"example.com/url-shortener/internal/config"
 	"example.com/url-shortener/internal/model"
+	"github.com/go-redis/redis/v9"
 )
 
 // some global variables to avoid duplication
@@ -36,6 +37,38 @@ func TestSetCachedUrl(t *testing.T) {
 	}
 }
 
+func TestSetCachedUrls(t *testing.T) {
+	testLog := "/tmp/TestSetCachedUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	cacheClient := GetCacheClient(c.CacheHost, c.CachePort, c.CacheDB, c.CachePass)
+	urls := []model.Url{
+		{Slug: "BATCH1", Target: "https://www.google.com"},
+		{Slug: "BATCH2", Target: "https://www.reddit.com"},
+	}
+	err = SetCachedUrls(f, verbose, c.CacheExpireHours, cacheClient, urls)
+	if err != nil {
+		t.Errorf("FAILED setting cached URLs. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED setting cached URLs. Expected: nil error, got: %v", err)
+	}
+	url, err := GetCachedUrl(f, verbose, cacheClient, "BATCH2")
+	if err != nil || url.Target != "https://www.reddit.com" {
+		t.Errorf("FAILED getting batch cached URL. Expected: https://www.reddit.com, got: %v (%v)", url.Target, err)
+	}
+	err = DeleteCachedUrls(f, verbose, cacheClient, []string{"BATCH1", "BATCH2"})
+	if err != nil {
+		t.Errorf("FAILED deleting cached URLs. Expected: nil error, got: %v", err)
+	}
+	_, err = GetCachedUrl(f, verbose, cacheClient, "BATCH1")
+	if err != redis.Nil {
+		t.Errorf("FAILED getting deleted cached URL. Expected: %v, got: %v", redis.Nil, err)
+	}
+}
+
 func TestGetCachedUrl(t *testing.T) {
 	testLog := "/tmp/TestGetCachedUrl.log"
 	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -67,3 +100,19 @@ func TestDeleteCachedUrl(t *testing.T) {
 		t.Logf("PASSED deleting cached URL. Expected: nil error, got: %v", err)
 	}
 }
+
+func TestFlushCache(t *testing.T) {
+	testLog := "/tmp/TestFlushCache.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	cacheClient := GetCacheClient(c.CacheHost, c.CachePort, c.CacheDB, c.CachePass)
+	err = FlushCache(f, verbose, cacheClient)
+	if err != nil {
+		t.Errorf("FAILED flushing cache. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED flushing cache. Expected: nil error, got: %v", err)
+	}
+}
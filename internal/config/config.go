@@ -4,10 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"regexp"
 	"time"
+
+	"example.com/url-shortener/internal/rbac"
 )
 
+// highest database number of a Redis server with the default number of databases
+const maxCacheDB = 15
+
 type Configuration struct {
 	// General
 	ConfigDir   string
@@ -15,19 +22,56 @@ type Configuration struct {
 	CounterFile string
 	DebugMode   bool
 	LogFile     string
+	// Counter
+	CounterAllocator string
+	CounterAllocFile string
+	CounterStart     uint64
+	CounterBlockSize uint64
 	// Limits
-	MaxSlugLen int
+	MaxSlugLen    int
+	BatchMaxSize  int
+	BulkMaxUrls   int
+	ImportMaxRows int
+	// Vanity slugs
+	VanitySlugPattern string
+	ReservedSlugs     []string
+	// compiled from VanitySlugPattern when the configuration is loaded, nil when no pattern is configured
+	VanitySlugRegexp *regexp.Regexp `json:"-"`
 	// Gin
 	GinPort string
+	// Authentication, enabled unless authEnabled is set to false
+	AuthEnabled bool
+	AdminApiKey string
+	// JWT authentication
+	JWTEnabled        bool
+	JWTKeySource      string
+	JWTKeyFile        string
+	JWTSecret         string
+	JWTIssuer         string
+	JWTAudience       string
+	JWTLeewaySeconds  time.Duration
+	JWTRefreshMinutes time.Duration
+	JWTDefaultRole    string
+	// Redirects
+	RedirectCode   int
+	RedirectMaxAge int
 	// TLS
 	TlsCrt string
 	TlsKey string
 	// Database
+	DBDriver     string
 	DBUser       string
 	DBPass       string
 	DBConnString string
 	DBDatabase   string
 	DBCollection string
+	// collection holding the shared counter document used by the mongo counter allocator
+	DBCountersCollection   string
+	DBKeysCollection       string
+	DBEventsCollection     string
+	DBRollupsCollection    string
+	DBWebhooksCollection   string
+	DBDeliveriesCollection string
 	// Cache
 	CacheEnabled     bool
 	CacheHost        string
@@ -35,6 +79,47 @@ type Configuration struct {
 	CachePass        string
 	CacheDB          int
 	CacheExpireHours time.Duration
+	// Expiry
+	PurgeIntervalMinutes time.Duration
+	// Password protection
+	PasswordMaxFailures    int
+	PasswordLockoutMinutes time.Duration
+	// Click events
+	EventsEnabled      bool
+	EventsQueueSize    int
+	EventsBatchSize    int
+	EventsFlushSeconds time.Duration
+	// header holding the country code of the client, set by a proxy or CDN in front of the service (countries are not recorded if empty)
+	EventsCountryHeader string
+	StatsRollupMinutes  time.Duration
+	// days raw click events are kept once rolled up, statistics are served from the rollups
+	EventsRetentionDays time.Duration
+	// Hit counting
+	HitsFlushSeconds time.Duration
+	HitsMaxPending   int
+	// Unique visitors and trending short URLs, counted in their own cache database so flushing the cache keeps them
+	VisitorsCacheDB       int
+	VisitorsRetentionDays time.Duration
+	// Bot traffic, classified by user agent with the rules in BotRulesFile (built in rules if empty)
+	BotRulesFile           string
+	BotRulesRefreshMinutes time.Duration
+	// count hits and unique visitors of bots like those of people, instead of separately
+	CountBotHits bool
+	// Event stream, where every subscriber buffers up to StreamBufferSize events before it is dropped for falling behind
+	StreamBufferSize       int
+	StreamMaxSubscribers   int
+	StreamHeartbeatSeconds time.Duration
+	// Webhooks, retried with exponential backoff from WebhooksBackoffSeconds up to WebhooksMaxBackoffMinutes, and given up after WebhooksMaxAttempts
+	WebhooksQueueSize         int
+	WebhooksMaxAttempts       int
+	WebhooksBackoffSeconds    time.Duration
+	WebhooksMaxBackoffMinutes time.Duration
+	WebhooksTimeoutSeconds    time.Duration
+	WebhooksPollSeconds       time.Duration
+	// days finished deliveries are kept in the delivery log
+	WebhooksRetentionDays time.Duration
+	// allow webhooks over http and to loopback, private and link-local addresses, only meant for trying webhooks out on a development machine
+	WebhooksAllowPrivate bool
 }
 
 /*
@@ -54,7 +139,9 @@ func LoadConfig(configFileName string, verbose *bool) Configuration {
 	}
 	defer configFile.Close()
 
-	config := Configuration{}
+	// the visitors cache database starts out negative so an unset value can be told apart from database 0,
+	// and authentication is on unless the file turns it off, so an older file never leaves the API open
+	config := Configuration{VisitorsCacheDB: -1, AuthEnabled: true}
 	decoder := json.NewDecoder(configFile)
 	err = decoder.Decode(&config)
 	if err != nil {
@@ -69,5 +156,221 @@ func LoadConfig(configFileName string, verbose *bool) Configuration {
 	config.TlsCrt = fmt.Sprintf("%v/%v", config.ConfigDir, config.TlsCrt)
 	config.TlsKey = fmt.Sprintf("%v/%v", config.ConfigDir, config.TlsKey)
 
+	// vanity slugs follow the generated slug rules unless a separate pattern is configured
+	if config.VanitySlugPattern != "" {
+		re, err := regexp.Compile(config.VanitySlugPattern)
+		if err != nil {
+			log.Fatalf("Invalid vanity slug pattern in configuration file (%v)", err)
+		}
+		config.VanitySlugRegexp = re
+	}
+	if config.ReservedSlugs == nil {
+		config.ReservedSlugs = []string{"v1", "api", "admin", "health", "export", "trending"}
+	}
+
+	// default to reserving counter ranges from a file on disk
+	if config.CounterAllocator == "" {
+		config.CounterAllocator = "file"
+	}
+	if config.CounterAllocator != "static" && config.CounterAllocator != "file" && config.CounterAllocator != "mongo" {
+		log.Fatalf("Invalid counter allocator in configuration file (allocator: %v)", config.CounterAllocator)
+	}
+	if config.CounterAllocFile == "" {
+		config.CounterAllocFile = "counter_alloc.dat"
+	}
+	config.CounterAllocFile = fmt.Sprintf("%v/%v", config.ConfigDir, config.CounterAllocFile)
+	// start after the legacy fixed range (1000000 through 2000000) so previously generated slugs are never reissued
+	if config.CounterStart == 0 {
+		config.CounterStart = 2000001
+	}
+	if config.CounterBlockSize == 0 {
+		config.CounterBlockSize = 1000000
+	}
+	// default to shortening at most 1000 URLs per batch request
+	if config.BatchMaxSize <= 0 {
+		config.BatchMaxSize = 1000
+	}
+	// default to changing at most 10000 URLs per bulk update or delete
+	if config.BulkMaxUrls <= 0 {
+		config.BulkMaxUrls = 10000
+	}
+	// default to importing at most 100000 URLs per file
+	if config.ImportMaxRows <= 0 {
+		config.ImportMaxRows = 100000
+	}
+	// default to purging expired URLs once an hour
+	if config.PurgeIntervalMinutes <= 0 {
+		config.PurgeIntervalMinutes = 60
+	}
+
+	// default to locking out a client for 15 minutes after 5 wrong passwords for a short URL
+	if config.PasswordMaxFailures <= 0 {
+		config.PasswordMaxFailures = 5
+	}
+	if config.PasswordLockoutMinutes <= 0 {
+		config.PasswordLockoutMinutes = 15
+	}
+
+	// default to storing URLs in MongoDB
+	if config.DBDriver == "" {
+		config.DBDriver = "mongo"
+	}
+	if config.DBDriver != "mongo" && config.DBDriver != "memory" && config.DBDriver != "bolt" {
+		log.Fatalf("Invalid database driver in configuration file (driver: %v)", config.DBDriver)
+	}
+	if config.CounterAllocator == "mongo" && config.DBDriver != "mongo" {
+		log.Fatalf("The mongo counter allocator requires the mongo database driver (driver: %v)", config.DBDriver)
+	}
+	if config.DBCountersCollection == "" {
+		config.DBCountersCollection = "counters"
+	}
+	if config.DBKeysCollection == "" {
+		config.DBKeysCollection = "api_keys"
+	}
+	if config.DBEventsCollection == "" {
+		config.DBEventsCollection = "click_events"
+	}
+	if config.DBRollupsCollection == "" {
+		config.DBRollupsCollection = "click_rollups"
+	}
+	if config.DBWebhooksCollection == "" {
+		config.DBWebhooksCollection = "webhooks"
+	}
+	if config.DBDeliveriesCollection == "" {
+		config.DBDeliveriesCollection = "webhook_deliveries"
+	}
+
+	// default to buffering up to 10000 click events, written in batches of 500 at least once a second
+	if config.EventsQueueSize <= 0 {
+		config.EventsQueueSize = 10000
+	}
+	if config.EventsBatchSize <= 0 {
+		config.EventsBatchSize = 500
+	}
+	if config.EventsFlushSeconds <= 0 {
+		config.EventsFlushSeconds = 1
+	}
+	// default to rolling up click events for statistics every 5 minutes
+	if config.StatsRollupMinutes <= 0 {
+		config.StatsRollupMinutes = 5
+	}
+	// default to keeping raw click events for 30 days
+	if config.EventsRetentionDays <= 0 {
+		config.EventsRetentionDays = 30
+	}
+
+	// default to writing hits every 5 seconds, keeping hits of up to 100000 short URLs in between
+	if config.HitsFlushSeconds <= 0 {
+		config.HitsFlushSeconds = 5
+	}
+	if config.HitsMaxPending <= 0 {
+		config.HitsMaxPending = 100000
+	}
+
+	// default to counting unique visitors in the cache database after the URL cache, keeping daily counts for 90 days
+	if config.VisitorsCacheDB < 0 {
+		config.VisitorsCacheDB = config.CacheDB + 1
+	}
+	if config.CacheEnabled {
+		if config.VisitorsCacheDB == config.CacheDB {
+			log.Fatalf("Unique visitors cannot share the cache database, as flushing the cache would remove them")
+		}
+		// Redis servers have databases 0 through 15 unless configured otherwise, and Redis Cluster only has database 0
+		if config.CacheDB > maxCacheDB || config.VisitorsCacheDB > maxCacheDB {
+			log.Fatalf("Cache databases must be between 0 and %v, set visitorsCacheDB to a free database other than cacheDB (cacheDB: %v) (visitorsCacheDB: %v)", maxCacheDB, config.CacheDB, config.VisitorsCacheDB)
+		}
+	}
+	if config.VisitorsRetentionDays <= 0 {
+		config.VisitorsRetentionDays = 90
+	}
+
+	// bot rules are read from a file next to the configuration when set, and reloaded once an hour by default
+	if config.BotRulesFile != "" {
+		config.BotRulesFile = fmt.Sprintf("%v/%v", config.ConfigDir, config.BotRulesFile)
+	}
+	if config.BotRulesRefreshMinutes <= 0 {
+		config.BotRulesRefreshMinutes = 60
+	}
+
+	// default to streaming events to at most 100 subscribers, each buffering up to 256 events, with a heartbeat every 15 seconds
+	if config.StreamBufferSize <= 0 {
+		config.StreamBufferSize = 256
+	}
+	if config.StreamMaxSubscribers <= 0 {
+		config.StreamMaxSubscribers = 100
+	}
+	if config.StreamHeartbeatSeconds <= 0 {
+		config.StreamHeartbeatSeconds = 15
+	}
+
+	// default to queueing up to 10000 events for webhooks, checking for due deliveries every 5 seconds and giving up on a delivery
+	// after 8 attempts, waiting 30 seconds after the first failed attempt and doubling the wait up to an hour
+	if config.WebhooksQueueSize <= 0 {
+		config.WebhooksQueueSize = 10000
+	}
+	if config.WebhooksMaxAttempts <= 0 {
+		config.WebhooksMaxAttempts = 8
+	}
+	if config.WebhooksBackoffSeconds <= 0 {
+		config.WebhooksBackoffSeconds = 30
+	}
+	if config.WebhooksMaxBackoffMinutes <= 0 {
+		config.WebhooksMaxBackoffMinutes = 60
+	}
+	if config.WebhooksTimeoutSeconds <= 0 {
+		config.WebhooksTimeoutSeconds = 10
+	}
+	if config.WebhooksPollSeconds <= 0 {
+		config.WebhooksPollSeconds = 5
+	}
+	// default to keeping finished deliveries for a week
+	if config.WebhooksRetentionDays <= 0 {
+		config.WebhooksRetentionDays = 7
+	}
+
+	// API keys can only be managed with the admin API key
+	if config.AuthEnabled && config.AdminApiKey == "" {
+		log.Fatalf("Authentication is enabled but no admin API key is configured (set adminApiKey, or authEnabled to false to leave the API open)")
+	}
+
+	// bearer tokens are verified with keys from a PEM or JWKS file, or with a shared HMAC secret
+	if config.JWTEnabled {
+		if !config.AuthEnabled {
+			log.Fatalf("JWT authentication requires authentication to be enabled")
+		}
+		switch config.JWTKeySource {
+		case "pem", "jwks":
+			if config.JWTKeyFile == "" {
+				log.Fatalf("JWT key source %v requires a key file", config.JWTKeySource)
+			}
+			config.JWTKeyFile = fmt.Sprintf("%v/%v", config.ConfigDir, config.JWTKeyFile)
+		case "hmac":
+			if config.JWTSecret == "" {
+				log.Fatalf("JWT key source hmac requires a secret")
+			}
+		default:
+			log.Fatalf("Invalid JWT key source in configuration file (source: %v)", config.JWTKeySource)
+		}
+		// tokens without a roles claim get the same access as new API keys
+		if config.JWTDefaultRole == "" {
+			config.JWTDefaultRole = rbac.Editor
+		}
+		if !rbac.IsValidRole(config.JWTDefaultRole) {
+			log.Fatalf("Invalid JWT default role in configuration file (role: %v)", config.JWTDefaultRole)
+		}
+		if config.JWTRefreshMinutes <= 0 {
+			config.JWTRefreshMinutes = 5
+		}
+	}
+
+	// default to a temporary redirect so browsers keep sending visitors through the service
+	switch config.RedirectCode {
+	case 0:
+		config.RedirectCode = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		log.Fatalf("Invalid redirect code in configuration file (code: %v)", config.RedirectCode)
+	}
+
 	return config
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"os"
 	"testing"
 )
 
@@ -18,3 +20,73 @@ func TestLoadConfig(t *testing.T) {
 		t.Logf("PASSED loading and validating configuration file. Expected: /etc/url_shortener, got: %v", config.ConfigDir)
 	}
 }
+
+/*
+	Writes the example configuration with the provided settings changed (or removed when nil) and loads it
+*/
+func loadTestConfig(t *testing.T, settings map[string]interface{}) Configuration {
+	example, err := os.ReadFile("../../example/url_shortener.conf")
+	if err != nil {
+		t.Fatalf("FAILED reading example configuration. Expected: nil error, got: %v", err)
+	}
+	config := map[string]interface{}{}
+	json.Unmarshal(example, &config)
+	for key, value := range settings {
+		if value == nil {
+			delete(config, key)
+		} else {
+			config[key] = value
+		}
+	}
+	configFileName := "/tmp/" + t.Name() + ".conf"
+	data, _ := json.Marshal(config)
+	if err := os.WriteFile(configFileName, data, 0644); err != nil {
+		t.Fatalf("FAILED writing configuration file. Expected: nil error, got: %v", err)
+	}
+	verbose := true
+	return LoadConfig(configFileName, &verbose)
+}
+
+/*
+	Checks that unique visitors can be counted in cache database 0, and default to the database after the URL cache
+*/
+func TestVisitorsCacheDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		expected int
+	}{
+		{"database 0", map[string]interface{}{"cacheDB": 1, "visitorsCacheDB": 0}, 0},
+		{"default database", map[string]interface{}{"cacheDB": 2, "visitorsCacheDB": nil}, 3},
+	}
+	for _, tt := range tests {
+		config := loadTestConfig(t, tt.settings)
+		if config.VisitorsCacheDB != tt.expected {
+			t.Errorf("FAILED loading %v for unique visitors. Expected: %v, got: %v", tt.name, tt.expected, config.VisitorsCacheDB)
+		} else {
+			t.Logf("PASSED loading %v for unique visitors. Expected: %v, got: %v", tt.name, tt.expected, config.VisitorsCacheDB)
+		}
+	}
+}
+
+/*
+	Checks that authentication stays enabled when the configuration does not mention it, and can only be turned off explicitly
+*/
+func TestAuthEnabledDefault(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		expected bool
+	}{
+		{"unset", map[string]interface{}{"authEnabled": nil}, true},
+		{"disabled", map[string]interface{}{"authEnabled": false, "jwtEnabled": false}, false},
+	}
+	for _, tt := range tests {
+		config := loadTestConfig(t, tt.settings)
+		if config.AuthEnabled != tt.expected {
+			t.Errorf("FAILED loading %v authentication. Expected: %v, got: %v", tt.name, tt.expected, config.AuthEnabled)
+		} else {
+			t.Logf("PASSED loading %v authentication. Expected: %v, got: %v", tt.name, tt.expected, config.AuthEnabled)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+/*
+	Where the writer sends batches of click events, i.e. the configured store.
+*/
+type Sink interface {
+	InsertClickEvents(events []model.ClickEvent) error
+}
+
+/*
+	Records click events in the background, so the redirect path never waits on the database.
+	Events are queued in a bounded buffer and written in batches, once a batch is full or the flush interval passes.
+	When the buffer is full new events are dropped rather than slowing down redirects. Safe for concurrent use.
+*/
+type Writer struct {
+	F         *os.File
+	Debug     bool
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+	queue     chan model.ClickEvent
+	// guards closing the queue against concurrent Record calls
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	dropped uint64
+	failed  uint64
+}
+
+/*
+	Returns a running writer that queues up to queueSize events and writes them to sink in batches of up to batchSize.
+*/
+func NewWriter(f *os.File, debug bool, sink Sink, queueSize int, batchSize int, interval time.Duration) *Writer {
+	w := &Writer{
+		F:         f,
+		Debug:     debug,
+		sink:      sink,
+		batchSize: batchSize,
+		interval:  interval,
+		queue:     make(chan model.ClickEvent, queueSize),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+/*
+	Queues an event for writing without blocking. Returns false if the event was dropped because the queue is full or the writer is closed.
+*/
+func (w *Writer) Record(event model.ClickEvent) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		atomic.AddUint64(&w.dropped, 1)
+		return false
+	}
+	select {
+	case w.queue <- event:
+		return true
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+		return false
+	}
+}
+
+/*
+	Returns the number of events dropped because the queue was full.
+*/
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+/*
+	Returns the number of events lost because the sink failed to write them.
+*/
+func (w *Writer) Failed() uint64 {
+	return atomic.LoadUint64(&w.failed)
+}
+
+/*
+	Stops accepting events and waits until the queued events have been written, used on shutdown.
+*/
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	batch := make([]model.ClickEvent, 0, w.batchSize)
+	for {
+		select {
+		case event, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = make([]model.ClickEvent, 0, w.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = make([]model.ClickEvent, 0, w.batchSize)
+			}
+		}
+	}
+}
+
+// writes a batch to the sink, failed batches are logged and dropped so a database outage cannot fill up memory
+func (w *Writer) flush(batch []model.ClickEvent) {
+	log.SetOutput(w.F)
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.sink.InsertClickEvents(batch); err != nil {
+		atomic.AddUint64(&w.failed, uint64(len(batch)))
+		log.Printf("Error writing click events, dropping batch (count: %v) (%v)", len(batch), err)
+		return
+	}
+	if w.Debug {
+		log.Printf("[DEBUG] Wrote click events (count: %v)", len(batch))
+	}
+}
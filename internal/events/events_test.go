@@ -0,0 +1,130 @@
+package events
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+/*
+	Keeps the batches it receives, optionally blocking until released or failing every write.
+*/
+type testSink struct {
+	mu      sync.Mutex
+	batches [][]model.ClickEvent
+	release chan struct{}
+	fail    bool
+}
+
+func (s *testSink) InsertClickEvents(events []model.ClickEvent) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("database unavailable")
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *testSink) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := []int{}
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func openTestLog(t *testing.T, testLog string) *os.File {
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(testLog)
+	})
+	return f
+}
+
+/*
+	Tests that events are written in full batches, and that the rest is written on close
+*/
+func TestWriterBatches(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestWriterBatches.log")
+	sink := &testSink{}
+	w := NewWriter(f, true, sink, 100, 4, time.Hour)
+	for i := 0; i < 10; i++ {
+		if !w.Record(model.ClickEvent{Slug: "abc"}) {
+			t.Errorf("FAILED recording click event. Expected: true, got: false")
+		}
+	}
+	w.Close()
+
+	sizes := sink.sizes()
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Errorf("FAILED writing click events in batches. Expected: [4 4 2], got: %v", sizes)
+	} else {
+		t.Logf("PASSED writing click events in batches. Expected: [4 4 2], got: %v", sizes)
+	}
+	if w.Record(model.ClickEvent{Slug: "abc"}) {
+		t.Errorf("FAILED recording click event after close. Expected: false, got: true")
+	}
+}
+
+/*
+	Tests that partial batches are written once the flush interval passes
+*/
+func TestWriterInterval(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestWriterInterval.log")
+	sink := &testSink{}
+	w := NewWriter(f, true, sink, 100, 100, 10*time.Millisecond)
+	defer w.Close()
+	w.Record(model.ClickEvent{Slug: "abc"})
+
+	for i := 0; i < 100 && len(sink.sizes()) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := sink.sizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("FAILED writing click events on interval. Expected: [1], got: %v", sizes)
+	}
+}
+
+/*
+	Tests that events are dropped instead of blocking once the queue is full, and that failed writes are counted
+*/
+func TestWriterFull(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestWriterFull.log")
+	sink := &testSink{release: make(chan struct{})}
+	w := NewWriter(f, true, sink, 2, 1, time.Hour)
+
+	// the first event is taken by the blocked write, the next two fill the queue
+	w.Record(model.ClickEvent{Slug: "abc"})
+	for i := 0; i < 100 && len(w.queue) > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	w.Record(model.ClickEvent{Slug: "abc"})
+	w.Record(model.ClickEvent{Slug: "abc"})
+	if w.Record(model.ClickEvent{Slug: "abc"}) || w.Dropped() != 1 {
+		t.Errorf("FAILED dropping click event with full queue. Expected: 1 dropped, got: %v", w.Dropped())
+	} else {
+		t.Logf("PASSED dropping click event with full queue. Expected: 1 dropped, got: %v", w.Dropped())
+	}
+
+	sink.mu.Lock()
+	sink.fail = true
+	sink.mu.Unlock()
+	close(sink.release)
+	w.Close()
+	if w.Failed() != 3 {
+		t.Errorf("FAILED counting failed click events. Expected: 3, got: %v", w.Failed())
+	}
+}
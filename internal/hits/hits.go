@@ -0,0 +1,203 @@
+package hits
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+/*
+	Where the batcher writes accumulated hit counts, i.e. the configured store.
+*/
+type Store interface {
+	IncrementUrlHits(hits map[string]model.HitCounts) error
+}
+
+/*
+	Counters of a batcher, as reported by the metrics API.
+*/
+type Metrics struct {
+	// hits counted but not yet written, including bot hits, and the number of short URLs they belong to
+	Pending     uint64 `json:"pending"`
+	PendingBots uint64 `json:"pendingBots"`
+	PendingUrls int    `json:"pendingUrls"`
+	// hits written to the store
+	Flushed uint64 `json:"flushed"`
+	// hits lost because too many short URLs were pending, or the batcher was closed
+	Dropped uint64 `json:"dropped"`
+	// writes to the store that failed, their hits are kept and written with the next flush
+	FailedFlushes uint64 `json:"failedFlushes"`
+}
+
+/*
+	Counts hits of short URLs in memory and writes them to the store in one batch on every flush interval,
+	so resolving a short URL does not wait on a database write. Safe for concurrent use.
+	At most maxPending short URLs are kept between flushes, hits of further short URLs are dropped until the next flush.
+	Hit limits cannot be checked on batched hits, so short URLs with a hit limit have to be counted directly.
+*/
+type Batcher struct {
+	F          *os.File
+	Debug      bool
+	store      Store
+	maxPending int
+	mu         sync.Mutex
+	pending    map[string]model.HitCounts
+	closed     bool
+	// serializes flushes, so failed batches are merged back before the next one is taken
+	flushMu       sync.Mutex
+	stop          chan struct{}
+	done          chan struct{}
+	flushed       uint64
+	dropped       uint64
+	failedFlushes uint64
+}
+
+/*
+	Returns a running batcher that writes the counted hits to store on every interval.
+*/
+func NewBatcher(f *os.File, debug bool, store Store, maxPending int, interval time.Duration) *Batcher {
+	b := &Batcher{
+		F:          f,
+		Debug:      debug,
+		store:      store,
+		maxPending: maxPending,
+		pending:    map[string]model.HitCounts{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go b.run(interval)
+	return b
+}
+
+/*
+	Counts a hit of the short URL with the provided slug, as a bot hit if bot is set. Returns false if the hit was dropped.
+*/
+func (b *Batcher) Add(slug string, bot bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts, ok := b.pending[slug]
+	if b.closed || (!ok && len(b.pending) >= b.maxPending) {
+		atomic.AddUint64(&b.dropped, 1)
+		return false
+	}
+	if bot {
+		counts.BotHits++
+	} else {
+		counts.Hits++
+	}
+	b.pending[slug] = counts
+	return true
+}
+
+// sums up human and bot hits
+func total(counts model.HitCounts) uint64 {
+	return counts.Hits + counts.BotHits
+}
+
+/*
+	Returns the current counters of the batcher.
+*/
+func (b *Batcher) Metrics() Metrics {
+	b.mu.Lock()
+	metrics := Metrics{PendingUrls: len(b.pending)}
+	for _, counts := range b.pending {
+		metrics.Pending += total(counts)
+		metrics.PendingBots += counts.BotHits
+	}
+	b.mu.Unlock()
+	metrics.Flushed = atomic.LoadUint64(&b.flushed)
+	metrics.Dropped = atomic.LoadUint64(&b.dropped)
+	metrics.FailedFlushes = atomic.LoadUint64(&b.failedFlushes)
+	return metrics
+}
+
+/*
+	Writes the pending hits to the store. If the write fails the hits are kept for the next flush,
+	as far as they fit next to the hits counted in the meantime.
+*/
+func (b *Batcher) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	log.SetOutput(b.F)
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = map[string]model.HitCounts{}
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	count := uint64(0)
+	for _, counts := range batch {
+		count += total(counts)
+	}
+	if err := b.store.IncrementUrlHits(batch); err != nil {
+		atomic.AddUint64(&b.failedFlushes, 1)
+		log.Printf("Error writing URL hits, keeping them for the next flush (count: %v) (%v)", count, err)
+		b.mu.Lock()
+		for slug, counts := range batch {
+			pending, ok := b.pending[slug]
+			if !ok && len(b.pending) >= b.maxPending {
+				atomic.AddUint64(&b.dropped, total(counts))
+				continue
+			}
+			pending.Hits += counts.Hits
+			pending.BotHits += counts.BotHits
+			b.pending[slug] = pending
+		}
+		b.mu.Unlock()
+		return err
+	}
+	atomic.AddUint64(&b.flushed, count)
+
+	if b.Debug {
+		log.Printf("[DEBUG] Wrote URL hits (count: %v) (urls: %v)", count, len(batch))
+	}
+	return nil
+}
+
+/*
+	Stops counting hits and writes the pending ones, used on shutdown. Hits that still cannot be written are dropped.
+*/
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		<-b.done
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+	close(b.stop)
+	<-b.done
+
+	err := b.Flush()
+	if err != nil {
+		b.mu.Lock()
+		for _, counts := range b.pending {
+			atomic.AddUint64(&b.dropped, total(counts))
+		}
+		b.pending = map[string]model.HitCounts{}
+		b.mu.Unlock()
+	}
+	return err
+}
+
+func (b *Batcher) run(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package hits
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+/*
+	Sums up the hits it receives, optionally failing every write.
+*/
+type testStore struct {
+	mu     sync.Mutex
+	hits   map[string]model.HitCounts
+	writes int
+	fail   bool
+}
+
+func (s *testStore) IncrementUrlHits(hits map[string]model.HitCounts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("database unavailable")
+	}
+	s.writes++
+	for slug, counts := range hits {
+		total := s.hits[slug]
+		total.Hits += counts.Hits
+		total.BotHits += counts.BotHits
+		s.hits[slug] = total
+	}
+	return nil
+}
+
+func (s *testStore) get(slug string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[slug].Hits
+}
+
+func openTestLog(t *testing.T, testLog string) *os.File {
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(testLog)
+	})
+	return f
+}
+
+/*
+	Tests that hits are summed up per short URL and written in one batch on close
+*/
+func TestBatcherClose(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestBatcherClose.log")
+	store := &testStore{hits: map[string]model.HitCounts{}}
+	b := NewBatcher(f, true, store, 100, time.Hour)
+	for i := 0; i < 5; i++ {
+		b.Add("abc", false)
+	}
+	b.Add("xyz", false)
+	b.Add("xyz", true)
+	if metrics := b.Metrics(); metrics.Pending != 7 || metrics.PendingBots != 1 || metrics.PendingUrls != 2 {
+		t.Errorf("FAILED counting pending hits. Expected: 7 hits (1 bot) of 2 URLs, got: %+v", metrics)
+	}
+
+	b.Close()
+	if store.get("abc") != 5 || store.get("xyz") != 1 || store.writes != 1 {
+		t.Errorf("FAILED writing hits on close. Expected: 5 and 1 in 1 write, got: %v and %v in %v", store.get("abc"), store.get("xyz"), store.writes)
+	} else {
+		t.Logf("PASSED writing hits on close. Expected: 5 and 1 in 1 write, got: %v and %v in %v", store.get("abc"), store.get("xyz"), store.writes)
+	}
+	if bots := store.hits["xyz"].BotHits; bots != 1 {
+		t.Errorf("FAILED writing bot hits on close. Expected: 1, got: %v", bots)
+	}
+	if metrics := b.Metrics(); metrics.Pending != 0 || metrics.Flushed != 7 {
+		t.Errorf("FAILED counting flushed hits. Expected: 0 pending, 7 flushed, got: %+v", metrics)
+	}
+	if b.Add("abc", false) || b.Metrics().Dropped != 1 {
+		t.Errorf("FAILED dropping hit after close. Expected: 1 dropped, got: %v", b.Metrics().Dropped)
+	}
+}
+
+/*
+	Tests that pending hits are written once the flush interval passes
+*/
+func TestBatcherInterval(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestBatcherInterval.log")
+	store := &testStore{hits: map[string]model.HitCounts{}}
+	b := NewBatcher(f, true, store, 100, 10*time.Millisecond)
+	defer b.Close()
+	b.Add("abc", false)
+
+	for i := 0; i < 100 && store.get("abc") == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if store.get("abc") != 1 {
+		t.Errorf("FAILED writing hits on interval. Expected: 1, got: %v", store.get("abc"))
+	}
+}
+
+/*
+	Tests that hits of new short URLs are dropped once too many are pending, and that failed writes keep the hits
+*/
+func TestBatcherFull(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestBatcherFull.log")
+	store := &testStore{hits: map[string]model.HitCounts{}, fail: true}
+	b := NewBatcher(f, true, store, 2, time.Hour)
+
+	b.Add("abc", false)
+	b.Add("xyz", false)
+	// short URLs already pending are still counted
+	if b.Add("new", false) || !b.Add("abc", false) {
+		t.Errorf("FAILED dropping hit of new URL. Expected: new dropped and abc counted")
+	}
+	if err := b.Flush(); err == nil {
+		t.Errorf("FAILED flushing hits. Expected: error, got: nil")
+	}
+	if metrics := b.Metrics(); metrics.Pending != 3 || metrics.Dropped != 1 || metrics.FailedFlushes != 1 {
+		t.Errorf("FAILED keeping hits of failed write. Expected: 3 pending, 1 dropped, 1 failed, got: %+v", metrics)
+	} else {
+		t.Logf("PASSED keeping hits of failed write. Expected: 3 pending, 1 dropped, 1 failed, got: %+v", metrics)
+	}
+
+	store.mu.Lock()
+	store.fail = false
+	store.mu.Unlock()
+	b.Close()
+	if store.get("abc") != 2 || store.get("xyz") != 1 {
+		t.Errorf("FAILED writing kept hits. Expected: 2 and 1, got: %v and %v", store.get("abc"), store.get("xyz"))
+	}
+}
@@ -0,0 +1,299 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// returned when an import file cannot be read at all
+var (
+	ErrUnsupportedFormat = errors.New("unsupported import format")
+	ErrMissingTarget     = errors.New("import file has no target URL column")
+	ErrTooManyRows       = errors.New("import file has too many rows")
+)
+
+/*
+	A short URL read from an import file. Line is the line of a CSV file, or the position of the entry in a JSON file, counting from 1.
+	Err is set when the row could not be read, in which case the other fields may be incomplete.
+	Created is 0 when the file does not say when the short URL was created.
+*/
+type Row struct {
+	Line    int
+	Slug    string
+	Target  string
+	Created uint64
+	Hits    uint64
+	Tags    []string
+	Err     error
+}
+
+/*
+	Column names (lower case, without separators) accepted for each field in order of preference, covering our own exports and those of Bitly-style hosted shorteners.
+	Short links (i.e. https://bit.ly/abc) are accepted as slugs, only the last path segment is kept.
+*/
+var columns = map[string][]string{
+	"slug":    {"slug", "keyword", "backhalf", "bitlink", "link", "shorturl", "shortlink", "id"},
+	"target":  {"target", "longurl", "url", "originalurl", "destination", "destinationurl"},
+	"created": {"created", "createdat", "createdon", "date"},
+	"hits":    {"hits", "clicks", "clickcount", "totalclicks"},
+	"tags":    {"tags", "tag"},
+}
+
+// layouts accepted for created dates that are not unix timestamps
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+/*
+	Reads the rows of an import file in the provided format (csv or json).
+	CSV files need a header row. JSON files hold a list of objects, either on their own or under a "urls" or "links" key.
+	Rows that cannot be read are returned with Err set, only problems with the file as a whole return an error.
+*/
+func Parse(format string, r io.Reader, maxRows int) ([]Row, error) {
+	switch format {
+	case "csv":
+		return parseCSV(r, maxRows)
+	case "json":
+		return parseJSON(r, maxRows)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+func parseCSV(r io.Reader, maxRows int) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrMissingTarget
+	} else if err != nil {
+		return nil, err
+	}
+	// spreadsheet tools like to start files with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	fields := fieldColumns(header)
+	if _, ok := fields["target"]; !ok {
+		return nil, ErrMissingTarget
+	}
+
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, Row{Line: parseErr.Line, Err: parseErr.Err})
+		} else if err != nil {
+			return nil, err
+		} else {
+			values := map[string]string{}
+			for field, i := range fields {
+				if i < len(record) {
+					values[field] = record[i]
+				}
+			}
+			rows = append(rows, newRow(line, values))
+		}
+		if len(rows) > maxRows {
+			return nil, ErrTooManyRows
+		}
+	}
+	return rows, nil
+}
+
+func parseJSON(r io.Reader, maxRows int) ([]Row, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := []map[string]interface{}{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		wrapped := struct {
+			Urls  []map[string]interface{} `json:"urls"`
+			Links []map[string]interface{} `json:"links"`
+		}{}
+		if err := unmarshal(data, &wrapped); err != nil {
+			return nil, err
+		}
+		entries = append(wrapped.Urls, wrapped.Links...)
+	} else if err := unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) > maxRows {
+		return nil, ErrTooManyRows
+	}
+
+	rows := []Row{}
+	for i, entry := range entries {
+		names := []string{}
+		for name := range entry {
+			names = append(names, name)
+		}
+		values := map[string]string{}
+		var err error
+		for field, column := range fieldColumns(names) {
+			if values[field], err = jsonString(entry[names[column]]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			rows = append(rows, Row{Line: i + 1, Err: err})
+			continue
+		}
+		rows = append(rows, newRow(i+1, values))
+	}
+	return rows, nil
+}
+
+// decodes JSON keeping numbers as they were written, so large click counts and timestamps are not rounded
+func unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// converts a JSON value to the text it would have in a CSV file, lists (i.e. tags) are joined with commas
+func jsonString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("invalid list value %v", item)
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("invalid value %v", value)
+}
+
+// maps each imported field to the position of its column, preferring column names listed first
+func fieldColumns(names []string) map[string]int {
+	positions := map[string]int{}
+	for i, name := range names {
+		name = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, name)
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+	fields := map[string]int{}
+	for field, aliases := range columns {
+		for _, alias := range aliases {
+			if i, ok := positions[alias]; ok {
+				fields[field] = i
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// builds a row from the text values of its fields
+func newRow(line int, values map[string]string) Row {
+	row := Row{
+		Line:   line,
+		Slug:   parseSlug(values["slug"]),
+		Target: strings.TrimSpace(values["target"]),
+		Tags:   parseTags(values["tags"]),
+	}
+	var err error
+	if row.Created, err = parseCreated(values["created"]); err != nil {
+		row.Err = err
+	} else if row.Hits, err = parseHits(values["hits"]); err != nil {
+		row.Err = err
+	}
+	return row
+}
+
+// keeps the last path segment of short links, i.e. abc for https://bit.ly/abc
+func parseSlug(value string) string {
+	value = strings.TrimRight(strings.TrimSpace(value), "/")
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	return value
+}
+
+// tags are separated by commas, semicolons or pipes
+func parseTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == '|' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// accepts unix timestamps in seconds or milliseconds and the usual date layouts
+func parseCreated(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 64); err == nil {
+		// timestamps past the year 33658 in seconds are taken as milliseconds
+		if seconds > 1e12 {
+			seconds /= 1000
+		}
+		return seconds, nil
+	}
+	for _, layout := range dateLayouts {
+		if created, err := time.Parse(layout, value); err == nil && created.Unix() > 0 {
+			return uint64(created.Unix()), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid created date %v", value)
+}
+
+// accepts whole click counts, including ones written with thousands separators
+func parseHits(value string) (uint64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+	if value == "" {
+		return 0, nil
+	}
+	hits, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid click count %v", value)
+	}
+	return hits, nil
+}
@@ -0,0 +1,72 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	data := "\ufeffTitle,Bitlink,Long URL,Created At,Clicks,Tags\n" +
+		"Home,https://bit.ly/3abcXYZ,https://www.google.com,2022-03-01T10:00:00+0000,\"1,024\",\"spring,sale\"\n" +
+		"No slug,,https://www.google.com/2,1646128800,0,\n" +
+		"Bad date,https://bit.ly/bad,https://www.google.com/3,yesterday,0,\n"
+	rows, err := Parse("csv", strings.NewReader(data), 10)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("FAILED parsing CSV import. Expected: 3 rows, got: %v (%v)", len(rows), err)
+	}
+
+	row := rows[0]
+	if row.Line != 2 || row.Slug != "3abcXYZ" || row.Target != "https://www.google.com" || row.Created != 1646128800 || row.Hits != 1024 || len(row.Tags) != 2 || row.Err != nil {
+		t.Errorf("FAILED parsing CSV row. Expected: 3abcXYZ https://www.google.com 1646128800 1024 [spring sale], got: %+v", row)
+	} else {
+		t.Logf("PASSED parsing CSV row. Expected: 3abcXYZ https://www.google.com 1646128800 1024 [spring sale], got: %+v", row)
+	}
+	if rows[1].Slug != "" || rows[1].Created != 1646128800 || rows[1].Err != nil {
+		t.Errorf("FAILED parsing CSV row without slug. Expected: no slug, got: %+v", rows[1])
+	}
+	if rows[2].Line != 4 || rows[2].Err == nil {
+		t.Errorf("FAILED parsing CSV row with invalid date. Expected: error on line 4, got: %+v", rows[2])
+	}
+
+	if _, err := Parse("csv", strings.NewReader("slug,title\nabc,Home\n"), 10); err != ErrMissingTarget {
+		t.Errorf("FAILED parsing CSV import without targets. Expected: %v, got: %v", ErrMissingTarget, err)
+	}
+	if _, err := Parse("csv", strings.NewReader(data), 2); err != ErrTooManyRows {
+		t.Errorf("FAILED parsing CSV import with too many rows. Expected: %v, got: %v", ErrTooManyRows, err)
+	}
+	if _, err := Parse("xml", strings.NewReader(data), 10); err != ErrUnsupportedFormat {
+		t.Errorf("FAILED parsing unsupported import. Expected: %v, got: %v", ErrUnsupportedFormat, err)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	data := `{"links":[
+		{"id":"bit.ly/abc","long_url":"https://www.google.com","created_at":"2022-03-01T10:00:00+0000","tags":["spring"]},
+		{"slug":"def","target":"https://www.google.com/2","created":1646128800000,"hits":12345678901},
+		{"slug":"ghi","target":"https://www.google.com/3","tags":[1]}
+	]}`
+	rows, err := Parse("json", strings.NewReader(data), 10)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("FAILED parsing JSON import. Expected: 3 rows, got: %v (%v)", len(rows), err)
+	}
+	if rows[0].Slug != "abc" || rows[0].Target != "https://www.google.com" || rows[0].Created != 1646128800 || len(rows[0].Tags) != 1 {
+		t.Errorf("FAILED parsing Bitly JSON entry. Expected: abc https://www.google.com 1646128800 [spring], got: %+v", rows[0])
+	} else {
+		t.Logf("PASSED parsing Bitly JSON entry. Expected: abc https://www.google.com 1646128800 [spring], got: %+v", rows[0])
+	}
+	if rows[1].Slug != "def" || rows[1].Created != 1646128800 || rows[1].Hits != 12345678901 {
+		t.Errorf("FAILED parsing JSON entry. Expected: def 1646128800 12345678901, got: %+v", rows[1])
+	}
+	if rows[2].Line != 3 || rows[2].Err == nil {
+		t.Errorf("FAILED parsing JSON entry with invalid tags. Expected: error on entry 3, got: %+v", rows[2])
+	}
+
+	// a plain list works as well
+	rows, err = Parse("json", strings.NewReader(`[{"url":"https://www.google.com"}]`), 10)
+	if err != nil || len(rows) != 1 || rows[0].Target != "https://www.google.com" {
+		t.Errorf("FAILED parsing JSON list. Expected: 1 row, got: %+v (%v)", rows, err)
+	}
+	if _, err := Parse("json", strings.NewReader(`{"urls":`), 10); err == nil {
+		t.Errorf("FAILED parsing invalid JSON import. Expected: error, got: nil")
+	}
+}
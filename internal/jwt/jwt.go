@@ -0,0 +1,185 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// returned when a token fails verification
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrTokenExpired         = errors.New("token has expired")
+	ErrTokenNotYetValid     = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("invalid token issuer")
+	ErrInvalidAudience      = errors.New("invalid token audience")
+)
+
+/*
+	A claim that can be sent either as a single string or as a list of strings (e.g. aud and roles).
+*/
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+/*
+	Holds the token claims used by the application.
+*/
+type Claims struct {
+	Subject   string     `json:"sub"`
+	Tenant    string     `json:"tenant"`
+	Roles     StringList `json:"roles"`
+	Issuer    string     `json:"iss"`
+	Audience  StringList `json:"aud"`
+	ExpiresAt int64      `json:"exp"`
+	NotBefore int64      `json:"nbf"`
+	IssuedAt  int64      `json:"iat"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+/*
+	Verifies signed tokens (HS256, RS256 or ES256) against the keys of a key source.
+	Issuer and Audience are only checked when set. Leeway allows for clock skew when checking exp and nbf.
+*/
+type Verifier struct {
+	Keys     KeySource
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+/*
+	Checks if the provided string looks like a token rather than an API key.
+*/
+func IsToken(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+/*
+	Verifies the signature and registered claims of the token, and returns its claims.
+*/
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	claims := Claims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrMalformedToken
+	}
+
+	h := header{}
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return claims, ErrMalformedToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrMalformedToken
+	}
+	if h.Alg != "HS256" && h.Alg != "RS256" && h.Alg != "ES256" {
+		return claims, ErrUnsupportedAlgorithm
+	}
+
+	// try every candidate key, only keys of the type matching the algorithm are ever used
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.Keys.Keys(h.Kid) {
+		if verifySignature(h.Alg, key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return claims, ErrInvalidSignature
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, ErrMalformedToken
+	}
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(v.Leeway)) {
+		return claims, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return claims, ErrTokenNotYetValid
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return claims, ErrInvalidIssuer
+	}
+	if v.Audience != "" && !contains(claims.Audience, v.Audience) {
+		return claims, ErrInvalidAudience
+	}
+	return claims, nil
+}
+
+// decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checks the signature using the provided key, which must be of the type the algorithm expects
+func verifySignature(alg string, key interface{}, signed []byte, sig []byte) bool {
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
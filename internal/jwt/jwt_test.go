@@ -0,0 +1,197 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Signs the provided claims with the key, using the algorithm matching the key type.
+*/
+func sign(t *testing.T, kid string, claims map[string]interface{}, key interface{}) string {
+	alg := map[string]string{"[]uint8": "HS256", "*rsa.PrivateKey": "RS256", "*ecdsa.PrivateKey": "ES256"}[fmt.Sprintf("%T", key)]
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		var r, s interface{ FillBytes([]byte) []byte }
+		r, s, err = ecdsa.Sign(rand.Reader, k, sum[:])
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	}
+	if err != nil {
+		t.Fatalf("FAILED signing token. Expected: nil error, got: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+/*
+	Tests the Verify function with each supported algorithm
+*/
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("test-secret")
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "user1", "tenant": "acme", "roles": []string{"editor"}, "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name   string
+		source KeySource
+		key    interface{}
+	}{
+		{"HS256", &HMACKeySource{Secret: secret}, secret},
+		{"RS256", &staticKeySource{&rsaKey.PublicKey}, rsaKey},
+		{"ES256", &staticKeySource{&ecKey.PublicKey}, ecKey},
+	}
+	for _, test := range tests {
+		v := Verifier{Keys: test.source}
+		token := sign(t, "", claims, test.key)
+		got, err := v.Verify(token, now)
+		if err != nil || got.Subject != "user1" || got.Tenant != "acme" || len(got.Roles) != 1 || got.Roles[0] != "editor" {
+			t.Errorf("FAILED verifying %v token. Expected: user1 acme [editor], got: %v %v %v (%v)", test.name, got.Subject, got.Tenant, got.Roles, err)
+		} else {
+			t.Logf("PASSED verifying %v token. Expected: user1, got: %v", test.name, got.Subject)
+		}
+
+		// flipping a byte of the signature must fail
+		i := strings.LastIndex(token, ".")
+		sig, _ := base64.RawURLEncoding.DecodeString(token[i+1:])
+		sig[0] ^= 1
+		tampered := token[:i+1] + base64.RawURLEncoding.EncodeToString(sig)
+		if _, err := v.Verify(tampered, now); err != ErrInvalidSignature {
+			t.Errorf("FAILED verifying tampered %v token. Expected: %v, got: %v", test.name, ErrInvalidSignature, err)
+		}
+	}
+
+	// an HS256 token signed with the public key must not be accepted by an RSA key source
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	token := sign(t, "", claims, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	v := Verifier{Keys: &staticKeySource{&rsaKey.PublicKey}}
+	if _, err := v.Verify(token, now); err != ErrInvalidSignature {
+		t.Errorf("FAILED verifying algorithm confusion token. Expected: %v, got: %v", ErrInvalidSignature, err)
+	}
+
+	v = Verifier{Keys: &HMACKeySource{Secret: secret}, Issuer: "gateway", Audience: "shortener", Leeway: time.Minute}
+	token = sign(t, "", map[string]interface{}{"sub": "user1", "exp": now.Add(-2 * time.Minute).Unix()}, secret)
+	if _, err := v.Verify(token, now); err != ErrTokenExpired {
+		t.Errorf("FAILED verifying expired token. Expected: %v, got: %v", ErrTokenExpired, err)
+	}
+	token = sign(t, "", map[string]interface{}{"sub": "user1", "iss": "other", "aud": "shortener"}, secret)
+	if _, err := v.Verify(token, now); err != ErrInvalidIssuer {
+		t.Errorf("FAILED verifying token from other issuer. Expected: %v, got: %v", ErrInvalidIssuer, err)
+	}
+	token = sign(t, "", map[string]interface{}{"sub": "user1", "iss": "gateway", "aud": []string{"billing", "shortener"}}, secret)
+	if _, err := v.Verify(token, now); err != nil {
+		t.Errorf("FAILED verifying token with audience list. Expected: nil error, got: %v", err)
+	}
+	if _, err := v.Verify("not.a-token", now); err != ErrMalformedToken {
+		t.Errorf("FAILED verifying malformed token. Expected: %v, got: %v", ErrMalformedToken, err)
+	}
+}
+
+type staticKeySource struct {
+	key interface{}
+}
+
+func (s *staticKeySource) Keys(kid string) []interface{} {
+	return []interface{}{s.key}
+}
+
+/*
+	Tests loading keys from PEM and JWKS files, including reloading after a key rotation
+*/
+func TestFileKeySource(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "user1"}
+
+	pemFile := "/tmp/TestFileKeySource.pem"
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	defer os.Remove(pemFile)
+	source, err := NewPEMKeySource(pemFile)
+	if err != nil {
+		t.Fatalf("FAILED loading PEM key file. Expected: nil error, got: %v", err)
+	}
+	v := Verifier{Keys: source}
+	if _, err := v.Verify(sign(t, "", claims, rsaKey), now); err != nil {
+		t.Errorf("FAILED verifying token with PEM key. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED verifying token with PEM key. Expected: nil error, got: %v", err)
+	}
+
+	jwksFile := "/tmp/TestFileKeySource.jwks"
+	defer os.Remove(jwksFile)
+	writeJWKS := func(keys ...map[string]string) {
+		data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+		os.WriteFile(jwksFile, data, 0600)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	rsaJWK := map[string]string{"kty": "RSA", "kid": "rsa1", "n": b64(rsaKey.N.Bytes()), "e": b64([]byte{1, 0, 1})}
+	ecJWK := map[string]string{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))}
+	writeJWKS(rsaJWK)
+	source, err = NewJWKSKeySource(jwksFile)
+	if err != nil {
+		t.Fatalf("FAILED loading JWKS file. Expected: nil error, got: %v", err)
+	}
+	v = Verifier{Keys: source}
+	if _, err := v.Verify(sign(t, "rsa1", claims, rsaKey), now); err != nil {
+		t.Errorf("FAILED verifying token with JWKS key. Expected: nil error, got: %v", err)
+	}
+	if _, err := v.Verify(sign(t, "ec1", claims, ecKey), now); err != ErrInvalidSignature {
+		t.Errorf("FAILED verifying token with unknown key ID. Expected: %v, got: %v", ErrInvalidSignature, err)
+	}
+
+	// rotate to the EC key
+	writeJWKS(ecJWK)
+	if err := source.Reload(); err != nil {
+		t.Fatalf("FAILED reloading JWKS file. Expected: nil error, got: %v", err)
+	}
+	if _, err := v.Verify(sign(t, "ec1", claims, ecKey), now); err != nil {
+		t.Errorf("FAILED verifying token with rotated JWKS key. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED verifying token with rotated JWKS key. Expected: nil error, got: %v", err)
+	}
+	if _, err := v.Verify(sign(t, "rsa1", claims, rsaKey), now); err != ErrInvalidSignature {
+		t.Errorf("FAILED verifying token with retired JWKS key. Expected: %v, got: %v", ErrInvalidSignature, err)
+	}
+
+	// a broken file keeps the previous keys
+	os.WriteFile(jwksFile, []byte("{"), 0600)
+	if err := source.Reload(); err == nil {
+		t.Errorf("FAILED reloading broken JWKS file. Expected: error, got: nil")
+	}
+	if _, err := v.Verify(sign(t, "ec1", claims, ecKey), now); err != nil {
+		t.Errorf("FAILED verifying token after broken reload. Expected: nil error, got: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+)
+
+/*
+	Provides the keys used to verify token signatures.
+	Keys returns the candidate keys for the key ID from the token header (which may be empty):
+	[]byte secrets for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+*/
+type KeySource interface {
+	Keys(kid string) []interface{}
+}
+
+/*
+	Verifies HS256 tokens with a single shared secret.
+*/
+type HMACKeySource struct {
+	Secret []byte
+}
+
+func (s *HMACKeySource) Keys(kid string) []interface{} {
+	return []interface{}{s.Secret}
+}
+
+// a verification key and its key ID, keys without an ID match every token
+type namedKey struct {
+	id  string
+	key interface{}
+}
+
+/*
+	Loads verification keys from a file on disk. Call Reload to pick up changes to the file, e.g. after a key rotation.
+	Safe for concurrent use.
+*/
+type FileKeySource struct {
+	FileName string
+	parse    func(data []byte) ([]namedKey, error)
+	mu       sync.RWMutex
+	keys     []namedKey
+}
+
+/*
+	Returns a key source reading PEM encoded public keys or certificates (RSA or P-256) from the provided file.
+*/
+func NewPEMKeySource(fileName string) (*FileKeySource, error) {
+	s := &FileKeySource{FileName: fileName, parse: parsePEM}
+	return s, s.Reload()
+}
+
+/*
+	Returns a key source reading a JSON Web Key Set (RSA, P-256 and oct keys) from the provided file.
+*/
+func NewJWKSKeySource(fileName string) (*FileKeySource, error) {
+	s := &FileKeySource{FileName: fileName, parse: parseJWKS}
+	return s, s.Reload()
+}
+
+/*
+	Reads the key file again. The previously loaded keys are kept if the file cannot be read or parsed.
+*/
+func (s *FileKeySource) Reload() error {
+	data, err := os.ReadFile(s.FileName)
+	if err != nil {
+		return err
+	}
+	keys, err := s.parse(data)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable keys in %v", s.FileName)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FileKeySource) Keys(kid string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []interface{}{}
+	for _, k := range s.keys {
+		if kid == "" || k.id == "" || k.id == kid {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys
+}
+
+// parses every public key or certificate in a PEM file
+func parsePEM(data []byte) ([]namedKey, error) {
+	keys := []namedKey{}
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block type %v", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, namedKey{key: key})
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parses the signing keys of a JSON Web Key Set, keys of unsupported types are skipped
+func parseJWKS(data []byte) ([]namedKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := []namedKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = parseRSAKey(k)
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			key, err = parseECKey(k)
+		case "oct":
+			key, err = base64.RawURLEncoding.DecodeString(k.K)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %v (%v)", k.Kid, err)
+		}
+		keys = append(keys, namedKey{id: k.Kid, key: key})
+	}
+	return keys, nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func parseECKey(k jwk) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("EC point is not on the curve")
+	}
+	return key, nil
+}
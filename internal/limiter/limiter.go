@@ -0,0 +1,84 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// number of tracked keys above which stale entries are swept
+const sweepThreshold = 10000
+
+/*
+	Tracks failed attempts per key (e.g. short URL slug and client IP) and locks a key out once it fails too often.
+	Failures older than the lockout duration are forgotten. Safe for concurrent use.
+*/
+type Lockout struct {
+	MaxFailures int
+	Duration    time.Duration
+	mu          sync.Mutex
+	entries     map[string]*entry
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+/*
+	Returns a lockout that locks a key for the provided duration after maxFailures failed attempts.
+*/
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	return &Lockout{MaxFailures: maxFailures, Duration: duration, entries: map[string]*entry{}}
+}
+
+/*
+	Checks if the key is currently allowed to make an attempt.
+*/
+func (l *Lockout) Allowed(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	return !ok || !now.Before(e.lockedUntil)
+}
+
+/*
+	Records a failed attempt for the key, locking it out once it reaches the maximum number of failures.
+*/
+func (l *Lockout) Fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) > sweepThreshold {
+		l.sweep(now)
+	}
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastFailure) > l.Duration {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures += 1
+	e.lastFailure = now
+	if e.failures >= l.MaxFailures {
+		e.failures = 0
+		e.lockedUntil = now.Add(l.Duration)
+	}
+}
+
+/*
+	Forgets all failed attempts for the key, used after a successful attempt.
+*/
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// removes entries that are no longer locked out and have no recent failures
+func (l *Lockout) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if !now.Before(e.lockedUntil) && now.Sub(e.lastFailure) > l.Duration {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Tests the Allowed, Fail and Reset functions
+*/
+func TestLockout(t *testing.T) {
+	l := NewLockout(3, time.Minute)
+	now := time.Now()
+
+	// two failures should not lock the key out yet
+	l.Fail("TEST1234", now)
+	l.Fail("TEST1234", now)
+	if !l.Allowed("TEST1234", now) {
+		t.Errorf("FAILED allowing key below the failure limit. Expected: true, got: false")
+	}
+
+	// the third failure should lock the key out until the lockout passes
+	l.Fail("TEST1234", now)
+	if l.Allowed("TEST1234", now) {
+		t.Errorf("FAILED locking out key at the failure limit. Expected: false, got: true")
+	}
+	if l.Allowed("TEST1234", now.Add(30*time.Second)) {
+		t.Errorf("FAILED locking out key during the lockout. Expected: false, got: true")
+	}
+	if !l.Allowed("TEST1234", now.Add(time.Minute)) {
+		t.Errorf("FAILED allowing key after the lockout. Expected: true, got: false")
+	} else {
+		t.Logf("PASSED allowing key after the lockout. Expected: true, got: true")
+	}
+
+	// other keys are not affected
+	if !l.Allowed("TEST5678", now) {
+		t.Errorf("FAILED allowing unrelated key. Expected: true, got: false")
+	}
+
+	// a reset forgets earlier failures
+	l.Fail("TEST5678", now)
+	l.Fail("TEST5678", now)
+	l.Reset("TEST5678")
+	l.Fail("TEST5678", now)
+	if !l.Allowed("TEST5678", now) {
+		t.Errorf("FAILED resetting key. Expected: true, got: false")
+	} else {
+		t.Logf("PASSED resetting key. Expected: true, got: true")
+	}
+}
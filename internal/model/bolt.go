@@ -0,0 +1,1176 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// current version of the buckets created by OpenBoltStore
+const boltSchemaVersion = 5
+
+var (
+	boltMetaBucket       = []byte("meta")
+	boltUrlsBucket       = []byte("urls")
+	boltCreatedBucket    = []byte("urls_by_created")
+	boltKeysBucket       = []byte("api_keys")
+	boltKeysByHashBucket = []byte("api_keys_by_hash")
+	boltEventsBucket     = []byte("click_events")
+	boltRollupsBucket    = []byte("click_rollups")
+	boltWebhooksBucket   = []byte("webhooks")
+	boltDeliveryBucket   = []byte("webhook_deliveries")
+	boltDueBucket        = []byte("webhook_deliveries_due")
+)
+
+/*
+	Stores short URLs in a single embedded database file, for deployments where running MongoDB is overkill.
+	URLs are keyed by slug, which keeps slugs unique, and a second bucket indexes them by creation time for listing.
+*/
+type BoltStore struct {
+	F     *os.File
+	Debug bool
+	db    *bolt.DB
+}
+
+/*
+	Opens (or creates) the database file at the provided path and makes sure all required buckets exist.
+*/
+func OpenBoltStore(f *os.File, debug bool, fileName string) (*BoltStore, error) {
+	log.SetOutput(f)
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("Error opening database file (path: %v) (%v)", fileName, err)
+		return nil, err
+	}
+
+	// create the schema on first use
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMetaBucket, boltUrlsBucket, boltCreatedBucket, boltKeysBucket, boltKeysByHashBucket, boltEventsBucket, boltRollupsBucket, boltWebhooksBucket, boltDeliveryBucket, boltDueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(boltMetaBucket)
+		if version := meta.Get([]byte("version")); version != nil && binary.BigEndian.Uint64(version) > boltSchemaVersion {
+			return fmt.Errorf("database schema version %v is newer than supported version %v", binary.BigEndian.Uint64(version), boltSchemaVersion)
+		}
+		return meta.Put([]byte("version"), boltUint64(boltSchemaVersion))
+	})
+	if err != nil {
+		log.Printf("Error creating database schema (path: %v) (%v)", fileName, err)
+		db.Close()
+		return nil, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Opened database file (path: %v)", fileName)
+	}
+
+	return &BoltStore{F: f, Debug: debug, db: db}, nil
+}
+
+// encodes an integer so byte ordering matches numeric ordering
+func boltUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// key used in the creation time index
+func boltCreatedKey(url Url) []byte {
+	return append(boltUint64(url.Created), []byte(url.Slug)...)
+}
+
+// key of a click event, events are stored in time order so they can be rolled up without reading older ones
+func boltEventKey(event ClickEvent, seq uint64) []byte {
+	return append(boltUint64(event.Timestamp), boltUint64(seq)...)
+}
+
+// key of a click rollup, rollups of a short URL are stored together in hour order
+func boltRollupKey(slug string, hour uint64) []byte {
+	key := append([]byte(slug), 0)
+	return append(key, boltUint64(hour)...)
+}
+
+// key in the index of pending deliveries, ordered by when they are due
+func boltDueKey(delivery WebhookDelivery) []byte {
+	return append(boltUint64(delivery.NextAttempt), []byte(delivery.ID)...)
+}
+
+// reads and decodes the URL stored under the provided slug
+func boltGetUrl(tx *bolt.Tx, slug string) (Url, error) {
+	url := Url{}
+	data := tx.Bucket(boltUrlsBucket).Get([]byte(slug))
+	if data == nil {
+		return url, ErrUrlNotFound
+	}
+	err := bson.Unmarshal(data, &url)
+	return url, err
+}
+
+// encodes and writes the provided URL, replacing any existing record for the slug
+func boltPutUrl(tx *bolt.Tx, url Url) error {
+	data, err := bson.Marshal(url)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltUrlsBucket).Put([]byte(url.Slug), data)
+}
+
+/*
+	Inserts a new long URL into the database file. Returns ErrDuplicateSlug if the slug is already taken.
+*/
+func (s *BoltStore) InsertUrl(url Url) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltUrlsBucket).Get([]byte(url.Slug)) != nil {
+			return ErrDuplicateSlug
+		}
+		url.Domain = TargetDomain(url.Target)
+		if err := boltPutUrl(tx, url); err != nil {
+			return err
+		}
+		return tx.Bucket(boltCreatedBucket).Put(boltCreatedKey(url), nil)
+	})
+	if err == ErrDuplicateSlug {
+		return err
+	} else if err != nil {
+		log.Printf("Error creating new short URL (slug: %v) (%v)", url.Slug, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted URL in database file (slug: %v) (target: %v)", url.Slug, url.Target)
+	}
+	return nil
+}
+
+/*
+	Inserts many new long URLs into the database file in one transaction.
+	Returns one error per URL, ErrDuplicateSlug when the slug is already taken.
+*/
+func (s *BoltStore) InsertUrls(urls []Url) []error {
+	log.SetOutput(s.F)
+	errs := make([]error, len(urls))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for i, url := range urls {
+			if tx.Bucket(boltUrlsBucket).Get([]byte(url.Slug)) != nil {
+				errs[i] = ErrDuplicateSlug
+				continue
+			}
+			url.Domain = TargetDomain(url.Target)
+			if err := boltPutUrl(tx, url); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltCreatedBucket).Put(boltCreatedKey(url), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// the transaction was rolled back, so none of the URLs were inserted
+		log.Printf("Error creating new short URLs (count: %v) (%v)", len(urls), err)
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted URLs in database file (count: %v)", len(urls))
+	}
+	return errs
+}
+
+/*
+	Looks up the provided short URL slug in the database file and returns the target URL.
+*/
+func (s *BoltStore) GetUrl(slug string) (Url, error) {
+	log.SetOutput(s.F)
+	url := Url{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		url, err = boltGetUrl(tx, slug)
+		return err
+	})
+	if err != nil && err != ErrUrlNotFound {
+		log.Printf("Error looking up URL (slug: %v) (%v)", slug, err)
+	}
+
+	if s.Debug {
+		if err == ErrUrlNotFound {
+			log.Printf("[DEBUG] Attempted to get missing URL from database file (slug: %v)", slug)
+		} else {
+			log.Printf("[DEBUG] Got URL from database file (slug: %v) (target: %v)", url.Slug, url.Target)
+		}
+	}
+	return url, err
+}
+
+/*
+	Returns one page of the URLs stored in the database file matching the filter.
+	Every record is read, which is fine for the single node deployments the embedded database is meant for.
+*/
+func (s *BoltStore) ListUrls(opts ListOptions) (UrlPage, error) {
+	log.SetOutput(s.F)
+	urls := []Url{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUrlsBucket).ForEach(func(k, v []byte) error {
+			url := Url{}
+			if err := bson.Unmarshal(v, &url); err != nil {
+				return err
+			}
+			urls = append(urls, url)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error listing URLs (%v)", err)
+		return UrlPage{Urls: []Url{}}, err
+	}
+
+	page, err := pageUrls(urls, opts)
+	if err != nil {
+		return page, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Listed URLs from database file (count: %v) (total: %v)", len(page.Urls), page.Total)
+	}
+	return page, nil
+}
+
+/*
+	Passes every URL matching the filter to fn, walking the creation time index so URLs come out ordered by creation time and slug.
+	The export runs in one read transaction, which gives a consistent snapshot without blocking writers.
+*/
+func (s *BoltStore) ExportUrls(filter UrlFilter, fn ExportFunc) (int64, error) {
+	log.SetOutput(s.F)
+	count := int64(0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCreatedBucket).ForEach(func(k, v []byte) error {
+			// the key is the creation time followed by the slug
+			url, err := boltGetUrl(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if !filter.Matches(url) {
+				return nil
+			}
+			if err := fn(url); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error exporting URLs (%v)", err)
+		return count, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Exported URLs from database file (count: %v)", count)
+	}
+	return count, nil
+}
+
+/*
+	Looks up the provided short URL slug in the database file and updates the target URL.
+*/
+func (s *BoltStore) UpdateUrl(url Url) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := boltGetUrl(tx, url.Slug)
+		if err != nil {
+			return err
+		}
+		existing.Target = url.Target
+		existing.Domain = TargetDomain(url.Target)
+		if url.Tags != nil {
+			existing.Tags = url.Tags
+		}
+		if url.ExpiresAt != 0 || url.ClearExpiry {
+			existing.ExpiresAt = url.ExpiresAt
+		}
+		if url.MaxHits != 0 || url.ClearMaxHits {
+			existing.MaxHits = url.MaxHits
+		}
+		if url.PasswordHash != "" {
+			existing.Protected = true
+			existing.PasswordHash = url.PasswordHash
+		}
+		return boltPutUrl(tx, existing)
+	})
+	if err == ErrUrlNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("Error updating target URL (slug: %v) (%v)", url.Slug, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated URL in database file (slug: %v) (target: %v)", url.Slug, url.Target)
+	}
+	return nil
+}
+
+/*
+	Looks up the URL for the provided short URL slug and deletes the record.
+*/
+func (s *BoltStore) DeleteUrl(slug string) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		url, err := boltGetUrl(tx, slug)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltCreatedBucket).Delete(boltCreatedKey(url)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltUrlsBucket).Delete([]byte(slug))
+	})
+	if err == ErrUrlNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("Error deleting URL (slug: %v) (%v)", slug, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted URL from database file (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Updates the hit count for the given short URL slug.
+	Returns ErrHitLimitReached if the short URL has already been resolved the maximum number of times.
+*/
+func (s *BoltStore) UpdateUrlHits(slug string) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		url, err := boltGetUrl(tx, slug)
+		if err != nil {
+			return err
+		}
+		if url.IsHitLimitReached() {
+			return ErrHitLimitReached
+		}
+		url.Hits += 1
+		return boltPutUrl(tx, url)
+	})
+	if err == ErrUrlNotFound || err == ErrHitLimitReached {
+		return err
+	} else if err != nil {
+		log.Printf("Error updating URL hits (slug: %v) (%v)", slug, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated URL hits in database file (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Adds batched hit counts to short URLs in one transaction, keyed by slug. Hit limits are not checked, and missing slugs are skipped.
+*/
+func (s *BoltStore) IncrementUrlHits(hits map[string]HitCounts) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for slug, counts := range hits {
+			url, err := boltGetUrl(tx, slug)
+			if err == ErrUrlNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			url.Hits += counts.Hits
+			url.BotHits += counts.BotHits
+			if err := boltPutUrl(tx, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error incrementing URL hits (count: %v) (%v)", len(hits), err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Incremented URL hits in database file (count: %v)", len(hits))
+	}
+	return nil
+}
+
+// returns every URL in the database file matching the filter, in slug order
+func boltMatchingUrls(tx *bolt.Tx, filter UrlFilter) ([]Url, error) {
+	urls := []Url{}
+	err := tx.Bucket(boltUrlsBucket).ForEach(func(k, v []byte) error {
+		url := Url{}
+		if err := bson.Unmarshal(v, &url); err != nil {
+			return err
+		}
+		if filter.Matches(url) {
+			urls = append(urls, url)
+		}
+		return nil
+	})
+	return urls, err
+}
+
+/*
+	Applies the update to every URL in the database file matching the filter and returns the sorted slugs of the updated URLs.
+*/
+func (s *BoltStore) UpdateUrls(filter UrlFilter, update UrlUpdate, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(s.F)
+	slugs := []string{}
+	apply := func(tx *bolt.Tx) error {
+		urls, err := boltMatchingUrls(tx, filter)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(urls) > limit {
+			return ErrTooManyUrls
+		}
+		for _, url := range urls {
+			slugs = append(slugs, url.Slug)
+			if dryRun {
+				continue
+			}
+			update.apply(&url)
+			if err := boltPutUrl(tx, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if dryRun {
+		err = s.db.View(apply)
+	} else {
+		err = s.db.Update(apply)
+	}
+	if err == ErrTooManyUrls {
+		return nil, err
+	} else if err != nil {
+		log.Printf("Error updating URLs (%v)", err)
+		return nil, err
+	}
+
+	if s.Debug && !dryRun {
+		log.Printf("[DEBUG] Updated URLs in database file (count: %v)", len(slugs))
+	}
+	return slugs, nil
+}
+
+/*
+	Deletes every URL in the database file matching the filter and returns the sorted slugs of the deleted URLs.
+*/
+func (s *BoltStore) DeleteUrls(filter UrlFilter, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(s.F)
+	slugs := []string{}
+	apply := func(tx *bolt.Tx) error {
+		urls, err := boltMatchingUrls(tx, filter)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(urls) > limit {
+			return ErrTooManyUrls
+		}
+		for _, url := range urls {
+			slugs = append(slugs, url.Slug)
+			if dryRun {
+				continue
+			}
+			if err := tx.Bucket(boltCreatedBucket).Delete(boltCreatedKey(url)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltUrlsBucket).Delete([]byte(url.Slug)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if dryRun {
+		err = s.db.View(apply)
+	} else {
+		err = s.db.Update(apply)
+	}
+	if err == ErrTooManyUrls {
+		return nil, err
+	} else if err != nil {
+		log.Printf("Error deleting URLs (%v)", err)
+		return nil, err
+	}
+
+	if s.Debug && !dryRun {
+		log.Printf("[DEBUG] Deleted URLs from database file (count: %v)", len(slugs))
+	}
+	return slugs, nil
+}
+
+/*
+	Deletes every URL that expired at or before the provided unix time. Returns the deleted URLs.
+*/
+func (s *BoltStore) DeleteExpiredUrls(now uint64) ([]Url, error) {
+	log.SetOutput(s.F)
+	expired := []Url{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltUrlsBucket).ForEach(func(k, v []byte) error {
+			url := Url{}
+			if err := bson.Unmarshal(v, &url); err != nil {
+				return err
+			}
+			if url.ExpiresAt != 0 && url.ExpiresAt <= now {
+				expired = append(expired, url)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// buckets must not be modified while iterating over them
+		for _, url := range expired {
+			if err := tx.Bucket(boltCreatedBucket).Delete(boltCreatedKey(url)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltUrlsBucket).Delete([]byte(url.Slug)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error deleting expired URLs (%v)", err)
+		return nil, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted expired URLs from database file (count: %v)", len(expired))
+	}
+	return expired, nil
+}
+
+/*
+	Inserts a new API key into the database file.
+*/
+func (s *BoltStore) InsertApiKey(key ApiKey) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		data, err := bson.Marshal(key)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltKeysBucket).Put([]byte(key.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(boltKeysByHashBucket).Put([]byte(key.Hash), []byte(key.ID))
+	})
+	if err != nil {
+		log.Printf("Error creating new API key (id: %v) (%v)", key.ID, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted API key in database file (id: %v) (name: %v)", key.ID, key.Name)
+	}
+	return nil
+}
+
+// reads and decodes the API key stored under the provided ID
+func boltGetApiKey(tx *bolt.Tx, id []byte) (ApiKey, error) {
+	key := ApiKey{}
+	data := tx.Bucket(boltKeysBucket).Get(id)
+	if data == nil {
+		return key, ErrApiKeyNotFound
+	}
+	err := bson.Unmarshal(data, &key)
+	return key, err
+}
+
+/*
+	Looks up the API key with the provided hash in the database file.
+*/
+func (s *BoltStore) GetApiKeyByHash(hash string) (ApiKey, error) {
+	log.SetOutput(s.F)
+	key := ApiKey{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltKeysByHashBucket).Get([]byte(hash))
+		if id == nil {
+			return ErrApiKeyNotFound
+		}
+		var err error
+		key, err = boltGetApiKey(tx, id)
+		return err
+	})
+	if err == ErrApiKeyNotFound {
+		return key, err
+	} else if err != nil {
+		log.Printf("Error looking up API key (%v)", err)
+		return key, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Got API key from database file (id: %v)", key.ID)
+	}
+	return key, nil
+}
+
+/*
+	Returns all API keys stored in the database file, oldest first.
+*/
+func (s *BoltStore) GetApiKeys() ([]ApiKey, error) {
+	log.SetOutput(s.F)
+	keys := []ApiKey{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).ForEach(func(k, v []byte) error {
+			key := ApiKey{}
+			if err := bson.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error retrieving all API keys (%v)", err)
+		return []ApiKey{}, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created < keys[j].Created
+	})
+
+	if s.Debug {
+		log.Printf("[DEBUG] Got API keys from database file (count: %v)", len(keys))
+	}
+	return keys, nil
+}
+
+/*
+	Assigns a new role to the API key with the provided ID in the database file.
+*/
+func (s *BoltStore) UpdateApiKeyRole(id string, role string) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, err := boltGetApiKey(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		key.Role = role
+		data, err := bson.Marshal(key)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltKeysBucket).Put([]byte(id), data)
+	})
+	if err == ErrApiKeyNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("Error updating API key role (id: %v) (%v)", id, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated API key role in database file (id: %v) (role: %v)", id, role)
+	}
+	return nil
+}
+
+/*
+	Deletes the API key with the provided ID from the database file.
+*/
+func (s *BoltStore) DeleteApiKey(id string) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, err := boltGetApiKey(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltKeysByHashBucket).Delete([]byte(key.Hash)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltKeysBucket).Delete([]byte(id))
+	})
+	if err == ErrApiKeyNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("Error deleting API key (id: %v) (%v)", id, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted API key from database file (id: %v)", id)
+	}
+	return nil
+}
+
+/*
+	Inserts a batch of click events into the database file in one transaction.
+*/
+func (s *BoltStore) InsertClickEvents(events []ClickEvent) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltEventsBucket)
+		for _, event := range events {
+			// the sequence keeps events of the same second apart
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := bson.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(boltEventKey(event, seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error inserting click events (count: %v) (%v)", len(events), err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted click events in database file (count: %v)", len(events))
+	}
+	return nil
+}
+
+/*
+	Passes every click event recorded at or after from to fn, seeking straight to the first one as events are keyed by time.
+*/
+func (s *BoltStore) ScanClickEvents(from uint64, fn ClickEventFunc) error {
+	log.SetOutput(s.F)
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltEventsBucket).Cursor()
+		for k, v := cursor.Seek(boltUint64(from)); k != nil; k, v = cursor.Next() {
+			event := ClickEvent{}
+			if err := bson.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error scanning click events (%v)", err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Scanned click events in database file (from: %v) (count: %v)", from, count)
+	}
+	return nil
+}
+
+/*
+	Deletes click events recorded before the provided time in one transaction. Events are keyed by time, so only the deleted ones are read.
+*/
+func (s *BoltStore) DeleteClickEvents(before uint64) (int64, error) {
+	log.SetOutput(s.F)
+	count := int64(0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltEventsBucket).Cursor()
+		for k, _ := cursor.First(); k != nil && binary.BigEndian.Uint64(k[:8]) < before; k, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error deleting old click events (%v)", err)
+		return 0, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted old click events from database file (count: %v)", count)
+	}
+	return count, nil
+}
+
+/*
+	Saves a batch of click rollups in one transaction, replacing any existing rollup of the same short URL and hour.
+	The latest rolled up hour is kept in the meta bucket, so it can be read without walking all rollups.
+*/
+func (s *BoltStore) SaveClickRollups(rollups []ClickRollup) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRollupsBucket)
+		meta := tx.Bucket(boltMetaBucket)
+		latest := uint64(0)
+		if value := meta.Get([]byte("latest_rollup")); value != nil {
+			latest = binary.BigEndian.Uint64(value)
+		}
+		for _, rollup := range rollups {
+			data, err := bson.Marshal(rollup)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(boltRollupKey(rollup.Slug, rollup.Hour), data); err != nil {
+				return err
+			}
+			if rollup.Hour > latest {
+				latest = rollup.Hour
+			}
+		}
+		return meta.Put([]byte("latest_rollup"), boltUint64(latest))
+	})
+	if err != nil {
+		log.Printf("Error saving click rollups (count: %v) (%v)", len(rollups), err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Saved click rollups in database file (count: %v)", len(rollups))
+	}
+	return nil
+}
+
+/*
+	Returns the click rollups of a short URL for the hours starting in [from, to), ordered by hour.
+*/
+func (s *BoltStore) GetClickRollups(slug string, from uint64, to uint64) ([]ClickRollup, error) {
+	log.SetOutput(s.F)
+	rollups := []ClickRollup{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltRollupsBucket).Cursor()
+		end := boltRollupKey(slug, to)
+		for k, v := cursor.Seek(boltRollupKey(slug, from)); k != nil && bytes.Compare(k, end) < 0; k, v = cursor.Next() {
+			rollup := ClickRollup{}
+			if err := bson.Unmarshal(v, &rollup); err != nil {
+				return err
+			}
+			rollups = append(rollups, rollup)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error getting click rollups (slug: %v) (%v)", slug, err)
+		return nil, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Got click rollups from database file (slug: %v) (count: %v)", slug, len(rollups))
+	}
+	return rollups, nil
+}
+
+/*
+	Returns the hour of the most recent click rollup, or 0 if nothing has been rolled up yet.
+*/
+func (s *BoltStore) LatestClickRollup() (uint64, error) {
+	latest := uint64(0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(boltMetaBucket).Get([]byte("latest_rollup")); value != nil {
+			latest = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return latest, err
+}
+
+/*
+	Inserts a new webhook into the database file.
+*/
+func (s *BoltStore) InsertWebhook(hook Webhook) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		data, err := bson.Marshal(hook)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltWebhooksBucket).Put([]byte(hook.ID), data)
+	})
+	if err != nil {
+		log.Printf("Error creating new webhook (id: %v) (%v)", hook.ID, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted webhook in database file (id: %v) (url: %v)", hook.ID, hook.Url)
+	}
+	return nil
+}
+
+/*
+	Looks up the webhook with the provided ID in the database file.
+*/
+func (s *BoltStore) GetWebhook(id string) (Webhook, error) {
+	log.SetOutput(s.F)
+	hook := Webhook{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltWebhooksBucket).Get([]byte(id))
+		if data == nil {
+			return ErrWebhookNotFound
+		}
+		return bson.Unmarshal(data, &hook)
+	})
+	if err == ErrWebhookNotFound {
+		return hook, err
+	} else if err != nil {
+		log.Printf("Error looking up webhook (id: %v) (%v)", id, err)
+		return hook, err
+	}
+	return hook, nil
+}
+
+/*
+	Returns all webhooks stored in the database file, oldest first.
+*/
+func (s *BoltStore) GetWebhooks() ([]Webhook, error) {
+	log.SetOutput(s.F)
+	hooks := []Webhook{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWebhooksBucket).ForEach(func(k, v []byte) error {
+			hook := Webhook{}
+			if err := bson.Unmarshal(v, &hook); err != nil {
+				return err
+			}
+			hooks = append(hooks, hook)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error retrieving all webhooks (%v)", err)
+		return []Webhook{}, err
+	}
+	// webhooks are keyed by ID, so ties are already in ID order
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Created < hooks[j].Created
+	})
+	return hooks, nil
+}
+
+/*
+	Deletes the webhook with the provided ID from the database file. Its deliveries are kept until they are pruned.
+*/
+func (s *BoltStore) DeleteWebhook(id string) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltWebhooksBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrWebhookNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err == ErrWebhookNotFound {
+		return err
+	} else if err != nil {
+		log.Printf("Error deleting webhook (id: %v) (%v)", id, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted webhook from database file (id: %v)", id)
+	}
+	return nil
+}
+
+// stores a delivery, keeping the index of pending deliveries in step with its status and next attempt
+func boltPutDelivery(tx *bolt.Tx, delivery WebhookDelivery) error {
+	bucket := tx.Bucket(boltDeliveryBucket)
+	due := tx.Bucket(boltDueBucket)
+	if data := bucket.Get([]byte(delivery.ID)); data != nil {
+		previous := WebhookDelivery{}
+		if err := bson.Unmarshal(data, &previous); err != nil {
+			return err
+		}
+		if err := due.Delete(boltDueKey(previous)); err != nil {
+			return err
+		}
+	}
+	data, err := bson.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(delivery.ID), data); err != nil {
+		return err
+	}
+	if delivery.Status == DeliveryPending {
+		return due.Put(boltDueKey(delivery), []byte(delivery.ID))
+	}
+	return nil
+}
+
+/*
+	Inserts a batch of webhook deliveries into the database file in one transaction.
+*/
+func (s *BoltStore) InsertWebhookDeliveries(deliveries []WebhookDelivery) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, delivery := range deliveries {
+			if err := boltPutDelivery(tx, delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error inserting webhook deliveries (count: %v) (%v)", len(deliveries), err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted webhook deliveries in database file (count: %v)", len(deliveries))
+	}
+	return nil
+}
+
+/*
+	Claims up to limit pending deliveries due at now, oldest due first, by moving their next attempt to the end of the lease.
+	Due deliveries are found through the index of pending deliveries, without reading the rest of the queue.
+*/
+func (s *BoltStore) ClaimWebhookDeliveries(now uint64, lease uint64, limit int) ([]WebhookDelivery, error) {
+	log.SetOutput(s.F)
+	deliveries := []WebhookDelivery{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ids := [][]byte{}
+		cursor := tx.Bucket(boltDueBucket).Cursor()
+		for k, v := cursor.First(); k != nil && len(ids) < limit && binary.BigEndian.Uint64(k[:8]) <= now; k, v = cursor.Next() {
+			ids = append(ids, append([]byte{}, v...))
+		}
+		// the index must not be modified while iterating over it
+		for _, id := range ids {
+			delivery := WebhookDelivery{}
+			if err := bson.Unmarshal(tx.Bucket(boltDeliveryBucket).Get(id), &delivery); err != nil {
+				return err
+			}
+			delivery.NextAttempt = now + lease
+			if err := boltPutDelivery(tx, delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error claiming webhook deliveries (%v)", err)
+		return []WebhookDelivery{}, err
+	}
+
+	if s.Debug && len(deliveries) > 0 {
+		log.Printf("[DEBUG] Claimed webhook deliveries from database file (count: %v)", len(deliveries))
+	}
+	return deliveries, nil
+}
+
+/*
+	Replaces the stored delivery with the same ID, recording the outcome of an attempt.
+*/
+func (s *BoltStore) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	log.SetOutput(s.F)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return boltPutDelivery(tx, delivery)
+	})
+	if err != nil {
+		log.Printf("Error updating webhook delivery (id: %v) (%v)", delivery.ID, err)
+		return err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated webhook delivery in database file (id: %v) (status: %v)", delivery.ID, delivery.Status)
+	}
+	return nil
+}
+
+/*
+	Returns up to limit deliveries of a webhook, newest first.
+*/
+func (s *BoltStore) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	log.SetOutput(s.F)
+	deliveries := []WebhookDelivery{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeliveryBucket).ForEach(func(k, v []byte) error {
+			delivery := WebhookDelivery{}
+			if err := bson.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if delivery.WebhookID == webhookID {
+				deliveries = append(deliveries, delivery)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error retrieving webhook deliveries (webhook: %v) (%v)", webhookID, err)
+		return []WebhookDelivery{}, err
+	}
+	// deliveries are keyed by ID, so ties are already in ID order
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Created > deliveries[j].Created
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+/*
+	Deletes delivered and failed deliveries last updated before the provided time. Pending deliveries are never deleted.
+*/
+func (s *BoltStore) DeleteWebhookDeliveries(before uint64) (int64, error) {
+	log.SetOutput(s.F)
+	count := int64(0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDeliveryBucket)
+		ids := [][]byte{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			delivery := WebhookDelivery{}
+			if err := bson.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if delivery.Status != DeliveryPending && delivery.Updated < before {
+				ids = append(ids, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// buckets must not be modified while iterating over them
+		for _, id := range ids {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		count = int64(len(ids))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error deleting old webhook deliveries (%v)", err)
+		return 0, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted old webhook deliveries from database file (count: %v)", count)
+	}
+	return count, nil
+}
+
+/*
+	Closes the database file.
+*/
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
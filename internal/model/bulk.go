@@ -0,0 +1,223 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	Holds the changes of a bulk update. Zero fields and nil Tags are left unchanged.
+*/
+type UrlUpdate struct {
+	Target    string
+	Tags      []string
+	ExpiresAt uint64
+	MaxHits   uint64
+}
+
+/*
+	Checks if the update does not change anything.
+*/
+func (update UrlUpdate) IsEmpty() bool {
+	return update.Target == "" && update.Tags == nil && update.ExpiresAt == 0 && update.MaxHits == 0
+}
+
+// applies the update to a URL held in memory
+func (update UrlUpdate) apply(url *Url) {
+	if update.Target != "" {
+		url.Target = update.Target
+		url.Domain = TargetDomain(update.Target)
+	}
+	if update.Tags != nil {
+		url.Tags = update.Tags
+	}
+	if update.ExpiresAt != 0 {
+		url.ExpiresAt = update.ExpiresAt
+	}
+	if update.MaxHits != 0 {
+		url.MaxHits = update.MaxHits
+	}
+}
+
+// builds the database $set document for the update
+func (update UrlUpdate) set() bson.M {
+	set := bson.M{}
+	if update.Target != "" {
+		set["target"] = update.Target
+		set["domain"] = TargetDomain(update.Target)
+	}
+	if update.Tags != nil {
+		set["tags"] = update.Tags
+	}
+	if update.ExpiresAt != 0 {
+		set["expiresAt"] = update.ExpiresAt
+	}
+	if update.MaxHits != 0 {
+		set["maxHits"] = update.MaxHits
+	}
+	return set
+}
+
+// URLs changed per database round trip by bulk updates and deletes, so a broad filter never loads every match at once
+const bulkPageSize = 1000
+
+// returned by bulk updates and deletes when more URLs match the filter than the limit allows, in which case nothing is changed
+var ErrTooManyUrls = errors.New("too many URLs match the filter")
+
+// checks that at most limit URLs in the database match the filter, a limit of 0 means no limit
+func checkBulkLimit(ctx context.Context, collection *mongo.Collection, filter UrlFilter, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	count, err := collection.CountDocuments(ctx, urlFilterQuery(filter), options.Count().SetLimit(int64(limit)+1))
+	if err != nil {
+		return err
+	}
+	if count > int64(limit) {
+		return ErrTooManyUrls
+	}
+	return nil
+}
+
+/*
+	Calls fn with the sorted slugs of the URLs in the database matching the filter, one page of at most bulkPageSize slugs at a time.
+	Pages continue after the last slug of the previous page, so URLs changed by fn are never seen twice. Returns the slugs of every page.
+	When fn fails, the slugs of the pages passed so far are returned with the error, including the failed page as fn may have changed part of it.
+*/
+func eachUrlSlugPage(ctx context.Context, collection *mongo.Collection, filter UrlFilter, fn func(slugs []string) error) ([]string, error) {
+	all := []string{}
+	last := ""
+	for {
+		query := urlFilterQuery(filter)
+		if last != "" {
+			after := bson.M{"$gt": last}
+			if len(filter.Slugs) > 0 {
+				after["$in"] = filter.Slugs
+			}
+			query["slug"] = after
+		}
+		findOptions := options.Find().SetProjection(bson.M{"slug": 1}).SetSort(bson.D{{Key: "slug", Value: 1}}).SetLimit(bulkPageSize)
+		cur, err := collection.Find(ctx, query, findOptions)
+		if err != nil {
+			return all, err
+		}
+		urls := []Url{}
+		if err := cur.All(ctx, &urls); err != nil {
+			return all, err
+		}
+		if len(urls) == 0 {
+			return all, nil
+		}
+
+		slugs := make([]string, len(urls))
+		for i, url := range urls {
+			slugs[i] = url.Slug
+		}
+		if err := fn(slugs); err != nil {
+			return append(all, slugs...), err
+		}
+		all = append(all, slugs...)
+		if len(urls) < bulkPageSize {
+			return all, nil
+		}
+		last = slugs[len(slugs)-1]
+	}
+}
+
+// builds the query matching only the found slugs, so URLs created after the lookup are never touched
+func foundSlugsQuery(filter UrlFilter, slugs []string) bson.M {
+	query := urlFilterQuery(filter)
+	query["slug"] = bson.M{"$in": slugs}
+	return query
+}
+
+/*
+	Deletes every URL matching the filter, one page at a time, and returns the slugs of the deleted URLs.
+	Returns ErrTooManyUrls without deleting anything if more than limit URLs match (0 means no limit).
+	With dryRun set, nothing is deleted and the slugs of the URLs that would be deleted are returned.
+	If a page fails, the slugs of the pages that may have been deleted already are returned with the error.
+*/
+func DeleteUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, filter UrlFilter, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := checkBulkLimit(ctx, collection, filter, limit); err != nil {
+		if err != ErrTooManyUrls {
+			log.Printf("Error counting URLs to delete (%v)", err)
+		}
+		return nil, err
+	}
+
+	deleted := int64(0)
+	slugs, err := eachUrlSlugPage(ctx, collection, filter, func(slugs []string) error {
+		if dryRun {
+			return nil
+		}
+		result, err := collection.DeleteMany(ctx, foundSlugsQuery(filter, slugs))
+		if err == nil {
+			deleted += result.DeletedCount
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Error deleting URLs (count: %v) (%v)", len(slugs), err)
+		return slugs, err
+	}
+
+	if debug && !dryRun {
+		log.Printf("[DEBUG] Deleted URLs from database (count: %v)", deleted)
+	}
+
+	return slugs, nil
+}
+
+/*
+	Applies the update to every URL matching the filter, one page at a time, and returns the slugs of the updated URLs.
+	Returns ErrTooManyUrls without updating anything if more than limit URLs match (0 means no limit).
+	With dryRun set, nothing is updated and the slugs of the URLs that would be updated are returned.
+	If a page fails, the slugs of the pages that may have been updated already are returned with the error.
+*/
+func UpdateUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, filter UrlFilter, update UrlUpdate, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := checkBulkLimit(ctx, collection, filter, limit); err != nil {
+		if err != ErrTooManyUrls {
+			log.Printf("Error counting URLs to update (%v)", err)
+		}
+		return nil, err
+	}
+
+	modified := int64(0)
+	slugs, err := eachUrlSlugPage(ctx, collection, filter, func(slugs []string) error {
+		if dryRun {
+			return nil
+		}
+		result, err := collection.UpdateMany(ctx, foundSlugsQuery(filter, slugs), bson.M{"$set": update.set()})
+		if err == nil {
+			modified += result.ModifiedCount
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Error updating URLs (count: %v) (%v)", len(slugs), err)
+		return slugs, err
+	}
+
+	if debug && !dryRun {
+		log.Printf("[DEBUG] Updated URLs in database (count: %v)", modified)
+	}
+
+	return slugs, nil
+}
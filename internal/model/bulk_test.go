@@ -0,0 +1,105 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+/*
+	Checks previewing, updating and deleting URLs by slug list and by filter
+*/
+func testBulkUrls(t *testing.T, store UrlStore) {
+	for i := 1; i <= 6; i++ {
+		url := Url{Slug: fmt.Sprintf("BULK%v", i), Target: "https://old.example.com/" + fmt.Sprint(i), Created: 100}
+		if i > 4 {
+			url.Target = "https://www.google.com"
+		}
+		if err := store.InsertUrl(url); err != nil {
+			t.Fatalf("FAILED inserting URL. Expected: nil error, got: %v", err)
+		}
+	}
+
+	filter := UrlFilter{Domain: "old.example.com"}
+	update := UrlUpdate{Target: "https://new.example.com", Tags: []string{"moved"}}
+	slugs, err := store.UpdateUrls(filter, update, 0, true)
+	if err != nil || fmt.Sprint(slugs) != "[BULK1 BULK2 BULK3 BULK4]" {
+		t.Errorf("FAILED previewing URL update. Expected: [BULK1 BULK2 BULK3 BULK4], got: %v (%v)", slugs, err)
+	}
+	url, _ := store.GetUrl("BULK1")
+	if url.Target != "https://old.example.com/1" {
+		t.Errorf("FAILED previewing URL update. Expected: unchanged target, got: %v", url.Target)
+	}
+
+	slugs, err = store.UpdateUrls(filter, update, 10, false)
+	if err != nil || len(slugs) != 4 {
+		t.Errorf("FAILED updating URLs. Expected: 4, got: %v (%v)", len(slugs), err)
+	}
+	url, _ = store.GetUrl("BULK1")
+	if url.Target != "https://new.example.com" || url.Domain != "new.example.com" || len(url.Tags) != 1 {
+		t.Errorf("FAILED updating URLs. Expected: https://new.example.com [moved], got: %v %v", url.Target, url.Tags)
+	} else {
+		t.Logf("PASSED updating URLs. Expected: https://new.example.com [moved], got: %v %v", url.Target, url.Tags)
+	}
+
+	// slug lists and filters narrow down the selection together
+	slugs, err = store.DeleteUrls(UrlFilter{Slugs: []string{"BULK2", "BULK5", "MISSING"}, Domain: "new.example.com"}, 0, true)
+	if err != nil || fmt.Sprint(slugs) != "[BULK2]" {
+		t.Errorf("FAILED previewing URL delete. Expected: [BULK2], got: %v (%v)", slugs, err)
+	}
+	slugs, err = store.DeleteUrls(UrlFilter{Slugs: []string{"BULK2", "BULK5", "MISSING"}}, 0, false)
+	if err != nil || fmt.Sprint(slugs) != "[BULK2 BULK5]" {
+		t.Errorf("FAILED deleting URLs. Expected: [BULK2 BULK5], got: %v (%v)", slugs, err)
+	} else {
+		t.Logf("PASSED deleting URLs. Expected: [BULK2 BULK5], got: %v", slugs)
+	}
+	if _, err := store.GetUrl("BULK5"); err != ErrUrlNotFound {
+		t.Errorf("FAILED getting deleted URL. Expected: %v, got: %v", ErrUrlNotFound, err)
+	}
+
+	// nothing is changed when more URLs match than the limit allows
+	if _, err := store.UpdateUrls(UrlFilter{Tags: []string{"moved"}}, UrlUpdate{MaxHits: 5}, 2, false); err != ErrTooManyUrls {
+		t.Errorf("FAILED limiting URL update. Expected: %v, got: %v", ErrTooManyUrls, err)
+	}
+	if _, err := store.DeleteUrls(UrlFilter{Tags: []string{"moved"}}, 2, false); err != ErrTooManyUrls {
+		t.Errorf("FAILED limiting URL delete. Expected: %v, got: %v", ErrTooManyUrls, err)
+	} else {
+		t.Logf("PASSED limiting URL delete. Expected: %v, got: %v", ErrTooManyUrls, err)
+	}
+	if url, _ := store.GetUrl("BULK1"); url.MaxHits != 0 {
+		t.Errorf("FAILED limiting URL update. Expected: unchanged hit limit, got: %v", url.MaxHits)
+	}
+
+	slugs, err = store.DeleteUrls(UrlFilter{Tags: []string{"moved"}}, 3, false)
+	if err != nil || fmt.Sprint(slugs) != "[BULK1 BULK3 BULK4]" {
+		t.Errorf("FAILED deleting URLs by tag. Expected: [BULK1 BULK3 BULK4], got: %v (%v)", slugs, err)
+	}
+	store.DeleteUrls(UrlFilter{Slugs: []string{"BULK6"}}, 0, false)
+}
+
+func TestMemoryStoreBulkUrls(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreBulkUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testBulkUrls(t, NewMemoryStore(f, verbose))
+}
+
+func TestBoltStoreBulkUrls(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreBulkUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreBulkUrls.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	testBulkUrls(t, store)
+}
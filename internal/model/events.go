@@ -0,0 +1,112 @@
+package model
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	Records one resolution of a short URL. Events are kept apart from the URLs, so recording them never touches the URL documents.
+	The IP address is anonymized before it is recorded (see util.AnonymizeIP).
+	Country is the ISO country code passed on by a proxy or CDN in front of the service, as the service does not look up locations itself.
+*/
+type ClickEvent struct {
+	Slug      string `bson:"slug" json:"slug"`
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+	Referrer  string `bson:"referrer,omitempty" json:"referrer,omitempty"`
+	UserAgent string `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	IP        string `bson:"ip,omitempty" json:"ip,omitempty"`
+	Language  string `bson:"language,omitempty" json:"language,omitempty"`
+	Country   string `bson:"country,omitempty" json:"country,omitempty"`
+	// name of the bot the user agent was classified as, empty for people
+	Bot string `bson:"bot,omitempty" json:"bot,omitempty"`
+}
+
+/*
+	Makes sure the indexes used to query click events by short URL and time, and to roll them up by time, exist on the events collection.
+*/
+func EnsureEventIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "slug", Value: 1}, {Key: "timestamp", Value: 1}},
+			Options: options.Index().SetName("slug_timestamp"),
+		},
+		{
+			Keys:    bson.D{{Key: "timestamp", Value: 1}},
+			Options: options.Index().SetName("timestamp"),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Inserts a batch of click events into the database. The insert is unordered, so one bad event does not stop the others.
+*/
+func InsertClickEvents(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, events []ClickEvent) error {
+	log.SetOutput(f)
+	if len(events) == 0 {
+		return nil
+	}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		docs[i] = event
+	}
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		log.Printf("Error inserting click events (count: %v) (%v)", len(events), err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Inserted click events in database (count: %v)", len(events))
+	}
+
+	return nil
+}
+
+/*
+	Deletes click events recorded before the provided time, using the timestamp index.
+*/
+func DeleteClickEvents(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, before uint64) (int64, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteMany(ctx, bson.M{"timestamp": bson.M{"$lt": before}})
+	if err != nil {
+		log.Printf("Error deleting old click events (%v)", err)
+		return 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted old click events from database (count: %v)", result.DeletedCount)
+	}
+
+	return result.DeletedCount, nil
+}
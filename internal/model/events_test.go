@@ -0,0 +1,82 @@
+package model
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var testClickEvents = []ClickEvent{
+	{Slug: "EVENTS1", Timestamp: 200, Referrer: "https://news.example.com", IP: "203.0.113.0"},
+	{Slug: "EVENTS1", Timestamp: 100, UserAgent: "curl/7.79.1"},
+	{Slug: "EVENTS1", Timestamp: 100, Language: "en-US"},
+}
+
+func TestMemoryStoreClickEvents(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreClickEvents.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	store := NewMemoryStore(f, verbose)
+	if err := store.InsertClickEvents(testClickEvents); err != nil || len(store.events) != 3 {
+		t.Errorf("FAILED inserting click events. Expected: 3, got: %v (%v)", len(store.events), err)
+	} else {
+		t.Logf("PASSED inserting click events. Expected: 3, got: %v", len(store.events))
+	}
+	testDeleteClickEvents(t, store)
+}
+
+func TestBoltStoreClickEvents(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreClickEvents.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreClickEvents.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.InsertClickEvents(testClickEvents); err != nil {
+		t.Fatalf("FAILED inserting click events. Expected: nil error, got: %v", err)
+	}
+	// events of the same second are kept apart, and come out in time order
+	timestamps := []uint64{}
+	store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEventsBucket).ForEach(func(k, v []byte) error {
+			timestamps = append(timestamps, binary.BigEndian.Uint64(k[:8]))
+			return nil
+		})
+	})
+	if len(timestamps) != 3 || timestamps[0] != 100 || timestamps[1] != 100 || timestamps[2] != 200 {
+		t.Errorf("FAILED inserting click events. Expected: [100 100 200], got: %v", timestamps)
+	} else {
+		t.Logf("PASSED inserting click events. Expected: [100 100 200], got: %v", timestamps)
+	}
+	testDeleteClickEvents(t, store)
+}
+
+/*
+	Checks that deleting old click events keeps the ones recorded at or after the provided time, expects testClickEvents to be stored
+*/
+func testDeleteClickEvents(t *testing.T, store EventStore) {
+	count, err := store.DeleteClickEvents(200)
+	timestamps := []uint64{}
+	store.ScanClickEvents(0, func(event ClickEvent) error {
+		timestamps = append(timestamps, event.Timestamp)
+		return nil
+	})
+	if err != nil || count != 2 || len(timestamps) != 1 || timestamps[0] != 200 {
+		t.Errorf("FAILED deleting old click events. Expected: 2 deleted, [200] kept, got: %v deleted, %v kept (%v)", count, timestamps, err)
+	} else {
+		t.Logf("PASSED deleting old click events. Expected: 2 deleted, [200] kept, got: %v deleted, %v kept", count, timestamps)
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	Called for every exported URL. Returning an error stops the export, i.e. when the client went away.
+*/
+type ExportFunc func(url Url) error
+
+/*
+	Passes every URL matching the filter to fn, ordered by creation time and slug, and returns the number of exported URLs.
+	URLs are read from a database cursor one batch at a time, so exports never hold the whole collection in memory.
+	There is no timeout, as exports run for as long as the client keeps reading.
+*/
+func ExportUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, filter UrlFilter, fn ExportFunc) (int64, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created", Value: 1}, {Key: "slug", Value: 1}}).
+		SetBatchSize(1000)
+	cur, err := collection.Find(ctx, urlFilterQuery(filter), findOptions)
+	if err != nil {
+		log.Printf("Error exporting URLs (%v)", err)
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	count := int64(0)
+	for cur.Next(ctx) {
+		url := Url{}
+		if err := cur.Decode(&url); err != nil {
+			log.Printf("Error exporting URLs (%v)", err)
+			return count, err
+		}
+		if err := fn(url); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("Error exporting URLs (%v)", err)
+		return count, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Exported URLs from database (count: %v)", count)
+	}
+
+	return count, nil
+}
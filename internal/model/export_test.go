@@ -0,0 +1,92 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+/*
+	Inserts a set of URLs and checks exporting them in creation order, with a filter and when the export is stopped early
+*/
+func testExportUrls(t *testing.T, store UrlStore) {
+	for i := 1; i <= 5; i++ {
+		url := Url{
+			Slug:    fmt.Sprintf("EXPORT%v", i),
+			Target:  "https://www.google.com/" + fmt.Sprint(i),
+			Created: uint64(200 - i),
+			Owner:   "alice",
+			Tags:    []string{"export"},
+		}
+		if i%2 == 0 {
+			url.Owner = "bob"
+		}
+		if err := store.InsertUrl(url); err != nil {
+			t.Fatalf("FAILED inserting URL. Expected: nil error, got: %v", err)
+		}
+	}
+
+	seen := []string{}
+	count, err := store.ExportUrls(UrlFilter{Tags: []string{"export"}}, func(url Url) error {
+		seen = append(seen, url.Slug)
+		return nil
+	})
+	if err != nil || count != 5 || fmt.Sprint(seen) != "[EXPORT5 EXPORT4 EXPORT3 EXPORT2 EXPORT1]" {
+		t.Errorf("FAILED exporting URLs. Expected: EXPORT5 through EXPORT1, got: %v (%v)", seen, err)
+	} else {
+		t.Logf("PASSED exporting URLs. Expected: EXPORT5 through EXPORT1, got: %v", seen)
+	}
+
+	seen = []string{}
+	count, err = store.ExportUrls(UrlFilter{Owner: "bob", Tags: []string{"export"}}, func(url Url) error {
+		seen = append(seen, url.Slug)
+		return nil
+	})
+	if err != nil || count != 2 || fmt.Sprint(seen) != "[EXPORT4 EXPORT2]" {
+		t.Errorf("FAILED exporting filtered URLs. Expected: [EXPORT4 EXPORT2], got: %v (%v)", seen, err)
+	}
+
+	// an error from the callback stops the export and is returned as is
+	stop := errors.New("stop")
+	count, err = store.ExportUrls(UrlFilter{Tags: []string{"export"}}, func(url Url) error {
+		if url.Slug == "EXPORT3" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 2 {
+		t.Errorf("FAILED stopping export. Expected: 2 exported (%v), got: %v (%v)", stop, count, err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		store.DeleteUrl(fmt.Sprintf("EXPORT%v", i))
+	}
+}
+
+func TestMemoryStoreExportUrls(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreExportUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testExportUrls(t, NewMemoryStore(f, verbose))
+}
+
+func TestBoltStoreExportUrls(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreExportUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreExportUrls.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	testExportUrls(t, store)
+}
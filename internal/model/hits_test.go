@@ -0,0 +1,63 @@
+package model
+
+import (
+	"os"
+	"testing"
+)
+
+/*
+	Inserts two URLs and checks adding batched hit counts to them, skipping a missing slug
+*/
+func testIncrementUrlHits(t *testing.T, store UrlStore) {
+	for _, slug := range []string{"HITS1", "HITS2"} {
+		if err := store.InsertUrl(Url{Slug: slug, Target: "https://www.google.com"}); err != nil {
+			t.Fatalf("FAILED inserting URL. Expected: nil error, got: %v", err)
+		}
+	}
+	hits := map[string]HitCounts{"HITS1": {Hits: 3}, "HITS2": {Hits: 1, BotHits: 4}, "HITSMISSING": {Hits: 2}}
+	if err := store.IncrementUrlHits(hits); err != nil {
+		t.Errorf("FAILED incrementing URL hits. Expected: nil error, got: %v", err)
+	}
+	store.IncrementUrlHits(map[string]HitCounts{"HITS1": {Hits: 2}})
+
+	first, _ := store.GetUrl("HITS1")
+	second, _ := store.GetUrl("HITS2")
+	if first.Hits != 5 || second.Hits != 1 || second.BotHits != 4 {
+		t.Errorf("FAILED incrementing URL hits. Expected: 5 and 1 (4 bots), got: %v and %v (%v bots)", first.Hits, second.Hits, second.BotHits)
+	} else {
+		t.Logf("PASSED incrementing URL hits. Expected: 5 and 1 (4 bots), got: %v and %v (%v bots)", first.Hits, second.Hits, second.BotHits)
+	}
+	if _, err := store.GetUrl("HITSMISSING"); err != ErrUrlNotFound {
+		t.Errorf("FAILED skipping missing URL. Expected: %v, got: %v", ErrUrlNotFound, err)
+	}
+
+	store.DeleteUrl("HITS1")
+	store.DeleteUrl("HITS2")
+}
+
+func TestMemoryStoreIncrementUrlHits(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreIncrementUrlHits.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testIncrementUrlHits(t, NewMemoryStore(f, verbose))
+}
+
+func TestBoltStoreIncrementUrlHits(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreIncrementUrlHits.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreIncrementUrlHits.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	testIncrementUrlHits(t, store)
+}
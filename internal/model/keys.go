@@ -0,0 +1,184 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// returned when no API key exists for the requested ID or hash
+var ErrApiKeyNotFound = errors.New("API key not found")
+
+/*
+	Holds the details of an API key used to authenticate API requests. Only a hash of the key itself is stored.
+	Role decides what the key is allowed to do (see the rbac package). Keys created before roles existed have no role stored.
+*/
+type ApiKey struct {
+	ID      string `bson:"id" json:"id"`
+	Name    string `bson:"name" json:"name"`
+	Hash    string `bson:"hash" json:"-"`
+	Role    string `bson:"role,omitempty" json:"role"`
+	Created uint64 `bson:"created" json:"created"`
+}
+
+/*
+	Makes sure the indexes used to look up API keys exist on the API key collection.
+*/
+func EnsureApiKeyIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_unique").SetUnique(true),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Inserts a new API key into the database.
+*/
+func InsertApiKey(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, key ApiKey) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		log.Printf("Error creating new API key (id: %v) (%v)", key.ID, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Inserted API key in database (id: %v) (name: %v)", key.ID, key.Name)
+	}
+
+	return nil
+}
+
+/*
+	Looks up the API key with the provided hash in the database.
+*/
+func GetApiKeyByHash(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, hash string) (ApiKey, error) {
+	log.SetOutput(f)
+	key := ApiKey{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return key, ErrApiKeyNotFound
+	} else if err != nil {
+		log.Printf("Error looking up API key (%v)", err)
+		return key, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got API key from database (id: %v)", key.ID)
+	}
+
+	return key, nil
+}
+
+/*
+	Returns all API keys stored in the database.
+*/
+func GetApiKeys(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) ([]ApiKey, error) {
+	log.SetOutput(f)
+	keys := []ApiKey{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.M{"created": 1}))
+	if err != nil {
+		log.Printf("Error retrieving all API keys (%v)", err)
+		return []ApiKey{}, err
+	}
+	if err := cur.All(ctx, &keys); err != nil {
+		log.Printf("Error retrieving all API keys (%v)", err)
+		return []ApiKey{}, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got API keys from database (count: %v)", len(keys))
+	}
+
+	return keys, nil
+}
+
+/*
+	Assigns a new role to the API key with the provided ID in the database.
+*/
+func UpdateApiKeyRole(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, id string, role string) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		log.Printf("Error updating API key role (id: %v) (%v)", id, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrApiKeyNotFound
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Updated API key role in database (id: %v) (role: %v)", id, role)
+	}
+
+	return nil
+}
+
+/*
+	Deletes the API key with the provided ID from the database.
+*/
+func DeleteApiKey(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, id string) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		log.Printf("Error deleting API key (id: %v) (%v)", id, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrApiKeyNotFound
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted API key from database (id: %v)", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// returned when a listing cursor cannot be decoded or was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+/*
+	Narrows down which URLs are listed. Empty fields do not filter.
+	Owner and Tenant identify the creator together, so Tenant is only applied along with Owner.
+	Created times are unix times and inclusive, and every listed tag must be present on a URL.
+*/
+type UrlFilter struct {
+	Slugs       []string
+	Domain      string
+	Owner       string
+	Tenant      string
+	CreatedFrom uint64
+	CreatedTo   uint64
+	Tags        []string
+}
+
+/*
+	Controls the order and page size of a listing. SortBy is either created (default) or hits.
+	Cursor is the NextCursor of the previous page, and a Limit of 0 returns every matching URL.
+*/
+type ListOptions struct {
+	Filter     UrlFilter
+	SortBy     string
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+/*
+	Holds one page of a listing, along with the total number of URLs matching the filter.
+	NextCursor is empty on the last page.
+*/
+type UrlPage struct {
+	Urls       []Url
+	NextCursor string
+	Total      int64
+}
+
+/*
+	Returns the lowercase host name of the target URL, which is what the domain filter matches against.
+*/
+func TargetDomain(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// position of the last URL of a page, so the next page can continue after it
+type cursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      uint64 `json:"v"`
+	Slug       string `json:"k"`
+}
+
+func encodeCursor(opts ListOptions, url Url) string {
+	data, _ := json.Marshal(cursor{SortBy: opts.SortBy, Descending: opts.Descending, Value: sortValue(opts.SortBy, url), Slug: url.Slug})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(opts ListOptions) (cursor, error) {
+	c := cursor{}
+	data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.Slug == "" {
+		return c, ErrInvalidCursor
+	}
+	if c.SortBy != opts.SortBy || c.Descending != opts.Descending {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func sortValue(sortBy string, url Url) uint64 {
+	if sortBy == "hits" {
+		return url.Hits
+	}
+	return url.Created
+}
+
+/*
+	Checks if none of the filters are set.
+*/
+func (filter UrlFilter) IsEmpty() bool {
+	return len(filter.Slugs) == 0 && filter.Domain == "" && filter.Owner == "" && filter.CreatedFrom == 0 && filter.CreatedTo == 0 && len(filter.Tags) == 0
+}
+
+/*
+	Checks if the URL matches every filter.
+*/
+func (filter UrlFilter) Matches(url Url) bool {
+	if len(filter.Slugs) > 0 {
+		found := false
+		for _, slug := range filter.Slugs {
+			if slug == url.Slug {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Domain != "" {
+		domain := url.Domain
+		if domain == "" {
+			domain = TargetDomain(url.Target)
+		}
+		if domain != strings.ToLower(filter.Domain) {
+			return false
+		}
+	}
+	if filter.Owner != "" && (url.Owner != filter.Owner || url.Tenant != filter.Tenant) {
+		return false
+	}
+	if filter.CreatedFrom != 0 && url.Created < filter.CreatedFrom {
+		return false
+	}
+	if filter.CreatedTo != 0 && url.Created > filter.CreatedTo {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		found := false
+		for _, t := range url.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Filters, sorts and pages URLs held in memory. Used by the stores that cannot query their records.
+*/
+func pageUrls(urls []Url, opts ListOptions) (UrlPage, error) {
+	page := UrlPage{Urls: []Url{}}
+	matched := []Url{}
+	for _, url := range urls {
+		if opts.Filter.Matches(url) {
+			matched = append(matched, url)
+		}
+	}
+	page.Total = int64(len(matched))
+
+	// ties are broken by slug so every URL has a stable position for the cursor
+	less := func(a, b Url) bool {
+		va, vb := sortValue(opts.SortBy, a), sortValue(opts.SortBy, b)
+		if va != vb {
+			return va < vb
+		}
+		return a.Slug < b.Slug
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if opts.Descending {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	start := 0
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts)
+		if err != nil {
+			return page, err
+		}
+		last := Url{Slug: c.Slug, Created: c.Value, Hits: c.Value}
+		start = sort.Search(len(matched), func(i int) bool {
+			if opts.Descending {
+				return less(matched[i], last)
+			}
+			return less(last, matched[i])
+		})
+	}
+	end := len(matched)
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+		page.NextCursor = encodeCursor(opts, matched[end-1])
+	}
+	page.Urls = append(page.Urls, matched[start:end]...)
+	return page, nil
+}
+
+// builds the database query for a filter
+func urlFilterQuery(filter UrlFilter) bson.M {
+	query := bson.M{}
+	if len(filter.Slugs) > 0 {
+		query["slug"] = bson.M{"$in": filter.Slugs}
+	}
+	if filter.Domain != "" {
+		query["domain"] = strings.ToLower(filter.Domain)
+	}
+	if filter.Owner != "" {
+		query["owner"] = filter.Owner
+		// URLs created with API keys have no tenant stored
+		if filter.Tenant == "" {
+			query["tenant"] = bson.M{"$in": bson.A{nil, ""}}
+		} else {
+			query["tenant"] = filter.Tenant
+		}
+	}
+	created := bson.M{}
+	if filter.CreatedFrom != 0 {
+		created["$gte"] = filter.CreatedFrom
+	}
+	if filter.CreatedTo != 0 {
+		created["$lte"] = filter.CreatedTo
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+	if len(filter.Tags) > 0 {
+		query["tags"] = bson.M{"$all": filter.Tags}
+	}
+	return query
+}
+
+/*
+	Returns one page of URLs matching the filter, using the sort field and slug indexes to continue from the cursor.
+*/
+func ListUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, opts ListOptions) (UrlPage, error) {
+	log.SetOutput(f)
+	page := UrlPage{Urls: []Url{}}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := urlFilterQuery(opts.Filter)
+	var err error
+	if len(query) == 0 {
+		page.Total, err = collection.EstimatedDocumentCount(ctx)
+	} else {
+		page.Total, err = collection.CountDocuments(ctx, query)
+	}
+	if err != nil {
+		log.Printf("Error counting URLs (%v)", err)
+		return page, err
+	}
+
+	sortBy := opts.SortBy
+	if sortBy != "hits" {
+		sortBy = "created"
+	}
+	direction, op := 1, "$gt"
+	if opts.Descending {
+		direction, op = -1, "$lt"
+	}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts)
+		if err != nil {
+			return page, err
+		}
+		query["$or"] = bson.A{
+			bson.M{sortBy: bson.M{op: c.Value}},
+			bson.M{sortBy: c.Value, "slug": bson.M{op: c.Slug}},
+		}
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: sortBy, Value: direction}, {Key: "slug", Value: direction}})
+	if opts.Limit > 0 {
+		// fetch one extra URL to know if there is a next page
+		findOptions.SetLimit(int64(opts.Limit) + 1)
+	}
+	cur, err := collection.Find(ctx, query, findOptions)
+	if err != nil {
+		log.Printf("Error listing URLs (%v)", err)
+		return page, err
+	}
+	if err := cur.All(ctx, &page.Urls); err != nil {
+		log.Printf("Error listing URLs (%v)", err)
+		return page, err
+	}
+	if opts.Limit > 0 && len(page.Urls) > opts.Limit {
+		page.Urls = page.Urls[:opts.Limit]
+		page.NextCursor = encodeCursor(opts, page.Urls[opts.Limit-1])
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Listed URLs from database (count: %v) (total: %v)", len(page.Urls), page.Total)
+	}
+
+	return page, nil
+}
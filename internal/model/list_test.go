@@ -0,0 +1,116 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+/*
+	Inserts a set of URLs and checks filtering, sorting and paging through them with cursors
+*/
+func testListUrls(t *testing.T, store UrlStore) {
+	for i := 1; i <= 10; i++ {
+		url := Url{
+			Slug:    fmt.Sprintf("LIST%02d", i),
+			Target:  "https://www.google.com/" + fmt.Sprint(i),
+			Created: uint64(100 + i/2),
+			Hits:    uint64(i % 3),
+			Owner:   "alice",
+		}
+		if i%2 == 0 {
+			url.Target = "https://News.Example.com:8443/" + fmt.Sprint(i)
+			url.Tags = []string{"news", "daily"}
+			url.Owner = "bob"
+		}
+		if err := store.InsertUrl(url); err != nil {
+			t.Fatalf("FAILED inserting URL. Expected: nil error, got: %v", err)
+		}
+	}
+
+	// page through everything, 3 at a time, newest first
+	seen := []string{}
+	opts := ListOptions{Descending: true, Limit: 3}
+	for pages := 0; pages < 10; pages++ {
+		page, err := store.ListUrls(opts)
+		if err != nil || page.Total != 10 {
+			t.Fatalf("FAILED listing URLs. Expected: total 10, got: %v (%v)", page.Total, err)
+		}
+		for _, url := range page.Urls {
+			seen = append(seen, url.Slug)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if fmt.Sprint(seen) != "[LIST10 LIST09 LIST08 LIST07 LIST06 LIST05 LIST04 LIST03 LIST02 LIST01]" {
+		t.Errorf("FAILED paging through URLs. Expected: LIST10 through LIST01, got: %v", seen)
+	} else {
+		t.Logf("PASSED paging through URLs. Expected: LIST10 through LIST01, got: %v", seen)
+	}
+
+	// a cursor only continues the listing it was issued for
+	_, err := store.ListUrls(ListOptions{SortBy: "hits", Limit: 3, Cursor: opts.Cursor})
+	if err != ErrInvalidCursor {
+		t.Errorf("FAILED listing with cursor from another sort. Expected: %v, got: %v", ErrInvalidCursor, err)
+	}
+	_, err = store.ListUrls(ListOptions{Cursor: "garbage"})
+	if err != ErrInvalidCursor {
+		t.Errorf("FAILED listing with invalid cursor. Expected: %v, got: %v", ErrInvalidCursor, err)
+	}
+
+	page, err := store.ListUrls(ListOptions{SortBy: "hits", Descending: true, Limit: 2})
+	if err != nil || len(page.Urls) != 2 || page.Urls[0].Hits != 2 || page.Urls[0].Slug != "LIST08" {
+		t.Errorf("FAILED listing URLs by hits. Expected: LIST08 with 2 hits first, got: %v (%v)", page.Urls, err)
+	}
+
+	tests := []struct {
+		name   string
+		filter UrlFilter
+		total  int64
+	}{
+		{"domain", UrlFilter{Domain: "news.example.com"}, 5},
+		{"owner", UrlFilter{Owner: "alice"}, 5},
+		{"owner in other tenant", UrlFilter{Owner: "alice", Tenant: "acme"}, 0},
+		{"created range", UrlFilter{CreatedFrom: 102, CreatedTo: 103}, 4},
+		{"tags", UrlFilter{Tags: []string{"daily", "news"}}, 5},
+		{"missing tag", UrlFilter{Tags: []string{"news", "weekly"}}, 0},
+		{"combined", UrlFilter{Owner: "bob", CreatedFrom: 104}, 2},
+	}
+	for _, test := range tests {
+		page, err := store.ListUrls(ListOptions{Filter: test.filter, Limit: 100})
+		if err != nil || page.Total != test.total || int64(len(page.Urls)) != test.total {
+			t.Errorf("FAILED listing URLs by %v. Expected: %v, got: %v/%v (%v)", test.name, test.total, page.Total, len(page.Urls), err)
+		} else {
+			t.Logf("PASSED listing URLs by %v. Expected: %v, got: %v", test.name, test.total, page.Total)
+		}
+	}
+}
+
+func TestMemoryStoreListUrls(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreListUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testListUrls(t, NewMemoryStore(f, verbose))
+}
+
+func TestBoltStoreListUrls(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreListUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreListUrls.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	testListUrls(t, store)
+}
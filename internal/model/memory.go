@@ -0,0 +1,747 @@
+package model
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+/*
+	Keeps short URLs in memory, allowing the server to run without any external services.
+	Safe for concurrent use. Everything is lost when the server exits.
+*/
+type MemoryStore struct {
+	F       *os.File
+	Debug   bool
+	mu      sync.RWMutex
+	urls    map[string]Url
+	keys    map[string]ApiKey
+	events  []ClickEvent
+	rollups map[memoryRollupKey]ClickRollup
+	hooks   map[string]Webhook
+	// webhook deliveries by ID
+	deliveries map[string]WebhookDelivery
+}
+
+// identifies the rollup of a short URL and hour
+type memoryRollupKey struct {
+	slug string
+	hour uint64
+}
+
+/*
+	Returns an empty in-memory store.
+*/
+func NewMemoryStore(f *os.File, debug bool) *MemoryStore {
+	return &MemoryStore{
+		F:          f,
+		Debug:      debug,
+		urls:       map[string]Url{},
+		keys:       map[string]ApiKey{},
+		rollups:    map[memoryRollupKey]ClickRollup{},
+		hooks:      map[string]Webhook{},
+		deliveries: map[string]WebhookDelivery{},
+	}
+}
+
+/*
+	Inserts a new long URL into the store. Returns ErrDuplicateSlug if the slug is already taken.
+*/
+func (s *MemoryStore) InsertUrl(url Url) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[url.Slug]; ok {
+		return ErrDuplicateSlug
+	}
+	url.Domain = TargetDomain(url.Target)
+	s.urls[url.Slug] = url
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted URL in memory (slug: %v) (target: %v)", url.Slug, url.Target)
+	}
+	return nil
+}
+
+/*
+	Inserts many new long URLs into the store. Returns one error per URL, ErrDuplicateSlug when the slug is already taken.
+*/
+func (s *MemoryStore) InsertUrls(urls []Url) []error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		if _, ok := s.urls[url.Slug]; ok {
+			errs[i] = ErrDuplicateSlug
+			continue
+		}
+		url.Domain = TargetDomain(url.Target)
+		s.urls[url.Slug] = url
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted URLs in memory (count: %v)", len(urls))
+	}
+	return errs
+}
+
+/*
+	Looks up the provided short URL slug and returns the target URL.
+*/
+func (s *MemoryStore) GetUrl(slug string) (Url, error) {
+	log.SetOutput(s.F)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	url, ok := s.urls[slug]
+	if !ok {
+		if s.Debug {
+			log.Printf("[DEBUG] Attempted to get missing URL from memory (slug: %v)", slug)
+		}
+		return Url{}, ErrUrlNotFound
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Got URL from memory (slug: %v) (target: %v)", url.Slug, url.Target)
+	}
+	return url, nil
+}
+
+/*
+	Returns one page of the stored URLs matching the filter.
+*/
+func (s *MemoryStore) ListUrls(opts ListOptions) (UrlPage, error) {
+	log.SetOutput(s.F)
+	s.mu.RLock()
+	urls := make([]Url, 0, len(s.urls))
+	for _, url := range s.urls {
+		urls = append(urls, url)
+	}
+	s.mu.RUnlock()
+
+	page, err := pageUrls(urls, opts)
+	if err != nil {
+		return page, err
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Listed URLs from memory (count: %v) (total: %v)", len(page.Urls), page.Total)
+	}
+	return page, nil
+}
+
+/*
+	Passes every URL matching the filter to fn, ordered by creation time and slug.
+	The matching URLs are copied first, so fn runs without holding the lock.
+*/
+func (s *MemoryStore) ExportUrls(filter UrlFilter, fn ExportFunc) (int64, error) {
+	log.SetOutput(s.F)
+	s.mu.RLock()
+	urls := []Url{}
+	for _, url := range s.urls {
+		if filter.Matches(url) {
+			urls = append(urls, url)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(urls, func(i, j int) bool {
+		if urls[i].Created != urls[j].Created {
+			return urls[i].Created < urls[j].Created
+		}
+		return urls[i].Slug < urls[j].Slug
+	})
+
+	count := int64(0)
+	for _, url := range urls {
+		if err := fn(url); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Exported URLs from memory (count: %v)", count)
+	}
+	return count, nil
+}
+
+/*
+	Looks up the provided short URL slug and updates the target URL.
+*/
+func (s *MemoryStore) UpdateUrl(url Url) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.urls[url.Slug]
+	if !ok {
+		return ErrUrlNotFound
+	}
+	existing.Target = url.Target
+	existing.Domain = TargetDomain(url.Target)
+	if url.Tags != nil {
+		existing.Tags = url.Tags
+	}
+	if url.ExpiresAt != 0 || url.ClearExpiry {
+		existing.ExpiresAt = url.ExpiresAt
+	}
+	if url.MaxHits != 0 || url.ClearMaxHits {
+		existing.MaxHits = url.MaxHits
+	}
+	if url.PasswordHash != "" {
+		existing.Protected = true
+		existing.PasswordHash = url.PasswordHash
+	}
+	s.urls[url.Slug] = existing
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated URL in memory (slug: %v) (target: %v)", url.Slug, url.Target)
+	}
+	return nil
+}
+
+/*
+	Deletes the URL record for the provided short URL slug.
+*/
+func (s *MemoryStore) DeleteUrl(slug string) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[slug]; !ok {
+		return ErrUrlNotFound
+	}
+	delete(s.urls, slug)
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted URL from memory (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Updates the hit count for the given short URL slug.
+	Returns ErrHitLimitReached if the short URL has already been resolved the maximum number of times.
+*/
+func (s *MemoryStore) UpdateUrlHits(slug string) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[slug]
+	if !ok {
+		return ErrUrlNotFound
+	}
+	if url.IsHitLimitReached() {
+		return ErrHitLimitReached
+	}
+	url.Hits += 1
+	s.urls[slug] = url
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated URL hits in memory (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Adds batched hit counts to short URLs, keyed by slug. Hit limits are not checked, and missing slugs are skipped.
+*/
+func (s *MemoryStore) IncrementUrlHits(hits map[string]HitCounts) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for slug, counts := range hits {
+		if url, ok := s.urls[slug]; ok {
+			url.Hits += counts.Hits
+			url.BotHits += counts.BotHits
+			s.urls[slug] = url
+		}
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Incremented URL hits in memory (count: %v)", len(hits))
+	}
+	return nil
+}
+
+// returns the sorted slugs of the URLs matching the filter, or ErrTooManyUrls if more than limit URLs match (0 means no limit)
+func (s *MemoryStore) matchingSlugs(filter UrlFilter, limit int) ([]string, error) {
+	slugs := []string{}
+	for slug, url := range s.urls {
+		if filter.Matches(url) {
+			slugs = append(slugs, slug)
+		}
+	}
+	if limit > 0 && len(slugs) > limit {
+		return nil, ErrTooManyUrls
+	}
+	sort.Strings(slugs)
+	return slugs, nil
+}
+
+/*
+	Applies the update to every URL matching the filter and returns the sorted slugs of the updated URLs.
+*/
+func (s *MemoryStore) UpdateUrls(filter UrlFilter, update UrlUpdate, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slugs, err := s.matchingSlugs(filter, limit)
+	if err != nil || dryRun {
+		return slugs, err
+	}
+	for _, slug := range slugs {
+		url := s.urls[slug]
+		update.apply(&url)
+		s.urls[slug] = url
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated URLs in memory (count: %v)", len(slugs))
+	}
+	return slugs, nil
+}
+
+/*
+	Deletes every URL matching the filter and returns the sorted slugs of the deleted URLs.
+*/
+func (s *MemoryStore) DeleteUrls(filter UrlFilter, limit int, dryRun bool) ([]string, error) {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slugs, err := s.matchingSlugs(filter, limit)
+	if err != nil || dryRun {
+		return slugs, err
+	}
+	for _, slug := range slugs {
+		delete(s.urls, slug)
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted URLs from memory (count: %v)", len(slugs))
+	}
+	return slugs, nil
+}
+
+/*
+	Deletes every URL that expired at or before the provided unix time. Returns the deleted URLs.
+*/
+func (s *MemoryStore) DeleteExpiredUrls(now uint64) ([]Url, error) {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := []Url{}
+	for slug, url := range s.urls {
+		if url.ExpiresAt != 0 && url.ExpiresAt <= now {
+			delete(s.urls, slug)
+			expired = append(expired, url)
+		}
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted expired URLs from memory (count: %v)", len(expired))
+	}
+	return expired, nil
+}
+
+/*
+	Inserts a new API key into the store.
+*/
+func (s *MemoryStore) InsertApiKey(key ApiKey) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted API key in memory (id: %v) (name: %v)", key.ID, key.Name)
+	}
+	return nil
+}
+
+/*
+	Looks up the API key with the provided hash.
+*/
+func (s *MemoryStore) GetApiKeyByHash(hash string) (ApiKey, error) {
+	log.SetOutput(s.F)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			if s.Debug {
+				log.Printf("[DEBUG] Got API key from memory (id: %v)", key.ID)
+			}
+			return key, nil
+		}
+	}
+	return ApiKey{}, ErrApiKeyNotFound
+}
+
+/*
+	Returns all stored API keys, oldest first.
+*/
+func (s *MemoryStore) GetApiKeys() ([]ApiKey, error) {
+	log.SetOutput(s.F)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]ApiKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created != keys[j].Created {
+			return keys[i].Created < keys[j].Created
+		}
+		return keys[i].ID < keys[j].ID
+	})
+
+	if s.Debug {
+		log.Printf("[DEBUG] Got API keys from memory (count: %v)", len(keys))
+	}
+	return keys, nil
+}
+
+/*
+	Assigns a new role to the API key with the provided ID.
+*/
+func (s *MemoryStore) UpdateApiKeyRole(id string, role string) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrApiKeyNotFound
+	}
+	key.Role = role
+	s.keys[id] = key
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated API key role in memory (id: %v) (role: %v)", id, role)
+	}
+	return nil
+}
+
+/*
+	Deletes the API key with the provided ID.
+*/
+func (s *MemoryStore) DeleteApiKey(id string) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return ErrApiKeyNotFound
+	}
+	delete(s.keys, id)
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted API key from memory (id: %v)", id)
+	}
+	return nil
+}
+
+/*
+	Appends a batch of click events to the store.
+*/
+func (s *MemoryStore) InsertClickEvents(events []ClickEvent) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted click events in memory (count: %v)", len(events))
+	}
+	return nil
+}
+
+/*
+	Passes every click event recorded at or after from to fn. Matching events are copied first, so fn runs without holding the lock.
+*/
+func (s *MemoryStore) ScanClickEvents(from uint64, fn ClickEventFunc) error {
+	s.mu.RLock()
+	events := []ClickEvent{}
+	for _, event := range s.events {
+		if event.Timestamp >= from {
+			events = append(events, event)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Deletes click events recorded before the provided time, keeping the newer ones in the order they were inserted.
+*/
+func (s *MemoryStore) DeleteClickEvents(before uint64) (int64, error) {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := []ClickEvent{}
+	for _, event := range s.events {
+		if event.Timestamp >= before {
+			kept = append(kept, event)
+		}
+	}
+	count := int64(len(s.events) - len(kept))
+	s.events = kept
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted old click events from memory (count: %v)", count)
+	}
+	return count, nil
+}
+
+/*
+	Saves a batch of click rollups, replacing any existing rollup of the same short URL and hour.
+*/
+func (s *MemoryStore) SaveClickRollups(rollups []ClickRollup) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rollup := range rollups {
+		s.rollups[memoryRollupKey{slug: rollup.Slug, hour: rollup.Hour}] = rollup
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Saved click rollups in memory (count: %v)", len(rollups))
+	}
+	return nil
+}
+
+/*
+	Returns the click rollups of a short URL for the hours starting in [from, to), ordered by hour.
+*/
+func (s *MemoryStore) GetClickRollups(slug string, from uint64, to uint64) ([]ClickRollup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rollups := []ClickRollup{}
+	for key, rollup := range s.rollups {
+		if key.slug == slug && key.hour >= from && key.hour < to {
+			rollups = append(rollups, rollup)
+		}
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Hour < rollups[j].Hour
+	})
+	return rollups, nil
+}
+
+/*
+	Returns the hour of the most recent click rollup, or 0 if nothing has been rolled up yet.
+*/
+func (s *MemoryStore) LatestClickRollup() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := uint64(0)
+	for key := range s.rollups {
+		if key.hour > latest {
+			latest = key.hour
+		}
+	}
+	return latest, nil
+}
+
+/*
+	Nothing to release for the in-memory store.
+*/
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+/*
+	Inserts a new webhook into the store.
+*/
+func (s *MemoryStore) InsertWebhook(hook Webhook) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks[hook.ID] = hook
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted webhook in memory (id: %v) (url: %v)", hook.ID, hook.Url)
+	}
+	return nil
+}
+
+/*
+	Looks up the webhook with the provided ID.
+*/
+func (s *MemoryStore) GetWebhook(id string) (Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hook, ok := s.hooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+/*
+	Returns all stored webhooks, oldest first.
+*/
+func (s *MemoryStore) GetWebhooks() ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := make([]Webhook, 0, len(s.hooks))
+	for _, hook := range s.hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Created != hooks[j].Created {
+			return hooks[i].Created < hooks[j].Created
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks, nil
+}
+
+/*
+	Deletes the webhook with the provided ID. Its deliveries are kept until they are pruned.
+*/
+func (s *MemoryStore) DeleteWebhook(id string) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.hooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.hooks, id)
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted webhook from memory (id: %v)", id)
+	}
+	return nil
+}
+
+/*
+	Inserts a batch of webhook deliveries into the store.
+*/
+func (s *MemoryStore) InsertWebhookDeliveries(deliveries []WebhookDelivery) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delivery := range deliveries {
+		s.deliveries[delivery.ID] = delivery
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Inserted webhook deliveries in memory (count: %v)", len(deliveries))
+	}
+	return nil
+}
+
+/*
+	Claims up to limit pending deliveries due at now, oldest due first, by moving their next attempt to the end of the lease.
+*/
+func (s *MemoryStore) ClaimWebhookDeliveries(now uint64, lease uint64, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.Status == DeliveryPending && delivery.NextAttempt <= now {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttempt != due[j].NextAttempt {
+			return due[i].NextAttempt < due[j].NextAttempt
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].NextAttempt = now + lease
+		s.deliveries[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+/*
+	Replaces the stored delivery with the same ID, recording the outcome of an attempt.
+*/
+func (s *MemoryStore) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[delivery.ID] = delivery
+
+	if s.Debug {
+		log.Printf("[DEBUG] Updated webhook delivery in memory (id: %v) (status: %v)", delivery.ID, delivery.Status)
+	}
+	return nil
+}
+
+/*
+	Returns up to limit deliveries of a webhook, newest first.
+*/
+func (s *MemoryStore) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].Created != deliveries[j].Created {
+			return deliveries[i].Created > deliveries[j].Created
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+/*
+	Deletes delivered and failed deliveries last updated before the provided time. Pending deliveries are never deleted.
+*/
+func (s *MemoryStore) DeleteWebhookDeliveries(before uint64) (int64, error) {
+	log.SetOutput(s.F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := int64(0)
+	for id, delivery := range s.deliveries {
+		if delivery.Status != DeliveryPending && delivery.Updated < before {
+			delete(s.deliveries, id)
+			count++
+		}
+	}
+
+	if s.Debug {
+		log.Printf("[DEBUG] Deleted old webhook deliveries from memory (count: %v)", count)
+	}
+	return count, nil
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+	Tests the MemoryStore insert, lookup, update, hit count and delete functions
+*/
+func TestMemoryStore(t *testing.T) {
+	testLog := "/tmp/TestMemoryStore.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	store := NewMemoryStore(f, verbose)
+
+	err = store.InsertUrl(Url{Slug: "TEST1234", Target: "https://www.google.com"})
+	if err != nil {
+		t.Errorf("FAILED inserting URL. Expected: nil error, got: %v", err)
+	}
+
+	err = store.InsertUrl(Url{Slug: "TEST1234", Target: "https://www.google.com"})
+	if err != ErrDuplicateSlug {
+		t.Errorf("FAILED inserting duplicate URL. Expected: %v, got: %v", ErrDuplicateSlug, err)
+	}
+
+	err = store.UpdateUrl(Url{Slug: "TEST1234", Target: "https://www.reddit.com", ExpiresAt: 4102444800})
+	if err != nil {
+		t.Errorf("FAILED updating URL. Expected: nil error, got: %v", err)
+	}
+	store.UpdateUrl(Url{Slug: "TEST1234", Target: "https://www.reddit.com", ClearExpiry: true})
+	if url, _ := store.GetUrl("TEST1234"); url.ExpiresAt != 0 {
+		t.Errorf("FAILED clearing URL expiry. Expected: 0, got: %v", url.ExpiresAt)
+	}
+	err = store.UpdateUrlHits("TEST1234")
+	if err != nil {
+		t.Errorf("FAILED updating URL hits. Expected: nil error, got: %v", err)
+	}
+
+	url, err := store.GetUrl("TEST1234")
+	if err != nil || url.Target != "https://www.reddit.com" || url.Hits != 1 {
+		t.Errorf("FAILED getting URL. Expected: https://www.reddit.com/1, got: %v/%v (%v)", url.Target, url.Hits, err)
+	} else {
+		t.Logf("PASSED getting URL. Expected: https://www.reddit.com/1, got: %v/%v", url.Target, url.Hits)
+	}
+
+	page, err := store.ListUrls(ListOptions{})
+	if err != nil || len(page.Urls) != 1 || page.Total != 1 {
+		t.Errorf("FAILED listing all URLs. Expected: 1, got: %v (%v)", len(page.Urls), err)
+	} else {
+		t.Logf("PASSED listing all URLs. Expected: 1, got: %v", len(page.Urls))
+	}
+
+	// batch inserts report duplicates per URL, including duplicates within the batch
+	errs := store.InsertUrls([]Url{
+		{Slug: "BATCH1", Target: "https://www.google.com"},
+		{Slug: "TEST1234", Target: "https://www.google.com"},
+		{Slug: "BATCH1", Target: "https://www.google.com"},
+	})
+	if len(errs) != 3 || errs[0] != nil || errs[1] != ErrDuplicateSlug || errs[2] != ErrDuplicateSlug {
+		t.Errorf("FAILED inserting URLs. Expected: [nil %v %v], got: %v", ErrDuplicateSlug, ErrDuplicateSlug, errs)
+	} else {
+		t.Logf("PASSED inserting URLs. Expected: [nil %v %v], got: %v", ErrDuplicateSlug, ErrDuplicateSlug, errs)
+	}
+	if err := store.DeleteUrl("BATCH1"); err != nil {
+		t.Errorf("FAILED deleting batch inserted URL. Expected: nil error, got: %v", err)
+	}
+
+	// only the expired URL should be purged
+	err = store.InsertUrl(Url{Slug: "TEST5678", Target: "https://www.google.com", ExpiresAt: 100})
+	if err != nil {
+		t.Errorf("FAILED inserting expiring URL. Expected: nil error, got: %v", err)
+	}
+	expired, err := store.DeleteExpiredUrls(200)
+	if err != nil || len(expired) != 1 || expired[0].Slug != "TEST5678" {
+		t.Errorf("FAILED deleting expired URLs. Expected: TEST5678, got: %v (%v)", expired, err)
+	} else {
+		t.Logf("PASSED deleting expired URLs. Expected: TEST5678, got: %v", expired[0].Slug)
+	}
+
+	// API keys are looked up by hash
+	err = store.InsertApiKey(ApiKey{ID: "TESTKEY", Name: "test", Hash: "TESTHASH"})
+	if err != nil {
+		t.Errorf("FAILED inserting API key. Expected: nil error, got: %v", err)
+	}
+	key, err := store.GetApiKeyByHash("TESTHASH")
+	if err != nil || key.ID != "TESTKEY" {
+		t.Errorf("FAILED getting API key. Expected: TESTKEY, got: %v (%v)", key.ID, err)
+	} else {
+		t.Logf("PASSED getting API key. Expected: TESTKEY, got: %v", key.ID)
+	}
+	// roles are persisted with the API key
+	err = store.UpdateApiKeyRole("TESTKEY", "readonly")
+	if err != nil {
+		t.Errorf("FAILED updating API key role. Expected: nil error, got: %v", err)
+	}
+	key, err = store.GetApiKeyByHash("TESTHASH")
+	if err != nil || key.Role != "readonly" {
+		t.Errorf("FAILED getting updated API key role. Expected: readonly, got: %v (%v)", key.Role, err)
+	} else {
+		t.Logf("PASSED getting updated API key role. Expected: readonly, got: %v", key.Role)
+	}
+	err = store.UpdateApiKeyRole("MISSING", "readonly")
+	if err != ErrApiKeyNotFound {
+		t.Errorf("FAILED updating missing API key role. Expected: %v, got: %v", ErrApiKeyNotFound, err)
+	}
+	err = store.DeleteApiKey("TESTKEY")
+	if err != nil {
+		t.Errorf("FAILED deleting API key. Expected: nil error, got: %v", err)
+	}
+	_, err = store.GetApiKeyByHash("TESTHASH")
+	if err != ErrApiKeyNotFound {
+		t.Errorf("FAILED getting deleted API key. Expected: %v, got: %v", ErrApiKeyNotFound, err)
+	}
+
+	err = store.DeleteUrl("TEST1234")
+	if err != nil {
+		t.Errorf("FAILED deleting URL. Expected: nil error, got: %v", err)
+	}
+	_, err = store.GetUrl("TEST1234")
+	if err != ErrUrlNotFound {
+		t.Errorf("FAILED getting deleted URL. Expected: %v, got: %v", ErrUrlNotFound, err)
+	} else {
+		t.Logf("PASSED getting deleted URL. Expected: %v, got: %v", ErrUrlNotFound, err)
+	}
+
+	os.Remove(testLog)
+}
+
+/*
+	Tests that concurrent hits never go over the hit limit of a URL
+*/
+func TestMemoryStoreMaxHits(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreMaxHits.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	store := NewMemoryStore(f, verbose)
+
+	err = store.InsertUrl(Url{Slug: "TEST1234", Target: "https://www.google.com", MaxHits: 10})
+	if err != nil {
+		t.Fatalf("FAILED inserting URL. Expected: nil error, got: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var counted int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.UpdateUrlHits("TEST1234") == nil {
+				atomic.AddInt64(&counted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counted != 10 {
+		t.Errorf("FAILED limiting concurrent hits. Expected: 10, got: %v", counted)
+	} else {
+		t.Logf("PASSED limiting concurrent hits. Expected: 10, got: %v", counted)
+	}
+	err = store.UpdateUrlHits("TEST1234")
+	if err != ErrHitLimitReached {
+		t.Errorf("FAILED updating hits past the limit. Expected: %v, got: %v", ErrHitLimitReached, err)
+	}
+
+	os.Remove(testLog)
+}
@@ -2,10 +2,13 @@ package model
 
 import (
 	"context"
+	"errors"
 	"log"
+	"math"
 	"os"
 	"time"
 
+	"example.com/url-shortener/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -20,8 +23,59 @@ type Url struct {
 	Target  string `bson:"target" json:"target"`
 	Created uint64 `bson:"created" json:"created"`
 	Hits    uint64 `bson:"hits" json:"hits"`
+	// unix time after which the short URL stops resolving, 0 means it never expires
+	ExpiresAt uint64 `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// only used in requests, as a relative alternative to ExpiresAt
+	TTLSeconds uint64 `bson:"-" json:"ttlSeconds,omitempty"`
+	// number of times the short URL can be resolved, 0 means no limit
+	MaxHits uint64 `bson:"maxHits,omitempty" json:"maxHits,omitempty"`
+	// only used in update requests, removes the expiry time or hit limit instead of leaving it unchanged
+	ClearExpiry  bool `bson:"-" json:"clearExpiry,omitempty"`
+	ClearMaxHits bool `bson:"-" json:"clearMaxHits,omitempty"`
+	// password protected short URLs keep a salted hash of the password, which is never returned or cached
+	Protected    bool   `bson:"protected,omitempty" json:"protected,omitempty"`
+	PasswordHash string `bson:"passwordHash,omitempty" json:"-"`
+	// only used in requests, hashed into PasswordHash before storing
+	Password string `bson:"-" json:"password,omitempty"`
+	// ID of the API key, or subject of the token, that created the short URL
+	Owner string `bson:"owner,omitempty" json:"owner,omitempty"`
+	// tenant of the token that created the short URL, empty for API keys
+	Tenant string `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	// free form labels used to filter listings
+	Tags []string `bson:"tags,omitempty" json:"tags,omitempty"`
+	// host name of the target URL, kept up to date by the stores for filtering
+	Domain string `bson:"domain,omitempty" json:"domain,omitempty"`
+	// hits from user agents classified as bots, which are not counted in Hits
+	BotHits uint64 `bson:"botHits,omitempty" json:"botHits,omitempty"`
+	// approximate all-time unique visitors, never stored and only filled in when looking up a single short URL
+	UniqueVisitors uint64 `bson:"-" json:"uniqueVisitors,omitempty"`
 }
 
+/*
+	Checks if the URL has a hit limit that has been reached.
+*/
+func (u Url) IsHitLimitReached() bool {
+	return u.MaxHits != 0 && u.Hits >= u.MaxHits
+}
+
+/*
+	Checks if the URL has an expiry time that has passed.
+*/
+func (u Url) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != 0 && uint64(now.Unix()) >= u.ExpiresAt
+}
+
+var (
+	// returned when no URL exists for the requested short URL slug
+	ErrUrlNotFound = errors.New("short URL not found")
+	// returned when inserting a URL whose short URL slug is already taken
+	ErrDuplicateSlug = errors.New("short URL slug already exists")
+	// returned when the requested short URL exists but has expired
+	ErrUrlExpired = errors.New("short URL has expired")
+	// returned when the requested short URL exists but has been resolved the maximum number of times
+	ErrHitLimitReached = errors.New("short URL has reached its maximum hits")
+)
+
 /*
 	Returns a valid database client for use by other functions.
 */
@@ -58,7 +112,128 @@ func GetDBClient(conn string) *mongo.Client {
 }
 
 /*
-	Inserts a new long URL into the database.
+	Makes sure all indexes required by the application exist on the URL collection.
+	Creating the unique slug index fails if duplicate slugs are already stored, which must be cleaned up by hand.
+*/
+func EnsureIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "slug", Value: 1}},
+			Options: options.Index().SetName("slug_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created", Value: -1}},
+			Options: options.Index().SetName("created"),
+		},
+		{
+			Keys:    bson.D{{Key: "target", Value: 1}},
+			Options: options.Index().SetName("target"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt").SetSparse(true),
+		},
+		// listing indexes, sorted listings continue from the cursor on the sort field and slug
+		{
+			Keys:    bson.D{{Key: "created", Value: 1}, {Key: "slug", Value: 1}},
+			Options: options.Index().SetName("created_slug"),
+		},
+		{
+			Keys:    bson.D{{Key: "hits", Value: 1}, {Key: "slug", Value: 1}},
+			Options: options.Index().SetName("hits_slug"),
+		},
+		{
+			Keys:    bson.D{{Key: "domain", Value: 1}},
+			Options: options.Index().SetName("domain"),
+		},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "tenant", Value: 1}},
+			Options: options.Index().SetName("owner_tenant"),
+		},
+		{
+			Keys:    bson.D{{Key: "tags", Value: 1}},
+			Options: options.Index().SetName("tags"),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Stores the target domain on URLs created before the domain was kept, so they show up in domain filtered listings.
+	Returns the number of updated URLs.
+*/
+func BackfillUrlDomains(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) (int64, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{"domain": bson.M{"$exists": false}}, options.Find().SetProjection(bson.M{"slug": 1, "target": 1}))
+	if err != nil {
+		log.Printf("Error backfilling URL domains (%v)", err)
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	count := int64(0)
+	updates := []mongo.WriteModel{}
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		count += result.ModifiedCount
+		updates = updates[:0]
+		return nil
+	}
+	for cur.Next(ctx) {
+		url := Url{}
+		if err := cur.Decode(&url); err != nil {
+			log.Printf("Error backfilling URL domains (%v)", err)
+			return count, err
+		}
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"slug": url.Slug}).
+			SetUpdate(bson.M{"$set": bson.M{"domain": TargetDomain(url.Target)}}))
+		if len(updates) == 1000 {
+			if err := flush(); err != nil {
+				log.Printf("Error backfilling URL domains (%v)", err)
+				return count, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		log.Printf("Error backfilling URL domains (%v)", err)
+		return count, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Backfilled URL domains in database (count: %v)", count)
+	}
+
+	return count, cur.Err()
+}
+
+/*
+	Inserts a new long URL into the database. Returns ErrDuplicateSlug if the slug is already taken.
 */
 func InsertUrl(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, url Url) error {
 	log.SetOutput(f)
@@ -66,9 +241,13 @@ func InsertUrl(f *os.File, debug bool, db string, dbCollection string, client *m
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	url.Domain = TargetDomain(url.Target)
 	_, err := collection.InsertOne(ctx, url)
-	if err != nil {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateSlug
+	} else if err != nil {
 		log.Printf("Error creating new short URL (slug: %v) (%v)", url.Slug, err)
+		return err
 	}
 
 	if debug {
@@ -79,71 +258,84 @@ func InsertUrl(f *os.File, debug bool, db string, dbCollection string, client *m
 }
 
 /*
-	Looks up the provided short URL slug in the database and returns the target URL.
+	Inserts many new long URLs into the database in one unordered write. Returns one error per URL:
+	nil when inserted, ErrDuplicateSlug when the slug is already taken.
 */
-func GetUrl(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, slug string) (Url, error) {
+func InsertUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, urls []Url) []error {
 	log.SetOutput(f)
-	url := Url{}
+	errs := make([]error, len(urls))
+	if len(urls) == 0 {
+		return errs
+	}
 	collection := client.Database(db).Collection(dbCollection)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	filter := bson.M{"slug": bson.M{"$eq": slug}}
-	opts := options.FindOne().SetSort(bson.M{"created": -1})
-
-	err := collection.FindOne(ctx, filter, opts).Decode(&url)
-	if err != nil && err != mongo.ErrNoDocuments {
-		log.Printf("Error looking up URL (slug: %v) (%v)", slug, err)
+	docs := make([]interface{}, len(urls))
+	for i, url := range urls {
+		url.Domain = TargetDomain(url.Target)
+		docs[i] = url
+	}
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			// the unordered insert reports each failed URL by its position
+			if writeErr.Code == 11000 || writeErr.Code == 11001 || writeErr.Code == 12582 {
+				errs[writeErr.Index] = ErrDuplicateSlug
+			} else {
+				log.Printf("Error creating new short URL (slug: %v) (%v)", urls[writeErr.Index].Slug, writeErr)
+				errs[writeErr.Index] = writeErr
+			}
+		}
+	} else if err != nil {
+		log.Printf("Error creating new short URLs (count: %v) (%v)", len(urls), err)
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
 	}
 
 	if debug {
-		if err == mongo.ErrNoDocuments {
-			log.Printf("[DEBUG] Attempted to get missing URL from database (slug: %v)", slug)
-		} else {
-			log.Printf("[DEBUG] Got URL from database (slug: %v) (target: %v)", url.Slug, url.Target)
-		}
+		log.Printf("[DEBUG] Inserted URLs in database (count: %v)", len(urls))
 	}
 
-	return url, err
+	return errs
 }
 
 /*
-	Returns all URLs stored in the database.
+	Looks up the provided short URL slug in the database and returns the target URL.
 */
-func GetUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) ([]Url, error) {
+func GetUrl(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, slug string) (Url, error) {
 	log.SetOutput(f)
-	urls := []Url{}
+	url := Url{}
 	collection := client.Database(db).Collection(dbCollection)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cur, err := collection.Find(ctx, bson.D{})
-	if err != nil {
-		log.Printf("Error retrieving all URLs (%v)", err)
-		return []Url{}, err
-	}
+	filter := bson.M{"slug": bson.M{"$eq": slug}}
 
-	count := 0
-	for cur.Next(ctx) {
-		var url Url
-		err := cur.Decode(&url)
-		if err != nil {
-			log.Printf("Error retrieving all URLs (%v)", err)
-			return []Url{}, err
-		}
-		urls = append(urls, url)
-		count += 1
+	err := collection.FindOne(ctx, filter).Decode(&url)
+	if err == mongo.ErrNoDocuments {
+		err = ErrUrlNotFound
+	} else if err != nil {
+		log.Printf("Error looking up URL (slug: %v) (%v)", slug, err)
 	}
 
 	if debug {
-		log.Printf("[DEBUG] Got URLs from database (count: %v)", count)
+		if err == ErrUrlNotFound {
+			log.Printf("[DEBUG] Attempted to get missing URL from database (slug: %v)", slug)
+		} else {
+			log.Printf("[DEBUG] Got URL from database (slug: %v) (target: %v)", url.Slug, url.Target)
+		}
 	}
 
-	return urls, err
+	return url, err
 }
 
 /*
 	Looks up the provided short URL slug in the database and updates the target URL.
+	The expiry time, hit limit, password and tags are only changed when provided, and the expiry time and hit limit are removed when cleared.
 */
 func UpdateUrl(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, url Url) error {
 	log.SetOutput(f)
@@ -151,13 +343,42 @@ func UpdateUrl(f *os.File, debug bool, db string, dbCollection string, client *m
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := collection.UpdateOne(
+	set := bson.M{"target": url.Target, "domain": TargetDomain(url.Target)}
+	if url.Tags != nil {
+		set["tags"] = url.Tags
+	}
+	if url.ExpiresAt != 0 {
+		set["expiresAt"] = url.ExpiresAt
+	}
+	if url.MaxHits != 0 {
+		set["maxHits"] = url.MaxHits
+	}
+	if url.PasswordHash != "" {
+		set["protected"] = true
+		set["passwordHash"] = url.PasswordHash
+	}
+	update := bson.M{"$set": set}
+	unset := bson.M{}
+	if url.ClearExpiry {
+		unset["expiresAt"] = ""
+	}
+	if url.ClearMaxHits {
+		unset["maxHits"] = ""
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	result, err := collection.UpdateOne(
 		ctx,
 		bson.M{"slug": url.Slug},
-		bson.M{"$set": bson.M{"target": url.Target}},
+		update,
 	)
 	if err != nil {
 		log.Printf("Error updating target URL (slug: %v) (%v)", url.Slug, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUrlNotFound
 	}
 
 	if debug {
@@ -176,12 +397,16 @@ func DeleteUrl(f *os.File, debug bool, db string, dbCollection string, client *m
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := collection.DeleteOne(
+	result, err := collection.DeleteOne(
 		ctx,
 		bson.M{"slug": slug},
 	)
 	if err != nil {
 		log.Printf("Error deleting URL (slug: %v) (%v)", slug, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUrlNotFound
 	}
 
 	if debug {
@@ -191,8 +416,53 @@ func DeleteUrl(f *os.File, debug bool, db string, dbCollection string, client *m
 	return err
 }
 
+/*
+	Deletes every URL that expired at or before the provided unix time. Returns the deleted URLs.
+	The expired URLs are read before they are deleted, so only URLs that were read are deleted and returned.
+*/
+func DeleteExpiredUrls(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, now uint64) ([]Url, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"expiresAt": bson.M{"$gt": 0, "$lte": now}}
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("Error deleting expired URLs (%v)", err)
+		return nil, err
+	}
+	expired := []Url{}
+	if err := cur.All(ctx, &expired); err != nil {
+		log.Printf("Error deleting expired URLs (%v)", err)
+		return nil, err
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+
+	slugs := make([]string, len(expired))
+	for i, url := range expired {
+		slugs[i] = url.Slug
+	}
+	filter["slug"] = bson.M{"$in": slugs}
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Printf("Error deleting expired URLs (%v)", err)
+		return nil, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted expired URLs from database (count: %v)", result.DeletedCount)
+	}
+
+	return expired, nil
+}
+
 /*
 	Updates the hit count for the given short URL slug.
+	The hit limit is checked in the same atomic update, so concurrent requests can never go over it.
+	Returns ErrHitLimitReached if the short URL has already been resolved the maximum number of times.
 */
 func UpdateUrlHits(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, slug string) error {
 	log.SetOutput(f)
@@ -200,13 +470,35 @@ func UpdateUrlHits(f *os.File, debug bool, db string, dbCollection string, clien
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := collection.UpdateOne(
+	// only match URLs without a hit limit, or with hits left
+	filter := bson.M{
+		"slug": slug,
+		"$or": bson.A{
+			bson.M{"maxHits": bson.M{"$exists": false}},
+			bson.M{"maxHits": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$hits", "$maxHits"}}},
+		},
+	}
+	result, err := collection.UpdateOne(
 		ctx,
-		bson.M{"slug": slug},
+		filter,
 		bson.M{"$inc": bson.M{"hits": 1}},
 	)
 	if err != nil {
 		log.Printf("Error updating URL hits (slug: %v) (%v)", slug, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// nothing matched, so either the URL is missing or it has no hits left
+		count, err := collection.CountDocuments(ctx, bson.M{"slug": slug})
+		if err != nil {
+			log.Printf("Error updating URL hits (slug: %v) (%v)", slug, err)
+			return err
+		}
+		if count == 0 {
+			return ErrUrlNotFound
+		}
+		return ErrHitLimitReached
 	}
 
 	if debug {
@@ -215,3 +507,147 @@ func UpdateUrlHits(f *os.File, debug bool, db string, dbCollection string, clien
 
 	return err
 }
+
+/*
+	Hits of a short URL to be added at once, split into hits from people and from bots.
+*/
+type HitCounts struct {
+	Hits    uint64
+	BotHits uint64
+}
+
+/*
+	Adds batched hit counts to short URLs in one unordered bulk write, keyed by slug.
+	Hit limits are not checked, so this is only used for short URLs without one, or for bot hits. Slugs of deleted short URLs are skipped.
+*/
+func IncrementUrlHits(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, hits map[string]HitCounts) error {
+	log.SetOutput(f)
+	if len(hits) == 0 {
+		return nil
+	}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	writes := make([]mongo.WriteModel, 0, len(hits))
+	for slug, counts := range hits {
+		inc := bson.M{}
+		if counts.Hits > 0 {
+			inc["hits"] = counts.Hits
+		}
+		if counts.BotHits > 0 {
+			inc["botHits"] = counts.BotHits
+		}
+		if len(inc) == 0 {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"slug": slug}).
+			SetUpdate(bson.M{"$inc": inc}))
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		log.Printf("Error incrementing URL hits (count: %v) (%v)", len(hits), err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Incremented URL hits in database (count: %v)", result.ModifiedCount)
+	}
+
+	return nil
+}
+
+/*
+	Reserves counter ranges from a shared document in the database, so multiple server instances never hand out the same slug.
+*/
+type MongoRangeAllocator struct {
+	Client     *mongo.Client
+	DB         string
+	Collection string
+	Name       string
+	Start      uint64
+	BlockSize  uint64
+}
+
+/*
+	Atomically reserves the next block of counter values using findAndModify on the counter document.
+*/
+func (a *MongoRangeAllocator) NextRange(f *os.File, debug bool) (uint64, uint64, error) {
+	log.SetOutput(f)
+	// the counter document stores the next value as an int64
+	if a.Start > math.MaxInt64 || a.BlockSize == 0 || a.BlockSize > math.MaxInt64 {
+		return 0, 0, util.ErrCounterOverflow
+	}
+	collection := a.Client.Database(a.DB).Collection(a.Collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// make sure the counter document exists, starting at the configured value
+	// another instance may have created it first, so duplicate key errors are expected
+	_, err := collection.InsertOne(ctx, bson.M{"_id": a.Name, "next": int64(a.Start)})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Error creating counter document (name: %v) (%v)", a.Name, err)
+		return 0, 0, err
+	}
+
+	counter := struct {
+		Next int64 `bson:"next"`
+	}{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	// only take a range if the counter document cannot overflow past it
+	err = collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": a.Name, "next": bson.M{"$lte": math.MaxInt64 - int64(a.BlockSize)}},
+		bson.M{"$inc": bson.M{"next": int64(a.BlockSize)}},
+		opts,
+	).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		err = util.ErrCounterOverflow
+	}
+	if err != nil {
+		log.Printf("Error reserving counter range (name: %v) (%v)", a.Name, err)
+		return 0, 0, err
+	}
+
+	next := uint64(counter.Next)
+	if debug {
+		log.Printf("[DEBUG] Reserved counter range from database (%v through %v)", next, next+a.BlockSize-1)
+	}
+
+	return next, next + a.BlockSize - 1, nil
+}
+
+/*
+	Moves the counter document past the provided value, unless it already is.
+*/
+func (a *MongoRangeAllocator) ReserveThrough(f *os.File, debug bool, value uint64) error {
+	log.SetOutput(f)
+	if value >= math.MaxInt64 || a.Start > math.MaxInt64 {
+		return util.ErrCounterOverflow
+	}
+	collection := a.Client.Database(a.DB).Collection(a.Collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, bson.M{"_id": a.Name, "next": int64(a.Start)})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Error creating counter document (name: %v) (%v)", a.Name, err)
+		return err
+	}
+	// $max only ever moves the counter forward, so concurrent reservations cannot undo each other
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": a.Name}, bson.M{"$max": bson.M{"next": int64(value + 1)}})
+	if err != nil {
+		log.Printf("Error reserving counter values (name: %v) (%v)", a.Name, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Reserved counter values in database (through %v)", value)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"example.com/url-shortener/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // some global variables to avoid duplication
@@ -25,6 +26,28 @@ func TestGetDBClient(t *testing.T) {
 	t.Logf("PASSED creating database connection. Expected: success, got: success")
 }
 
+func TestEnsureIndexes(t *testing.T) {
+	testLog := "/tmp/TestEnsureIndexes.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	err = EnsureIndexes(f, verbose, c.DBDatabase, c.DBCollection, dbClient)
+	if err != nil {
+		t.Errorf("FAILED ensuring indexes. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED ensuring indexes. Expected: nil error, got: %v", err)
+	}
+}
+
 func TestInsertUrl(t *testing.T) {
 	testLog := "/tmp/TestInsertUrl.log"
 	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -46,6 +69,41 @@ func TestInsertUrl(t *testing.T) {
 	} else {
 		t.Logf("PASSED inserting URL. Expected: nil error, got: %v", err)
 	}
+
+	// the unique slug index should reject a second URL with the same slug
+	err = InsertUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, url)
+	if err != ErrDuplicateSlug {
+		t.Errorf("FAILED inserting duplicate URL. Expected: %v, got: %v", ErrDuplicateSlug, err)
+	} else {
+		t.Logf("PASSED inserting duplicate URL. Expected: %v, got: %v", ErrDuplicateSlug, err)
+	}
+}
+
+func TestInsertUrls(t *testing.T) {
+	testLog := "/tmp/TestInsertUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	// TEST1234 is inserted by TestInsertUrl
+	errs := InsertUrls(f, verbose, c.DBDatabase, c.DBCollection, dbClient, []Url{
+		{Slug: "BATCH1", Target: "https://www.google.com"},
+		{Slug: "TEST1234", Target: "https://www.google.com"},
+	})
+	if len(errs) != 2 || errs[0] != nil || errs[1] != ErrDuplicateSlug {
+		t.Errorf("FAILED inserting URLs. Expected: [nil %v], got: %v", ErrDuplicateSlug, errs)
+	} else {
+		t.Logf("PASSED inserting URLs. Expected: [nil %v], got: %v", ErrDuplicateSlug, errs)
+	}
+	DeleteUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, "BATCH1")
 }
 
 func TestGetUrl(t *testing.T) {
@@ -70,8 +128,8 @@ func TestGetUrl(t *testing.T) {
 	}
 }
 
-func TestGetUrls(t *testing.T) {
-	testLog := "/tmp/TestGetUrls.log"
+func TestListUrls(t *testing.T) {
+	testLog := "/tmp/TestListUrls.log"
 	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
@@ -84,11 +142,17 @@ func TestGetUrls(t *testing.T) {
 			panic(err)
 		}
 	}()
-	_, err = GetUrls(f, verbose, c.DBDatabase, c.DBCollection, dbClient)
-	if err != nil {
-		t.Errorf("FAILED getting all URLs. Expected: nil error, got: %v", err)
+	page, err := ListUrls(f, verbose, c.DBDatabase, c.DBCollection, dbClient, ListOptions{Limit: 1})
+	if err != nil || len(page.Urls) > 1 {
+		t.Errorf("FAILED listing URLs. Expected: at most 1, got: %v (%v)", len(page.Urls), err)
 	} else {
-		t.Logf("PASSED getting all URLs. Expected: nil error, got: %v", err)
+		t.Logf("PASSED listing URLs. Expected: at most 1, got: %v", len(page.Urls))
+	}
+	if page.NextCursor != "" {
+		_, err = ListUrls(f, verbose, c.DBDatabase, c.DBCollection, dbClient, ListOptions{Limit: 1, Cursor: page.NextCursor})
+		if err != nil {
+			t.Errorf("FAILED listing next page of URLs. Expected: nil error, got: %v", err)
+		}
 	}
 }
 
@@ -107,12 +171,19 @@ func TestUpdateUrl(t *testing.T) {
 		}
 	}()
 	url := Url{Slug: "TEST1234", Target: "https://www.reddit.com"}
-	err = InsertUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, url)
+	err = UpdateUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, url)
 	if err != nil {
 		t.Errorf("FAILED updating URL. Expected: nil error, got: %v", err)
 	} else {
 		t.Logf("PASSED updating URL. Expected: nil error, got: %v", err)
 	}
+
+	// a cleared expiry is removed from the record
+	UpdateUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, Url{Slug: "TEST1234", Target: "https://www.reddit.com", ExpiresAt: 4102444800})
+	UpdateUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, Url{Slug: "TEST1234", Target: "https://www.reddit.com", ClearExpiry: true})
+	if url, err := GetUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, "TEST1234"); err != nil || url.ExpiresAt != 0 {
+		t.Errorf("FAILED clearing URL expiry. Expected: 0, got: %v (%v)", url.ExpiresAt, err)
+	}
 }
 
 func TestUpdateUrlHits(t *testing.T) {
@@ -137,6 +208,51 @@ func TestUpdateUrlHits(t *testing.T) {
 	}
 }
 
+func TestMongoStoreIncrementUrlHits(t *testing.T) {
+	testLog := "/tmp/TestMongoStoreIncrementUrlHits.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	store := &MongoStore{F: f, Debug: verbose, DB: c.DBDatabase, Collection: c.DBCollection, Client: dbClient}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	testIncrementUrlHits(t, store)
+}
+
+func TestDeleteExpiredUrls(t *testing.T) {
+	testLog := "/tmp/TestDeleteExpiredUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	url := Url{Slug: "TEST5678", Target: "https://www.google.com", ExpiresAt: 100}
+	err = InsertUrl(f, verbose, c.DBDatabase, c.DBCollection, dbClient, url)
+	if err != nil {
+		t.Fatalf("FAILED inserting expiring URL. Expected: nil error, got: %v", err)
+	}
+	expired, err := DeleteExpiredUrls(f, verbose, c.DBDatabase, c.DBCollection, dbClient, 200)
+	if err != nil || len(expired) < 1 {
+		t.Errorf("FAILED deleting expired URLs. Expected: at least 1, got: %v (%v)", len(expired), err)
+	} else {
+		t.Logf("PASSED deleting expired URLs. Expected: at least 1, got: %v", len(expired))
+	}
+}
+
 func TestDeleteUrl(t *testing.T) {
 	testLog := "/tmp/TestDeleteUrl.log"
 	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -158,3 +274,193 @@ func TestDeleteUrl(t *testing.T) {
 		t.Logf("PASSED deleting URL. Expected: nil error, got: %v", err)
 	}
 }
+
+func TestMongoRangeAllocator(t *testing.T) {
+	testLog := "/tmp/TestMongoRangeAllocator.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	a := MongoRangeAllocator{Client: dbClient, DB: c.DBDatabase, Collection: c.DBCountersCollection, Name: "TestMongoRangeAllocator", Start: 100, BlockSize: 50}
+	counter, _, err := a.NextRange(f, verbose)
+	if err != nil {
+		t.Fatalf("FAILED reserving counter range. Expected: nil error, got: %v", err)
+	}
+	nextCounter, _, err := a.NextRange(f, verbose)
+	if err != nil || nextCounter != counter+50 {
+		t.Errorf("FAILED reserving next counter range. Expected: %v, got: %v (%v)", counter+50, nextCounter, err)
+	} else {
+		t.Logf("PASSED reserving next counter range. Expected: %v, got: %v", counter+50, nextCounter)
+	}
+
+	// reserved values are skipped by the next range
+	if err := a.ReserveThrough(f, verbose, nextCounter+500); err != nil {
+		t.Errorf("FAILED reserving counter values. Expected: nil error, got: %v", err)
+	}
+	reservedCounter, _, err := a.NextRange(f, verbose)
+	if err != nil || reservedCounter != nextCounter+501 {
+		t.Errorf("FAILED reserving counter range after reserved values. Expected: %v, got: %v (%v)", nextCounter+501, reservedCounter, err)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	testLog := "/tmp/TestApiKeys.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	key := ApiKey{ID: "TESTKEY", Name: "test", Hash: "TESTHASH"}
+	err = InsertApiKey(f, verbose, c.DBDatabase, c.DBKeysCollection, dbClient, key)
+	if err != nil {
+		t.Errorf("FAILED inserting API key. Expected: nil error, got: %v", err)
+	}
+	key, err = GetApiKeyByHash(f, verbose, c.DBDatabase, c.DBKeysCollection, dbClient, "TESTHASH")
+	if err != nil || key.ID != "TESTKEY" {
+		t.Errorf("FAILED getting API key. Expected: TESTKEY, got: %v (%v)", key.ID, err)
+	} else {
+		t.Logf("PASSED getting API key. Expected: TESTKEY, got: %v", key.ID)
+	}
+	err = UpdateApiKeyRole(f, verbose, c.DBDatabase, c.DBKeysCollection, dbClient, "TESTKEY", "readonly")
+	if err != nil {
+		t.Errorf("FAILED updating API key role. Expected: nil error, got: %v", err)
+	}
+	key, err = GetApiKeyByHash(f, verbose, c.DBDatabase, c.DBKeysCollection, dbClient, "TESTHASH")
+	if err != nil || key.Role != "readonly" {
+		t.Errorf("FAILED getting updated API key role. Expected: readonly, got: %v (%v)", key.Role, err)
+	} else {
+		t.Logf("PASSED getting updated API key role. Expected: readonly, got: %v", key.Role)
+	}
+	err = DeleteApiKey(f, verbose, c.DBDatabase, c.DBKeysCollection, dbClient, "TESTKEY")
+	if err != nil {
+		t.Errorf("FAILED deleting API key. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED deleting API key. Expected: nil error, got: %v", err)
+	}
+}
+
+func TestMongoStoreBulkUrls(t *testing.T) {
+	testLog := "/tmp/TestMongoStoreBulkUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	store := &MongoStore{F: f, Debug: verbose, DB: c.DBDatabase, Collection: c.DBCollection, Client: dbClient}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	testBulkUrls(t, store)
+}
+
+func TestMongoStoreExportUrls(t *testing.T) {
+	testLog := "/tmp/TestMongoStoreExportUrls.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	store := &MongoStore{F: f, Debug: verbose, DB: c.DBDatabase, Collection: c.DBCollection, Client: dbClient}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	testExportUrls(t, store)
+}
+
+func TestInsertClickEvents(t *testing.T) {
+	testLog := "/tmp/TestInsertClickEvents.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	// close the database connection before exit
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			panic(err)
+		}
+	}()
+	if err := EnsureEventIndexes(f, verbose, c.DBDatabase, c.DBEventsCollection, dbClient); err != nil {
+		t.Fatalf("FAILED creating event indexes. Expected: nil error, got: %v", err)
+	}
+	if err := InsertClickEvents(f, verbose, c.DBDatabase, c.DBEventsCollection, dbClient, testClickEvents); err != nil {
+		t.Errorf("FAILED inserting click events. Expected: nil error, got: %v", err)
+	} else {
+		t.Logf("PASSED inserting click events. Expected: nil error, got: %v", err)
+	}
+	dbClient.Database(c.DBDatabase).Collection(c.DBEventsCollection).DeleteMany(context.TODO(), bson.M{"slug": "EVENTS1"})
+}
+
+func TestMongoStoreClickRollups(t *testing.T) {
+	testLog := "/tmp/TestMongoStoreClickRollups.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	store := &MongoStore{F: f, Debug: verbose, DB: c.DBDatabase, EventsCollection: c.DBEventsCollection, RollupsCollection: c.DBRollupsCollection, Client: dbClient}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	if err := EnsureRollupIndexes(f, verbose, c.DBDatabase, c.DBRollupsCollection, dbClient); err != nil {
+		t.Fatalf("FAILED creating rollup indexes. Expected: nil error, got: %v", err)
+	}
+	testClickRollups(t, store, false)
+	for _, collection := range []string{c.DBEventsCollection, c.DBRollupsCollection} {
+		dbClient.Database(c.DBDatabase).Collection(collection).DeleteMany(context.TODO(), bson.M{"slug": bson.M{"$in": []string{"STATS1", "STATS2"}}})
+	}
+}
+
+func TestMongoStoreWebhooks(t *testing.T) {
+	testLog := "/tmp/TestMongoStoreWebhooks.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	dbClient := GetDBClient(c.DBConnString)
+	store := &MongoStore{F: f, Debug: verbose, DB: c.DBDatabase, WebhooksCollection: c.DBWebhooksCollection, DeliveriesCollection: c.DBDeliveriesCollection, Client: dbClient}
+	// close the database connection before exit
+	defer func() {
+		if err := store.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	if err := EnsureWebhookIndexes(f, verbose, c.DBDatabase, c.DBWebhooksCollection, dbClient); err != nil {
+		t.Fatalf("FAILED creating webhook indexes. Expected: nil error, got: %v", err)
+	}
+	if err := EnsureDeliveryIndexes(f, verbose, c.DBDatabase, c.DBDeliveriesCollection, dbClient); err != nil {
+		t.Fatalf("FAILED creating delivery indexes. Expected: nil error, got: %v", err)
+	}
+	dbClient.Database(c.DBDatabase).Collection(c.DBDeliveriesCollection).DeleteMany(context.TODO(), bson.M{"webhookId": bson.M{"$in": []string{"HOOK1", "HOOK2"}}})
+	testWebhooks(t, store)
+	dbClient.Database(c.DBDatabase).Collection(c.DBDeliveriesCollection).DeleteMany(context.TODO(), bson.M{"webhookId": bson.M{"$in": []string{"HOOK1", "HOOK2"}}})
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	Called for every scanned click event. Returning an error stops the scan.
+*/
+type ClickEventFunc func(event ClickEvent) error
+
+/*
+	A value seen in click events, i.e. a referrer or browser, and how often it was seen.
+	Stored as a list rather than a map, as values like referrer hosts are not safe to use as document keys.
+*/
+type NamedCount struct {
+	Name  string `bson:"name" json:"name"`
+	Count uint64 `bson:"count" json:"count"`
+}
+
+/*
+	Summarizes the click events of one short URL in one hour, so statistics can be queried without scanning raw events.
+	Hour is the unix time the hour starts at. Visitors holds hashed visitor IDs, used to count unique clicks across hours.
+	Clicks includes the BotClicks, which are only broken down by bot, so the other values only describe clicks by people.
+	Rollups are recomputed from the raw events, so saving one replaces the previous rollup of the same short URL and hour.
+*/
+type ClickRollup struct {
+	Slug       string       `bson:"slug" json:"slug"`
+	Hour       uint64       `bson:"hour" json:"hour"`
+	Clicks     uint64       `bson:"clicks" json:"clicks"`
+	Visitors   []string     `bson:"visitors,omitempty" json:"-"`
+	Referrers  []NamedCount `bson:"referrers,omitempty" json:"referrers,omitempty"`
+	UserAgents []NamedCount `bson:"userAgents,omitempty" json:"userAgents,omitempty"`
+	Browsers   []NamedCount `bson:"browsers,omitempty" json:"browsers,omitempty"`
+	OS         []NamedCount `bson:"os,omitempty" json:"os,omitempty"`
+	Countries  []NamedCount `bson:"countries,omitempty" json:"countries,omitempty"`
+	BotClicks  uint64       `bson:"botClicks,omitempty" json:"botClicks,omitempty"`
+	Bots       []NamedCount `bson:"bots,omitempty" json:"bots,omitempty"`
+}
+
+/*
+	Makes sure the indexes used to query click rollups by short URL and hour exist on the rollups collection.
+	The unique index keeps a single rollup per short URL and hour.
+*/
+func EnsureRollupIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "slug", Value: 1}, {Key: "hour", Value: 1}},
+			Options: options.Index().SetName("slug_hour").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "hour", Value: -1}},
+			Options: options.Index().SetName("hour"),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Passes every click event recorded at or after from to fn, reading them from a database cursor one batch at a time.
+*/
+func ScanClickEvents(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, from uint64, fn ClickEventFunc) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	findOptions := options.Find().SetBatchSize(1000)
+	cur, err := collection.Find(ctx, bson.M{"timestamp": bson.M{"$gte": from}}, findOptions)
+	if err != nil {
+		log.Printf("Error scanning click events (%v)", err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	count := 0
+	for cur.Next(ctx) {
+		event := ClickEvent{}
+		if err := cur.Decode(&event); err != nil {
+			log.Printf("Error scanning click events (%v)", err)
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("Error scanning click events (%v)", err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Scanned click events in database (from: %v) (count: %v)", from, count)
+	}
+
+	return nil
+}
+
+/*
+	Saves a batch of click rollups, replacing any existing rollup of the same short URL and hour.
+*/
+func SaveClickRollups(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, rollups []ClickRollup) error {
+	log.SetOutput(f)
+	if len(rollups) == 0 {
+		return nil
+	}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	writes := make([]mongo.WriteModel, len(rollups))
+	for i, rollup := range rollups {
+		writes[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"slug": rollup.Slug, "hour": rollup.Hour}).
+			SetReplacement(rollup).
+			SetUpsert(true)
+	}
+	_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		log.Printf("Error saving click rollups (count: %v) (%v)", len(rollups), err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Saved click rollups in database (count: %v)", len(rollups))
+	}
+
+	return nil
+}
+
+/*
+	Returns the click rollups of a short URL for the hours starting in [from, to), ordered by hour.
+*/
+func GetClickRollups(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, slug string, from uint64, to uint64) ([]ClickRollup, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"slug": slug, "hour": bson.M{"$gte": from, "$lt": to}}
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "hour", Value: 1}}))
+	if err != nil {
+		log.Printf("Error getting click rollups (slug: %v) (%v)", slug, err)
+		return nil, err
+	}
+	rollups := []ClickRollup{}
+	if err := cur.All(ctx, &rollups); err != nil {
+		log.Printf("Error getting click rollups (slug: %v) (%v)", slug, err)
+		return nil, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got click rollups from database (slug: %v) (count: %v)", slug, len(rollups))
+	}
+
+	return rollups, nil
+}
+
+/*
+	Returns the hour of the most recent click rollup, or 0 if nothing has been rolled up yet.
+*/
+func LatestClickRollup(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) (uint64, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rollup := ClickRollup{}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "hour", Value: -1}}).SetProjection(bson.M{"hour": 1})
+	err := collection.FindOne(ctx, bson.M{}, findOptions).Decode(&rollup)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	} else if err != nil {
+		log.Printf("Error getting latest click rollup (%v)", err)
+		return 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got latest click rollup from database (hour: %v)", rollup.Hour)
+	}
+
+	return rollup.Hour, nil
+}
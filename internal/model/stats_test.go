@@ -0,0 +1,104 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+const testHour = uint64(3600 * 400000)
+
+/*
+	Inserts click events and rollups and checks scanning events by time, replacing rollups and reading them by short URL and hour
+*/
+func testClickRollups(t *testing.T, store EventStore, exactLatest bool) {
+	events := []ClickEvent{
+		{Slug: "STATS1", Timestamp: testHour + 5},
+		{Slug: "STATS1", Timestamp: testHour + 3600 + 1},
+		{Slug: "STATS2", Timestamp: testHour + 3600 + 2},
+	}
+	if err := store.InsertClickEvents(events); err != nil {
+		t.Fatalf("FAILED inserting click events. Expected: nil error, got: %v", err)
+	}
+	count := 0
+	err := store.ScanClickEvents(testHour+3600, func(event ClickEvent) error {
+		if event.Slug == "STATS1" || event.Slug == "STATS2" {
+			count++
+		}
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Errorf("FAILED scanning click events. Expected: 2, got: %v (%v)", count, err)
+	} else {
+		t.Logf("PASSED scanning click events. Expected: 2, got: %v", count)
+	}
+
+	rollups := []ClickRollup{
+		{Slug: "STATS1", Hour: testHour, Clicks: 1},
+		{Slug: "STATS1", Hour: testHour + 3600, Clicks: 1, Visitors: []string{"a"}, Browsers: []NamedCount{{Name: "Firefox", Count: 1}}},
+		{Slug: "STATS1", Hour: testHour + 7200, Clicks: 2},
+		{Slug: "STATS2", Hour: testHour + 3600, Clicks: 1},
+	}
+	if err := store.SaveClickRollups(rollups); err != nil {
+		t.Fatalf("FAILED saving click rollups. Expected: nil error, got: %v", err)
+	}
+	// saving a rollup again replaces it
+	rollups[1].Clicks = 3
+	if err := store.SaveClickRollups(rollups[1:2]); err != nil {
+		t.Fatalf("FAILED saving click rollups. Expected: nil error, got: %v", err)
+	}
+
+	saved, err := store.GetClickRollups("STATS1", testHour+3600, testHour+7200+1)
+	hours := []string{}
+	for _, rollup := range saved {
+		hours = append(hours, fmt.Sprintf("%v:%v", rollup.Hour-testHour, rollup.Clicks))
+	}
+	if err != nil || fmt.Sprint(hours) != "[3600:3 7200:2]" {
+		t.Errorf("FAILED getting click rollups. Expected: [3600:3 7200:2], got: %v (%v)", hours, err)
+	} else {
+		t.Logf("PASSED getting click rollups. Expected: [3600:3 7200:2], got: %v", hours)
+	}
+	if len(saved) > 0 && (len(saved[0].Visitors) != 1 || len(saved[0].Browsers) != 1 || saved[0].Browsers[0].Name != "Firefox") {
+		t.Errorf("FAILED getting click rollup values. Expected: 1 visitor and Firefox, got: %+v", saved[0])
+	}
+
+	// other stores may hold later rollups, so only stores of the test itself know the exact latest hour
+	latest, err := store.LatestClickRollup()
+	if err != nil || latest < testHour+7200 || (exactLatest && latest != testHour+7200) {
+		t.Errorf("FAILED getting latest click rollup. Expected: %v, got: %v (%v)", testHour+7200, latest, err)
+	}
+}
+
+func TestMemoryStoreClickRollups(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreClickRollups.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	store := NewMemoryStore(f, verbose)
+	if latest, err := store.LatestClickRollup(); err != nil || latest != 0 {
+		t.Errorf("FAILED getting latest click rollup of empty store. Expected: 0, got: %v (%v)", latest, err)
+	}
+	testClickRollups(t, store, true)
+}
+
+func TestBoltStoreClickRollups(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreClickRollups.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreClickRollups.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	if latest, err := store.LatestClickRollup(); err != nil || latest != 0 {
+		t.Errorf("FAILED getting latest click rollup of empty store. Expected: 0, got: %v (%v)", latest, err)
+	}
+	testClickRollups(t, store, true)
+}
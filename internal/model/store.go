@@ -0,0 +1,241 @@
+package model
+
+import (
+	"context"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+	Common set of operations used to persist short URLs.
+	Lookups, updates and deletes of a missing short URL return ErrUrlNotFound.
+	InsertUrls returns one error per URL (nil when inserted), so one bad URL does not fail the others.
+	DeleteUrls and UpdateUrls return the sorted slugs of the affected URLs. With dryRun set they change nothing and return the slugs of the URLs they would change.
+	When they fail part way, the slugs of the URLs that may have changed are returned with the error.
+	They return ErrTooManyUrls without changing anything when more than limit URLs match (0 means no limit).
+*/
+type UrlStore interface {
+	InsertUrl(url Url) error
+	InsertUrls(urls []Url) []error
+	GetUrl(slug string) (Url, error)
+	ListUrls(opts ListOptions) (UrlPage, error)
+	ExportUrls(filter UrlFilter, fn ExportFunc) (int64, error)
+	UpdateUrl(url Url) error
+	DeleteUrl(slug string) error
+	UpdateUrls(filter UrlFilter, update UrlUpdate, limit int, dryRun bool) ([]string, error)
+	DeleteUrls(filter UrlFilter, limit int, dryRun bool) ([]string, error)
+	UpdateUrlHits(slug string) error
+	IncrementUrlHits(hits map[string]HitCounts) error
+	DeleteExpiredUrls(now uint64) ([]Url, error)
+}
+
+/*
+	Common set of operations used to persist API keys.
+	Lookups and deletes of a missing API key return ErrApiKeyNotFound.
+*/
+type ApiKeyStore interface {
+	InsertApiKey(key ApiKey) error
+	GetApiKeyByHash(hash string) (ApiKey, error)
+	GetApiKeys() ([]ApiKey, error)
+	UpdateApiKeyRole(id string, role string) error
+	DeleteApiKey(id string) error
+}
+
+/*
+	Common set of operations used to persist click events and the hourly rollups computed from them.
+	ScanClickEvents passes events in no particular order. DeleteClickEvents removes events recorded before the provided time, returning how many were deleted.
+	LatestClickRollup returns 0 if nothing has been rolled up yet.
+*/
+type EventStore interface {
+	InsertClickEvents(events []ClickEvent) error
+	ScanClickEvents(from uint64, fn ClickEventFunc) error
+	DeleteClickEvents(before uint64) (int64, error)
+	SaveClickRollups(rollups []ClickRollup) error
+	GetClickRollups(slug string, from uint64, to uint64) ([]ClickRollup, error)
+	LatestClickRollup() (uint64, error)
+}
+
+/*
+	Common set of operations used to persist webhooks and the queue of their deliveries.
+	Lookups and deletes of a missing webhook return ErrWebhookNotFound.
+	ClaimWebhookDeliveries hands out due pending deliveries for lease seconds, so a delivery is attempted by one server at a time.
+*/
+type WebhookStore interface {
+	InsertWebhook(hook Webhook) error
+	GetWebhook(id string) (Webhook, error)
+	GetWebhooks() ([]Webhook, error)
+	DeleteWebhook(id string) error
+	InsertWebhookDeliveries(deliveries []WebhookDelivery) error
+	ClaimWebhookDeliveries(now uint64, lease uint64, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery WebhookDelivery) error
+	GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+	DeleteWebhookDeliveries(before uint64) (int64, error)
+}
+
+/*
+	Everything the application persists. Each supported database driver provides an implementation.
+*/
+type Store interface {
+	UrlStore
+	ApiKeyStore
+	EventStore
+	WebhookStore
+	Close() error
+}
+
+/*
+	Stores short URLs in a MongoDB collection using the package level database functions.
+*/
+type MongoStore struct {
+	F          *os.File
+	Debug      bool
+	DB         string
+	Collection string
+	// collection holding API keys
+	KeysCollection string
+	// collection holding click events
+	EventsCollection string
+	// collection holding hourly click rollups
+	RollupsCollection string
+	// collection holding webhooks
+	WebhooksCollection string
+	// collection holding webhook deliveries
+	DeliveriesCollection string
+	Client               *mongo.Client
+}
+
+func (s *MongoStore) InsertUrl(url Url) error {
+	return InsertUrl(s.F, s.Debug, s.DB, s.Collection, s.Client, url)
+}
+
+func (s *MongoStore) InsertUrls(urls []Url) []error {
+	return InsertUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, urls)
+}
+
+func (s *MongoStore) GetUrl(slug string) (Url, error) {
+	return GetUrl(s.F, s.Debug, s.DB, s.Collection, s.Client, slug)
+}
+
+func (s *MongoStore) ListUrls(opts ListOptions) (UrlPage, error) {
+	return ListUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, opts)
+}
+
+func (s *MongoStore) ExportUrls(filter UrlFilter, fn ExportFunc) (int64, error) {
+	return ExportUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, filter, fn)
+}
+
+func (s *MongoStore) UpdateUrl(url Url) error {
+	return UpdateUrl(s.F, s.Debug, s.DB, s.Collection, s.Client, url)
+}
+
+func (s *MongoStore) DeleteUrl(slug string) error {
+	return DeleteUrl(s.F, s.Debug, s.DB, s.Collection, s.Client, slug)
+}
+
+func (s *MongoStore) UpdateUrls(filter UrlFilter, update UrlUpdate, limit int, dryRun bool) ([]string, error) {
+	return UpdateUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, filter, update, limit, dryRun)
+}
+
+func (s *MongoStore) DeleteUrls(filter UrlFilter, limit int, dryRun bool) ([]string, error) {
+	return DeleteUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, filter, limit, dryRun)
+}
+
+func (s *MongoStore) UpdateUrlHits(slug string) error {
+	return UpdateUrlHits(s.F, s.Debug, s.DB, s.Collection, s.Client, slug)
+}
+
+func (s *MongoStore) IncrementUrlHits(hits map[string]HitCounts) error {
+	return IncrementUrlHits(s.F, s.Debug, s.DB, s.Collection, s.Client, hits)
+}
+
+func (s *MongoStore) DeleteExpiredUrls(now uint64) ([]Url, error) {
+	return DeleteExpiredUrls(s.F, s.Debug, s.DB, s.Collection, s.Client, now)
+}
+
+func (s *MongoStore) InsertApiKey(key ApiKey) error {
+	return InsertApiKey(s.F, s.Debug, s.DB, s.KeysCollection, s.Client, key)
+}
+
+func (s *MongoStore) GetApiKeyByHash(hash string) (ApiKey, error) {
+	return GetApiKeyByHash(s.F, s.Debug, s.DB, s.KeysCollection, s.Client, hash)
+}
+
+func (s *MongoStore) GetApiKeys() ([]ApiKey, error) {
+	return GetApiKeys(s.F, s.Debug, s.DB, s.KeysCollection, s.Client)
+}
+
+func (s *MongoStore) UpdateApiKeyRole(id string, role string) error {
+	return UpdateApiKeyRole(s.F, s.Debug, s.DB, s.KeysCollection, s.Client, id, role)
+}
+
+func (s *MongoStore) DeleteApiKey(id string) error {
+	return DeleteApiKey(s.F, s.Debug, s.DB, s.KeysCollection, s.Client, id)
+}
+
+func (s *MongoStore) InsertClickEvents(events []ClickEvent) error {
+	return InsertClickEvents(s.F, s.Debug, s.DB, s.EventsCollection, s.Client, events)
+}
+
+func (s *MongoStore) ScanClickEvents(from uint64, fn ClickEventFunc) error {
+	return ScanClickEvents(s.F, s.Debug, s.DB, s.EventsCollection, s.Client, from, fn)
+}
+
+func (s *MongoStore) DeleteClickEvents(before uint64) (int64, error) {
+	return DeleteClickEvents(s.F, s.Debug, s.DB, s.EventsCollection, s.Client, before)
+}
+
+func (s *MongoStore) SaveClickRollups(rollups []ClickRollup) error {
+	return SaveClickRollups(s.F, s.Debug, s.DB, s.RollupsCollection, s.Client, rollups)
+}
+
+func (s *MongoStore) GetClickRollups(slug string, from uint64, to uint64) ([]ClickRollup, error) {
+	return GetClickRollups(s.F, s.Debug, s.DB, s.RollupsCollection, s.Client, slug, from, to)
+}
+
+func (s *MongoStore) LatestClickRollup() (uint64, error) {
+	return LatestClickRollup(s.F, s.Debug, s.DB, s.RollupsCollection, s.Client)
+}
+
+func (s *MongoStore) InsertWebhook(hook Webhook) error {
+	return InsertWebhook(s.F, s.Debug, s.DB, s.WebhooksCollection, s.Client, hook)
+}
+
+func (s *MongoStore) GetWebhook(id string) (Webhook, error) {
+	return GetWebhook(s.F, s.Debug, s.DB, s.WebhooksCollection, s.Client, id)
+}
+
+func (s *MongoStore) GetWebhooks() ([]Webhook, error) {
+	return GetWebhooks(s.F, s.Debug, s.DB, s.WebhooksCollection, s.Client)
+}
+
+func (s *MongoStore) DeleteWebhook(id string) error {
+	return DeleteWebhook(s.F, s.Debug, s.DB, s.WebhooksCollection, s.Client, id)
+}
+
+func (s *MongoStore) InsertWebhookDeliveries(deliveries []WebhookDelivery) error {
+	return InsertWebhookDeliveries(s.F, s.Debug, s.DB, s.DeliveriesCollection, s.Client, deliveries)
+}
+
+func (s *MongoStore) ClaimWebhookDeliveries(now uint64, lease uint64, limit int) ([]WebhookDelivery, error) {
+	return ClaimWebhookDeliveries(s.F, s.Debug, s.DB, s.DeliveriesCollection, s.Client, now, lease, limit)
+}
+
+func (s *MongoStore) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	return UpdateWebhookDelivery(s.F, s.Debug, s.DB, s.DeliveriesCollection, s.Client, delivery)
+}
+
+func (s *MongoStore) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return GetWebhookDeliveries(s.F, s.Debug, s.DB, s.DeliveriesCollection, s.Client, webhookID, limit)
+}
+
+func (s *MongoStore) DeleteWebhookDeliveries(before uint64) (int64, error) {
+	return DeleteWebhookDeliveries(s.F, s.Debug, s.DB, s.DeliveriesCollection, s.Client, before)
+}
+
+/*
+	Closes the database connection.
+*/
+func (s *MongoStore) Close() error {
+	return s.Client.Disconnect(context.TODO())
+}
@@ -0,0 +1,363 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// returned when no webhook exists for the requested ID
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// states of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// gave up after the last attempt failed, kept in the delivery log as a dead letter
+	DeliveryFailed = "failed"
+)
+
+/*
+	A subscription of an integration to short URL events. Matching events are posted to Url as JSON, signed with Secret.
+	Events lists the event types to deliver (see the stream package). Scope limits deliveries to the short URLs of one owner
+	(owner, or tenant/owner), and is empty for webhooks receiving events of every short URL.
+*/
+type Webhook struct {
+	ID      string   `bson:"id" json:"id"`
+	Url     string   `bson:"url" json:"url"`
+	Secret  string   `bson:"secret" json:"-"`
+	Events  []string `bson:"events" json:"events"`
+	Owner   string   `bson:"owner,omitempty" json:"owner,omitempty"`
+	Tenant  string   `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Scope   string   `bson:"scope,omitempty" json:"-"`
+	Created uint64   `bson:"created" json:"created"`
+}
+
+/*
+	One event to be posted to a webhook, along with the outcome of the attempts so far.
+	Pending deliveries are attempted once NextAttempt has passed. LastStatus is the HTTP status of the last response, 0 if there was none.
+*/
+type WebhookDelivery struct {
+	ID          string `bson:"id" json:"id"`
+	WebhookID   string `bson:"webhookId" json:"webhookId"`
+	Event       string `bson:"event" json:"event"`
+	Slug        string `bson:"slug" json:"slug"`
+	Payload     string `bson:"payload" json:"payload"`
+	Status      string `bson:"status" json:"status"`
+	Attempts    int    `bson:"attempts" json:"attempts"`
+	NextAttempt uint64 `bson:"nextAttempt" json:"nextAttempt,omitempty"`
+	LastStatus  int    `bson:"lastStatus,omitempty" json:"lastStatus,omitempty"`
+	LastError   string `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	Created     uint64 `bson:"created" json:"created"`
+	Updated     uint64 `bson:"updated" json:"updated"`
+}
+
+/*
+	Makes sure the indexes used to look up webhooks exist on the webhook collection.
+*/
+func EnsureWebhookIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Makes sure the indexes used to claim due deliveries, list the deliveries of a webhook and prune old deliveries exist on the delivery collection.
+*/
+func EnsureDeliveryIndexes(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "nextAttempt", Value: 1}},
+			Options: options.Index().SetName("status_next_attempt"),
+		},
+		{
+			Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "created", Value: -1}},
+			Options: options.Index().SetName("webhook_created"),
+		},
+		{
+			Keys:    bson.D{{Key: "updated", Value: 1}},
+			Options: options.Index().SetName("updated"),
+		},
+	}
+	names, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Printf("Error creating database indexes (collection: %v) (%v)", dbCollection, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Ensured database indexes (collection: %v) (indexes: %v)", dbCollection, names)
+	}
+
+	return nil
+}
+
+/*
+	Inserts a new webhook into the database.
+*/
+func InsertWebhook(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, hook Webhook) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, hook)
+	if err != nil {
+		log.Printf("Error creating new webhook (id: %v) (%v)", hook.ID, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Inserted webhook in database (id: %v) (url: %v)", hook.ID, hook.Url)
+	}
+
+	return nil
+}
+
+/*
+	Looks up the webhook with the provided ID in the database.
+*/
+func GetWebhook(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, id string) (Webhook, error) {
+	log.SetOutput(f)
+	hook := Webhook{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&hook)
+	if err == mongo.ErrNoDocuments {
+		return hook, ErrWebhookNotFound
+	} else if err != nil {
+		log.Printf("Error looking up webhook (id: %v) (%v)", id, err)
+		return hook, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got webhook from database (id: %v)", id)
+	}
+
+	return hook, nil
+}
+
+/*
+	Returns all webhooks stored in the database, oldest first.
+*/
+func GetWebhooks(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client) ([]Webhook, error) {
+	log.SetOutput(f)
+	hooks := []Webhook{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created", Value: 1}, {Key: "id", Value: 1}}))
+	if err != nil {
+		log.Printf("Error retrieving all webhooks (%v)", err)
+		return []Webhook{}, err
+	}
+	if err := cur.All(ctx, &hooks); err != nil {
+		log.Printf("Error retrieving all webhooks (%v)", err)
+		return []Webhook{}, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got webhooks from database (count: %v)", len(hooks))
+	}
+
+	return hooks, nil
+}
+
+/*
+	Deletes the webhook with the provided ID from the database. Its deliveries are kept until they are pruned.
+*/
+func DeleteWebhook(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, id string) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		log.Printf("Error deleting webhook (id: %v) (%v)", id, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted webhook from database (id: %v)", id)
+	}
+
+	return nil
+}
+
+/*
+	Inserts a batch of webhook deliveries into the database.
+*/
+func InsertWebhookDeliveries(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, deliveries []WebhookDelivery) error {
+	log.SetOutput(f)
+	if len(deliveries) == 0 {
+		return nil
+	}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, len(deliveries))
+	for i, delivery := range deliveries {
+		docs[i] = delivery
+	}
+	_, err := collection.InsertMany(ctx, docs)
+	if err != nil {
+		log.Printf("Error inserting webhook deliveries (count: %v) (%v)", len(deliveries), err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Inserted webhook deliveries in database (count: %v)", len(deliveries))
+	}
+
+	return nil
+}
+
+/*
+	Claims up to limit pending deliveries due at now, oldest due first, by moving their next attempt to the end of the lease.
+	Each delivery is claimed atomically, so concurrent servers never claim the same delivery, and deliveries of a server that stops
+	before updating them are attempted again once the lease has passed.
+*/
+func ClaimWebhookDeliveries(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, now uint64, lease uint64, limit int) ([]WebhookDelivery, error) {
+	log.SetOutput(f)
+	deliveries := []WebhookDelivery{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttempt", Value: 1}, {Key: "id", Value: 1}}).SetReturnDocument(options.After)
+	for len(deliveries) < limit {
+		delivery := WebhookDelivery{}
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"status": DeliveryPending, "nextAttempt": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"nextAttempt": now + lease}},
+			opts,
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			log.Printf("Error claiming webhook deliveries (%v)", err)
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if debug && len(deliveries) > 0 {
+		log.Printf("[DEBUG] Claimed webhook deliveries from database (count: %v)", len(deliveries))
+	}
+
+	return deliveries, nil
+}
+
+/*
+	Replaces the stored delivery with the same ID, recording the outcome of an attempt.
+*/
+func UpdateWebhookDelivery(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, delivery WebhookDelivery) error {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"id": delivery.ID}, delivery)
+	if err != nil {
+		log.Printf("Error updating webhook delivery (id: %v) (%v)", delivery.ID, err)
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Updated webhook delivery in database (id: %v) (status: %v)", delivery.ID, delivery.Status)
+	}
+
+	return nil
+}
+
+/*
+	Returns up to limit deliveries of a webhook, newest first.
+*/
+func GetWebhookDeliveries(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, webhookID string, limit int) ([]WebhookDelivery, error) {
+	log.SetOutput(f)
+	deliveries := []WebhookDelivery{}
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "id", Value: 1}}).SetLimit(int64(limit))
+	cur, err := collection.Find(ctx, bson.M{"webhookId": webhookID}, opts)
+	if err != nil {
+		log.Printf("Error retrieving webhook deliveries (webhook: %v) (%v)", webhookID, err)
+		return []WebhookDelivery{}, err
+	}
+	if err := cur.All(ctx, &deliveries); err != nil {
+		log.Printf("Error retrieving webhook deliveries (webhook: %v) (%v)", webhookID, err)
+		return []WebhookDelivery{}, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Got webhook deliveries from database (webhook: %v) (count: %v)", webhookID, len(deliveries))
+	}
+
+	return deliveries, nil
+}
+
+/*
+	Deletes delivered and failed deliveries last updated before the provided time. Pending deliveries are never deleted.
+*/
+func DeleteWebhookDeliveries(f *os.File, debug bool, db string, dbCollection string, client *mongo.Client, before uint64) (int64, error) {
+	log.SetOutput(f)
+	collection := client.Database(db).Collection(dbCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteMany(ctx, bson.M{"status": bson.M{"$ne": DeliveryPending}, "updated": bson.M{"$lt": before}})
+	if err != nil {
+		log.Printf("Error deleting old webhook deliveries (%v)", err)
+		return 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Deleted old webhook deliveries from database (count: %v)", result.DeletedCount)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"os"
+	"testing"
+)
+
+/*
+	Inserts webhooks and deliveries and checks listing webhooks, claiming due deliveries under a lease, the delivery log and pruning finished deliveries
+*/
+func testWebhooks(t *testing.T, store WebhookStore) {
+	hooks := []Webhook{
+		{ID: "HOOK2", Url: "https://example.com/b", Events: []string{"click"}, Scope: "alice", Created: 200},
+		{ID: "HOOK1", Url: "https://example.com/a", Events: []string{"created", "deleted"}, Created: 100},
+	}
+	for _, hook := range hooks {
+		if err := store.InsertWebhook(hook); err != nil {
+			t.Fatalf("FAILED inserting webhook. Expected: nil error, got: %v", err)
+		}
+	}
+	all, err := store.GetWebhooks()
+	if err != nil || len(all) != 2 || all[0].ID != "HOOK1" || all[1].ID != "HOOK2" {
+		t.Errorf("FAILED listing webhooks. Expected: HOOK1 and HOOK2, got: %+v (%v)", all, err)
+	} else {
+		t.Logf("PASSED listing webhooks. Expected: HOOK1 and HOOK2, got: %v and %v", all[0].ID, all[1].ID)
+	}
+	if hook, err := store.GetWebhook("HOOK2"); err != nil || hook.Scope != "alice" || len(hook.Events) != 1 {
+		t.Errorf("FAILED getting webhook. Expected: scope alice, got: %+v (%v)", hook, err)
+	}
+	if _, err := store.GetWebhook("MISSING"); err != ErrWebhookNotFound {
+		t.Errorf("FAILED getting missing webhook. Expected: %v, got: %v", ErrWebhookNotFound, err)
+	}
+
+	deliveries := []WebhookDelivery{
+		{ID: "DELIVERY1", WebhookID: "HOOK1", Event: "created", Status: DeliveryPending, NextAttempt: 100, Created: 100, Updated: 100},
+		{ID: "DELIVERY2", WebhookID: "HOOK1", Event: "deleted", Status: DeliveryPending, NextAttempt: 300, Created: 110, Updated: 110},
+		{ID: "DELIVERY3", WebhookID: "HOOK2", Event: "click", Status: DeliveryPending, NextAttempt: 50, Created: 120, Updated: 120},
+	}
+	if err := store.InsertWebhookDeliveries(deliveries); err != nil {
+		t.Fatalf("FAILED inserting webhook deliveries. Expected: nil error, got: %v", err)
+	}
+
+	// only deliveries due by now are claimed, and not again until the lease has passed, when ties are claimed in ID order
+	claimed, err := store.ClaimWebhookDeliveries(200, 60, 10)
+	if err != nil || len(claimed) != 2 || claimed[0].ID != "DELIVERY3" || claimed[1].ID != "DELIVERY1" || claimed[0].NextAttempt != 260 {
+		t.Errorf("FAILED claiming due deliveries. Expected: DELIVERY3 and DELIVERY1, got: %+v (%v)", claimed, err)
+	} else {
+		t.Logf("PASSED claiming due deliveries. Expected: DELIVERY3 and DELIVERY1, got: %v and %v", claimed[0].ID, claimed[1].ID)
+	}
+	if claimed, _ := store.ClaimWebhookDeliveries(250, 60, 10); len(claimed) != 0 {
+		t.Errorf("FAILED claiming leased deliveries. Expected: none, got: %+v", claimed)
+	}
+	if claimed, _ := store.ClaimWebhookDeliveries(1000, 60, 1); len(claimed) != 1 || claimed[0].ID != "DELIVERY1" {
+		t.Errorf("FAILED claiming deliveries after the lease. Expected: DELIVERY1 only, got: %+v", claimed)
+	}
+
+	delivered := deliveries[0]
+	delivered.Status, delivered.Attempts, delivered.LastStatus, delivered.Updated = DeliveryDelivered, 1, 200, 210
+	if err := store.UpdateWebhookDelivery(delivered); err != nil {
+		t.Errorf("FAILED updating webhook delivery. Expected: nil error, got: %v", err)
+	}
+	if claimed, _ := store.ClaimWebhookDeliveries(2000, 60, 10); len(claimed) != 2 || claimed[0].ID == "DELIVERY1" || claimed[1].ID == "DELIVERY1" {
+		t.Errorf("FAILED claiming deliveries. Expected: no delivered delivery, got: %+v", claimed)
+	}
+
+	log, err := store.GetWebhookDeliveries("HOOK1", 10)
+	if err != nil || len(log) != 2 || log[0].ID != "DELIVERY2" || log[1].Status != DeliveryDelivered || log[1].LastStatus != 200 {
+		t.Errorf("FAILED getting delivery log. Expected: DELIVERY2 and delivered DELIVERY1, got: %+v (%v)", log, err)
+	} else {
+		t.Logf("PASSED getting delivery log. Expected: DELIVERY2 and delivered DELIVERY1, got: %v and %v", log[0].ID, log[1].ID)
+	}
+	if log, _ := store.GetWebhookDeliveries("HOOK1", 1); len(log) != 1 {
+		t.Errorf("FAILED limiting delivery log. Expected: 1, got: %v", len(log))
+	}
+
+	// pending deliveries are kept however old they are
+	count, err := store.DeleteWebhookDeliveries(1000)
+	if err != nil || count != 1 {
+		t.Errorf("FAILED deleting old deliveries. Expected: 1, got: %v (%v)", count, err)
+	} else {
+		t.Logf("PASSED deleting old deliveries. Expected: 1, got: %v", count)
+	}
+
+	if err := store.DeleteWebhook("HOOK1"); err != nil {
+		t.Errorf("FAILED deleting webhook. Expected: nil error, got: %v", err)
+	}
+	if err := store.DeleteWebhook("HOOK1"); err != ErrWebhookNotFound {
+		t.Errorf("FAILED deleting missing webhook. Expected: %v, got: %v", ErrWebhookNotFound, err)
+	}
+	store.DeleteWebhook("HOOK2")
+}
+
+func TestMemoryStoreWebhooks(t *testing.T) {
+	testLog := "/tmp/TestMemoryStoreWebhooks.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testWebhooks(t, NewMemoryStore(f, verbose))
+}
+
+func TestBoltStoreWebhooks(t *testing.T) {
+	testLog := "/tmp/TestBoltStoreWebhooks.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	testFile := "/tmp/TestBoltStoreWebhooks.db"
+	os.Remove(testFile)
+	store, err := OpenBoltStore(f, verbose, testFile)
+	if err != nil {
+		t.Fatalf("FAILED opening database file. Expected: nil error, got: %v", err)
+	}
+	defer store.Close()
+	testWebhooks(t, store)
+}
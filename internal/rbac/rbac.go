@@ -0,0 +1,86 @@
+package rbac
+
+import "sort"
+
+/*
+	An action that API keys can be allowed to take.
+*/
+type Permission string
+
+const (
+	// create, update and delete short URLs owned by the API key
+	WriteUrls Permission = "urls:write"
+	// update and delete short URLs owned by any API key
+	WriteAnyUrls Permission = "urls:write:any"
+	// list short URLs owned by the API key
+	ReadUrls Permission = "urls:read"
+	// list short URLs owned by any API key
+	ReadAnyUrls Permission = "urls:read:any"
+	// query statistics, trending short URLs and events of short URLs owned by the API key
+	ReadStats Permission = "stats:read"
+	// query statistics, trending short URLs and events of short URLs owned by any API key
+	ReadAnyStats Permission = "stats:read:any"
+	// create and delete API keys, and assign their roles
+	ManageKeys Permission = "keys:manage"
+	// flush the cache
+	ManageCache Permission = "cache:manage"
+	// read the service metrics
+	ReadMetrics Permission = "metrics:read"
+	// create and delete webhooks receiving events of short URLs owned by the API key, or of every short URL along with ReadAnyUrls
+	ManageWebhooks Permission = "webhooks:manage"
+)
+
+const (
+	Admin    = "admin"
+	Editor   = "editor"
+	ReadOnly = "readonly"
+)
+
+// permissions granted by each role
+var roles = map[string][]Permission{
+	Admin:    {WriteUrls, WriteAnyUrls, ReadUrls, ReadAnyUrls, ReadStats, ReadAnyStats, ManageKeys, ManageCache, ReadMetrics, ManageWebhooks},
+	Editor:   {WriteUrls, ReadUrls, ReadStats, ManageWebhooks},
+	ReadOnly: {ReadStats, ReadAnyStats},
+}
+
+/*
+	Checks if the provided role is one of the defined roles.
+*/
+func IsValidRole(role string) bool {
+	_, ok := roles[role]
+	return ok
+}
+
+/*
+	Checks if the provided role grants the permission. Unknown roles grant nothing.
+*/
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range roles[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Holds a role and the permissions it grants, as returned by the API.
+*/
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+/*
+	Returns all defined roles, sorted by name.
+*/
+func Roles() []Role {
+	list := make([]Role, 0, len(roles))
+	for name, permissions := range roles {
+		list = append(list, Role{Name: name, Permissions: append([]Permission{}, permissions...)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
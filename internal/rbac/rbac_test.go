@@ -0,0 +1,33 @@
+package rbac
+
+import "testing"
+
+/*
+	Tests the IsValidRole and HasPermission functions
+*/
+func TestHasPermission(t *testing.T) {
+	if !IsValidRole(Editor) || IsValidRole("superuser") {
+		t.Errorf("FAILED validating roles. Expected: editor valid and superuser invalid, got: %v/%v", IsValidRole(Editor), IsValidRole("superuser"))
+	}
+
+	// admins can do everything
+	if !HasPermission(Admin, ManageKeys) || !HasPermission(Admin, WriteAnyUrls) || !HasPermission(Admin, ReadMetrics) {
+		t.Errorf("FAILED checking admin permissions. Expected: true, got: false")
+	}
+
+	// editors can manage their own short URLs and webhooks, but nothing else
+	if !HasPermission(Editor, WriteUrls) || !HasPermission(Editor, ManageWebhooks) || HasPermission(Editor, WriteAnyUrls) || HasPermission(Editor, ManageCache) {
+		t.Errorf("FAILED checking editor permissions. Expected: own short URLs and webhooks only")
+	}
+
+	// read-only keys can only query stats, of every short URL
+	if !HasPermission(ReadOnly, ReadStats) || !HasPermission(ReadOnly, ReadAnyStats) || HasPermission(Editor, ReadAnyStats) || HasPermission(ReadOnly, ReadUrls) || HasPermission(ReadOnly, WriteUrls) || HasPermission(ReadOnly, ManageWebhooks) {
+		t.Errorf("FAILED checking read-only permissions. Expected: stats only")
+	} else {
+		t.Logf("PASSED checking read-only permissions. Expected: stats only, got: stats only")
+	}
+
+	if HasPermission("superuser", ReadStats) {
+		t.Errorf("FAILED checking unknown role permissions. Expected: false, got: true")
+	}
+}
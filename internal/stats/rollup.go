@@ -0,0 +1,230 @@
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"example.com/url-shortener/internal/model"
+)
+
+const (
+	// seconds in a rollup hour
+	hourSeconds = 3600
+	// visitors kept per rollup, clicks of further visitors are still counted but no longer add unique clicks
+	maxRollupVisitors = 10000
+	// values kept per dimension of a rollup, the least seen values are summed up as other
+	maxRollupValues = 100
+)
+
+// identifies the rollup of a short URL and hour
+type rollupKey struct {
+	slug string
+	hour uint64
+}
+
+// counts of one short URL and hour while they are being rolled up
+type rollup struct {
+	clicks     uint64
+	visitors   map[string]struct{}
+	referrers  map[string]uint64
+	userAgents map[string]uint64
+	browsers   map[string]uint64
+	os         map[string]uint64
+	countries  map[string]uint64
+	botClicks  uint64
+	bots       map[string]uint64
+}
+
+/*
+	Groups click events into hourly rollups per short URL. Not safe for concurrent use.
+*/
+type Builder struct {
+	rollups map[rollupKey]*rollup
+}
+
+/*
+	Returns an empty builder.
+*/
+func NewBuilder() *Builder {
+	return &Builder{rollups: map[rollupKey]*rollup{}}
+}
+
+/*
+	Counts a click event in the rollup of its short URL and hour.
+	Clicks by bots are only counted by bot name, so they do not show up as visitors, referrers or browsers.
+*/
+func (b *Builder) Add(event model.ClickEvent) {
+	key := rollupKey{slug: event.Slug, hour: event.Timestamp - event.Timestamp%hourSeconds}
+	r, ok := b.rollups[key]
+	if !ok {
+		r = &rollup{
+			visitors:   map[string]struct{}{},
+			referrers:  map[string]uint64{},
+			userAgents: map[string]uint64{},
+			browsers:   map[string]uint64{},
+			os:         map[string]uint64{},
+			countries:  map[string]uint64{},
+			bots:       map[string]uint64{},
+		}
+		b.rollups[key] = r
+	}
+
+	r.clicks++
+	if event.Bot != "" {
+		r.botClicks++
+		r.bots[event.Bot]++
+		return
+	}
+	if len(r.visitors) < maxRollupVisitors {
+		r.visitors[VisitorID(event)] = struct{}{}
+	}
+	browser, os := ParseUserAgent(event.UserAgent)
+	r.referrers[referrerHost(event.Referrer)]++
+	r.userAgents[valueOrUnknown(event.UserAgent)]++
+	r.browsers[browser]++
+	r.os[os]++
+	r.countries[valueOrUnknown(event.Country)]++
+}
+
+/*
+	Returns the rollups of all added events, ordered by short URL and hour.
+*/
+func (b *Builder) Rollups() []model.ClickRollup {
+	rollups := make([]model.ClickRollup, 0, len(b.rollups))
+	for key, r := range b.rollups {
+		visitors := make([]string, 0, len(r.visitors))
+		for visitor := range r.visitors {
+			visitors = append(visitors, visitor)
+		}
+		sort.Strings(visitors)
+		rollups = append(rollups, model.ClickRollup{
+			Slug:       key.slug,
+			Hour:       key.hour,
+			Clicks:     r.clicks,
+			Visitors:   visitors,
+			Referrers:  topCounts(r.referrers, maxRollupValues),
+			UserAgents: topCounts(r.userAgents, maxRollupValues),
+			Browsers:   topCounts(r.browsers, maxRollupValues),
+			OS:         topCounts(r.os, maxRollupValues),
+			Countries:  topCounts(r.countries, maxRollupValues),
+			BotClicks:  r.botClicks,
+			Bots:       topCounts(r.bots, maxRollupValues),
+		})
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Slug != rollups[j].Slug {
+			return rollups[i].Slug < rollups[j].Slug
+		}
+		return rollups[i].Hour < rollups[j].Hour
+	})
+	return rollups
+}
+
+/*
+	Returns an ID for the visitor behind a click event, a hash of the anonymized IP address and user agent.
+	As IP addresses are anonymized, visitors sharing a network and browser are counted as one.
+*/
+func VisitorID(event model.ClickEvent) string {
+	sum := sha256.Sum256([]byte(event.IP + "\x00" + event.UserAgent))
+	return hex.EncodeToString(sum[:8])
+}
+
+// the host a click came from, clicks without a referrer are direct visits
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return "direct"
+	}
+	parsed, err := url.Parse(referrer)
+	if err != nil || parsed.Hostname() == "" {
+		return Other
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return Unknown
+	}
+	return value
+}
+
+// returns the n most seen values, most seen first, with the rest summed up as other
+func topCounts(counts map[string]uint64, n int) []model.NamedCount {
+	list := sortedCounts(counts)
+	if len(list) <= n {
+		return list
+	}
+	other := uint64(0)
+	for _, value := range list[n-1:] {
+		other += value.Count
+	}
+	return append(list[:n-1], model.NamedCount{Name: Other, Count: other})
+}
+
+/*
+	Recomputes the hourly rollups of all click events since the latest rolled up hour and saves them, returning the number of saved rollups.
+	The hour before the latest one is recomputed as well, to pick up events that were written late.
+	Rollups are replaced rather than incremented, so running this twice, or on several servers at once, gives the same result.
+*/
+func RollUp(f *os.File, debug bool, store model.EventStore) (int, error) {
+	log.SetOutput(f)
+	latest, err := store.LatestClickRollup()
+	if err != nil {
+		return 0, err
+	}
+	from := uint64(0)
+	if latest >= hourSeconds {
+		from = latest - hourSeconds
+	}
+
+	builder := NewBuilder()
+	err = store.ScanClickEvents(from, func(event model.ClickEvent) error {
+		builder.Add(event)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	rollups := builder.Rollups()
+	if err := store.SaveClickRollups(rollups); err != nil {
+		return 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Rolled up click events (from: %v) (count: %v)", from, len(rollups))
+	}
+	return len(rollups), nil
+}
+
+/*
+	Deletes click events recorded before the provided time, returning the number of deleted events.
+	Events are only deleted once they can no longer be rolled up again, so events of the latest two rolled up hours and later are always kept.
+*/
+func PurgeClickEvents(f *os.File, debug bool, store model.EventStore, before uint64) (int64, error) {
+	log.SetOutput(f)
+	latest, err := store.LatestClickRollup()
+	if err != nil {
+		return 0, err
+	}
+	// nothing is purged until the first rollup, as no event has been counted yet
+	if latest < hourSeconds {
+		return 0, nil
+	}
+	if before > latest-hourSeconds {
+		before = latest - hourSeconds
+	}
+	count, err := store.DeleteClickEvents(before)
+	if err != nil {
+		return 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Purged click events (before: %v) (count: %v)", before, count)
+	}
+	return count, nil
+}
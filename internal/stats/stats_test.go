@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"example.com/url-shortener/internal/model"
+)
+
+const (
+	testHour    = uint64(3600 * 400000)
+	firefoxUA   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/118.0"
+	iphoneUA    = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	edgeUA      = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46"
+	androidUA   = "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"
+	googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func openTestLog(t *testing.T, testLog string) *os.File {
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(testLog)
+	})
+	return f
+}
+
+func TestParseUserAgent(t *testing.T) {
+	var tests = []struct {
+		userAgent string
+		browser   string
+		os        string
+	}{
+		{firefoxUA, "Firefox", "Windows"},
+		{iphoneUA, "Safari", "iOS"},
+		{edgeUA, "Edge", "macOS"},
+		{androidUA, "Chrome", "Android"},
+		{googlebotUA, "Bot", Other},
+		{"curl/7.79.1", "curl", Other},
+		{"", Unknown, Unknown},
+	}
+	for _, tt := range tests {
+		browser, os := ParseUserAgent(tt.userAgent)
+		if browser != tt.browser || os != tt.os {
+			t.Errorf("FAILED parsing user agent %q. Expected: %v %v, got: %v %v", tt.userAgent, tt.browser, tt.os, browser, os)
+		} else {
+			t.Logf("PASSED parsing user agent %q. Expected: %v %v, got: %v %v", tt.userAgent, tt.browser, tt.os, browser, os)
+		}
+	}
+}
+
+/*
+	Tests that events are grouped per short URL and hour, counting visitors and values of each dimension
+*/
+func TestBuilder(t *testing.T) {
+	builder := NewBuilder()
+	builder.Add(model.ClickEvent{Slug: "abc", Timestamp: testHour + 10, Referrer: "https://www.news.example.com/a", UserAgent: firefoxUA, IP: "203.0.113.0", Country: "DE"})
+	builder.Add(model.ClickEvent{Slug: "abc", Timestamp: testHour + 20, Referrer: "https://news.example.com/b", UserAgent: firefoxUA, IP: "203.0.113.0", Country: "DE"})
+	builder.Add(model.ClickEvent{Slug: "abc", Timestamp: testHour + 30, UserAgent: iphoneUA, IP: "198.51.100.0"})
+	builder.Add(model.ClickEvent{Slug: "abc", Timestamp: testHour + 3600, UserAgent: iphoneUA, IP: "198.51.100.0"})
+	builder.Add(model.ClickEvent{Slug: "abc", Timestamp: testHour + 50, UserAgent: "Slackbot-LinkExpanding 1.0", IP: "192.0.2.0", Bot: "Slack"})
+	builder.Add(model.ClickEvent{Slug: "xyz", Timestamp: testHour + 40})
+
+	rollups := builder.Rollups()
+	if len(rollups) != 3 || rollups[0].Slug != "abc" || rollups[0].Hour != testHour || rollups[1].Hour != testHour+3600 || rollups[2].Slug != "xyz" {
+		t.Fatalf("FAILED rolling up click events. Expected: abc twice and xyz, got: %+v", rollups)
+	}
+	first := rollups[0]
+	if first.Clicks != 4 || first.BotClicks != 1 || len(first.Visitors) != 2 {
+		t.Errorf("FAILED counting clicks. Expected: 4 clicks (1 bot) by 2 visitors, got: %v (%v bot) by %v", first.Clicks, first.BotClicks, len(first.Visitors))
+	} else {
+		t.Logf("PASSED counting clicks. Expected: 4 clicks (1 bot) by 2 visitors, got: %v (%v bot) by %v", first.Clicks, first.BotClicks, len(first.Visitors))
+	}
+	// bots are only counted by name
+	if got := fmt.Sprint(first.Bots, first.UserAgents); got != fmt.Sprintf("[{Slack 1}] [{%v 2} {%v 1}]", firefoxUA, iphoneUA) {
+		t.Errorf("FAILED counting bots. Expected: Slack, and no bot user agents, got: %v", got)
+	}
+	if got := fmt.Sprint(first.Referrers); got != "[{news.example.com 2} {direct 1}]" {
+		t.Errorf("FAILED counting referrers. Expected: [{news.example.com 2} {direct 1}], got: %v", got)
+	}
+	if got := fmt.Sprint(first.Browsers, first.OS, first.Countries); got != "[{Firefox 2} {Safari 1}] [{Windows 2} {iOS 1}] [{DE 2} {unknown 1}]" {
+		t.Errorf("FAILED counting browsers, OS and countries. Expected: Firefox, Windows and DE first, got: %v", got)
+	}
+}
+
+/*
+	Tests that values beyond the limit of a rollup are summed up as other
+*/
+func TestTopCounts(t *testing.T) {
+	counts := map[string]uint64{"a": 5, "b": 4, "c": 1, "d": 1}
+	if got := fmt.Sprint(topCounts(counts, 3)); got != "[{a 5} {b 4} {other 2}]" {
+		t.Errorf("FAILED limiting values. Expected: [{a 5} {b 4} {other 2}], got: %v", got)
+	} else {
+		t.Logf("PASSED limiting values. Expected: [{a 5} {b 4} {other 2}], got: %v", got)
+	}
+}
+
+/*
+	Tests that rolling up recomputes the latest hours from the raw events, picking up events written since the last run
+*/
+func TestRollUp(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestRollUp.log")
+	store := model.NewMemoryStore(f, true)
+	store.InsertClickEvents([]model.ClickEvent{
+		{Slug: "abc", Timestamp: testHour + 10},
+		{Slug: "abc", Timestamp: testHour + 3600 + 10},
+	})
+	if count, err := RollUp(f, true, store); err != nil || count != 2 {
+		t.Fatalf("FAILED rolling up click events. Expected: 2 rollups, got: %v (%v)", count, err)
+	}
+
+	// a late event of the previous hour and a new one of the latest hour
+	store.InsertClickEvents([]model.ClickEvent{
+		{Slug: "abc", Timestamp: testHour + 20},
+		{Slug: "abc", Timestamp: testHour + 3600 + 20},
+	})
+	if _, err := RollUp(f, true, store); err != nil {
+		t.Fatalf("FAILED rolling up click events. Expected: nil error, got: %v", err)
+	}
+	rollups, _ := store.GetClickRollups("abc", testHour, testHour+7200)
+	clicks := []uint64{}
+	for _, rollup := range rollups {
+		clicks = append(clicks, rollup.Clicks)
+	}
+	if fmt.Sprint(clicks) != "[2 2]" {
+		t.Errorf("FAILED rolling up new click events. Expected: [2 2], got: %v", clicks)
+	} else {
+		t.Logf("PASSED rolling up new click events. Expected: [2 2], got: %v", clicks)
+	}
+}
+
+/*
+	Tests that purging click events never deletes events that would still be rolled up again
+*/
+func TestPurgeClickEvents(t *testing.T) {
+	f := openTestLog(t, "/tmp/TestPurgeClickEvents.log")
+	store := model.NewMemoryStore(f, true)
+	store.InsertClickEvents([]model.ClickEvent{
+		{Slug: "abc", Timestamp: testHour + 10},
+		{Slug: "abc", Timestamp: testHour + 3600 + 10},
+		{Slug: "abc", Timestamp: testHour + 7200 + 10},
+	})
+	if count, err := PurgeClickEvents(f, true, store, testHour+7200+20); err != nil || count != 0 {
+		t.Errorf("FAILED purging click events before first rollup. Expected: 0, got: %v (%v)", count, err)
+	}
+
+	RollUp(f, true, store)
+	count, err := PurgeClickEvents(f, true, store, testHour+7200+20)
+	remaining := 0
+	store.ScanClickEvents(0, func(event model.ClickEvent) error {
+		remaining++
+		return nil
+	})
+	if err != nil || count != 1 || remaining != 2 {
+		t.Errorf("FAILED purging rolled up click events. Expected: 1 purged, 2 kept, got: %v purged, %v kept (%v)", count, remaining, err)
+	} else {
+		t.Logf("PASSED purging rolled up click events. Expected: 1 purged, 2 kept, got: %v purged, %v kept", count, remaining)
+	}
+}
+
+/*
+	Tests merging rollups into buckets, counting visitors of several hours once
+*/
+func TestSummarize(t *testing.T) {
+	rollups := []model.ClickRollup{
+		{Slug: "abc", Hour: testHour, Clicks: 2, Visitors: []string{"a", "b"}, Browsers: []model.NamedCount{{Name: "Firefox", Count: 2}}},
+		{Slug: "abc", Hour: testHour + 3600, Clicks: 3, Visitors: []string{"b", "c"}, Browsers: []model.NamedCount{{Name: "Safari", Count: 2}, {Name: "Firefox", Count: 1}}},
+		{Slug: "abc", Hour: testHour + 86400, Clicks: 2, Visitors: []string{"a"}, Browsers: []model.NamedCount{{Name: "Chrome", Count: 1}}, BotClicks: 1, Bots: []model.NamedCount{{Name: "Twitter", Count: 1}}},
+	}
+	summary := Summarize(rollups, testHour, testHour+2*86400, 86400, 2)
+	if summary.Clicks != 7 || summary.HumanClicks != 6 || summary.BotClicks != 1 || summary.Unique != 3 {
+		t.Errorf("FAILED summarizing clicks. Expected: 7 clicks (6 human, 1 bot), 3 unique, got: %v clicks (%v human, %v bot), %v unique", summary.Clicks, summary.HumanClicks, summary.BotClicks, summary.Unique)
+	} else {
+		t.Logf("PASSED summarizing clicks. Expected: 7 clicks (6 human, 1 bot), 3 unique, got: %v clicks (%v human, %v bot), %v unique", summary.Clicks, summary.HumanClicks, summary.BotClicks, summary.Unique)
+	}
+	if got := fmt.Sprint(summary.Buckets); got != fmt.Sprintf("[{%v 5 0 3} {%v 2 1 1}]", testHour, testHour+86400) {
+		t.Errorf("FAILED summarizing buckets. Expected: 5 and 2 clicks, got: %v", got)
+	}
+	if got := fmt.Sprint(summary.Bots); got != "[{Twitter 1}]" {
+		t.Errorf("FAILED summarizing bots. Expected: [{Twitter 1}], got: %v", got)
+	}
+	if got := fmt.Sprint(summary.Browsers); got != "[{Firefox 3} {Safari 2}]" {
+		t.Errorf("FAILED summarizing top browsers. Expected: [{Firefox 3} {Safari 2}], got: %v", got)
+	}
+
+	// empty buckets are included
+	summary = Summarize(nil, testHour, testHour+3*3600, 3600, 10)
+	if len(summary.Buckets) != 3 || summary.Clicks != 0 || summary.Referrers == nil {
+		t.Errorf("FAILED summarizing without rollups. Expected: 3 empty buckets, got: %+v", summary)
+	}
+}
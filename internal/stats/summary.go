@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"sort"
+
+	"example.com/url-shortener/internal/model"
+)
+
+/*
+	Clicks of a short URL in one bucket of a summary. Start is the unix time the bucket starts at.
+*/
+type Bucket struct {
+	Start     uint64 `json:"start"`
+	Clicks    uint64 `json:"clicks"`
+	BotClicks uint64 `json:"botClicks"`
+	Unique    uint64 `json:"unique"`
+}
+
+/*
+	Statistics of a short URL over a window, merged from its hourly rollups.
+	Unique clicks are counted across the whole window (or bucket), so a visitor returning in a later hour is only counted once.
+	Clicks includes clicks by bots, which are broken down in Bots and left out of every other dimension.
+*/
+type Summary struct {
+	Clicks      uint64             `json:"clicks"`
+	HumanClicks uint64             `json:"humanClicks"`
+	BotClicks   uint64             `json:"botClicks"`
+	Unique      uint64             `json:"unique"`
+	Buckets     []Bucket           `json:"buckets"`
+	Referrers   []model.NamedCount `json:"referrers"`
+	UserAgents  []model.NamedCount `json:"userAgents"`
+	Browsers    []model.NamedCount `json:"browsers"`
+	OS          []model.NamedCount `json:"os"`
+	Countries   []model.NamedCount `json:"countries"`
+	Bots        []model.NamedCount `json:"bots"`
+}
+
+/*
+	Merges the rollups of a short URL into a summary of [from, to), with buckets of interval seconds and the top values of each dimension.
+	from and to are expected to be multiples of interval, and interval a multiple of an hour. Buckets without clicks are included.
+*/
+func Summarize(rollups []model.ClickRollup, from uint64, to uint64, interval uint64, top int) Summary {
+	summary := Summary{Buckets: []Bucket{}}
+	for start := from; start < to; start += interval {
+		summary.Buckets = append(summary.Buckets, Bucket{Start: start})
+	}
+	visitors := map[string]struct{}{}
+	bucketVisitors := make([]map[string]struct{}, len(summary.Buckets))
+	referrers, userAgents, browsers, os, countries := map[string]uint64{}, map[string]uint64{}, map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	bots := map[string]uint64{}
+
+	for _, rollup := range rollups {
+		if rollup.Hour < from || rollup.Hour >= to {
+			continue
+		}
+		i := (rollup.Hour - from) / interval
+		if bucketVisitors[i] == nil {
+			bucketVisitors[i] = map[string]struct{}{}
+		}
+		summary.Clicks += rollup.Clicks
+		summary.BotClicks += rollup.BotClicks
+		summary.Buckets[i].Clicks += rollup.Clicks
+		summary.Buckets[i].BotClicks += rollup.BotClicks
+		for _, visitor := range rollup.Visitors {
+			visitors[visitor] = struct{}{}
+			bucketVisitors[i][visitor] = struct{}{}
+		}
+		addCounts(referrers, rollup.Referrers)
+		addCounts(userAgents, rollup.UserAgents)
+		addCounts(browsers, rollup.Browsers)
+		addCounts(os, rollup.OS)
+		addCounts(countries, rollup.Countries)
+		addCounts(bots, rollup.Bots)
+	}
+
+	summary.HumanClicks = summary.Clicks - summary.BotClicks
+
+	summary.Unique = uint64(len(visitors))
+	for i := range summary.Buckets {
+		summary.Buckets[i].Unique = uint64(len(bucketVisitors[i]))
+	}
+	summary.Referrers = topValues(referrers, top)
+	summary.UserAgents = topValues(userAgents, top)
+	summary.Browsers = topValues(browsers, top)
+	summary.OS = topValues(os, top)
+	summary.Countries = topValues(countries, top)
+	summary.Bots = topValues(bots, top)
+	return summary
+}
+
+func addCounts(counts map[string]uint64, values []model.NamedCount) {
+	for _, value := range values {
+		counts[value.Name] += value.Count
+	}
+}
+
+// returns the n most seen values, most seen first
+func topValues(counts map[string]uint64, n int) []model.NamedCount {
+	list := sortedCounts(counts)
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// returns all values, most seen first and then by name
+func sortedCounts(counts map[string]uint64) []model.NamedCount {
+	list := make([]model.NamedCount, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, model.NamedCount{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,73 @@
+package stats
+
+import "strings"
+
+// reported for clicks where a value was not sent or not recognized
+const (
+	Unknown = "unknown"
+	Other   = "other"
+)
+
+/*
+	Maps a token found in a user agent to a name. Rules are checked in order, as most user agents name several browsers,
+	i.e. Edge user agents also contain Chrome and Safari, and Chrome user agents also contain Safari.
+*/
+type uaRule struct {
+	token string
+	name  string
+}
+
+var browserRules = []uaRule{
+	{"bot", "Bot"},
+	{"spider", "Bot"},
+	{"crawl", "Bot"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"edg/", "Edge"},
+	{"edga/", "Edge"},
+	{"edgios/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"chrome/", "Chrome"},
+	{"safari/", "Safari"},
+	{"trident/", "Internet Explorer"},
+	{"msie ", "Internet Explorer"},
+}
+
+var osRules = []uaRule{
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"cros", "ChromeOS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+/*
+	Returns the browser and operating system named in a user agent, using a small set of well known tokens.
+	Missing user agents are reported as unknown, and ones not matching any rule as other.
+*/
+func ParseUserAgent(userAgent string) (string, string) {
+	if userAgent == "" {
+		return Unknown, Unknown
+	}
+	userAgent = strings.ToLower(userAgent)
+	return matchRule(browserRules, userAgent), matchRule(osRules, userAgent)
+}
+
+func matchRule(rules []uaRule, userAgent string) string {
+	for _, rule := range rules {
+		if strings.Contains(userAgent, rule.token) {
+			return rule.name
+		}
+	}
+	return Other
+}
@@ -0,0 +1,170 @@
+package stream
+
+import (
+	"sync"
+
+	"example.com/url-shortener/internal/model"
+)
+
+// types of events published to subscribers
+const (
+	Click   = "click"
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+	Expired = "expired"
+)
+
+/*
+	Something that happened to a short URL, i.e. it was clicked, created or expired. ID numbers the events published by a hub, starting at 1.
+	Owner identifies the owner of the short URL for filtering, and is empty if it has none or is not known, as for bulk changes by admins.
+	Url holds the short URL as created or updated, and Click the recorded click, when available.
+*/
+type Event struct {
+	ID        uint64            `json:"id"`
+	Type      string            `json:"type"`
+	Slug      string            `json:"slug"`
+	Owner     string            `json:"-"`
+	Timestamp uint64            `json:"timestamp"`
+	Url       *model.Url        `json:"url,omitempty"`
+	Click     *model.ClickEvent `json:"click,omitempty"`
+}
+
+/*
+	Selects the events a subscriber receives, by slug and owner. Empty values match every event.
+*/
+type Filter struct {
+	Slug  string
+	Owner string
+}
+
+func (f Filter) matches(event Event) bool {
+	return (f.Slug == "" || f.Slug == event.Slug) && (f.Owner == "" || f.Owner == event.Owner)
+}
+
+/*
+	Receives the events matching its filter on Events, which is closed once the subscriber is evicted or the hub is closed.
+*/
+type Subscriber struct {
+	Events  <-chan Event
+	events  chan Event
+	filter  Filter
+	evicted bool
+}
+
+/*
+	Checks if the subscriber was evicted for falling behind, rather than unsubscribed or closed with the hub. Only valid once Events is closed.
+*/
+func (s *Subscriber) Evicted() bool {
+	return s.evicted
+}
+
+/*
+	Counters of a hub, as reported by the metrics API.
+*/
+type Metrics struct {
+	Subscribers int    `json:"subscribers"`
+	Published   uint64 `json:"published"`
+	// subscribers removed because their buffer was full
+	Evicted uint64 `json:"evicted"`
+}
+
+/*
+	Fans out published events to subscribers. Every subscriber has a buffer of bufferSize events,
+	and publishing never waits on a subscriber: one whose buffer is full has fallen behind and is evicted, closing its channel.
+	At most maxSubscribers subscribe at once. Safe for concurrent use.
+*/
+type Hub struct {
+	mu             sync.Mutex
+	subscribers    map[*Subscriber]struct{}
+	bufferSize     int
+	maxSubscribers int
+	closed         bool
+	lastID         uint64
+	evicted        uint64
+}
+
+/*
+	Returns a hub without subscribers.
+*/
+func NewHub(bufferSize int, maxSubscribers int) *Hub {
+	return &Hub{subscribers: map[*Subscriber]struct{}{}, bufferSize: bufferSize, maxSubscribers: maxSubscribers}
+}
+
+/*
+	Adds a subscriber receiving the events matching the filter. Returns false if the hub is full or closed.
+*/
+func (h *Hub) Subscribe(filter Filter) (*Subscriber, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || len(h.subscribers) >= h.maxSubscribers {
+		return nil, false
+	}
+	events := make(chan Event, h.bufferSize)
+	s := &Subscriber{Events: events, events: events, filter: filter}
+	h.subscribers[s] = struct{}{}
+	return s, true
+}
+
+// removes a subscriber and closes its channel, the hub must be locked
+func (h *Hub) remove(s *Subscriber) {
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+/*
+	Removes a subscriber, if it has not been evicted already.
+*/
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+/*
+	Numbers the event and passes it to every subscriber whose filter matches, evicting subscribers that cannot take it.
+*/
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.lastID++
+	event.ID = h.lastID
+	for s := range h.subscribers {
+		if !s.filter.matches(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			s.evicted = true
+			h.remove(s)
+			h.evicted++
+		}
+	}
+}
+
+/*
+	Returns the current counters of the hub.
+*/
+func (h *Hub) Metrics() Metrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Metrics{Subscribers: len(h.subscribers), Published: h.lastID, Evicted: h.evicted}
+}
+
+/*
+	Removes every subscriber and stops publishing, used on shutdown so open streams end.
+*/
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		h.remove(s)
+	}
+}
@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"testing"
+)
+
+// reads the events buffered for a subscriber, up to the first missing one
+func received(s *Subscriber) []Event {
+	events := []Event{}
+	for {
+		select {
+		case event, ok := <-s.Events:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+/*
+	Tests that events are numbered and only passed to subscribers whose filter matches
+*/
+func TestPublish(t *testing.T) {
+	h := NewHub(10, 10)
+	all, _ := h.Subscribe(Filter{})
+	bySlug, _ := h.Subscribe(Filter{Slug: "abc"})
+	byOwner, _ := h.Subscribe(Filter{Owner: "/alice"})
+
+	h.Publish(Event{Type: Created, Slug: "abc", Owner: "/alice"})
+	h.Publish(Event{Type: Click, Slug: "abc", Owner: "/alice"})
+	h.Publish(Event{Type: Created, Slug: "xyz", Owner: "/bob"})
+
+	var tests = []struct {
+		name       string
+		subscriber *Subscriber
+		expected   []uint64
+	}{
+		{"all events", all, []uint64{1, 2, 3}},
+		{"events of a short URL", bySlug, []uint64{1, 2}},
+		{"events of an owner", byOwner, []uint64{1, 2}},
+	}
+	for _, tt := range tests {
+		ids := []uint64{}
+		for _, event := range received(tt.subscriber) {
+			ids = append(ids, event.ID)
+		}
+		if len(ids) != len(tt.expected) || ids[len(ids)-1] != tt.expected[len(tt.expected)-1] {
+			t.Errorf("FAILED receiving %v. Expected: %v, got: %v", tt.name, tt.expected, ids)
+		} else {
+			t.Logf("PASSED receiving %v. Expected: %v, got: %v", tt.name, tt.expected, ids)
+		}
+	}
+}
+
+/*
+	Tests that a subscriber falling behind is evicted without holding up the others, and that closing the hub ends every subscription
+*/
+func TestEviction(t *testing.T) {
+	h := NewHub(2, 2)
+	slow, _ := h.Subscribe(Filter{})
+	fast, _ := h.Subscribe(Filter{})
+	if _, ok := h.Subscribe(Filter{}); ok {
+		t.Errorf("FAILED limiting subscribers. Expected: third subscriber rejected, got: subscribed")
+	}
+
+	for i := 0; i < 3; i++ {
+		h.Publish(Event{Type: Click, Slug: "abc"})
+		received(fast)
+	}
+	if events := received(slow); len(events) != 2 || !slow.Evicted() {
+		t.Errorf("FAILED evicting slow subscriber. Expected: 2 events and evicted, got: %v and %v", len(events), slow.Evicted())
+	} else {
+		t.Logf("PASSED evicting slow subscriber. Expected: 2 events and evicted, got: %v and %v", len(events), slow.Evicted())
+	}
+	if metrics := h.Metrics(); metrics.Subscribers != 1 || metrics.Published != 3 || metrics.Evicted != 1 {
+		t.Errorf("FAILED counting evictions. Expected: 1 subscriber, 3 published, 1 evicted, got: %+v", metrics)
+	}
+
+	h.Close()
+	if _, ok := <-fast.Events; ok || fast.Evicted() {
+		t.Errorf("FAILED closing subscriptions. Expected: closed and not evicted, got: open or evicted")
+	}
+	if _, ok := h.Subscribe(Filter{}); ok {
+		t.Errorf("FAILED subscribing to closed hub. Expected: rejected, got: subscribed")
+	}
+}
@@ -0,0 +1,258 @@
+package trending
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// longest window trending short URLs can be requested for
+const MaxWindow = 24 * time.Hour
+
+/*
+	A short URL and its clicks within the requested window.
+*/
+type Url struct {
+	Slug   string `json:"slug"`
+	Clicks uint64 `json:"clicks"`
+}
+
+/*
+	Counts recent clicks of short URLs in time buckets, so the short URLs clicked most in a recent window can be listed.
+	Clicks are counted for all short URLs and, if the short URL has one, for its owner, so callers can be limited to their own short URLs.
+	Top returns the n short URLs clicked most within the window before now, for the owner or for all short URLs if owner is empty.
+*/
+type Tracker interface {
+	Add(slug string, owner string, now time.Time) error
+	Top(owner string, window time.Duration, n int, now time.Time) ([]Url, error)
+}
+
+/*
+	Length of the buckets clicks are counted in, in seconds, and how many buckets are kept.
+	Buckets older than that drop out of every window, so old clicks decay without ever being decremented.
+*/
+type resolution struct {
+	bucket uint64
+	count  uint64
+}
+
+// minute buckets for the last hour, and hour buckets for the last day
+var resolutions = []resolution{{bucket: 60, count: 60}, {bucket: 3600, count: 24}}
+
+// the finest resolution keeping buckets for the whole window
+func resolutionFor(window time.Duration) resolution {
+	for _, r := range resolutions {
+		if window <= time.Duration(r.bucket*r.count)*time.Second {
+			return r
+		}
+	}
+	return resolutions[len(resolutions)-1]
+}
+
+// start times of the buckets covering the window, starting with the current, partially filled, bucket
+func (r resolution) buckets(window time.Duration, now time.Time) []uint64 {
+	seconds := uint64(window / time.Second)
+	n := (seconds + r.bucket - 1) / r.bucket
+	if n > r.count {
+		n = r.count
+	}
+	current := uint64(now.Unix()) - uint64(now.Unix())%r.bucket
+	starts := make([]uint64, 0, n)
+	for i := uint64(0); i < n && i*r.bucket <= current; i++ {
+		starts = append(starts, current-i*r.bucket)
+	}
+	return starts
+}
+
+// orders short URLs by clicks, most clicked first and then by slug, keeping the first n
+func topUrls(counts map[string]uint64, n int) []Url {
+	urls := make([]Url, 0, len(counts))
+	for slug, clicks := range counts {
+		urls = append(urls, Url{Slug: slug, Clicks: clicks})
+	}
+	sortUrls(urls)
+	if len(urls) > n {
+		urls = urls[:n]
+	}
+	return urls
+}
+
+func sortUrls(urls []Url) {
+	sort.Slice(urls, func(i, j int) bool {
+		if urls[i].Clicks != urls[j].Clicks {
+			return urls[i].Clicks > urls[j].Clicks
+		}
+		return urls[i].Slug < urls[j].Slug
+	})
+}
+
+/*
+	Keeps click buckets in Redis as sorted sets of slugs scored by clicks, one per owner and bucket.
+	Buckets expire once they are older than their resolution keeps them, and windows are summed up with ZUNIONSTORE.
+*/
+type RedisTracker struct {
+	F      *os.File
+	Debug  bool
+	Client *redis.Client
+}
+
+// key of the sorted set of a bucket, for all short URLs or those of one owner
+func redisKey(owner string, r resolution, start uint64) string {
+	if owner == "" {
+		return fmt.Sprintf("trending:all:%v:%v", r.bucket, start)
+	}
+	return fmt.Sprintf("trending:owner:%v:%v:%v", owner, r.bucket, start)
+}
+
+/*
+	Counts a click of the short URL in the current bucket of every resolution, in one round trip.
+*/
+func (t *RedisTracker) Add(slug string, owner string, now time.Time) error {
+	log.SetOutput(t.F)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	owners := []string{""}
+	if owner != "" {
+		owners = append(owners, owner)
+	}
+	_, err := t.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, r := range resolutions {
+			start := uint64(now.Unix()) - uint64(now.Unix())%r.bucket
+			for _, o := range owners {
+				key := redisKey(o, r, start)
+				pipe.ZIncrBy(ctx, key, 1, slug)
+				pipe.Expire(ctx, key, time.Duration(r.bucket*(r.count+1))*time.Second)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error counting trending click (slug: %v) (%v)", slug, err)
+		return err
+	}
+
+	if t.Debug {
+		log.Printf("[DEBUG] Counted trending click in cache (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Returns the short URLs clicked most within the window, summing up its buckets in a transaction so concurrent requests can share the destination key.
+*/
+func (t *RedisTracker) Top(owner string, window time.Duration, n int, now time.Time) ([]Url, error) {
+	log.SetOutput(t.F)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r := resolutionFor(window)
+	starts := r.buckets(window, now)
+	keys := make([]string, len(starts))
+	for i, start := range starts {
+		keys[i] = redisKey(owner, r, start)
+	}
+	dest := redisKey(owner, r, 0) + ":union"
+
+	var top *redis.ZSliceCmd
+	_, err := t.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys})
+		top = pipe.ZRevRangeWithScores(ctx, dest, 0, int64(n-1))
+		pipe.Del(ctx, dest)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error getting trending URLs (window: %v) (%v)", window, err)
+		return nil, err
+	}
+
+	urls := make([]Url, 0, len(top.Val()))
+	for _, z := range top.Val() {
+		slug, _ := z.Member.(string)
+		urls = append(urls, Url{Slug: slug, Clicks: uint64(z.Score)})
+	}
+	sortUrls(urls)
+
+	if t.Debug {
+		log.Printf("[DEBUG] Got trending URLs from cache (window: %v) (count: %v)", window, len(urls))
+	}
+	return urls, nil
+}
+
+// identifies a bucket of an owner and resolution
+type bucketKey struct {
+	owner  string
+	bucket uint64
+	start  uint64
+}
+
+/*
+	Keeps click buckets in memory, for servers running without Redis. Buckets are lost when the server exits. Safe for concurrent use.
+*/
+type MemoryTracker struct {
+	mu      sync.Mutex
+	buckets map[bucketKey]map[string]uint64
+	// start of the minute buckets older than their resolution were last removed in
+	pruned uint64
+}
+
+/*
+	Returns an empty in-memory tracker.
+*/
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{buckets: map[bucketKey]map[string]uint64{}}
+}
+
+/*
+	Counts a click of the short URL in the current bucket of every resolution. Expired buckets are removed once a minute.
+*/
+func (t *MemoryTracker) Add(slug string, owner string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	unix := uint64(now.Unix())
+	if minute := unix - unix%60; t.pruned != minute {
+		t.pruned = minute
+		for key := range t.buckets {
+			for _, r := range resolutions {
+				if key.bucket == r.bucket && key.start+r.bucket*r.count <= unix {
+					delete(t.buckets, key)
+				}
+			}
+		}
+	}
+
+	owners := []string{""}
+	if owner != "" {
+		owners = append(owners, owner)
+	}
+	for _, r := range resolutions {
+		for _, o := range owners {
+			key := bucketKey{owner: o, bucket: r.bucket, start: unix - unix%r.bucket}
+			if t.buckets[key] == nil {
+				t.buckets[key] = map[string]uint64{}
+			}
+			t.buckets[key][slug]++
+		}
+	}
+	return nil
+}
+
+/*
+	Returns the short URLs clicked most within the window.
+*/
+func (t *MemoryTracker) Top(owner string, window time.Duration, n int, now time.Time) ([]Url, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := resolutionFor(window)
+	counts := map[string]uint64{}
+	for _, start := range r.buckets(window, now) {
+		for slug, clicks := range t.buckets[bucketKey{owner: owner, bucket: r.bucket, start: start}] {
+			counts[slug] += clicks
+		}
+	}
+	return topUrls(counts, n), nil
+}
@@ -0,0 +1,118 @@
+package trending
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/cache"
+	"example.com/url-shortener/internal/config"
+)
+
+// some global variables to avoid duplication
+var configFileName string = "../../example/url_shortener.conf"
+var verbose bool = true
+var c config.Configuration = config.LoadConfig(configFileName, &verbose)
+
+/*
+	Tests picking the resolution and buckets covering a window
+*/
+func TestBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var tests = []struct {
+		window time.Duration
+		bucket uint64
+		count  int
+	}{
+		{time.Minute, 60, 1},
+		{15 * time.Minute, 60, 15},
+		{time.Hour, 60, 60},
+		{90 * time.Minute, 3600, 2},
+		{MaxWindow, 3600, 24},
+	}
+	for _, tt := range tests {
+		r := resolutionFor(tt.window)
+		starts := r.buckets(tt.window, now)
+		if r.bucket != tt.bucket || len(starts) != tt.count || starts[0] != 1700000000-1700000000%tt.bucket {
+			t.Errorf("FAILED getting buckets of window %v. Expected: %v buckets of %v seconds, got: %v buckets of %v seconds", tt.window, tt.count, tt.bucket, len(starts), r.bucket)
+		} else {
+			t.Logf("PASSED getting buckets of window %v. Expected: %v buckets of %v seconds, got: %v buckets of %v seconds", tt.window, tt.count, tt.bucket, len(starts), r.bucket)
+		}
+	}
+}
+
+// counts clicks of the slugs, one click per occurrence, for the owner
+func addClicks(t *testing.T, tracker Tracker, owner string, now time.Time, slugs ...string) {
+	for _, slug := range slugs {
+		if err := tracker.Add(slug, owner, now); err != nil {
+			t.Fatalf("FAILED counting click. Expected: nil error, got: %v", err)
+		}
+	}
+}
+
+/*
+	Counts clicks now and two hours ago, and checks the top short URLs of several windows and owners
+*/
+func testTracker(t *testing.T, tracker Tracker) {
+	now := time.Now()
+	a, b, old := "A", "B", "OLD"
+	addClicks(t, tracker, "", now.Add(-2*time.Hour), old, old, old, old)
+	addClicks(t, tracker, "alice", now, a, a, a)
+	addClicks(t, tracker, "bob", now, b, b, old)
+
+	top, err := tracker.Top("", time.Hour, 10, now)
+	if err != nil || fmt.Sprint(top) != fmt.Sprintf("[{%v 3} {%v 2} {%v 1}]", a, b, old) {
+		t.Errorf("FAILED getting trending URLs of the last hour. Expected: %v, %v and %v, got: %v (%v)", a, b, old, top, err)
+	} else {
+		t.Logf("PASSED getting trending URLs of the last hour. Expected: %v, %v and %v, got: %v (%v)", a, b, old, top, err)
+	}
+	top, _ = tracker.Top("", 3*time.Hour, 1, now)
+	if fmt.Sprint(top) != fmt.Sprintf("[{%v 5}]", old) {
+		t.Errorf("FAILED getting trending URLs of the last 3 hours. Expected: %v first, got: %v", old, top)
+	}
+	top, _ = tracker.Top("bob", time.Hour, 10, now)
+	if fmt.Sprint(top) != fmt.Sprintf("[{%v 2} {%v 1}]", b, old) {
+		t.Errorf("FAILED getting trending URLs of an owner. Expected: %v and %v, got: %v", b, old, top)
+	}
+}
+
+/*
+	Tests the in-memory tracker, including that buckets past their resolution are removed
+*/
+func TestMemoryTracker(t *testing.T) {
+	tracker := NewMemoryTracker()
+	testTracker(t, tracker)
+
+	tracker.Add("NEW", "", time.Now().Add(25*time.Hour))
+	for key := range tracker.buckets {
+		if key.start < uint64(time.Now().Unix()) {
+			t.Errorf("FAILED removing expired buckets. Expected: only new buckets, got: %+v", key)
+		}
+	}
+}
+
+/*
+	Tests the Redis tracker against the configured cache
+*/
+func TestRedisTracker(t *testing.T) {
+	testLog := "/tmp/TestRedisTracker.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	client := cache.GetCacheClient(c.CacheHost, c.CachePort, c.VisitorsCacheDB, c.CachePass)
+	tracker := &RedisTracker{F: f, Debug: verbose, Client: client}
+	// buckets of earlier runs have not expired yet
+	deleteKeys := func() {
+		keys, _ := client.Keys(context.Background(), "trending:*").Result()
+		if len(keys) > 0 {
+			client.Del(context.Background(), keys...)
+		}
+	}
+	deleteKeys()
+	defer deleteKeys()
+	testTracker(t, tracker)
+}
@@ -1,8 +1,14 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/url"
 	"os"
 	"regexp"
@@ -11,35 +17,59 @@ import (
 	"sync"
 )
 
+/*
+	Reserves counter ranges for use in URL slug creation. Returned ranges are inclusive of both ends.
+	Implementations must never hand out overlapping ranges, including across server restarts and between server instances sharing the same backend.
+*/
+type RangeAllocator interface {
+	NextRange(f *os.File, debug bool) (uint64, uint64, error)
+	// makes sure no range handed out afterwards includes the provided value or anything below it
+	ReserveThrough(f *os.File, debug bool, value uint64) error
+}
+
 /*
 	Holds the current start and end range for the counter, which is used in URL slug creation.
 	Also contains a mutex to make sure that no two threads grab the same counter value and create duplicate slugs.
+	New ranges are reserved through the Allocator. Without one, the counter falls back to the legacy fixed range.
+	Reserve only reserves values up to ReserveWindow above the current range, as the counter would take too long to reach the rest.
 */
 type Counter struct {
-	Counter    uint64
-	CounterEnd uint64
-	Mu         sync.Mutex
+	Counter       uint64
+	CounterEnd    uint64
+	Mu            sync.Mutex
+	Allocator     RangeAllocator
+	ReserveWindow uint64
 }
 
+// returned by range allocators when the next range or reservation would overflow the counter
+var ErrCounterOverflow = errors.New("counter values exhausted")
+
 /*
 	Returns a counter range to use for URL slug creation.
 */
-func (c *Counter) GetNewRange(f *os.File, debug bool) {
-	/*
-		TODO:
-		1. query ZooKeeper/etcd to get next available counter range
-	*/
+func (c *Counter) GetNewRange(f *os.File, debug bool) error {
 	log.SetOutput(f)
-	c.Counter, c.CounterEnd = 1000000, 2000000
+	if c.Allocator == nil {
+		c.Counter, c.CounterEnd = 1000000, 2000000
+	} else {
+		counter, counterEnd, err := c.Allocator.NextRange(f, debug)
+		if err != nil {
+			log.Printf("Error getting new counter range (%v)", err)
+			return err
+		}
+		c.Counter, c.CounterEnd = counter, counterEnd
+	}
 
 	if debug {
 		log.Printf("[DEBUG] Generated new counter range (%v through %v)", c.Counter, c.CounterEnd)
 	}
+	return nil
 }
 
 /*
 	Gets a counter value for use in URL slug creation, and then increases the counter.
 	If the current counter range (i.e. 1000000-2000000) is exhausted, it grabs a new range.
+	If a new range cannot be reserved, the counter is left empty so the next call tries again, and 0 is returned.
 */
 func (c *Counter) GetAndIncrease(f *os.File, debug bool) (uint64, uint64) {
 	c.Mu.Lock()
@@ -50,12 +80,183 @@ func (c *Counter) GetAndIncrease(f *os.File, debug bool) (uint64, uint64) {
 	// if not, get a new range
 	if curCounter < curCounterEnd {
 		c.Counter += 1
-	} else {
-		c.GetNewRange(f, debug)
+	} else if err := c.GetNewRange(f, debug); err != nil {
+		c.Counter, c.CounterEnd = 0, 0
 	}
 	return curCounter, curCounterEnd
 }
 
+/*
+	Gets n counter values for use in URL slug creation in one locked block, grabbing new ranges as needed.
+	If a new range cannot be reserved, fewer than n values are returned.
+*/
+func (c *Counter) GetAndIncreaseN(f *os.File, debug bool, n int) []uint64 {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	values := make([]uint64, 0, n)
+	for len(values) < n {
+		curCounter := c.Counter
+		// a counter of 0 means the previous range could not be reserved, so it is not a usable value
+		if curCounter < c.CounterEnd {
+			c.Counter += 1
+		} else if err := c.GetNewRange(f, debug); err != nil {
+			c.Counter, c.CounterEnd = 0, 0
+			if curCounter != 0 {
+				values = append(values, curCounter)
+			}
+			break
+		}
+		if curCounter != 0 {
+			values = append(values, curCounter)
+		}
+	}
+	return values
+}
+
+/*
+	Makes sure the counter never hands out the provided values, which is used when slugs are created outside of the counter (i.e. imports).
+	Values inside the current range move the counter past them, and values above the current range are reserved through the Allocator.
+	Values below the counter are left alone, as they have already been handed out.
+	Values more than ReserveWindow above the current range are left alone as well, so a single long vanity slug cannot push generated slugs to its length.
+	The unique slug index rejects the insert if the counter ever reaches one of them.
+	Without an Allocator the legacy fixed range is handed out again on every start, so values above the current range cannot be reserved.
+	Other server instances may still hold a range including one of the values, in which case the unique slug index rejects their insert.
+*/
+func (c *Counter) Reserve(f *os.File, debug bool, values []uint64) error {
+	log.SetOutput(f)
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	// only the highest value inside and above the current range matter, everything below them is skipped as well
+	// the end of the range is handed out too, so it counts as above the range
+	inRange, above := uint64(0), uint64(0)
+	for _, value := range values {
+		if value > c.CounterEnd && value-c.CounterEnd > c.ReserveWindow {
+			continue
+		}
+		if value >= c.Counter && value < c.CounterEnd && value >= inRange {
+			inRange = value + 1
+		} else if value >= c.CounterEnd && value+1 > above {
+			above = value + 1
+		}
+	}
+	if inRange > 0 {
+		c.Counter = inRange
+	}
+	if above > 0 && c.Allocator != nil {
+		if err := c.Allocator.ReserveThrough(f, debug, above-1); err != nil {
+			log.Printf("Error reserving counter values (through: %v) (%v)", above-1, err)
+			return err
+		}
+		// the current range ends with a reserved value, so move on to a new one
+		if above-1 == c.CounterEnd {
+			if err := c.GetNewRange(f, debug); err != nil {
+				c.Counter, c.CounterEnd = 0, 0
+				return err
+			}
+		}
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Reserved counter values (count: %v)", len(values))
+	}
+	return nil
+}
+
+/*
+	Reserves counter ranges by keeping the next unreserved counter value in a file on disk.
+	Only safe for single node deployments, as the file is not shared between server instances.
+*/
+type FileRangeAllocator struct {
+	FileName  string
+	Start     uint64
+	BlockSize uint64
+	mu        sync.Mutex
+}
+
+/*
+	Reserves the next block of counter values and saves the new high-water mark to disk before returning.
+*/
+func (a *FileRangeAllocator) NextRange(f *os.File, debug bool) (uint64, uint64, error) {
+	log.SetOutput(f)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	next, err := a.readNext()
+	if err != nil {
+		return 0, 0, err
+	}
+	if a.BlockSize == 0 || next > math.MaxUint64-a.BlockSize {
+		return 0, 0, ErrCounterOverflow
+	}
+	if err := a.writeNext(next + a.BlockSize); err != nil {
+		return 0, 0, err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Reserved counter range from file (%v through %v)", next, next+a.BlockSize-1)
+	}
+
+	return next, next + a.BlockSize - 1, nil
+}
+
+/*
+	Moves the saved high-water mark past the provided value, unless it already is.
+*/
+func (a *FileRangeAllocator) ReserveThrough(f *os.File, debug bool, value uint64) error {
+	log.SetOutput(f)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	next, err := a.readNext()
+	if err != nil {
+		return err
+	}
+	if next > value {
+		return nil
+	}
+	if value == math.MaxUint64 {
+		return ErrCounterOverflow
+	}
+	if err := a.writeNext(value + 1); err != nil {
+		return err
+	}
+
+	if debug {
+		log.Printf("[DEBUG] Reserved counter values in file (through %v)", value)
+	}
+	return nil
+}
+
+// reads the next unreserved counter value, falling back to the configured start if the file does not exist yet
+func (a *FileRangeAllocator) readNext() (uint64, error) {
+	data, err := os.ReadFile(a.FileName)
+	if os.IsNotExist(err) {
+		return a.Start, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// saves the next unreserved counter value
+func (a *FileRangeAllocator) writeNext(next uint64) error {
+	// write to a temporary file first so a crash never leaves a partially written high-water mark
+	tmpFileName := a.FileName + ".tmp"
+	tmp, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strconv.FormatUint(next, 10))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, a.FileName)
+}
+
 /*
 	In order to reduce wasted counter ranges, on a graceful server exit the current counter range is saved to a file.
 	On the next server start up, the counter range is loaded and then the file is deleted to prevent re-using an old range.
@@ -125,13 +326,32 @@ func FileExists(fileName string) bool {
 */
 func GenerateUrlSlug(f *os.File, debug bool, c *Counter) string {
 	log.SetOutput(f)
-	base := uint64(62)
-	characterSet := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	slug := ""
-
 	// uses mutex to get a unique counter value
+	// a counter of 0 means no range could be reserved, which results in an empty slug
 	counter, _ := c.GetAndIncrease(f, debug)
+	return encodeSlug(counter)
+}
+
+/*
+	Generates n unique base62 slugs from counter values reserved in one locked block.
+	Fewer slugs are returned if no new counter range could be reserved.
+*/
+func GenerateUrlSlugs(f *os.File, debug bool, c *Counter, n int) []string {
+	log.SetOutput(f)
+	slugs := []string{}
+	for _, counter := range c.GetAndIncreaseN(f, debug, n) {
+		slugs = append(slugs, encodeSlug(counter))
+	}
+	return slugs
+}
 
+// base62 characters used in generated slugs, in order of value
+const characterSet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// encodes the counter value in base62
+func encodeSlug(counter uint64) string {
+	base := uint64(62)
+	slug := ""
 	for counter > 0 {
 		r := counter % base
 		counter /= base
@@ -140,13 +360,58 @@ func GenerateUrlSlug(f *os.File, debug bool, c *Counter) string {
 	return slug
 }
 
+/*
+	Returns the counter value the provided slug would be generated from.
+	Returns false if the counter can never generate the slug, i.e. it is not base62, has a leading zero or overflows the counter.
+*/
+func DecodeSlug(slug string) (uint64, bool) {
+	if slug == "" || slug[0] == '0' {
+		return 0, false
+	}
+	counter := uint64(0)
+	for _, r := range slug {
+		i := strings.IndexRune(characterSet, r)
+		if i < 0 || counter > (math.MaxUint64-uint64(i))/62 {
+			return 0, false
+		}
+		counter = counter*62 + uint64(i)
+	}
+	return counter, true
+}
+
 /*
 	Checks if the provided slug is base62 and the correct length.
 */
 func IsValidSlug(maxSlugLen int, slug string) bool {
-	re := fmt.Sprintf("^[A-Za-z0-9]{1,%v}$", maxSlugLen)
-	isBase62 := regexp.MustCompile(re).MatchString
-	return isBase62(slug)
+	if slug == "" || len(slug) > maxSlugLen {
+		return false
+	}
+	for _, r := range slug {
+		if !strings.ContainsRune(characterSet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Checks if the provided vanity slug matches the vanity slug pattern, compiled once when the configuration is loaded.
+	No slug is valid without a pattern.
+*/
+func IsValidVanitySlug(re *regexp.Regexp, slug string) bool {
+	return re != nil && re.MatchString(slug)
+}
+
+/*
+	Checks if the provided slug is one of the reserved words that cannot be used as a short URL (case insensitive).
+*/
+func IsReservedSlug(reserved []string, slug string) bool {
+	for _, word := range reserved {
+		if strings.EqualFold(word, slug) {
+			return true
+		}
+	}
+	return false
 }
 
 /*
@@ -160,3 +425,59 @@ func IsValidUrl(u string) bool {
 		return true
 	}
 }
+
+/*
+	Generates a new random API key. Only the hash of the key (see HashApiKey) should ever be stored.
+*/
+func GenerateApiKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "us_" + hex.EncodeToString(b), nil
+}
+
+/*
+	Generates a new random secret used to sign webhook deliveries. Webhooks need the secret itself to sign, so unlike API keys it is stored as is.
+*/
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+/*
+	Returns the hash stored for the provided API key. API keys are random, so a fast unsalted hash is enough.
+*/
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+	Removes the host part of an IP address before it is recorded: the last octet of IPv4 addresses and all but the first 48 bits of IPv6 addresses.
+	Returns an empty string if the address cannot be parsed.
+*/
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+/*
+	Generates a random identifier, used for records that are not addressed by slug.
+*/
+func GenerateID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"math"
 	"os"
+	"regexp"
 	"testing"
 )
 
@@ -65,6 +67,152 @@ func TestLoadCounterRange(t *testing.T) {
 	os.Remove(testLog)
 }
 
+/*
+	Tests the FileRangeAllocator, including picking up where it left off after a restart
+*/
+func TestFileRangeAllocator(t *testing.T) {
+	testLog := "/tmp/TestFileRangeAllocator.log"
+	testFile := "/tmp/TestFileRangeAllocator.dat"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	os.Remove(testFile)
+
+	a := FileRangeAllocator{FileName: testFile, Start: 100, BlockSize: 50}
+	counter, counterEnd, err := a.NextRange(f, true)
+	if err != nil || counter != 100 || counterEnd != 149 {
+		t.Errorf("FAILED reserving first counter range. Expected: 100/149, got: %v/%v (%v)", counter, counterEnd, err)
+	} else {
+		t.Logf("PASSED reserving first counter range. Expected: 100/149, got: %v/%v", counter, counterEnd)
+	}
+
+	// a new allocator simulates a server restart without a saved counter range
+	a = FileRangeAllocator{FileName: testFile, Start: 100, BlockSize: 50}
+	counter, counterEnd, err = a.NextRange(f, true)
+	if err != nil || counter != 150 || counterEnd != 199 {
+		t.Errorf("FAILED reserving counter range after restart. Expected: 150/199, got: %v/%v (%v)", counter, counterEnd, err)
+	} else {
+		t.Logf("PASSED reserving counter range after restart. Expected: 150/199, got: %v/%v", counter, counterEnd)
+	}
+
+	// an exhausted counter should move on to the next reserved range
+	c := Counter{Counter: 199, CounterEnd: 199, Allocator: &a}
+	c.GetAndIncrease(f, true)
+	if c.Counter != 200 || c.CounterEnd != 249 {
+		t.Errorf("FAILED getting new counter range from allocator. Expected: 200/249, got: %v/%v", c.Counter, c.CounterEnd)
+	} else {
+		t.Logf("PASSED getting new counter range from allocator. Expected: 200/249, got: %v/%v", c.Counter, c.CounterEnd)
+	}
+
+	os.Remove(testFile)
+	os.Remove(testLog)
+}
+
+/*
+	Tests reserving many counter values at once across counter ranges
+*/
+func TestGetAndIncreaseN(t *testing.T) {
+	testLog := "/tmp/TestGetAndIncreaseN.log"
+	testFile := "/tmp/TestGetAndIncreaseN.dat"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	os.Remove(testFile)
+
+	a := FileRangeAllocator{FileName: testFile, Start: 100, BlockSize: 4}
+	c := Counter{Allocator: &a}
+	values := c.GetAndIncreaseN(f, true, 10)
+	if len(values) != 10 || values[0] != 100 || values[9] != 109 {
+		t.Errorf("FAILED reserving counter values. Expected: 100 through 109, got: %v", values)
+	} else {
+		t.Logf("PASSED reserving counter values. Expected: 100 through 109, got: %v", values)
+	}
+
+	// the counter keeps going where the batch stopped
+	slugs := GenerateUrlSlugs(f, true, &c, 2)
+	if len(slugs) != 2 || slugs[0] != encodeSlug(110) || slugs[1] != encodeSlug(111) {
+		t.Errorf("FAILED generating slugs. Expected: %v %v, got: %v", encodeSlug(110), encodeSlug(111), slugs)
+	}
+
+	// an allocator that cannot reserve a new range cuts the batch short at the end of the current range
+	a.FileName = "/nonexistent/TestGetAndIncreaseN.dat"
+	values = c.GetAndIncreaseN(f, true, 10)
+	if len(values) != 4 || values[0] != 112 || values[3] != 115 {
+		t.Errorf("FAILED reserving counter values without a new range. Expected: 112 through 115, got: %v", values)
+	}
+
+	os.Remove(testFile)
+	os.Remove(testLog)
+}
+
+/*
+	Tests that reserved counter values are never handed out, inside and above the current range
+*/
+func TestCounterReserve(t *testing.T) {
+	testLog := "/tmp/TestCounterReserve.log"
+	testFile := "/tmp/TestCounterReserve.dat"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	os.Remove(testFile)
+
+	a := FileRangeAllocator{FileName: testFile, Start: 100, BlockSize: 10}
+	c := Counter{Allocator: &a, ReserveWindow: 100}
+	c.GetNewRange(f, true)
+
+	// inside the current range, the counter moves past the highest value
+	if err := c.Reserve(f, true, []uint64{101, 103}); err != nil || c.Counter != 104 {
+		t.Errorf("FAILED reserving counter values in range. Expected: 104, got: %v (%v)", c.Counter, err)
+	}
+	// the end of the current range
+	if err := c.Reserve(f, true, []uint64{109}); err != nil || c.Counter != 110 || c.CounterEnd != 119 {
+		t.Errorf("FAILED reserving end of counter range. Expected: 110/119, got: %v/%v (%v)", c.Counter, c.CounterEnd, err)
+	}
+	// above the current range, the next range starts after it
+	if err := c.Reserve(f, true, []uint64{112, 150}); err != nil || c.Counter != 113 {
+		t.Errorf("FAILED reserving counter values above range. Expected: 113, got: %v (%v)", c.Counter, err)
+	}
+	c.Counter = c.CounterEnd
+	values := c.GetAndIncreaseN(f, true, 2)
+	if len(values) != 2 || values[0] != 119 || values[1] != 151 {
+		t.Errorf("FAILED skipping reserved counter values. Expected: [119 151], got: %v", values)
+	} else {
+		t.Logf("PASSED skipping reserved counter values. Expected: [119 151], got: %v", values)
+	}
+	// already handed out values are left alone
+	if err := c.Reserve(f, true, []uint64{120}); err != nil || c.Counter != 152 {
+		t.Errorf("FAILED reserving used counter value. Expected: 152, got: %v (%v)", c.Counter, err)
+	}
+	// values too far above the current range are left alone, so the next range stays close
+	if err := c.Reserve(f, true, []uint64{10000, math.MaxUint64}); err != nil {
+		t.Errorf("FAILED skipping far counter values. Expected: nil error, got: %v", err)
+	}
+	c.Counter = c.CounterEnd
+	values = c.GetAndIncreaseN(f, true, 2)
+	if len(values) != 2 || values[1] != 161 {
+		t.Errorf("FAILED skipping far counter values. Expected: [160 161], got: %v", values)
+	} else {
+		t.Logf("PASSED skipping far counter values. Expected: [160 161], got: %v", values)
+	}
+	// the allocator refuses to overflow the counter
+	a.writeNext(math.MaxUint64 - 5)
+	if _, _, err := a.NextRange(f, true); err != ErrCounterOverflow {
+		t.Errorf("FAILED overflowing counter range. Expected: %v, got: %v", ErrCounterOverflow, err)
+	}
+	if err := a.ReserveThrough(f, true, math.MaxUint64); err != ErrCounterOverflow {
+		t.Errorf("FAILED overflowing counter reservation. Expected: %v, got: %v", ErrCounterOverflow, err)
+	}
+
+	os.Remove(testFile)
+	os.Remove(testLog)
+}
+
 func TestFileExists(t *testing.T) {
 	testFile := "/tmp/TestFileExists.log"
 	f, err := os.OpenFile(testFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -101,6 +249,30 @@ func TestGenerateUrlSlug(t *testing.T) {
 	}
 }
 
+func TestDecodeSlug(t *testing.T) {
+	tests := []struct {
+		slug    string
+		counter uint64
+		ok      bool
+	}{
+		{"4C92", 1000000, true},
+		{"z", 61, true},
+		{"10", 62, true},
+		{"0abc", 0, false},
+		{"", 0, false},
+		{"ab-c", 0, false},
+		{"zzzzzzzzzzzzz", 0, false},
+	}
+	for _, test := range tests {
+		counter, ok := DecodeSlug(test.slug)
+		if counter != test.counter || ok != test.ok {
+			t.Errorf("FAILED decoding slug %v. Expected: %v/%v, got: %v/%v", test.slug, test.counter, test.ok, counter, ok)
+		} else if ok && encodeSlug(counter) != test.slug {
+			t.Errorf("FAILED encoding decoded slug %v. Expected: %v, got: %v", test.slug, test.slug, encodeSlug(counter))
+		}
+	}
+}
+
 func TestIsValidSlug(t *testing.T) {
 	// test a valid slug
 	slug := "4C92"
@@ -139,6 +311,56 @@ func TestIsValidSlug(t *testing.T) {
 	}
 }
 
+func TestIsValidVanitySlug(t *testing.T) {
+	pattern := regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9-]{0,29}$")
+
+	// test a valid vanity slug (with hyphen)
+	slug := "spring-sale"
+	isValid := IsValidVanitySlug(pattern, slug)
+	if isValid != true {
+		t.Errorf("FAILED validating valid vanity slug. Expected: true, got: %v", isValid)
+	} else {
+		t.Logf("PASSED validating valid vanity slug. Expected true, got: %v", isValid)
+	}
+
+	// test an invalid vanity slug (leading hyphen)
+	slug = "-spring-sale"
+	isValid = IsValidVanitySlug(pattern, slug)
+	if isValid != false {
+		t.Errorf("FAILED validating invalid vanity slug (leading hyphen). Expected: false, got: %v", isValid)
+	} else {
+		t.Logf("PASSED validating invalid vanity slug (leading hyphen). Expected false, got: %v", isValid)
+	}
+
+	// test a vanity slug without a pattern
+	isValid = IsValidVanitySlug(nil, "spring-sale")
+	if isValid != false {
+		t.Errorf("FAILED validating vanity slug without pattern. Expected: false, got: %v", isValid)
+	} else {
+		t.Logf("PASSED validating vanity slug without pattern. Expected false, got: %v", isValid)
+	}
+}
+
+func TestIsReservedSlug(t *testing.T) {
+	reserved := []string{"v1", "admin", "health"}
+
+	// test a reserved slug (different case)
+	isReserved := IsReservedSlug(reserved, "Admin")
+	if isReserved != true {
+		t.Errorf("FAILED checking reserved slug. Expected: true, got: %v", isReserved)
+	} else {
+		t.Logf("PASSED checking reserved slug. Expected true, got: %v", isReserved)
+	}
+
+	// test a slug that is not reserved
+	isReserved = IsReservedSlug(reserved, "4C92")
+	if isReserved != false {
+		t.Errorf("FAILED checking unreserved slug. Expected: false, got: %v", isReserved)
+	} else {
+		t.Logf("PASSED checking unreserved slug. Expected false, got: %v", isReserved)
+	}
+}
+
 func TestIsValidUrl(t *testing.T) {
 	// test a valid URL
 	url := "https://www.google.com"
@@ -158,3 +380,38 @@ func TestIsValidUrl(t *testing.T) {
 		t.Logf("PASSED validating invalid url (no protocol). Expected false, got: %v", isValid)
 	}
 }
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.42":                "203.0.113.0",
+		"::ffff:203.0.113.42":         "203.0.113.0",
+		"2001:db8:85a3:8d3:1319::370": "2001:db8:85a3::",
+		"not an ip":                   "",
+	}
+	for ip, expected := range tests {
+		if anonymized := AnonymizeIP(ip); anonymized != expected {
+			t.Errorf("FAILED anonymizing IP %v. Expected: %v, got: %v", ip, expected, anonymized)
+		} else {
+			t.Logf("PASSED anonymizing IP %v. Expected: %v, got: %v", ip, expected, anonymized)
+		}
+	}
+}
+
+func TestGenerateApiKey(t *testing.T) {
+	key, err := GenerateApiKey()
+	if err != nil {
+		t.Fatalf("FAILED generating API key. Expected: nil error, got: %v", err)
+	}
+	otherKey, _ := GenerateApiKey()
+	if key == otherKey {
+		t.Errorf("FAILED generating unique API keys. Expected: different keys, got: %v", key)
+	}
+
+	// the same key should always hash to the same value, and never to the key itself
+	hash := HashApiKey(key)
+	if hash != HashApiKey(key) || hash == key {
+		t.Errorf("FAILED hashing API key. Expected: stable hash, got: %v", hash)
+	} else {
+		t.Logf("PASSED hashing API key. Expected: stable hash, got: %v", hash)
+	}
+}
@@ -0,0 +1,80 @@
+package visitors
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// bits of the hash selecting a register, 2^12 registers take 4KB per sketch for an error of about 1.6%
+	sketchPrecision = 12
+	sketchRegisters = 1 << sketchPrecision
+)
+
+/*
+	A HyperLogLog sketch, estimating the number of distinct values added to it in constant memory.
+	Used when Redis is not available, matching what PFADD and PFCOUNT do there. Not safe for concurrent use.
+*/
+type sketch struct {
+	registers [sketchRegisters]uint8
+}
+
+// hashes a value to 64 well mixed bits
+func sketchHash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	// FNV alone leaves the high bits poorly mixed for short values, so finish with the splitmix64 mixer
+	x := binary.BigEndian.Uint64(h.Sum(nil))
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+/*
+	Adds a value to the sketch.
+*/
+func (s *sketch) add(value string) {
+	hash := sketchHash(value)
+	index := hash >> (64 - sketchPrecision)
+	// position of the first set bit in the remaining bits, counting from 1
+	rank := uint8(bits.LeadingZeros64(hash<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+/*
+	Adds all values of another sketch to this one.
+*/
+func (s *sketch) merge(other *sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+/*
+	Returns the estimated number of distinct values added to the sketch.
+*/
+func (s *sketch) count() uint64 {
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	m := float64(sketchRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// small counts are estimated more accurately from the empty registers
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,229 @@
+package visitors
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// layout of the days visitors are counted on, in UTC
+const dayLayout = "2006-01-02"
+
+/*
+	Unique visitors of a short URL on one day.
+*/
+type Day struct {
+	Day    string `json:"day"`
+	Unique uint64 `json:"unique"`
+}
+
+/*
+	Counts approximate unique visitors per short URL, per day and all-time, using HyperLogLog sketches.
+	Days returns the unique visitors of each day from the day of from through the day of to, and of all those days together.
+	Days older than the retention are no longer kept and count no visitors.
+*/
+type Counter interface {
+	Add(slug string, visitor string, now time.Time) error
+	Total(slug string) (uint64, error)
+	Days(slug string, from time.Time, to time.Time) ([]Day, uint64, error)
+}
+
+/*
+	Returns the fingerprint of a visitor, a hash of the full IP address, user agent and accepted languages.
+	Only the hash is added to the sketches, and sketches cannot be turned back into the values added to them.
+*/
+func Fingerprint(ip string, userAgent string, language string) string {
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent + "\x00" + language))
+	return hex.EncodeToString(sum[:16])
+}
+
+// the days from the day of from through the day of to
+func daysBetween(from time.Time, to time.Time) []string {
+	days := []string{}
+	end := to.UTC().Format(dayLayout)
+	for day := from.UTC(); ; day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(dayLayout))
+		if days[len(days)-1] >= end {
+			return days
+		}
+	}
+}
+
+/*
+	Keeps visitor sketches in Redis, using PFADD and PFCOUNT. Sketches of a day expire once they are older than the retention.
+*/
+type RedisCounter struct {
+	F         *os.File
+	Debug     bool
+	Client    *redis.Client
+	Retention time.Duration
+}
+
+// key of the sketch of a short URL, suffixed by the day or all
+func redisKey(slug string, suffix string) string {
+	return "visitors:" + slug + ":" + suffix
+}
+
+/*
+	Adds a visitor to the sketches of the short URL for the day and all-time, in one round trip.
+*/
+func (c *RedisCounter) Add(slug string, visitor string, now time.Time) error {
+	log.SetOutput(c.F)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	day := redisKey(slug, now.UTC().Format(dayLayout))
+	_, err := c.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.PFAdd(ctx, day, visitor)
+		pipe.Expire(ctx, day, c.Retention+24*time.Hour)
+		pipe.PFAdd(ctx, redisKey(slug, "all"), visitor)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error counting visitor (slug: %v) (%v)", slug, err)
+		return err
+	}
+
+	if c.Debug {
+		log.Printf("[DEBUG] Counted visitor in cache (slug: %v)", slug)
+	}
+	return nil
+}
+
+/*
+	Returns the estimated all-time unique visitors of the short URL.
+*/
+func (c *RedisCounter) Total(slug string) (uint64, error) {
+	log.SetOutput(c.F)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	count, err := c.Client.PFCount(ctx, redisKey(slug, "all")).Result()
+	if err != nil {
+		log.Printf("Error counting visitors (slug: %v) (%v)", slug, err)
+		return 0, err
+	}
+	return uint64(count), nil
+}
+
+/*
+	Returns the estimated unique visitors of the short URL per day, and across all of the days, in one round trip.
+*/
+func (c *RedisCounter) Days(slug string, from time.Time, to time.Time) ([]Day, uint64, error) {
+	log.SetOutput(c.F)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	days := daysBetween(from, to)
+	keys := make([]string, len(days))
+	counts := make([]*redis.IntCmd, len(days))
+	var union *redis.IntCmd
+	_, err := c.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, day := range days {
+			keys[i] = redisKey(slug, day)
+			counts[i] = pipe.PFCount(ctx, keys[i])
+		}
+		// counting several keys at once counts their union
+		union = pipe.PFCount(ctx, keys...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error counting visitors (slug: %v) (%v)", slug, err)
+		return nil, 0, err
+	}
+
+	list := make([]Day, len(days))
+	for i, day := range days {
+		list[i] = Day{Day: day, Unique: uint64(counts[i].Val())}
+	}
+	return list, uint64(union.Val()), nil
+}
+
+// identifies the sketch of a short URL and day
+type dayKey struct {
+	slug string
+	day  string
+}
+
+/*
+	Keeps visitor sketches in memory, for servers running without Redis. Each sketch takes about 4KB,
+	so memory grows with the number of short URLs visited per day and is lost when the server exits. Safe for concurrent use.
+*/
+type MemoryCounter struct {
+	mu        sync.Mutex
+	retention time.Duration
+	days      map[dayKey]*sketch
+	totals    map[string]*sketch
+	// day of the last removal of sketches older than the retention
+	pruned string
+}
+
+/*
+	Returns an empty in-memory counter keeping daily sketches for the retention.
+*/
+func NewMemoryCounter(retention time.Duration) *MemoryCounter {
+	return &MemoryCounter{retention: retention, days: map[dayKey]*sketch{}, totals: map[string]*sketch{}}
+}
+
+/*
+	Adds a visitor to the sketches of the short URL for the day and all-time. Sketches past the retention are removed once a day.
+*/
+func (c *MemoryCounter) Add(slug string, visitor string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	today := now.UTC().Format(dayLayout)
+	if c.pruned != today {
+		c.pruned = today
+		oldest := now.Add(-c.retention).UTC().Format(dayLayout)
+		for key := range c.days {
+			if key.day < oldest {
+				delete(c.days, key)
+			}
+		}
+	}
+
+	key := dayKey{slug: slug, day: today}
+	if c.days[key] == nil {
+		c.days[key] = &sketch{}
+	}
+	c.days[key].add(visitor)
+	if c.totals[slug] == nil {
+		c.totals[slug] = &sketch{}
+	}
+	c.totals[slug].add(visitor)
+	return nil
+}
+
+/*
+	Returns the estimated all-time unique visitors of the short URL.
+*/
+func (c *MemoryCounter) Total(slug string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.totals[slug] == nil {
+		return 0, nil
+	}
+	return c.totals[slug].count(), nil
+}
+
+/*
+	Returns the estimated unique visitors of the short URL per day, and across all of the days by merging their sketches.
+*/
+func (c *MemoryCounter) Days(slug string, from time.Time, to time.Time) ([]Day, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	days := daysBetween(from, to)
+	list := make([]Day, len(days))
+	union := &sketch{}
+	for i, day := range days {
+		list[i] = Day{Day: day}
+		if s := c.days[dayKey{slug: slug, day: day}]; s != nil {
+			list[i].Unique = s.count()
+			union.merge(s)
+		}
+	}
+	return list, union.count(), nil
+}
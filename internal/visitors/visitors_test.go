@@ -0,0 +1,106 @@
+package visitors
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/cache"
+	"example.com/url-shortener/internal/config"
+)
+
+// some global variables to avoid duplication
+var configFileName string = "../../example/url_shortener.conf"
+var verbose bool = true
+var c config.Configuration = config.LoadConfig(configFileName, &verbose)
+
+/*
+	Tests that the sketch estimates distinct values within its expected error, ignoring repeated values
+*/
+func TestSketch(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		s := &sketch{}
+		for i := 0; i < n; i++ {
+			s.add(fmt.Sprint("visitor", i))
+			s.add(fmt.Sprint("visitor", i))
+		}
+		count := s.count()
+		if math.Abs(float64(count)-float64(n)) > 0.05*float64(n) {
+			t.Errorf("FAILED estimating distinct values. Expected: %v (5%%), got: %v", n, count)
+		} else {
+			t.Logf("PASSED estimating distinct values. Expected: %v (5%%), got: %v", n, count)
+		}
+	}
+
+	first, second := &sketch{}, &sketch{}
+	for i := 0; i < 1000; i++ {
+		first.add(fmt.Sprint("visitor", i))
+		second.add(fmt.Sprint("visitor", i+500))
+	}
+	first.merge(second)
+	if count := first.count(); count < 1425 || count > 1575 {
+		t.Errorf("FAILED merging sketches. Expected: 1500 (5%%), got: %v", count)
+	}
+}
+
+/*
+	Tests counting visitors per day, across days and all-time, and that days past the retention are removed
+*/
+func TestMemoryCounter(t *testing.T) {
+	counter := NewMemoryCounter(48 * time.Hour)
+	day := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		counter.Add("abc", Fingerprint("203.0.113.1", "curl", ""), day)
+	}
+	counter.Add("abc", Fingerprint("203.0.113.2", "curl", ""), day)
+	counter.Add("abc", Fingerprint("203.0.113.2", "curl", ""), day.AddDate(0, 0, 1))
+	counter.Add("abc", Fingerprint("203.0.113.3", "curl", ""), day.AddDate(0, 0, 1))
+	counter.Add("xyz", Fingerprint("203.0.113.4", "curl", ""), day)
+
+	days, unique, _ := counter.Days("abc", day.Add(-13*time.Hour), day.AddDate(0, 0, 1))
+	if got := fmt.Sprint(days, unique); got != "[{2026-09-30 0} {2026-10-01 2} {2026-10-02 2}] 3" {
+		t.Errorf("FAILED counting daily visitors. Expected: [{2026-09-30 0} {2026-10-01 2} {2026-10-02 2}] 3, got: %v", got)
+	} else {
+		t.Logf("PASSED counting daily visitors. Expected: [{2026-09-30 0} {2026-10-01 2} {2026-10-02 2}] 3, got: %v", got)
+	}
+	if total, _ := counter.Total("abc"); total != 3 {
+		t.Errorf("FAILED counting all-time visitors. Expected: 3, got: %v", total)
+	}
+
+	// a visit three days later removes the first day, but keeps the all-time count
+	counter.Add("abc", Fingerprint("203.0.113.1", "curl", ""), day.AddDate(0, 0, 3))
+	days, _, _ = counter.Days("abc", day, day)
+	total, _ := counter.Total("abc")
+	if days[0].Unique != 0 || total != 3 {
+		t.Errorf("FAILED removing days past the retention. Expected: 0 on the first day and 3 all-time, got: %v and %v", days[0].Unique, total)
+	}
+}
+
+func TestRedisCounter(t *testing.T) {
+	testLog := "/tmp/TestRedisCounter.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+	client := cache.GetCacheClient(c.CacheHost, c.CachePort, c.VisitorsCacheDB, c.CachePass)
+	counter := &RedisCounter{F: f, Debug: verbose, Client: client, Retention: c.VisitorsRetentionDays * 24 * time.Hour}
+	defer client.Del(context.Background(), redisKey("TESTVISITORS", "all"), redisKey("TESTVISITORS", time.Now().UTC().Format(dayLayout)))
+
+	now := time.Now()
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.1"} {
+		if err := counter.Add("TESTVISITORS", Fingerprint(ip, "curl", ""), now); err != nil {
+			t.Fatalf("FAILED counting visitor. Expected: nil error, got: %v", err)
+		}
+	}
+	total, err := counter.Total("TESTVISITORS")
+	days, unique, _ := counter.Days("TESTVISITORS", now, now)
+	if err != nil || total != 2 || unique != 2 || len(days) != 1 || days[0].Unique != 2 {
+		t.Errorf("FAILED counting visitors. Expected: 2, got: %v %v %v (%v)", total, unique, days, err)
+	} else {
+		t.Logf("PASSED counting visitors. Expected: 2, got: %v", total)
+	}
+}
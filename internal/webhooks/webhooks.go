@@ -0,0 +1,522 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stream"
+	"example.com/url-shortener/internal/util"
+)
+
+// headers sent with every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// event types webhooks can subscribe to
+var Events = []string{stream.Click, stream.Created, stream.Updated, stream.Deleted, stream.Expired}
+
+// deliveries claimed and attempted at once
+const claimSize = 20
+
+// bytes of a response body read before the connection is reused
+const maxResponseBytes = 64 * 1024
+
+var (
+	ErrInsecureUrl    = errors.New("webhook URL must use https")
+	ErrPrivateAddress = errors.New("webhook address is not public")
+	ErrUnresolvedHost = errors.New("webhook host cannot be resolved")
+)
+
+/*
+	Checks if webhooks can subscribe to the provided event type.
+*/
+func IsValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// address ranges refused besides the ones net.IP can tell apart: "this network" and carrier-grade NAT
+var nonPublicNetworks = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+/*
+	Checks if an address can receive webhook deliveries. Loopback, link-local, private, carrier-grade NAT, multicast and unspecified addresses are refused,
+	so webhooks cannot be used to reach services on the host or its network.
+*/
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsPrivate() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Checks if a webhook can be created for the provided URL: it must use https, and every address its host resolves to must be public (see IsPublicIP).
+	When allowPrivate is set http URLs and local receivers are allowed, so webhooks can be tried out on a development machine.
+*/
+func CheckUrl(ctx context.Context, rawUrl string, allowPrivate bool) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if allowPrivate {
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return ErrInsecureUrl
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", parsed.Hostname())
+	if err != nil || len(ips) == 0 {
+		return ErrUnresolvedHost
+	}
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+/*
+	Refuses connections to addresses that are not public, checked once the host is resolved so a webhook cannot be pointed at a local service
+	by changing its DNS records after it was created.
+*/
+func dialControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+/*
+	Signs a delivery body sent at the provided Unix time, returning the value of the signature header.
+	The signature is a hex encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook secret,
+	so receivers can reject replayed deliveries by checking the timestamp.
+*/
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+	Checks the signature header of a delivery in constant time, as a receiver would.
+*/
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+/*
+	Decides when failed deliveries are attempted again. The wait starts at Backoff and doubles with every failed attempt, up to MaxBackoff.
+	A delivery is given up on, and kept as a dead letter, once MaxAttempts attempts have failed.
+*/
+type Retry struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+/*
+	Returns how long to wait after the provided number of failed attempts before attempting again.
+*/
+func (r Retry) Delay(attempts int) time.Duration {
+	delay := r.Backoff
+	for i := 1; i < attempts && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
+
+/*
+	The JSON body posted to webhooks. ID is the ID of the delivery, which stays the same when it is attempted again.
+*/
+type Payload struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Slug      string            `json:"slug"`
+	Timestamp uint64            `json:"timestamp"`
+	Url       *model.Url        `json:"url,omitempty"`
+	Click     *model.ClickEvent `json:"click,omitempty"`
+}
+
+/*
+	Counters of a dispatcher, as reported by the metrics API.
+*/
+type Metrics struct {
+	// events dropped because the queue was full
+	Dropped   uint64 `json:"dropped"`
+	Delivered uint64 `json:"delivered"`
+	// failed attempts that will be attempted again
+	Retried uint64 `json:"retried"`
+	// deliveries given up on after their last attempt
+	Failed uint64 `json:"failed"`
+}
+
+/*
+	Delivers short URL events to the webhooks subscribed to them, in the background so requests never wait on a webhook.
+	Events are queued in a bounded buffer, and one delivery is stored per matching webhook, making the store a persistent retry queue.
+	Due deliveries are claimed from the store on every poll interval (and as soon as new ones are stored), and posted concurrently.
+	When the buffer is full new events are dropped rather than slowing down requests. Safe for concurrent use.
+*/
+type Dispatcher struct {
+	F        *os.File
+	Debug    bool
+	store    model.WebhookStore
+	retry    Retry
+	client   *http.Client
+	interval time.Duration
+	queue    chan stream.Event
+	// wakes up the poller when new deliveries are stored
+	wake chan struct{}
+	stop chan struct{}
+	// guards closing the queue against concurrent Enqueue calls
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	// webhooks by ID, reloaded on every poll interval
+	hooksMu   sync.RWMutex
+	hooks     map[string]model.Webhook
+	dropped   uint64
+	delivered uint64
+	retried   uint64
+	failed    uint64
+}
+
+/*
+	Returns a running dispatcher that queues up to queueSize events, posting deliveries with the provided timeout and checking for due ones every interval.
+	Deliveries only connect to public addresses (see IsPublicIP), unless allowPrivate is set. Proxies are not used, so the check applies to the webhook itself.
+*/
+func NewDispatcher(f *os.File, debug bool, store model.WebhookStore, queueSize int, retry Retry, timeout time.Duration, interval time.Duration, allowPrivate bool) *Dispatcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = dialControl
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	d := &Dispatcher{
+		F:     f,
+		Debug: debug,
+		store: store,
+		retry: retry,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// a redirect is reported as a failed attempt, rather than followed with a changed method
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval: interval,
+		queue:    make(chan stream.Event, queueSize),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		hooks:    map[string]model.Webhook{},
+	}
+	if err := d.Refresh(); err != nil {
+		log.SetOutput(f)
+		log.Printf("Error loading webhooks (%v)", err)
+	}
+	d.wg.Add(2)
+	go d.run()
+	go d.poll()
+	return d
+}
+
+/*
+	Reloads the webhooks from the store, so new and deleted webhooks take effect right away. Keeps the previous webhooks if the store fails.
+*/
+func (d *Dispatcher) Refresh() error {
+	hooks, err := d.store.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]model.Webhook, len(hooks))
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+	d.hooksMu.Lock()
+	d.hooks = byID
+	d.hooksMu.Unlock()
+	return nil
+}
+
+/*
+	Queues an event for the webhooks subscribed to it without blocking. Returns false if the event was dropped because the queue is full or the dispatcher is closed.
+*/
+func (d *Dispatcher) Enqueue(event stream.Event) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		atomic.AddUint64(&d.dropped, 1)
+		return false
+	}
+	select {
+	case d.queue <- event:
+		return true
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+		return false
+	}
+}
+
+/*
+	Returns the current counters of the dispatcher.
+*/
+func (d *Dispatcher) Metrics() Metrics {
+	return Metrics{
+		Dropped:   atomic.LoadUint64(&d.dropped),
+		Delivered: atomic.LoadUint64(&d.delivered),
+		Retried:   atomic.LoadUint64(&d.retried),
+		Failed:    atomic.LoadUint64(&d.failed),
+	}
+}
+
+/*
+	Stops accepting events, stores deliveries for the queued events and waits for attempts in progress, used on shutdown.
+	Deliveries that are still pending are attempted once the server starts again.
+*/
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+		close(d.stop)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// stores a delivery for every webhook subscribed to each queued event
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	log.SetOutput(d.F)
+	for event := range d.queue {
+		deliveries := d.deliveries(event)
+		if len(deliveries) == 0 {
+			continue
+		}
+		if err := d.store.InsertWebhookDeliveries(deliveries); err != nil {
+			log.Printf("Error queueing webhook deliveries, dropping event (type: %v) (slug: %v) (%v)", event.Type, event.Slug, err)
+			continue
+		}
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// builds the deliveries of an event, one per webhook subscribed to its type within its scope
+func (d *Dispatcher) deliveries(event stream.Event) []model.WebhookDelivery {
+	d.hooksMu.RLock()
+	defer d.hooksMu.RUnlock()
+	now := uint64(time.Now().Unix())
+	deliveries := []model.WebhookDelivery{}
+	for _, hook := range d.hooks {
+		if !matches(hook, event) {
+			continue
+		}
+		id, err := util.GenerateID()
+		if err != nil {
+			log.Printf("Error creating webhook delivery (webhook: %v) (%v)", hook.ID, err)
+			continue
+		}
+		payload, err := json.Marshal(Payload{ID: id, Type: event.Type, Slug: event.Slug, Timestamp: event.Timestamp, Url: event.Url, Click: event.Click})
+		if err != nil {
+			log.Printf("Error creating webhook delivery (webhook: %v) (%v)", hook.ID, err)
+			continue
+		}
+		deliveries = append(deliveries, model.WebhookDelivery{
+			ID:          id,
+			WebhookID:   hook.ID,
+			Event:       event.Type,
+			Slug:        event.Slug,
+			Payload:     string(payload),
+			Status:      model.DeliveryPending,
+			NextAttempt: now,
+			Created:     now,
+			Updated:     now,
+		})
+	}
+	return deliveries
+}
+
+/*
+	Checks if the webhook is subscribed to the event. Webhooks limited to an owner only receive events of short URLs known to be theirs.
+*/
+func matches(hook model.Webhook, event stream.Event) bool {
+	if hook.Scope != "" && hook.Scope != event.Owner {
+		return false
+	}
+	for _, e := range hook.Events {
+		if e == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// attempts due deliveries on every poll interval, or when woken up, until stopped
+func (d *Dispatcher) poll() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.Refresh(); err != nil {
+				log.SetOutput(d.F)
+				log.Printf("Error reloading webhooks, keeping previous webhooks (%v)", err)
+			}
+			d.attemptDue()
+		case <-d.wake:
+			d.attemptDue()
+		}
+	}
+}
+
+// claims and attempts due deliveries until none are left, or the dispatcher is stopped
+func (d *Dispatcher) attemptDue() {
+	log.SetOutput(d.F)
+	// claims outlast the attempts, so they are not attempted twice
+	lease := uint64(2*d.client.Timeout/time.Second) + 1
+	for {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+		deliveries, err := d.store.ClaimWebhookDeliveries(uint64(time.Now().Unix()), lease, claimSize)
+		if err != nil {
+			log.Printf("Error claiming webhook deliveries (%v)", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery model.WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+		if len(deliveries) < claimSize {
+			return
+		}
+	}
+}
+
+// posts a delivery to its webhook and records the outcome, scheduling another attempt or giving up when it failed
+func (d *Dispatcher) attempt(delivery model.WebhookDelivery) {
+	d.hooksMu.RLock()
+	hook, ok := d.hooks[delivery.WebhookID]
+	d.hooksMu.RUnlock()
+	// another server may have created the webhook since the last refresh
+	var err error
+	if !ok {
+		hook, err = d.store.GetWebhook(delivery.WebhookID)
+	}
+
+	status := 0
+	if err == nil {
+		status, err = d.post(hook, delivery)
+	}
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.Updated = uint64(now.Unix())
+	switch {
+	case err == nil:
+		delivery.Status = model.DeliveryDelivered
+		delivery.LastError = ""
+		atomic.AddUint64(&d.delivered, 1)
+	case errors.Is(err, model.ErrWebhookNotFound) || delivery.Attempts >= d.retry.MaxAttempts:
+		delivery.Status = model.DeliveryFailed
+		delivery.LastError = err.Error()
+		atomic.AddUint64(&d.failed, 1)
+	default:
+		delivery.NextAttempt = uint64(now.Add(d.retry.Delay(delivery.Attempts)).Unix())
+		delivery.LastError = err.Error()
+		atomic.AddUint64(&d.retried, 1)
+	}
+	if err := d.store.UpdateWebhookDelivery(delivery); err != nil {
+		log.Printf("Error recording webhook delivery attempt (id: %v) (%v)", delivery.ID, err)
+		return
+	}
+
+	if d.Debug {
+		log.Printf("[DEBUG] Attempted webhook delivery (id: %v) (webhook: %v) (status: %v) (attempts: %v)", delivery.ID, delivery.WebhookID, delivery.Status, delivery.Attempts)
+	}
+}
+
+// posts the signed payload of a delivery, returning the response status and an error unless the webhook accepted it with a 2xx status
+func (d *Dispatcher) post(hook model.Webhook, delivery model.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhooks")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %v", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,261 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/stream"
+)
+
+var verbose bool = true
+
+/*
+	Tests signing deliveries and verifying their signatures
+*/
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"created"}`)
+	signature := Sign("secret", "1700000000", body)
+	if !Verify("secret", "1700000000", body, signature) {
+		t.Errorf("FAILED verifying signature. Expected: true, got: false (%v)", signature)
+	} else {
+		t.Logf("PASSED verifying signature. Expected: true, got: true (%v)", signature)
+	}
+	if Verify("other", "1700000000", body, signature) || Verify("secret", "1700000001", body, signature) || Verify("secret", "1700000000", []byte("{}"), signature) {
+		t.Errorf("FAILED rejecting signature. Expected: wrong secret, timestamp and body rejected, got: accepted")
+	}
+}
+
+/*
+	Tests that the wait between attempts doubles up to the maximum backoff
+*/
+func TestRetryDelay(t *testing.T) {
+	retry := Retry{MaxAttempts: 8, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+	var tests = []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{20, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if delay := retry.Delay(tt.attempts); delay != tt.delay {
+			t.Errorf("FAILED getting retry delay after %v attempts. Expected: %v, got: %v", tt.attempts, tt.delay, delay)
+		} else {
+			t.Logf("PASSED getting retry delay after %v attempts. Expected: %v, got: %v", tt.attempts, tt.delay, delay)
+		}
+	}
+}
+
+/*
+	Tests that webhooks must use https and public addresses unless private webhooks are allowed
+*/
+func TestCheckUrl(t *testing.T) {
+	var tests = []struct {
+		url          string
+		allowPrivate bool
+		expected     error
+	}{
+		{"https://93.184.216.34/hook", false, nil},
+		{"http://93.184.216.34/hook", false, ErrInsecureUrl},
+		{"https://127.0.0.1/hook", false, ErrPrivateAddress},
+		{"https://[::1]/hook", false, ErrPrivateAddress},
+		{"https://169.254.169.254/latest", false, ErrPrivateAddress},
+		{"https://192.168.1.10/hook", false, ErrPrivateAddress},
+		{"https://0.0.0.0/hook", false, ErrPrivateAddress},
+		{"https://0.1.2.3/hook", false, ErrPrivateAddress},
+		{"https://100.64.0.1/hook", false, ErrPrivateAddress},
+		{"https://100.127.255.254/hook", false, ErrPrivateAddress},
+		{"https://100.128.0.1/hook", false, nil},
+		{"http://127.0.0.1:8080/hook", true, nil},
+	}
+	for _, tt := range tests {
+		if err := CheckUrl(context.Background(), tt.url, tt.allowPrivate); err != tt.expected {
+			t.Errorf("FAILED checking webhook URL %v (allow private: %v). Expected: %v, got: %v", tt.url, tt.allowPrivate, tt.expected, err)
+		} else {
+			t.Logf("PASSED checking webhook URL %v (allow private: %v). Expected: %v, got: %v", tt.url, tt.allowPrivate, tt.expected, err)
+		}
+	}
+
+	if IsPublicIP(net.ParseIP("10.1.2.3")) || IsPublicIP(net.ParseIP("fd00::1")) || !IsPublicIP(net.ParseIP("2606:4700::1111")) {
+		t.Errorf("FAILED checking public addresses. Expected: private ranges refused, got: accepted")
+	}
+}
+
+// a local webhook receiver failing the first failures requests, recording the verified requests it accepted
+type testReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	requests int
+	accepted []Payload
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	body, _ := io.ReadAll(req.Body)
+	if !Verify(r.secret, req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload := Payload{}
+	json.Unmarshal(body, &payload)
+	if payload.ID != req.Header.Get(DeliveryHeader) || payload.Type != req.Header.Get(EventHeader) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.accepted = append(r.accepted, payload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// waits until every delivery of the webhook is finished, failing the test if that takes too long
+func waitForDeliveries(t *testing.T, store model.WebhookStore, webhookID string, count int) []model.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := store.GetWebhookDeliveries(webhookID, 10)
+		finished := len(deliveries) == count
+		for _, delivery := range deliveries {
+			finished = finished && delivery.Status != model.DeliveryPending
+		}
+		if finished {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("FAILED finishing deliveries. Expected: %v finished, got: %+v", count, deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/*
+	Tests delivering events to local receivers: matching events by type and scope, retrying failed attempts and giving up after the last attempt
+*/
+func TestDispatcher(t *testing.T) {
+	testLog := "/tmp/TestDispatcher.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+
+	// fails twice before accepting deliveries
+	flaky := &testReceiver{secret: "flaky-secret", failures: 2}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	// never accepts deliveries
+	down := &testReceiver{secret: "down-secret", failures: 100}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+
+	store := model.NewMemoryStore(f, verbose)
+	store.InsertWebhook(model.Webhook{ID: "FLAKY", Url: flakyServer.URL, Secret: flaky.secret, Events: []string{stream.Created}, Scope: "/alice"})
+	store.InsertWebhook(model.Webhook{ID: "DOWN", Url: downServer.URL, Secret: down.secret, Events: []string{stream.Created, stream.Click}})
+	retry := Retry{MaxAttempts: 3, Backoff: 0, MaxBackoff: 0}
+	d := NewDispatcher(f, verbose, store, 10, retry, time.Second, 10*time.Millisecond, true)
+	defer d.Close()
+
+	url := model.Url{Slug: "HOOKED", Target: "https://www.google.com", Owner: "alice"}
+	d.Enqueue(stream.Event{Type: stream.Created, Slug: url.Slug, Owner: "/alice", Timestamp: 1700000000, Url: &url})
+	// neither of another owner, nor of an event type the flaky webhook is not subscribed to
+	d.Enqueue(stream.Event{Type: stream.Created, Slug: "OTHER", Owner: "/bob"})
+	d.Enqueue(stream.Event{Type: stream.Click, Slug: url.Slug, Owner: "/alice"})
+
+	deliveries := waitForDeliveries(t, store, "FLAKY", 1)
+	flaky.mu.Lock()
+	accepted := flaky.accepted
+	flaky.mu.Unlock()
+	if deliveries[0].Status != model.DeliveryDelivered || deliveries[0].Attempts != 3 || deliveries[0].LastStatus != http.StatusNoContent || len(accepted) != 1 || accepted[0].Url == nil || accepted[0].Url.Slug != "HOOKED" {
+		t.Errorf("FAILED retrying delivery. Expected: delivered on attempt 3, got: %+v (accepted: %+v)", deliveries[0], accepted)
+	} else {
+		t.Logf("PASSED retrying delivery. Expected: delivered on attempt 3, got: %v on attempt %v", deliveries[0].Status, deliveries[0].Attempts)
+	}
+
+	deliveries = waitForDeliveries(t, store, "DOWN", 3)
+	for _, delivery := range deliveries {
+		if delivery.Status != model.DeliveryFailed || delivery.Attempts != 3 || delivery.LastStatus != http.StatusInternalServerError || delivery.LastError == "" {
+			t.Errorf("FAILED giving up on delivery. Expected: failed after 3 attempts, got: %+v", delivery)
+		}
+	}
+	if metrics := d.Metrics(); metrics.Delivered != 1 || metrics.Failed != 3 || metrics.Retried != 8 {
+		t.Errorf("FAILED counting deliveries. Expected: 1 delivered, 3 failed and 8 retried, got: %+v", metrics)
+	} else {
+		t.Logf("PASSED counting deliveries. Expected: 1 delivered, 3 failed and 8 retried, got: %+v", metrics)
+	}
+}
+
+/*
+	Tests that deliveries of a deleted webhook are given up on, and that a closed dispatcher drops events
+*/
+func TestDispatcherDeletedWebhook(t *testing.T) {
+	testLog := "/tmp/TestDispatcherDeletedWebhook.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+
+	store := model.NewMemoryStore(f, verbose)
+	store.InsertWebhookDeliveries([]model.WebhookDelivery{{ID: "ORPHAN", WebhookID: "GONE", Status: model.DeliveryPending}})
+	d := NewDispatcher(f, verbose, store, 10, Retry{MaxAttempts: 3}, time.Second, 10*time.Millisecond, true)
+	deliveries := waitForDeliveries(t, store, "GONE", 1)
+	if deliveries[0].Status != model.DeliveryFailed || deliveries[0].Attempts != 1 {
+		t.Errorf("FAILED giving up on delivery of deleted webhook. Expected: failed after 1 attempt, got: %+v", deliveries[0])
+	} else {
+		t.Logf("PASSED giving up on delivery of deleted webhook. Expected: failed after 1 attempt, got: %v after %v", deliveries[0].Status, deliveries[0].Attempts)
+	}
+
+	d.Close()
+	if d.Enqueue(stream.Event{Type: stream.Created}) || d.Metrics().Dropped != 1 {
+		t.Errorf("FAILED dropping event after close. Expected: dropped, got: queued")
+	}
+}
+
+/*
+	Tests that deliveries are never posted to local addresses unless private webhooks are allowed, even when the webhook was stored with one
+*/
+func TestDispatcherPrivateAddress(t *testing.T) {
+	testLog := "/tmp/TestDispatcherPrivateAddress.log"
+	f, err := os.OpenFile(testLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("FAILED opening log file. Expected: nil error, got: %v", err)
+	}
+	defer f.Close()
+
+	local := &testReceiver{secret: "local-secret"}
+	localServer := httptest.NewServer(local)
+	defer localServer.Close()
+
+	store := model.NewMemoryStore(f, verbose)
+	store.InsertWebhook(model.Webhook{ID: "LOCAL", Url: localServer.URL, Secret: local.secret, Events: []string{stream.Created}})
+	d := NewDispatcher(f, verbose, store, 10, Retry{MaxAttempts: 1}, time.Second, 10*time.Millisecond, false)
+	defer d.Close()
+	d.Enqueue(stream.Event{Type: stream.Created, Slug: "LOCAL"})
+
+	deliveries := waitForDeliveries(t, store, "LOCAL", 1)
+	local.mu.Lock()
+	accepted := len(local.accepted)
+	local.mu.Unlock()
+	if deliveries[0].Status != model.DeliveryFailed || !strings.Contains(deliveries[0].LastError, ErrPrivateAddress.Error()) || accepted != 0 {
+		t.Errorf("FAILED refusing local address. Expected: failed with %v, got: %+v (accepted: %v)", ErrPrivateAddress, deliveries[0], accepted)
+	} else {
+		t.Logf("PASSED refusing local address. Expected: failed with %v, got: %v", ErrPrivateAddress, deliveries[0].LastError)
+	}
+}